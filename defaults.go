@@ -0,0 +1,47 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	rbac_proxy_config "github.com/brancz/kube-rbac-proxy/pkg/config"
+)
+
+const printDefaultConfigUsage = `Usage: kube-rbac-proxy print-default-config
+
+Prints a config file in the versioned format setting every flag to its
+default, with the usage of each flag as comment.
+`
+
+// fileExcludedFlags are the flags which can't be set by the config file.
+var fileExcludedFlags = []string{"config-file", "config-object", "config-configmap", "windows-service-name", "profile"}
+
+// runPrintDefaultConfig runs the print-default-config subcommand with the
+// given arguments.
+func runPrintDefaultConfig(args []string) error {
+	flagset := pflag.NewFlagSet("print-default-config", pflag.ExitOnError)
+	flagset.Usage = func() {
+		fmt.Fprint(os.Stderr, printDefaultConfigUsage)
+	}
+	flagset.Parse(args)
+
+	return rbac_proxy_config.WriteDefaults(os.Stdout, newFlagSet(os.Args[0], newConfig()), fileExcludedFlags...)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+
+	rbac_proxy_config "github.com/brancz/kube-rbac-proxy/pkg/config"
+)
+
+// TestDefaultConfig ensures the default config is valid and leaves all flags
+// at their defaults.
+func TestDefaultConfig(t *testing.T) {
+	var buf bytes.Buffer
+	if err := rbac_proxy_config.WriteDefaults(&buf, newFlagSet("test", newConfig()), fileExcludedFlags...); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, flagset, err := parseConfig([]string{"--config-file=" + path, "--upstream=http://127.0.0.1:8081/"})
+	if err != nil {
+		t.Fatalf("failed to parse default config:\n%s\n%v", buf.String(), err)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("want default config to be valid, got %v", err)
+	}
+
+	defaults := newFlagSet("defaults", newConfig())
+	flagset.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "config-file" || f.Name == "upstream" {
+			return
+		}
+		if want := defaults.Lookup(f.Name).Value.String(); f.Value.String() != want {
+			t.Errorf("want flag %q to keep its default %q, got %q", f.Name, want, f.Value.String())
+		}
+	})
+}
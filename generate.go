@@ -0,0 +1,242 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+	rbac_proxy_config "github.com/brancz/kube-rbac-proxy/pkg/config"
+	"github.com/brancz/kube-rbac-proxy/pkg/manifests"
+	"github.com/brancz/kube-rbac-proxy/pkg/monitoring"
+)
+
+const generateUsage = `Usage: kube-rbac-proxy generate monitoring [flags]
+       kube-rbac-proxy generate manifests [flags] -- [proxy flags]
+
+monitoring writes a Grafana dashboard and a PrometheusRule with alerts for the
+metrics of this version of kube-rbac-proxy.
+
+manifests writes the ServiceAccount, RBAC objects and config file ConfigMap
+needed by kube-rbac-proxy run with the given proxy flags, and a patch adding
+the kube-rbac-proxy container to a Deployment.
+`
+
+// defaultImage is the image of this version of kube-rbac-proxy.
+const defaultImage = "quay.io/brancz/kube-rbac-proxy:v0.8.0"
+
+// runGenerate runs the generate subcommand with the given arguments.
+func runGenerate(args []string) error {
+	if len(args) > 0 && args[0] == "manifests" {
+		return runGenerateManifests(args[1:])
+	}
+	if len(args) == 0 || args[0] != "monitoring" {
+		fmt.Fprint(os.Stderr, generateUsage)
+		return fmt.Errorf("unknown or missing asset, must be \"monitoring\" or \"manifests\"")
+	}
+
+	cfg := monitoring.Config{}
+	outputDir := ""
+
+	flagset := pflag.NewFlagSet("generate monitoring", pflag.ExitOnError)
+	flagset.Usage = func() {
+		fmt.Fprint(os.Stderr, generateUsage+"\n")
+		flagset.PrintDefaults()
+	}
+	flagset.StringVar(&outputDir, "output-dir", ".", "The directory to write <name>-dashboard.json and <name>-prometheusrule.yaml to.")
+	flagset.StringVar(&cfg.Name, "name", "kube-rbac-proxy", "The name of the PrometheusRule and the title and UID of the dashboard.")
+	flagset.StringVar(&cfg.Namespace, "namespace", "", "The namespace of the PrometheusRule.")
+	flagset.StringVar(&cfg.Selector, "selector", `job="kube-rbac-proxy"`, "The label matchers selecting the proxy's series in all queries, e.g. 'namespace=\"monitoring\",container=\"kube-rbac-proxy\"'.")
+	flagset.Parse(args[1:])
+
+	dashboard, err := monitoring.Dashboard(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to generate dashboard: %v", err)
+	}
+	rules, err := monitoring.Rules(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to generate rules: %v", err)
+	}
+
+	return writeFiles(outputDir, map[string][]byte{
+		cfg.Name + "-dashboard.json":      append(dashboard, '\n'),
+		cfg.Name + "-prometheusrule.yaml": rules,
+	})
+}
+
+// runGenerateManifests runs the generate manifests subcommand with the given
+// arguments, the proxy flags following "--".
+func runGenerateManifests(args []string) error {
+	mcfg := manifests.Config{}
+	outputDir := ""
+
+	flagset := pflag.NewFlagSet("generate manifests", pflag.ExitOnError)
+	flagset.Usage = func() {
+		fmt.Fprint(os.Stderr, generateUsage+"\n")
+		flagset.PrintDefaults()
+	}
+	flagset.StringVar(&outputDir, "output-dir", ".", "The directory to write <name>-manifests.yaml and <name>-deployment-patch.yaml to.")
+	flagset.StringVar(&mcfg.Name, "name", "kube-rbac-proxy", "The name of the ServiceAccount, the RBAC objects and the container.")
+	flagset.StringVar(&mcfg.Namespace, "namespace", "default", "The namespace of the Deployment and the ServiceAccount.")
+	flagset.StringVar(&mcfg.Image, "image", defaultImage, "The image of the kube-rbac-proxy container.")
+	flagset.Parse(args)
+
+	proxyArgs := flagset.Args()
+	if dash := flagset.ArgsLenAtDash(); dash >= 0 {
+		proxyArgs = proxyArgs[dash:]
+	}
+	cfg, _, err := parseConfig(proxyArgs)
+	if err != nil {
+		return err
+	}
+	if err := cfg.validate(); err != nil {
+		printErrors(os.Stderr, "Invalid proxy flags:", err)
+		return fmt.Errorf("invalid proxy flags")
+	}
+
+	mcfg.Args = proxyArgs
+	if cfg.configFile != "" {
+		mcfg.ConfigFileName = filepath.Base(cfg.configFile)
+		mcfg.ConfigFile, err = ioutil.ReadFile(cfg.configFile)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %v", err)
+		}
+		mcfg.Args = replaceFlag(proxyArgs, "config-file", filepath.Join(manifests.ConfigFileDir, mcfg.ConfigFileName))
+	}
+	if len(cfg.secureListenAddresses) > 0 {
+		_, port, err := net.SplitHostPort(cfg.secureListenAddresses[0])
+		if err != nil {
+			return fmt.Errorf("invalid secure listen address: %v", err)
+		}
+		p, err := strconv.ParseInt(port, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid secure listen address port %q: %v", port, err)
+		}
+		mcfg.Port = int32(p)
+	}
+	mcfg.Rules, err = cfg.rules(mcfg.Namespace)
+	if err != nil {
+		return err
+	}
+
+	objects, err := manifests.Manifests(mcfg)
+	if err != nil {
+		return fmt.Errorf("failed to generate manifests: %v", err)
+	}
+	patch, err := manifests.DeploymentPatch(mcfg)
+	if err != nil {
+		return fmt.Errorf("failed to generate deployment patch: %v", err)
+	}
+
+	return writeFiles(outputDir, map[string][]byte{
+		mcfg.Name + "-manifests.yaml":        objects,
+		mcfg.Name + "-deployment-patch.yaml": patch,
+	})
+}
+
+// rules returns the namespaced permissions needed with cfg. Objects without
+// a namespace are in the proxy's namespace podNamespace.
+func (cfg *config) rules(podNamespace string) ([]manifests.Rule, error) {
+	var rules []manifests.Rule
+	watch := func(ns, group, resource, name string) {
+		rules = append(rules, manifests.Rule{
+			Namespace:     ns,
+			APIGroup:      group,
+			Resource:      resource,
+			ResourceNames: []string{name},
+			Verbs:         []string{"get", "list", "watch"},
+		})
+	}
+
+	if cfg.configObject != "" {
+		ns, name, err := rbac_proxy_config.ParseObjectName(cfg.configObject)
+		if err != nil {
+			return nil, err
+		}
+		watch(ns, v1alpha1.GroupName, rbac_proxy_config.ObjectResource.Resource, name)
+	}
+	if cfg.configConfigMap != "" {
+		k, err := rbac_proxy_config.ParseConfigMapKey(cfg.configConfigMap)
+		if err != nil {
+			return nil, err
+		}
+		watch(k.Namespace, "", "configmaps", k.Name)
+	}
+	if cfg.tls.secret != "" {
+		parts := strings.Split(cfg.tls.secret, "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid secret reference %q, must be namespace/name", cfg.tls.secret)
+		}
+		watch(parts[0], "", "secrets", parts[1])
+	}
+	if cfg.tls.selfSigned.CAConfigMap != "" {
+		parts := strings.Split(cfg.tls.selfSigned.CAConfigMap, "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid configmap reference %q, must be namespace/name", cfg.tls.selfSigned.CAConfigMap)
+		}
+		// Creating cannot be restricted to a name.
+		rules = append(rules,
+			manifests.Rule{Namespace: parts[0], Resource: "configmaps", ResourceNames: []string{parts[1]}, Verbs: []string{"get", "update"}},
+			manifests.Rule{Namespace: parts[0], Resource: "configmaps", Verbs: []string{"create"}},
+		)
+	}
+	if cfg.failureEvents.Threshold > 0 {
+		ns := cfg.failureEvents.PodNamespace
+		if ns == "" {
+			ns = podNamespace
+		}
+		rules = append(rules, manifests.Rule{Namespace: ns, Resource: "events", Verbs: []string{"create", "patch", "update"}})
+	}
+	return rules, nil
+}
+
+// replaceFlag returns args with the value of the flag name replaced.
+func replaceFlag(args []string, name, value string) []string {
+	res := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--"+name && i+1 < len(args):
+			res = append(res, args[i], value)
+			i++
+		case strings.HasPrefix(args[i], "--"+name+"="):
+			res = append(res, "--"+name+"="+value)
+		default:
+			res = append(res, args[i])
+		}
+	}
+	return res
+}
+
+func writeFiles(dir string, files map[string][]byte) error {
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		klog.Infof("Wrote %s", path)
+	}
+	return nil
+}
@@ -19,78 +19,1302 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/http/pprof"
 	"net/url"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/oklog/run"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/pflag"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
-	"k8s.io/apiserver/pkg/authentication/authenticator"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	certutil "k8s.io/client-go/util/cert"
 	k8sapiflag "k8s.io/component-base/cli/flag"
 	"k8s.io/klog/v2"
 
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+	"github.com/brancz/kube-rbac-proxy/pkg/audit"
 	"github.com/brancz/kube-rbac-proxy/pkg/authn"
 	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+	rbac_proxy_config "github.com/brancz/kube-rbac-proxy/pkg/config"
+	"github.com/brancz/kube-rbac-proxy/pkg/decisionlog"
+	"github.com/brancz/kube-rbac-proxy/pkg/events"
+	"github.com/brancz/kube-rbac-proxy/pkg/filters"
+	"github.com/brancz/kube-rbac-proxy/pkg/health"
+	"github.com/brancz/kube-rbac-proxy/pkg/logging"
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+	"github.com/brancz/kube-rbac-proxy/pkg/prewarm"
+	"github.com/brancz/kube-rbac-proxy/pkg/profiling"
 	"github.com/brancz/kube-rbac-proxy/pkg/proxy"
+	"github.com/brancz/kube-rbac-proxy/pkg/routing"
+	"github.com/brancz/kube-rbac-proxy/pkg/runtimelimits"
+	"github.com/brancz/kube-rbac-proxy/pkg/sockopt"
+	"github.com/brancz/kube-rbac-proxy/pkg/startup"
+	"github.com/brancz/kube-rbac-proxy/pkg/statsd"
 	rbac_proxy_tls "github.com/brancz/kube-rbac-proxy/pkg/tls"
+	"github.com/brancz/kube-rbac-proxy/pkg/version"
+	"github.com/brancz/kube-rbac-proxy/pkg/watchdog"
+	"github.com/brancz/kube-rbac-proxy/pkg/winservice"
 )
 
 type config struct {
-	insecureListenAddress string
-	secureListenAddress   string
-	upstream              string
-	upstreamForceH2C      bool
-	upstreamCAFile        string
-	auth                  proxy.Config
-	tls                   tlsConfig
-	kubeconfigLocation    string
-	allowPaths            []string
-	ignorePaths           []string
+	insecureListenAddress    string
+	insecureAllowNonLoopback bool
+	health                   healthConfig
+	debug                    debugConfig
+	secureListenAddresses    []string
+	upstream                 string
+	upstreamForceH2C         bool
+	upstreamProtocol         string
+	upstreamCAFile           string
+	upstreamProxyURL         string
+	upstreamAuthPassthrough  bool
+	upstreamCopyBufferSize   int
+	kubeAPIProxyURL          string
+	kubeAPIHTTP2             kubeAPIHTTP2Config
+	auth                     proxy.Config
+	tls                      tlsConfig
+	kubeconfigLocation       string
+	kubeconfigContext        string
+	windowsServiceName       string
+	profile                  string
+	allowPaths               []string
+	ignorePaths              []string
+	inFlight                 filters.InFlightConfig
+	server                   serverConfig
+	listenSockopts           sockopt.Config
+	upstreamSockopts         sockopt.Config
+	proxyBehavior            proxyBehavior
+	hosts                    []v1alpha1.Host
+	routes                   []v1alpha1.Route
+	audit                    audit.Config
+	failureEvents            events.FailureConfig
+	logSampling              logging.SamplingConfig
+	logSlowRequests          time.Duration
+	decisionLog              decisionlog.Config
+	metricsExemplars         bool
+	metricsSALimit           int
+	metricsPrometheus        bool
+	statsd                   statsd.Config
+	profiling                profiling.Config
+	watchdog                 watchdog.Config
+	runtimeLimits            runtimelimits.Config
+	prewarmConnections       bool
+	prewarmInterval          time.Duration
+	startup                  startup.Config
+	auditLogMaxSize          int
+	auditLogMaxAge           int
+
+	configFile           string
+	configReloadInterval time.Duration
+	configObject         string
+	configConfigMap      string
+	// file is the parsed config file and fileContent its content.
+	file        v1alpha1.KubeRBACProxyConfig
+	fileContent []byte
+	// cmdlineFlags holds the names of the flags set on the command line.
+	cmdlineFlags sets.String
+	// klogFlags are the flags of klog, which are part of the flag set.
+	klogFlags *flag.FlagSet
+}
+
+type serverConfig struct {
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	drainTimeout      time.Duration
+	shutdownDelay     time.Duration
+	handoffTimeout    time.Duration
+
+	http2Disable              bool
+	http2MaxConcurrentStreams uint32
+	http2MaxReadFrameSize     uint32
+}
+
+type healthConfig struct {
+	listenAddress string
+	certFile      string
+	keyFile       string
+	clientAuth    string
+	checkTimeout  time.Duration
+	upstreamCheck bool
+	readyzExclude []string
+
+	kubeAPIServerWindow time.Duration
+}
+
+type debugConfig struct {
+	endpoints      bool
+	verbosity      int
+	nonResourceURL string
+}
+
+type tlsConfig struct {
+	certFile             string
+	keyFile              string
+	keyURI               string
+	secret               string
+	minVersion           string
+	maxVersion           string
+	cipherSuites         []string
+	curvePreferences     []string
+	sniCertKeys          []k8sapiflag.NamedCertKey
+	clientAuth           string
+	reloadInterval       time.Duration
+	acme                 rbac_proxy_tls.ACMEConfig
+	selfSigned           rbac_proxy_tls.SelfSignedConfig
+	spiffe               bool
+	spiffeEndpointSocket string
+	upstreamSPIFFE       bool
+	waitForCert          bool
+	fips                 bool
+	ocspStapling         bool
+	acmeHTTP01ListenAddr string
 }
 
-type tlsConfig struct {
-	certFile       string
-	keyFile        string
-	minVersion     string
-	cipherSuites   []string
-	reloadInterval time.Duration
+// defaultRoute is the route name of requests not matching any virtual host.
+const defaultRoute = "default"
+
+// proxyBehavior configures how requests are proxied to the upstream.
+type proxyBehavior struct {
+	timeout              time.Duration
+	retries              int
+	maxRequestBodyBytes  int64
+	maxResponseBodyBytes int64
+	flushInterval        time.Duration
+}
+
+// override returns b with the fields set in o replaced.
+func (b proxyBehavior) override(o v1alpha1.ProxyOverrides) proxyBehavior {
+	if o.Timeout != nil {
+		b.timeout = o.Timeout.Duration
+	}
+	if o.Retries != nil {
+		b.retries = *o.Retries
+	}
+	if o.MaxRequestBodyBytes != nil {
+		b.maxRequestBodyBytes = *o.MaxRequestBodyBytes
+	}
+	if o.MaxResponseBodyBytes != nil {
+		b.maxResponseBodyBytes = *o.MaxResponseBodyBytes
+	}
+	if o.FlushInterval != nil {
+		b.flushInterval = o.FlushInterval.Duration
+	}
+	return b
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		if err := runGenerate(os.Args[2:]); err != nil {
+			klog.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfig(os.Args[2:]); err != nil {
+			klog.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "print-default-config" {
+		if err := runPrintDefaultConfig(os.Args[2:]); err != nil {
+			klog.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		if err := runVersion(os.Args[2:]); err != nil {
+			klog.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		if err := runTest(os.Args[2:]); err != nil {
+			klog.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		if err := runValidate(os.Args[2:]); err != nil {
+			printErrors(os.Stderr, "Invalid configuration:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid.")
+		return
+	}
+
+	// The service changes the working directory, so it is started before
+	// files named by relative paths are read.
+	var winService *winservice.Service
+	if name := windowsServiceName(os.Args[1:]); name != "" {
+		var err error
+		winService, err = winservice.Start(name)
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+
+	cfg, flagset, err := parseConfig(os.Args[1:])
+	if err != nil {
+		klog.Fatal(err)
+	}
+	klog.Infof("Starting %v", version.Get())
+
+	kcfg := initKubeConfig(cfg.kubeconfigLocation, cfg.kubeconfigContext)
+
+	kcfg.Proxy, err = proxyFunc(cfg.kubeAPIProxyURL)
+	if err != nil {
+		klog.Fatalf("Invalid Kubernetes API proxy: %v", err)
+	}
+	if err := configureKubeAPITransport(kcfg, cfg.kubeAPIHTTP2); err != nil {
+		klog.Fatalf("Failed to set up the Kubernetes API transport: %v", err)
+	}
+
+	var (
+		dynamicClient                  dynamic.Interface
+		configNamespace, configObjName string
+	)
+	if cfg.configObject != "" {
+		configNamespace, configObjName, err = rbac_proxy_config.ParseObjectName(cfg.configObject)
+		if err != nil {
+			klog.Fatalf("Invalid --config-object: %v", err)
+		}
+		dynamicClient, err = dynamic.NewForConfig(kcfg)
+		if err != nil {
+			klog.Fatalf("Failed to instantiate dynamic Kubernetes client: %v", err)
+		}
+
+		klog.Infof("Reading config object: %s", cfg.configObject)
+		b, err := rbac_proxy_config.GetObject(context.Background(), dynamicClient, configNamespace, configObjName)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		if err := cfg.applyFile(flagset, b); err != nil {
+			klog.Fatal(err)
+		}
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(kcfg)
+	if err != nil {
+		klog.Fatalf("Failed to instantiate Kubernetes client: %v", err)
+	}
+
+	var configMapKey rbac_proxy_config.ConfigMapKey
+	if cfg.configConfigMap != "" {
+		configMapKey, err = rbac_proxy_config.ParseConfigMapKey(cfg.configConfigMap)
+		if err != nil {
+			klog.Fatalf("Invalid --config-configmap: %v", err)
+		}
+
+		klog.Infof("Reading config from %s", configMapKey)
+		b, err := rbac_proxy_config.GetConfigMap(context.Background(), kubeClient, configMapKey)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		if err := cfg.applyFile(flagset, b); err != nil {
+			klog.Fatal(err)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		klog.Fatalf("Invalid configuration: %v", err)
+	}
+	runtimelimits.Apply(cfg.runtimeLimits)
+
+	readiness := health.NewReadiness()
+	startupCheck := func(dependency string, check health.CheckFunc) {
+		err := cfg.startup.Check(context.Background(), dependency, func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, cfg.health.checkTimeout)
+			defer cancel()
+			return check(ctx)
+		}, readiness.Set)
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+	startupCheck(startup.KubeAPIServer, kubeAPIServerCheck(kubeClient.AuthorizationV1().SubjectAccessReviews()))
+	if policy, _ := cfg.startup.Policy(startup.Certificate); policy == startup.Degraded {
+		cfg.tls.waitForCert = true
+	}
+	if !cfg.tls.waitForCert && len(cfg.secureListenAddresses) > 0 {
+		startupCheck(startup.Certificate, certificateCheck(cfg.tls, kubeClient))
+	}
+
+	upstreamURL, err := url.Parse(cfg.upstream)
+	if err != nil {
+		klog.Fatalf("Failed to parse upstream URL: %v", err)
+	}
+
+	var connectivity *health.Connectivity
+	if cfg.health.kubeAPIServerWindow > 0 {
+		connectivity = health.NewConnectivity(cfg.health.kubeAPIServerWindow)
+		metrics.OnDelegatedRequest(func(_ string, err error) {
+			connectivity.Observe(err)
+		})
+		metrics.Registry.MustRegister(metrics.NewKubeAPIServerUnreachable(connectivity.Unreachable))
+	}
+
+	if cfg.auth.Authentication.OIDC.IssuerURL == "" {
+		klog.Infof("Valid token audiences: %s", strings.Join(cfg.auth.Authentication.Token.Audiences, ", "))
+	}
+	authenticator, err := proxy.NewAuthenticator(kubeClient, cfg.auth.Authentication)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	sarClient := kubeClient.AuthorizationV1().SubjectAccessReviews()
+	authorizer, err := authz.NewAuthorizer(sarClient)
+
+	if err != nil {
+		klog.Fatalf("Failed to create authorizer: %v", err)
+	}
+
+	reloader := newConfigReloader(flagset, cfg.cmdlineFlags, cfg.file)
+	auth := proxy.NewReloadable(cfg.auth, authorizer, authenticator)
+	reloader.add(defaultRoute, cfg.auth.Authentication, auth)
+
+	upstreamProxy, err := proxyFunc(cfg.upstreamProxyURL)
+	if err != nil {
+		klog.Fatalf("Invalid upstream proxy: %v", err)
+	}
+
+	if cfg.upstreamForceH2C {
+		cfg.upstreamProtocol = upstreamProtocolHTTP2
+	}
+
+	var gr run.Group
+
+	var spiffeSource *rbac_proxy_tls.SPIFFESource
+	if cfg.tls.spiffe || cfg.tls.upstreamSPIFFE {
+		klog.Info("Obtaining X509-SVID from SPIFFE Workload API")
+		if cfg.tls.waitForCert {
+			spiffeSource = rbac_proxy_tls.NewPendingSPIFFESource(cfg.tls.spiffeEndpointSocket)
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			spiffeSource, err = rbac_proxy_tls.NewSPIFFESource(ctx, cfg.tls.spiffeEndpointSocket)
+			cancel()
+			if err != nil {
+				klog.Fatalf("Failed to initialize SPIFFE source: %v", err)
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		gr.Add(func() error {
+			return spiffeSource.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	// upstreamTransports are closed after the listeners are drained.
+	var upstreamTransports []idleConnectionsCloser
+	var prewarmTargets []prewarm.Target
+	newUpstreamTransport := func(upstreamURL *url.URL, caFile string, retries int) http.RoundTripper {
+		upstreamTransport, err := initTransport(caFile, cfg.upstreamSockopts, upstreamProxy)
+		if err != nil {
+			klog.Fatalf("Failed to set up upstream TLS connection: %v", err)
+		}
+
+		if cfg.tls.upstreamSPIFFE {
+			t := upstreamTransport.(*http.Transport)
+			if t.TLSClientConfig == nil {
+				t.TLSClientConfig = &tls.Config{}
+			}
+			t.TLSClientConfig.GetClientCertificate = spiffeSource.GetClientCertificate
+		}
+
+		if cfg.tls.fips {
+			t := upstreamTransport.(*http.Transport)
+			if t.TLSClientConfig == nil {
+				t.TLSClientConfig = &tls.Config{}
+			}
+			if err := rbac_proxy_tls.ApplyFIPS(t.TLSClientConfig); err != nil {
+				klog.Fatalf("Failed to restrict upstream TLS to FIPS: %v", err)
+			}
+		}
+
+		upstreamTransport, err = initUpstreamTransport(upstreamTransport, cfg.upstreamProtocol)
+		if err != nil {
+			klog.Fatalf("Failed to set up upstream transport: %v", err)
+		}
+		if c, ok := upstreamTransport.(idleConnectionsCloser); ok {
+			upstreamTransports = append(upstreamTransports, c)
+		}
+		if cfg.prewarmConnections {
+			prewarmTargets = append(prewarmTargets, prewarm.HTTPTarget(upstreamURL.String(), upstreamTransport, upstreamURL))
+		}
+		return &timingTransport{next: withRetries(metrics.InstrumentUpstreamConnections(upstreamTransport), retries)}
+	}
+
+	var saLabels *metrics.ServiceAccountLabels
+	if cfg.metricsSALimit > 0 {
+		saLabels = metrics.NewServiceAccountLabels(cfg.metricsSALimit)
+	}
+
+	copyBuffers := newBufferPool(cfg.upstreamCopyBufferSize)
+	newProxyHandler := func(route string, upstreamURL *url.URL, caFile string, auth proxyAuthenticator, behavior proxyBehavior) http.Handler {
+		proxy := httputil.NewSingleHostReverseProxy(upstreamURL)
+		proxy.Transport = newUpstreamTransport(upstreamURL, caFile, behavior.retries)
+		proxy.FlushInterval = behavior.flushInterval
+		proxy.BufferPool = copyBuffers
+		withErrorResponse(proxy)
+		withResponseSizeLimit(proxy, route, behavior.maxResponseBodyBytes)
+		if cfg.upstreamAuthPassthrough {
+			withAuthChallengePassthrough(proxy)
+		}
+
+		var handler http.Handler = proxy
+		handler = filters.WithTimeout(handler, behavior.timeout)
+		handler = filters.WithMaxBodySize(handler, behavior.maxRequestBodyBytes)
+		handler = protectedHandler(auth, handler, cfg.allowPaths, cfg.ignorePaths)
+		handler = filters.WithServiceAccountAccounting(handler, route, saLabels)
+		handler = filters.WithSizeAccounting(handler, route)
+		handler = filters.WithDurationAccounting(handler, route)
+		return filters.WithRoute(handler, route)
+	}
+
+	var defaultHandler http.Handler = newProxyHandler(defaultRoute, upstreamURL, cfg.upstreamCAFile, auth, cfg.proxyBehavior)
+	upstreamURLs := []*url.URL{upstreamURL}
+	if len(cfg.routes) > 0 {
+		paths := routing.NewPaths(defaultHandler)
+		for _, r := range cfg.routes {
+			routeUpstreamURL, err := url.Parse(r.Upstream)
+			if err != nil {
+				klog.Fatalf("Failed to parse upstream URL of route %q: %v", r.Name, err)
+			}
+			upstreamURLs = append(upstreamURLs, routeUpstreamURL)
+
+			routeCfg := cfg.auth
+			routeCfg.Authorization = authorizationConfig(r.Authorization, cfg.auth.Authorization)
+			routeAuthn := *cfg.auth.Authentication
+			header, token := *routeAuthn.Header, *routeAuthn.Token
+			routeAuthn.Header, routeAuthn.Token = &header, &token
+			routeCfg.Authentication = &routeAuthn
+
+			routeAuthenticator := authenticator
+			if r.Authentication != nil {
+				// The conversion of valid configs doesn't fail.
+				_ = v1alpha1.Convert_v1alpha1_RouteAuthentication_To_authn_AuthnConfig(r.Authentication, &routeAuthn, nil)
+				if r.Authentication.TokenAudiences != nil && routeAuthn.OIDC.IssuerURL == "" {
+					routeAuthenticator, err = authn.NewDelegatingAuthenticator(kubeClient.AuthenticationV1().TokenReviews(), &routeAuthn)
+					if err != nil {
+						klog.Fatalf("Failed to instantiate delegating authenticator for route %q: %v", r.Name, err)
+					}
+				}
+			}
+
+			routeAuth := proxy.NewReloadable(routeCfg, authorizer, routeAuthenticator)
+			reloader.add(r.Name, routeCfg.Authentication, routeAuth)
+
+			klog.Infof("Routing path prefix %s to %s", r.PathPrefix, r.Upstream)
+			paths.Add(r.PathPrefix, newProxyHandler(r.Name, routeUpstreamURL, r.UpstreamCAFile, routeAuth, cfg.proxyBehavior.override(r.ProxyOverrides)))
+		}
+		defaultHandler = paths
+	}
+
+	hosts := routing.NewHosts(defaultHandler)
+	sniCerts := map[string]v1alpha1.Host{}
+	sniClientCAs := map[string]string{}
+	for _, h := range cfg.hosts {
+		hostUpstreamURL, err := url.Parse(h.Upstream)
+		if err != nil {
+			klog.Fatalf("Failed to parse upstream URL of host %q: %v", h.Host, err)
+		}
+		upstreamURLs = append(upstreamURLs, hostUpstreamURL)
+
+		hostCfg := cfg.auth
+		hostCfg.Authorization = authorizationConfig(h.Authorization, cfg.auth.Authorization)
+
+		hostAuthenticator := authenticator
+		if h.ClientCAFile != "" && cfg.auth.Authentication.OIDC.IssuerURL == "" {
+			hostAuthn := *cfg.auth.Authentication
+			hostAuthn.X509 = &authn.X509Config{ClientCAFile: h.ClientCAFile}
+			hostCfg.Authentication = &hostAuthn
+
+			hostAuthenticator, err = authn.NewDelegatingAuthenticator(kubeClient.AuthenticationV1().TokenReviews(), hostCfg.Authentication)
+			if err != nil {
+				klog.Fatalf("Failed to instantiate delegating authenticator for host %q: %v", h.Host, err)
+			}
+		}
+
+		// Every host gets its own proxy, so its authorization can be
+		// reloaded independently of the default route.
+		hostAuth := proxy.NewReloadable(hostCfg, authorizer, hostAuthenticator)
+		reloader.add(h.Host, hostCfg.Authentication, hostAuth)
+
+		klog.Infof("Routing host %s to %s", h.Host, h.Upstream)
+		hosts.Add(h.Host, newProxyHandler(h.Host, hostUpstreamURL, h.UpstreamCAFile, hostAuth, cfg.proxyBehavior.override(h.ProxyOverrides)))
+
+		if h.TLSCertFile != "" || h.TLSKeyFile != "" {
+			sniCerts[h.Host] = h
+		}
+		if h.ClientCAFile != "" {
+			sniClientCAs[h.Host] = h.ClientCAFile
+		}
+	}
+	startupCheck(startup.Upstream, upstreamDNSCheck(upstreamURLs))
+
+	cfg.audit.LogRotation.MaxSize = int64(cfg.auditLogMaxSize) * 1024 * 1024
+	cfg.audit.LogRotation.MaxAge = time.Duration(cfg.auditLogMaxAge) * 24 * time.Hour
+	auditBackend, err := audit.NewBackend(cfg.audit)
+	if err != nil {
+		klog.Fatalf("Failed to set up auditing: %v", err)
+	}
+
+	var failureRecorder *events.FailureRecorder
+	if cfg.failureEvents.Threshold > 0 {
+		failureRecorder, err = events.NewFailureRecorder(kubeClient, cfg.failureEvents)
+		if err != nil {
+			klog.Fatalf("Failed to set up failure events: %v", err)
+		}
+		defer failureRecorder.Shutdown()
+	}
+
+	var decisionLogger *decisionlog.Logger
+	if cfg.decisionLog.URL != "" {
+		cfg.decisionLog.RetryBackoff = time.Second
+		decisionLogger = decisionlog.NewLogger(cfg.decisionLog, &http.Client{Timeout: 30 * time.Second})
+	}
+
+	var proxyHandler http.Handler = hosts
+	proxyHandler = decisionlog.WithDecisionLogs(proxyHandler, decisionLogger)
+	proxyHandler = events.WithFailureEvents(proxyHandler, failureRecorder)
+	proxyHandler = logging.WithSampledLogging(proxyHandler, cfg.logSampling)
+	proxyHandler = logging.WithSlowRequestLogging(proxyHandler, cfg.logSlowRequests)
+	proxyHandler = audit.WithAudit(proxyHandler, auditBackend)
+	if cfg.metricsExemplars {
+		proxyHandler = filters.WithTraceExemplars(proxyHandler)
+	}
+	proxyHandler = filters.WithHijackAccounting(proxyHandler)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", proxyHandler)
+	drainer := &filters.Drainer{}
+	handler := drainer.WithDraining(filters.WithRequestID(filters.WithMaxInFlightLimit(mux, cfg.inFlight)))
+
+	// serversDone is closed once the proxy servers shut down, from then on
+	// no new requests are tracked by drainer.
+	var serversShutdown sync.WaitGroup
+	serversDone := make(chan struct{})
+	// waitDrained blocks until the proxy servers shut down and their
+	// remaining requests finished.
+	waitDrained := func() {
+		<-serversDone
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.server.drainTimeout)
+		defer cancel()
+		_ = drainer.Wait(ctx)
+	}
+
+	if cfg.configFile != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		gr.Add(func() error {
+			return rbac_proxy_config.Watch(ctx, cfg.configFile, cfg.fileContent, cfg.configReloadInterval, reloader.reload)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if cfg.configObject != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		gr.Add(func() error {
+			return rbac_proxy_config.WatchObject(ctx, dynamicClient, configNamespace, configObjName, cfg.fileContent, reloader.reload)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if cfg.configConfigMap != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		gr.Add(func() error {
+			return rbac_proxy_config.WatchConfigMap(ctx, kubeClient, configMapKey, cfg.fileContent, reloader.reload)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if cfg.prewarmConnections {
+		prewarmTargets = append(prewarmTargets, prewarm.KubeAPIServerTarget(kubeClient.Discovery().RESTClient()))
+		ctx, cancel := context.WithCancel(context.Background())
+		prewarmer := prewarm.New(cfg.prewarmInterval, prewarmTargets...)
+		gr.Add(func() error {
+			return prewarmer.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if cfg.watchdog.Interval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		wd := watchdog.New(cfg.watchdog)
+		gr.Add(func() error {
+			return wd.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if cfg.profiling.ServerURL != "" {
+		pusher, err := profiling.NewPusher(cfg.profiling, &http.Client{})
+		if err != nil {
+			klog.Fatalf("Failed to set up continuous profiling: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		gr.Add(func() error {
+			return pusher.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if cfg.statsd.Address != "" {
+		pusher, err := statsd.NewPusher(cfg.statsd, metrics.Registry)
+		if err != nil {
+			klog.Fatalf("Failed to set up statsd metrics: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		gr.Add(func() error {
+			return pusher.Run(ctx)
+		}, func(error) {
+			// Push the metrics of requests completed while draining.
+			go func() {
+				waitDrained()
+				cancel()
+			}()
+		})
+	}
+
+	if decisionLogger != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		gr.Add(func() error {
+			return decisionLogger.Run(ctx)
+		}, func(error) {
+			// Ship the decisions of requests completed while draining.
+			go func() {
+				waitDrained()
+				cancel()
+			}()
+		})
+	}
+
+	if auditBackend != nil {
+		stopCh := make(chan struct{})
+		if err := auditBackend.Run(stopCh); err != nil {
+			klog.Fatalf("Failed to start audit backend: %v", err)
+		}
+
+		done := make(chan struct{})
+		gr.Add(func() error {
+			<-done
+			return nil
+		}, func(error) {
+			// Deliver the events of requests completed while draining.
+			go func() {
+				defer close(done)
+				waitDrained()
+				close(stopCh)
+				auditBackend.Shutdown()
+			}()
+		})
+	}
+
+	listenerSet, err := sockopt.NewListeners(cfg.listenSockopts)
+	if err != nil {
+		klog.Fatalf("Failed to inherit listeners: %v", err)
+	}
+
+	{
+		if len(cfg.secureListenAddresses) > 0 {
+			srv := newServer(cfg.server, "secure", handler)
+			srv.TLSConfig = &tls.Config{}
+
+			if cfg.tls.spiffe {
+				srv.TLSConfig.GetCertificate = spiffeSource.GetCertificate
+			} else if len(cfg.tls.acme.Domains) > 0 {
+				klog.Infof("Obtaining certificate for %v via ACME", cfg.tls.acme.Domains)
+				m, err := rbac_proxy_tls.NewACMEManager(cfg.tls.acme)
+				if err != nil {
+					klog.Fatalf("Failed to initialize ACME: %v", err)
+				}
+
+				srv.TLSConfig.GetCertificate = m.GetCertificate
+				srv.TLSConfig.NextProtos = append(srv.TLSConfig.NextProtos, acme.ALPNProto)
+
+				ctx, cancel := context.WithCancel(context.Background())
+				gr.Add(func() error {
+					return m.Run(ctx)
+				}, func(error) {
+					cancel()
+				})
+
+				if cfg.tls.acmeHTTP01ListenAddr != "" && cfg.tls.acme.DNS01WebhookURL == "" {
+					challengeSrv := newServer(cfg.server, "acme", m.HTTPHandler())
+					l, err := listenerSet.Listen(cfg.tls.acmeHTTP01ListenAddr)
+					if err != nil {
+						klog.Fatalf("Failed to listen on ACME HTTP-01 address: %v", err)
+					}
+
+					gr.Add(func() error {
+						klog.Infof("Answering ACME HTTP-01 challenges on %v", cfg.tls.acmeHTTP01ListenAddr)
+						if err := challengeSrv.Serve(l); err != http.ErrServerClosed {
+							return err
+						}
+						return nil
+					}, func(error) {
+						if err := challengeSrv.Close(); err != nil {
+							klog.Errorf("failed to close ACME HTTP-01 server: %v", err)
+						}
+					})
+				}
+			} else if cfg.tls.secret != "" {
+				klog.Infof("Reading certificate from secret %s", cfg.tls.secret)
+				ctx, cancel := context.WithCancel(context.Background())
+				sc, err := rbac_proxy_tls.NewSecretCertificate(kubeClient, cfg.tls.secret)
+				if err != nil {
+					klog.Fatalf("Failed to read certificate from secret: %v", err)
+				}
+
+				srv.TLSConfig.GetCertificate = sc.GetCertificate
+
+				gr.Add(func() error {
+					return sc.Run(ctx)
+				}, func(error) {
+					cancel()
+				})
+			} else if cfg.tls.keyURI != "" {
+				klog.Infof("Reading certificate file with private key from %s provider", strings.SplitN(cfg.tls.keyURI, ":", 2)[0])
+				cert, err := rbac_proxy_tls.NewSignerCertificate(cfg.tls.certFile, cfg.tls.keyURI)
+				if err != nil {
+					klog.Fatalf("Failed to load certificate with private key URI: %v", err)
+				}
+
+				srv.TLSConfig.Certificates = []tls.Certificate{*cert}
+			} else if cfg.tls.certFile == "" && cfg.tls.keyFile == "" {
+				klog.Info("Generating self signed cert as no cert is provided")
+				if len(cfg.tls.selfSigned.Hosts) == 0 {
+					host, err := os.Hostname()
+					if err != nil {
+						klog.Fatalf("Failed to retrieve hostname for self-signed cert: %v", err)
+					}
+					cfg.tls.selfSigned.Hosts = []string{host}
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				ss, err := rbac_proxy_tls.NewSelfSignedCertificate(kubeClient, cfg.tls.selfSigned)
+				if err != nil {
+					klog.Fatalf("Failed to generate self signed cert and key: %v", err)
+				}
+
+				srv.TLSConfig.GetCertificate = ss.GetCertificate
+
+				gr.Add(func() error {
+					return ss.Run(ctx)
+				}, func(error) {
+					cancel()
+				})
+			} else {
+				klog.Info("Reading certificate files")
+				ctx, cancel := context.WithCancel(context.Background())
+				var r *rbac_proxy_tls.CertReloader
+				if cfg.tls.waitForCert {
+					r = rbac_proxy_tls.NewPendingCertReloader(cfg.tls.certFile, cfg.tls.keyFile, cfg.tls.reloadInterval)
+				} else {
+					r, err = rbac_proxy_tls.NewCertReloader(cfg.tls.certFile, cfg.tls.keyFile, cfg.tls.reloadInterval)
+					if err != nil {
+						klog.Fatalf("Failed to initialize certificate reloader: %v", err)
+					}
+				}
+
+				srv.TLSConfig.GetCertificate = r.GetCertificate
+
+				gr.Add(func() error {
+					return r.Watch(ctx)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			// The default certificate is reported for an empty server name,
+			// ACME certificates for the names they are obtained for.
+			servingNames := []string{""}
+			if len(cfg.tls.acme.Domains) > 0 {
+				servingNames = cfg.tls.acme.Domains
+			}
+			if len(sniCerts) > 0 || len(cfg.tls.sniCertKeys) > 0 {
+				sni := rbac_proxy_tls.NewSNICertificates(srv.TLSConfig.GetCertificate)
+				for _, nkc := range cfg.tls.sniCertKeys {
+					klog.Infof("Reading SNI certificate files %s", nkc.CertFile)
+					ctx, cancel := context.WithCancel(context.Background())
+					r, err := rbac_proxy_tls.NewCertReloader(nkc.CertFile, nkc.KeyFile, cfg.tls.reloadInterval)
+					if err != nil {
+						klog.Fatalf("Failed to initialize certificate reloader for %q: %v", nkc.CertFile, err)
+					}
+
+					names := nkc.Names
+					if len(names) == 0 {
+						cert, _ := r.GetCertificate(nil)
+						names, err = rbac_proxy_tls.CertificateNames(cert)
+						if err != nil {
+							klog.Fatalf("Failed to extract names of certificate %q: %v", nkc.CertFile, err)
+						}
+					}
+					for _, name := range names {
+						sni.Add(name, r.GetCertificate)
+					}
+
+					gr.Add(func() error {
+						return r.Watch(ctx)
+					}, func(error) {
+						cancel()
+					})
+				}
+
+				// Certificates of virtual hosts take precedence.
+				for host, h := range sniCerts {
+					klog.Infof("Reading certificate files for host %s", host)
+					ctx, cancel := context.WithCancel(context.Background())
+					r, err := rbac_proxy_tls.NewCertReloader(h.TLSCertFile, h.TLSKeyFile, cfg.tls.reloadInterval)
+					if err != nil {
+						klog.Fatalf("Failed to initialize certificate reloader for host %q: %v", host, err)
+					}
+
+					sni.Add(host, r.GetCertificate)
+
+					gr.Add(func() error {
+						return r.Watch(ctx)
+					}, func(error) {
+						cancel()
+					})
+				}
+				srv.TLSConfig.GetCertificate = sni.GetCertificate
+				servingNames = append(servingNames, sni.Names()...)
+			}
+
+			if cfg.tls.ocspStapling {
+				getCertificate := srv.TLSConfig.GetCertificate
+				if getCertificate == nil {
+					static := srv.TLSConfig.Certificates[0]
+					getCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+						return &static, nil
+					}
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				stapler := rbac_proxy_tls.NewOCSPStapler(getCertificate)
+				srv.TLSConfig.GetCertificate = stapler.GetCertificate
+
+				gr.Add(func() error {
+					return stapler.Run(ctx)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			if cfg.tls.waitForCert && srv.TLSConfig.GetCertificate != nil {
+				hello := &tls.ClientHelloInfo{}
+				if len(cfg.tls.acme.Domains) > 0 {
+					hello.ServerName = cfg.tls.acme.Domains[0]
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				getCertificate := srv.TLSConfig.GetCertificate
+				gr.Add(func() error {
+					return waitForCertificate(ctx, getCertificate, hello, readiness)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			getServingCertificate := srv.TLSConfig.GetCertificate
+			if getServingCertificate == nil {
+				static := srv.TLSConfig.Certificates[0]
+				getServingCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+					return &static, nil
+				}
+			}
+			clientCerts := metrics.NewClientCertificates(clientCertificateWindow)
+			srv.TLSConfig.VerifyConnection = clientCerts.VerifyConnection
+			metrics.Registry.MustRegister(
+				metrics.NewServingCertificates(getServingCertificate, servingNames),
+				clientCerts,
+			)
+
+			minVersion, maxVersion, err := rbac_proxy_tls.VersionRange(cfg.tls.minVersion, cfg.tls.maxVersion)
+			if err != nil {
+				klog.Fatalf("TLS version invalid: %v", err)
+			}
+
+			cipherSuiteIDs, err := k8sapiflag.TLSCipherSuites(cfg.tls.cipherSuites)
+			if err != nil {
+				klog.Fatalf("Failed to convert TLS cipher suite name to ID: %v", err)
+			}
+
+			curveIDs, err := rbac_proxy_tls.CurvePreferences(cfg.tls.curvePreferences)
+			if err != nil {
+				klog.Fatalf("Failed to convert TLS curve name to ID: %v", err)
+			}
+
+			clientAuth := cfg.tls.clientAuth
+			if clientAuth == "" {
+				clientAuth = "NoClientCert"
+				if cfg.auth.Authentication.X509.ClientCAFile != "" {
+					clientAuth = "RequestClientCert"
+				}
+			}
+			if err := configureClientAuth(srv.TLSConfig, clientAuth, cfg.auth.Authentication.X509.ClientCAFile); err != nil {
+				klog.Fatalf("Failed to configure client certificate policy: %v", err)
+			}
+
+			srv.TLSConfig.CipherSuites = cipherSuiteIDs
+			srv.TLSConfig.CurvePreferences = curveIDs
+			srv.TLSConfig.MinVersion = minVersion
+			srv.TLSConfig.MaxVersion = maxVersion
+
+			if cfg.tls.fips {
+				if err := rbac_proxy_tls.ApplyFIPS(srv.TLSConfig); err != nil {
+					klog.Fatalf("TLS options are not FIPS compliant: %v", err)
+				}
+			}
+
+			if cfg.server.http2Disable {
+				srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+			} else if err := http2.ConfigureServer(srv, newHTTP2Server(cfg.server)); err != nil {
+				klog.Fatalf("failed to configure http2 server: %v", err)
+			}
+
+			if len(sniClientCAs) > 0 {
+				// The base config is copied for each host on the first
+				// handshake, including the protocols added by ConfigureServer.
+				clientCAs := rbac_proxy_tls.NewSNIClientCAs(srv.TLSConfig)
+				for host, caFile := range sniClientCAs {
+					pool, err := certutil.NewPool(caFile)
+					if err != nil {
+						klog.Fatalf("Failed to load client CAs for host %q: %v", host, err)
+					}
+					clientCAs.Add(host, pool)
+				}
+				srv.TLSConfig.GetConfigForClient = clientCAs.GetConfigForClient
+			}
+
+			listeners := make([]net.Listener, 0, len(cfg.secureListenAddresses))
+			for _, addr := range cfg.secureListenAddresses {
+				klog.Infof("Starting TCP socket on %v", addr)
+				l, err := listenerSet.Listen(addr)
+				if err != nil {
+					klog.Fatalf("failed to listen on secure address: %v", err)
+				}
+				listeners = append(listeners, l)
+			}
+
+			shutdown := make(chan struct{})
+			var shutdownOnce sync.Once
+			serversShutdown.Add(1)
+			for i := range listeners {
+				addr, l := cfg.secureListenAddresses[i], listeners[i]
+				gr.Add(func() error {
+					klog.Infof("Listening securely on %v", addr)
+					tlsListener := tls.NewListener(l, srv.TLSConfig)
+					if err := srv.Serve(tlsListener); err != http.ErrServerClosed {
+						return err
+					}
+					<-shutdown
+					return nil
+				}, func(err error) {
+					// Drain all servers concurrently, interrupt functions are called sequentially.
+					shutdownOnce.Do(func() {
+						go func() {
+							defer serversShutdown.Done()
+							defer close(shutdown)
+							shutdownServer(srv, drainer, cfg.server.drainTimeout)
+							for _, l := range listeners {
+								if err := l.Close(); err != nil {
+									klog.Errorf("failed to gracefully close secure listener: %v", err)
+								}
+							}
+						}()
+					})
+				})
+			}
+		}
+	}
+	{
+		if cfg.insecureListenAddress != "" {
+			insecureHandler := handler
+			if !cfg.server.http2Disable {
+				insecureHandler = h2c.NewHandler(handler, newHTTP2Server(cfg.server))
+			}
+			srv := newServer(cfg.server, "insecure", insecureHandler)
+
+			l, err := listenerSet.Listen(cfg.insecureListenAddress)
+			if err != nil {
+				klog.Fatalf("Failed to listen on insecure address: %v", err)
+			}
+
+			if !isLoopbackListener(l) {
+				if !cfg.insecureAllowNonLoopback {
+					klog.Fatalf("Insecure listen address %v is not a loopback address, set --insecure-listen-allow-non-loopback to listen on it anyway.", cfg.insecureListenAddress)
+				}
+				klog.Warningf("Listening insecurely on non-loopback address %v, requests are not encrypted", cfg.insecureListenAddress)
+			}
+
+			shutdown := make(chan struct{})
+			serversShutdown.Add(1)
+			gr.Add(func() error {
+				klog.Infof("Listening insecurely on %v", cfg.insecureListenAddress)
+				if err := srv.Serve(l); err != http.ErrServerClosed {
+					return err
+				}
+				<-shutdown
+				return nil
+			}, func(err error) {
+				go func() {
+					defer serversShutdown.Done()
+					defer close(shutdown)
+					shutdownServer(srv, drainer, cfg.server.drainTimeout)
+					if err := l.Close(); err != nil {
+						klog.Errorf("failed to gracefully close listener: %v", err)
+					}
+				}()
+			})
+		}
+	}
+	verbosity, err := logging.NewVerbosity(cfg.klogFlags, cfg.debug.verbosity)
+	if err != nil {
+		klog.Fatalf("Failed to set up runtime verbosity changes: %v", err)
+	}
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		gr.Add(func() error {
+			return verbosity.ToggleOnSignal(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		gr.Add(func() error {
+			return listenerSet.HandoffOnSignal(ctx, cfg.server.handoffTimeout)
+		}, func(error) {
+			cancel()
+		})
+	}
+	if cfg.health.listenAddress != "" {
+		livez := health.NewChecks(cfg.health.checkTimeout)
+		livez.Add("ping", health.Ping)
+
+		readyz := health.NewChecks(cfg.health.checkTimeout)
+		readyz.Add("ping", health.Ping)
+		readyz.Add("conditions", readiness.CheckFunc())
+		readyz.Add("kube-apiserver", kubeAPIServerCheck(kubeClient.AuthorizationV1().SubjectAccessReviews()))
+		if connectivity != nil {
+			readyz.Add("kube-apiserver-connectivity", connectivity.Check)
+		}
+		if cfg.health.upstreamCheck {
+			readyz.Add("upstream", upstreamCheck(upstreamURLs))
+		}
+		for _, name := range cfg.health.readyzExclude {
+			if !sets.NewString(readyz.Names()...).Has(name) {
+				klog.Fatalf("Unknown check %q in --readyz-exclude, must be one of %v.", name, readyz.Names())
+			}
+		}
+		readyz.Exclude(cfg.health.readyzExclude...)
+
+		healthMux := http.NewServeMux()
+		for _, path := range []string{"/healthz", "/livez"} {
+			healthMux.Handle(path, livez.Handler(path))
+			healthMux.Handle(path+"/", livez.Handler(path))
+		}
+		healthMux.Handle("/readyz", readyz.Handler("/readyz"))
+		healthMux.Handle("/readyz/", readyz.Handler("/readyz"))
+		healthMux.Handle("/version", versionHandler())
+		if cfg.metricsPrometheus {
+			healthMux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{EnableOpenMetrics: cfg.metricsExemplars}))
+		}
+
+		if cfg.debug.endpoints {
+			// Without resource attributes the request path is authorized as non-resource URL.
+			debugAuth, err := proxy.New(kubeClient, proxy.Config{
+				Authentication: cfg.auth.Authentication,
+				Authorization:  &authz.Config{NonResourceURL: cfg.debug.nonResourceURL},
+				LatencyBudget:  cfg.auth.LatencyBudget,
+			}, authorizer, authenticator)
+			if err != nil {
+				klog.Fatalf("Failed to create rbac-proxy for debug endpoints: %v", err)
+			}
+
+			debugMux := http.NewServeMux()
+			debugMux.HandleFunc("/debug/pprof/", pprof.Index)
+			debugMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+			debugMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+			debugMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+			debugMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+			debugMux.Handle("/debug/config", configHandler(flagset, cfg.file))
+			debugMux.Handle("/debug/flags", flagsHandler(flagset))
+			debugMux.Handle("/debug/flags/v", verbosity)
+			healthMux.Handle("/debug/", protectedHandler(debugAuth, debugMux, nil, nil))
+		}
+
+		srv := newServer(cfg.server, "health", healthMux)
+
+		l, err := listenerSet.Listen(cfg.health.listenAddress)
+		if err != nil {
+			klog.Fatalf("Failed to listen on health address: %v", err)
+		}
+
+		if cfg.health.certFile != "" || cfg.health.keyFile != "" {
+			ctx, cancel := context.WithCancel(context.Background())
+			r, err := rbac_proxy_tls.NewCertReloader(cfg.health.certFile, cfg.health.keyFile, cfg.tls.reloadInterval)
+			if err != nil {
+				klog.Fatalf("Failed to initialize health certificate reloader: %v", err)
+			}
+
+			srv.TLSConfig = &tls.Config{
+				GetCertificate: r.GetCertificate,
+				MinVersion:     tls.VersionTLS12,
+			}
+			if err := configureClientAuth(srv.TLSConfig, cfg.health.clientAuth, cfg.auth.Authentication.X509.ClientCAFile); err != nil {
+				klog.Fatalf("Failed to configure health client certificate policy: %v", err)
+			}
+			if cfg.tls.fips {
+				if err := rbac_proxy_tls.ApplyFIPS(srv.TLSConfig); err != nil {
+					klog.Fatalf("Health TLS options are not FIPS compliant: %v", err)
+				}
+			}
+			l = tls.NewListener(l, srv.TLSConfig)
+
+			gr.Add(func() error {
+				return r.Watch(ctx)
+			}, func(error) {
+				cancel()
+			})
+		}
+
+		shutdown := make(chan struct{})
+		gr.Add(func() error {
+			klog.Infof("Serving health and metrics on %v", cfg.health.listenAddress)
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				return err
+			}
+			<-shutdown
+			return nil
+		}, func(error) {
+			// Keep answering probes while the proxy listeners drain.
+			go func() {
+				defer close(shutdown)
+				waitDrained()
+				if err := srv.Close(); err != nil {
+					klog.Errorf("failed to close health server: %v", err)
+				}
+			}()
+		})
+	}
+	// sig stays registered until the listeners are drained, so further
+	// signals can't interrupt draining. Closing it instead would make the
+	// runtime panic sending them.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	{
+		done := make(chan struct{})
+		gr.Add(func() error {
+			select {
+			case <-sig:
+				klog.Info("received interrupt, shutting down")
+			case <-winService.Stopping():
+				klog.Info("received Windows service stop request, shutting down")
+			case <-done:
+				return nil
+			}
+			readiness.Set("shutdown", errors.New("shutting down"))
+			if cfg.server.shutdownDelay > 0 {
+				klog.Infof("Waiting %v for endpoints to be deregistered before draining", cfg.server.shutdownDelay)
+				select {
+				case <-time.After(cfg.server.shutdownDelay):
+				case <-sig:
+				case <-done:
+				}
+			}
+			return nil
+		}, func(err error) {
+			close(done)
+		})
+	}
+
+	go func() {
+		serversShutdown.Wait()
+		close(serversDone)
+	}()
+
+	listenerSet.Ready()
+	err = gr.Run()
+	signal.Stop(sig)
+	for _, t := range upstreamTransports {
+		t.CloseIdleConnections()
+	}
+	winService.Stopped(err)
+	if err != nil {
+		klog.Fatalf("failed to run groups: %v", err)
+	}
 }
 
-type configfile struct {
-	AuthorizationConfig *authz.Config `json:"authorization,omitempty"`
+// windowsServiceName returns the value of --windows-service-name in args,
+// which is needed before the other flags are parsed.
+func windowsServiceName(args []string) string {
+	name := ""
+	fs := pflag.NewFlagSet("windows-service", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&name, "windows-service-name", "", "")
+	_ = fs.Parse(args)
+	return name
 }
 
-var versions = map[string]uint16{
-	"VersionTLS10": tls.VersionTLS10,
-	"VersionTLS11": tls.VersionTLS11,
-	"VersionTLS12": tls.VersionTLS12,
+// idleConnectionsCloser is implemented by the HTTP/1 and HTTP/2 transports,
+// and the upstream transports of initUpstreamTransport wrapping them.
+type idleConnectionsCloser interface {
+	CloseIdleConnections()
 }
 
-func tlsVersion(versionName string) (uint16, error) {
-	if version, ok := versions[versionName]; ok {
-		return version, nil
+// authorizationConfig returns the authorization config c, if set, replacing
+// the settings of base the config file can set.
+func authorizationConfig(c *v1alpha1.AuthorizationConfig, base *authz.Config) *authz.Config {
+	if c == nil {
+		return base
 	}
-	return 0, fmt.Errorf("unknown tls version %q", versionName)
+	out := *base
+	// The conversion of valid configs doesn't fail.
+	_ = v1alpha1.Convert_v1alpha1_AuthorizationConfig_To_authz_Config(c, &out, nil)
+	return &out
 }
 
-func main() {
-	cfg := config{
+// newConfig returns a config whose flags can be added by newFlagSet.
+func newConfig() *config {
+	return &config{
 		auth: proxy.Config{
 			Authentication: &authn.AuthnConfig{
 				X509:   &authn.X509Config{},
@@ -101,130 +1325,296 @@ func main() {
 			Authorization: &authz.Config{},
 		},
 	}
-	configFileName := ""
+}
+
+// parseConfig parses the flags in args, and the config file they name.
+func parseConfig(args []string) (*config, *pflag.FlagSet, error) {
+	cfg := newConfig()
+	flagset := newFlagSet(os.Args[0], cfg)
+	if err := flagset.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg.cmdlineFlags = sets.NewString()
+	flagset.Visit(func(f *pflag.Flag) {
+		cfg.cmdlineFlags.Insert(f.Name)
+	})
+
+	if cfg.configFile == "" {
+		// The profile is applied with the config object or ConfigMap otherwise.
+		if cfg.configObject == "" && cfg.configConfigMap == "" {
+			if err := cfg.applyProfile(flagset); err != nil {
+				return nil, nil, err
+			}
+		}
+		return cfg, flagset, nil
+	}
+
+	klog.Infof("Reading config file: %s", cfg.configFile)
+	b, err := ioutil.ReadFile(cfg.configFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := cfg.applyFile(flagset, b); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, flagset, nil
+}
+
+// applyFile applies the config file content b to cfg and the flags which
+// weren't set on the command line.
+func (cfg *config) applyFile(flagset *pflag.FlagSet, b []byte) error {
+	cfg.fileContent = b
+
+	expanded, err := rbac_proxy_config.Expand(b)
+	if err != nil {
+		return fmt.Errorf("invalid config file: %v", err)
+	}
+	if err := yaml.Unmarshal(expanded, &cfg.file); err != nil {
+		return fmt.Errorf("failed to parse config file content: %v", err)
+	}
+	// The content of the file is validated with the flags by cfg.validate.
+	if err := v1alpha1.ValidateVersion(&cfg.file); err != nil {
+		return fmt.Errorf("invalid config file: %v", err)
+	}
+	v1alpha1.SetDefaults_KubeRBACProxyConfig(&cfg.file)
+	// Flags set on the command line take precedence over the file.
+	if err := rbac_proxy_config.Apply(&cfg.file.Options, flagset); err != nil {
+		return fmt.Errorf("failed to apply config file: %v", err)
+	}
+	if err := cfg.applyProfile(flagset); err != nil {
+		return err
+	}
+
+	cfg.auth.Authorization = authorizationConfig(cfg.file.Authorization, cfg.auth.Authorization)
+	cfg.hosts = cfg.file.Hosts
+	cfg.routes = cfg.file.Routes
+
+	return nil
+}
+
+// applyProfile applies the defaults of the profile to the flags which
+// weren't set on the command line or in the config file.
+func (cfg *config) applyProfile(flagset *pflag.FlagSet) error {
+	if cfg.profile == "" {
+		return nil
+	}
+	return rbac_proxy_config.ApplyProfile(flagset, cfg.profile)
+}
 
+// newFlagSet returns the flags of kube-rbac-proxy, setting cfg.
+func newFlagSet(name string, cfg *config) *pflag.FlagSet {
 	// Add klog flags
-	klogFlags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-	klog.InitFlags(klogFlags)
+	cfg.klogFlags = flag.NewFlagSet(name, flag.ExitOnError)
+	klog.InitFlags(cfg.klogFlags)
 
-	flagset := pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
-	flagset.AddGoFlagSet(klogFlags)
+	flagset := pflag.NewFlagSet(name, pflag.ExitOnError)
+	flagset.AddGoFlagSet(cfg.klogFlags)
 
 	// kube-rbac-proxy flags
-	flagset.StringVar(&cfg.insecureListenAddress, "insecure-listen-address", "", "The address the kube-rbac-proxy HTTP server should listen on.")
-	flagset.StringVar(&cfg.secureListenAddress, "secure-listen-address", "", "The address the kube-rbac-proxy HTTPs server should listen on.")
+	flagset.StringVar(&cfg.insecureListenAddress, "insecure-listen-address", "", "The address the kube-rbac-proxy HTTP server should listen on. It must be a loopback address, e.g. to receive requests from a sidecar terminating TLS, unless --insecure-listen-allow-non-loopback is set. Accepts \"fd:<name>\" like --secure-listen-address.")
+	flagset.BoolVar(&cfg.insecureAllowNonLoopback, "insecure-listen-allow-non-loopback", false, "Allow the HTTP server to listen on non-loopback addresses. Requests and tokens are then transferred in plaintext over the network.")
+	flagset.StringSliceVar(&cfg.secureListenAddresses, "secure-listen-address", nil, "The address the kube-rbac-proxy HTTPs server should listen on. Can be repeated to listen on several addresses, e.g. \"0.0.0.0:8443\" and \"[::]:8443\" for dual-stack. Addresses of the form \"fd:<name>\" use a listener passed via systemd socket activation, selected by its name or file descriptor number.")
 	flagset.StringVar(&cfg.upstream, "upstream", "", "The upstream URL to proxy to once requests have successfully been authenticated and authorized.")
-	flagset.BoolVar(&cfg.upstreamForceH2C, "upstream-force-h2c", false, "Force h2c to communiate with the upstream. This is required when the upstream speaks h2c(http/2 cleartext - insecure variant of http/2) only. For example, go-grpc server in the insecure mode, such as helm's tiller w/o TLS, speaks h2c only")
+	flagset.BoolVar(&cfg.upstreamForceH2C, "upstream-force-h2c", false, "Force h2c to communiate with the upstream. This is required when the upstream speaks h2c(http/2 cleartext - insecure variant of http/2) only. For example, go-grpc server in the insecure mode, such as helm's tiller w/o TLS, speaks h2c only. Equivalent to --upstream-protocol=http2")
+	flagset.StringVar(&cfg.upstreamProtocol, "upstream-protocol", upstreamProtocolALPN, "The protocol to speak to the upstream, one of \"alpn\", \"auto\", \"http1\" or \"http2\". With \"alpn\" HTTP/2 is negotiated via ALPN for TLS upstreams and HTTP/1.1 is spoken to cleartext upstreams. \"auto\" additionally probes cleartext upstreams for h2c support.")
 	flagset.StringVar(&cfg.upstreamCAFile, "upstream-ca-file", "", "The CA the upstream uses for TLS connection. This is required when the upstream uses TLS and its own CA certificate")
-	flagset.StringVar(&configFileName, "config-file", "", "Configuration file to configure kube-rbac-proxy.")
+	flagset.StringVar(&cfg.upstreamProxyURL, "upstream-proxy-url", "", "The URL of the HTTP proxy used for connections to the upstream. If omitted, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are honored.")
+	flagset.BoolVar(&cfg.upstreamAuthPassthrough, "upstream-auth-challenge-passthrough", false, "Pass 407 responses of the upstream including their Proxy-Authenticate headers through to the client, and the client's Proxy-Authorization header to the upstream. This is required for upstreams adding a second authentication layer.")
+	flagset.IntVar(&cfg.upstreamCopyBufferSize, "upstream-copy-buffer-size", 64*1024, "The size of the pooled buffers responses of the upstream are copied to the client with. Larger buffers need fewer reads and writes for large responses, e.g. of /metrics endpoints, at the cost of memory per concurrent request.")
+	flagset.DurationVar(&cfg.proxyBehavior.timeout, "upstream-timeout", 0, "The maximum duration of requests proxied to the upstream, including reading the response. Zero means no timeout.")
+	flagset.IntVar(&cfg.proxyBehavior.retries, "upstream-retries", 0, "The number of times idempotent requests without a body are retried if the upstream couldn't be reached.")
+	flagset.Int64Var(&cfg.proxyBehavior.maxRequestBodyBytes, "max-request-body-bytes", 0, "The maximum size of request bodies proxied to the upstream. Larger requests are rejected with 413. Zero means no limit.")
+	flagset.Int64Var(&cfg.proxyBehavior.maxResponseBodyBytes, "max-response-body-bytes", 0, "The maximum size of upstream responses. Larger responses are answered with 502, or terminated if their size isn't known upfront. Zero means no limit.")
+	flagset.DurationVar(&cfg.proxyBehavior.flushInterval, "upstream-flush-interval", 0, "The interval in which responses of the upstream are flushed to the client. A negative value flushes immediately after each write. Streaming responses are always flushed immediately.")
+	flagset.StringVar(&cfg.configFile, "config-file", "", "Configuration file to configure kube-rbac-proxy. Flags set on the command line take precedence over settings of the file.")
+	flagset.StringVar(&cfg.configObject, "config-object", "", "A KubeRBACProxyConfig object, given as namespace/name, whose spec configures kube-rbac-proxy like a config file. Changes of the object are applied like those of the config file. Only one of --config-file, --config-object and --config-configmap can be used.")
+	flagset.StringVar(&cfg.configConfigMap, "config-configmap", "", "A ConfigMap key, given as namespace/name/key, whose value configures kube-rbac-proxy like a config file. Changes of the ConfigMap are applied like those of the config file. Only one of --config-file, --config-object and --config-configmap can be used.")
+	flagset.DurationVar(&cfg.configReloadInterval, "config-file-reload-interval", 10*time.Second, "The interval to check the config file for changes in. Changes of the authorization and authentication header settings are applied to new requests without a restart, as on SIGHUP. Zero only reloads on SIGHUP.")
 	flagset.StringSliceVar(&cfg.allowPaths, "allow-paths", nil, "Comma-separated list of paths against which kube-rbac-proxy matches the incoming request. If the request doesn't match, kube-rbac-proxy responds with a 404 status code. If omitted, the incoming request path isn't checked. Cannot be used with --ignore-paths.")
 	flagset.StringSliceVar(&cfg.ignorePaths, "ignore-paths", nil, "Comma-separated list of paths against which kube-rbac-proxy will proxy without performing an authentication or authorization check. Cannot be used with --allow-paths.")
+	flagset.IntVar(&cfg.inFlight.MaxRequests, "max-inflight-requests", 0, "The maximum number of requests served concurrently. If --max-mutating-inflight-requests is set, this only limits non-mutating requests. Requests exceeding the limit are rejected with 429. Zero means no limit.")
+	flagset.IntVar(&cfg.inFlight.MaxMutatingRequests, "max-mutating-inflight-requests", 0, "The maximum number of mutating requests served concurrently. Requests exceeding the limit are rejected with 429. Zero means mutating requests share the --max-inflight-requests limit.")
+
+	// Server timeout flags
+	flagset.DurationVar(&cfg.server.readHeaderTimeout, "read-header-timeout", 10*time.Second, "The maximum duration for reading the request headers. Zero means no timeout.")
+	flagset.DurationVar(&cfg.server.readTimeout, "read-timeout", 0, "The maximum duration for reading the entire request, including the body. Zero means no timeout.")
+	flagset.DurationVar(&cfg.server.writeTimeout, "write-timeout", 0, "The maximum duration before timing out writes of the response. This includes the time spent proxying to the upstream, so it must be large enough for streaming responses. Zero means no timeout.")
+	flagset.DurationVar(&cfg.server.idleTimeout, "idle-timeout", 2*time.Minute, "The maximum amount of time to wait for the next request when keep-alives are enabled. Zero means the value of --read-timeout is used.")
+
+	flagset.DurationVar(&cfg.server.drainTimeout, "shutdown-drain-timeout", 15*time.Second, "The maximum duration to keep serving in-flight requests, including streaming and upgraded connections, after receiving SIGTERM. Remaining requests are canceled and connections are closed afterwards.")
+	flagset.DurationVar(&cfg.server.handoffTimeout, "handoff-timeout", 30*time.Second, "The maximum duration to wait for the new process started on SIGUSR1 to take over the listeners. The new process is killed and the listeners are kept if it doesn't start serving in time. Not supported on Windows.")
+	flagset.DurationVar(&cfg.server.shutdownDelay, "shutdown-delay", 0, "The duration to keep accepting new connections after receiving SIGTERM while /readyz fails, before draining, so the Pod is removed from the Service endpoints first. A second SIGTERM skips the delay.")
+
+	// HTTP/2 flags
+	flagset.BoolVar(&cfg.server.http2Disable, "http2-disable", false, "Disable HTTP/2 on the listeners, only HTTP/1.1 is advertised via ALPN and h2c is not accepted.")
+	flagset.Uint32Var(&cfg.server.http2MaxConcurrentStreams, "http2-max-concurrent-streams", 250, "The maximum number of concurrent streams per HTTP/2 connection.")
+	flagset.Uint32Var(&cfg.server.http2MaxReadFrameSize, "http2-max-size", 0, "The maximum size of HTTP/2 frames the server is willing to read, between 16KiB and 16MiB. Zero means 1MiB.")
+
+	// Socket option flags
+	flagset.DurationVar(&cfg.listenSockopts.KeepAlive, "listen-tcp-keepalive", 3*time.Minute, "The TCP keep-alive period for accepted client connections. A negative value disables keep-alives.")
+	flagset.BoolVar(&cfg.listenSockopts.NoDelay, "listen-tcp-nodelay", true, "Set TCP_NODELAY on accepted client connections, disabling Nagle's algorithm.")
+	flagset.BoolVar(&cfg.listenSockopts.ReusePort, "listen-reuse-port", false, "Set SO_REUSEPORT on the listening sockets, allowing multiple processes to bind the same address. Not supported on Windows.")
+	flagset.DurationVar(&cfg.upstreamSockopts.KeepAlive, "upstream-tcp-keepalive", 30*time.Second, "The TCP keep-alive period for connections to the upstream. A negative value disables keep-alives.")
+	flagset.BoolVar(&cfg.upstreamSockopts.NoDelay, "upstream-tcp-nodelay", true, "Set TCP_NODELAY on connections to the upstream, disabling Nagle's algorithm.")
 
 	// TLS flags
 	flagset.StringVar(&cfg.tls.certFile, "tls-cert-file", "", "File containing the default x509 Certificate for HTTPS. (CA cert, if any, concatenated after server cert)")
 	flagset.StringVar(&cfg.tls.keyFile, "tls-private-key-file", "", "File containing the default x509 private key matching --tls-cert-file.")
-	flagset.StringVar(&cfg.tls.minVersion, "tls-min-version", "VersionTLS12", "Minimum TLS version supported. Value must match version names from https://golang.org/pkg/crypto/tls/#pkg-constants.")
+	flagset.StringVar(&cfg.tls.keyURI, "tls-private-key-uri", "", fmt.Sprintf("URI of the private key matching --tls-cert-file in a hardware security module or KMS, e.g. \"pkcs11:token=proxy;object=serving?module-path=/usr/lib/libsofthsm2.so&pin-source=/etc/pin\". The key never leaves its key provider. Cannot be used with --tls-private-key-file. Available key providers: %v.", rbac_proxy_tls.KeyProviders()))
+	flagset.BoolVar(&cfg.tls.waitForCert, "tls-wait-for-cert", false, "Start even if the serving certificate is not available yet, e.g. because it is delivered asynchronously by a CSI driver, the SPIFFE Workload API or ACME. Until it is, /readyz fails and TLS handshakes are refused.")
+	flagset.BoolVar(&cfg.tls.fips, "fips", false, "Restrict the listeners and upstream transports to FIPS 140-2 approved TLS versions, cipher suites and curves. Refuses to start if the binary isn't built with Go+BoringCrypto or non-compliant TLS options are configured.")
+	flagset.BoolVar(&cfg.tls.ocspStapling, "tls-ocsp-stapling", false, "Staple OCSP responses of the serving certificates. Responses are fetched from the OCSP responder named in the certificates and refreshed in the background.")
+	flagset.StringVar(&cfg.tls.secret, "tls-secret", "", "Secret of type kubernetes.io/tls in the form namespace/name to read the default x509 Certificate and private key for HTTPS from. The certificate is updated when the Secret changes. Cannot be used with --tls-cert-file.")
+	flagset.StringVar(&cfg.tls.minVersion, "tls-min-version", "VersionTLS12", "Minimum TLS version supported. Value must match version names from https://golang.org/pkg/crypto/tls/#pkg-constants, and be at least VersionTLS12.")
+	flagset.StringVar(&cfg.tls.maxVersion, "tls-max-version", "", "Maximum TLS version supported. Value must match version names from https://golang.org/pkg/crypto/tls/#pkg-constants. If omitted, the highest version supported by Go is allowed.")
 	flagset.StringSliceVar(&cfg.tls.cipherSuites, "tls-cipher-suites", nil, "Comma-separated list of cipher suites for the server. Values are from tls package constants (https://golang.org/pkg/crypto/tls/#pkg-constants). If omitted, the default Go cipher suites will be used")
+	flagset.StringSliceVar(&cfg.tls.curvePreferences, "tls-curve-preferences", nil, "Comma-separated list of elliptic curves for the server in order of preference. Values are from tls package constants (https://golang.org/pkg/crypto/tls/#CurveID), e.g. CurveP256 or X25519. If omitted, the default Go curves will be used")
+	flagset.Var(k8sapiflag.NewNamedCertKeyArray(&cfg.tls.sniCertKeys), "tls-sni-cert-key", "A pair of x509 certificate and private key file paths, optionally suffixed with a list of domain patterns which are fully qualified domain names, possibly with prefixed wildcard segments. If no domain patterns are provided, the names of the certificate are extracted. The certificate is served to clients requesting one of the names via SNI, falling back to the default certificate. Examples: \"example.crt,example.key\" or \"foo.crt,foo.key:*.foo.com,foo.com\".")
+	flagset.StringVar(&cfg.tls.clientAuth, "tls-client-auth", "", "Client certificate policy of the secure listener. Values are from tls package constants (https://golang.org/pkg/crypto/tls/#ClientAuthType), e.g. RequireAndVerifyClientCert. Policies verifying certificates use --client-ca-file. If omitted, RequestClientCert is used if --client-ca-file is set, NoClientCert otherwise.")
 	flagset.DurationVar(&cfg.tls.reloadInterval, "tls-reload-interval", time.Minute, "The interval at which to watch for TLS certificate changes, by default set to 1 minute.")
 
-	// Auth flags
-	flagset.StringVar(&cfg.auth.Authentication.X509.ClientCAFile, "client-ca-file", "", "If set, any request presenting a client certificate signed by one of the authorities in the client-ca-file is authenticated with an identity corresponding to the CommonName of the client certificate.")
-	flagset.BoolVar(&cfg.auth.Authentication.Header.Enabled, "auth-header-fields-enabled", false, "When set to true, kube-rbac-proxy adds auth-related fields to the headers of http requests sent to the upstream")
-	flagset.StringVar(&cfg.auth.Authentication.Header.UserFieldName, "auth-header-user-field-name", "x-remote-user", "The name of the field inside a http(2) request header to tell the upstream server about the user's name")
-	flagset.StringVar(&cfg.auth.Authentication.Header.GroupsFieldName, "auth-header-groups-field-name", "x-remote-groups", "The name of the field inside a http(2) request header to tell the upstream server about the user's groups")
-	flagset.StringVar(&cfg.auth.Authentication.Header.GroupSeparator, "auth-header-groups-field-separator", "|", "The separator string used for concatenating multiple group names in a groups header field's value")
-	flagset.StringSliceVar(&cfg.auth.Authentication.Token.Audiences, "auth-token-audiences", []string{}, "Comma-separated list of token audiences to accept. By default a token does not have to have any specific audience. It is recommended to set a specific audience.")
-
-	//Authn OIDC flags
-	flagset.StringVar(&cfg.auth.Authentication.OIDC.IssuerURL, "oidc-issuer", "", "The URL of the OpenID issuer, only HTTPS scheme will be accepted. If set, it will be used to verify the OIDC JSON Web Token (JWT).")
-	flagset.StringVar(&cfg.auth.Authentication.OIDC.ClientID, "oidc-clientID", "", "The client ID for the OpenID Connect client, must be set if oidc-issuer-url is set.")
-	flagset.StringVar(&cfg.auth.Authentication.OIDC.GroupsClaim, "oidc-groups-claim", "groups", "Identifier of groups in JWT claim, by default set to 'groups'")
-	flagset.StringVar(&cfg.auth.Authentication.OIDC.UsernameClaim, "oidc-username-claim", "email", "Identifier of the user in JWT claim, by default set to 'email'")
-	flagset.StringVar(&cfg.auth.Authentication.OIDC.GroupsPrefix, "oidc-groups-prefix", "", "If provided, all groups will be prefixed with this value to prevent conflicts with other authentication strategies.")
-	flagset.StringArrayVar(&cfg.auth.Authentication.OIDC.SupportedSigningAlgs, "oidc-sign-alg", []string{"RS256"}, "Supported signing algorithms, default RS256")
-	flagset.StringVar(&cfg.auth.Authentication.OIDC.CAFile, "oidc-ca-file", "", "If set, the OpenID server's certificate will be verified by one of the authorities in the oidc-ca-file, otherwise the host's root CA set will be used.")
+	// ACME flags
+	flagset.StringSliceVar(&cfg.tls.selfSigned.Hosts, "self-signed-cert-hosts", nil, "Comma-separated list of DNS names and IP addresses of the self-signed certificate generated when no certificate is provided. If omitted, the hostname is used.")
+	flagset.DurationVar(&cfg.tls.selfSigned.Validity, "self-signed-cert-validity", 365*24*time.Hour, "How long the generated self-signed certificate is valid.")
+	flagset.DurationVar(&cfg.tls.selfSigned.RenewBefore, "self-signed-cert-renew-before", 30*24*time.Hour, "How long before expiry the generated self-signed certificate is rotated.")
+	flagset.StringVar(&cfg.tls.selfSigned.CAConfigMap, "self-signed-ca-configmap", "", "ConfigMap in the form namespace/name to publish the CA of the generated self-signed certificate to under the ca.crt key.")
+	flagset.StringSliceVar(&cfg.tls.acme.Domains, "acme-domains", nil, "Comma-separated list of domains to obtain the serving certificate for from an ACME CA like Let's Encrypt. Cannot be used with --tls-cert-file.")
+	flagset.StringVar(&cfg.tls.acme.Email, "acme-email", "", "The contact email address of the ACME account.")
+	flagset.StringVar(&cfg.tls.acme.DirectoryURL, "acme-directory-url", "", "The ACME directory URL. If omitted, Let's Encrypt production is used.")
+	flagset.StringVar(&cfg.tls.acme.CacheDir, "acme-cache-dir", "", "The directory to persist the ACME account key and certificates in. Required with --acme-domains.")
+	flagset.DurationVar(&cfg.tls.acme.RenewBefore, "acme-renew-before", 30*24*time.Hour, "How long before expiry ACME certificates are renewed.")
+	flagset.StringVar(&cfg.tls.acmeHTTP01ListenAddr, "acme-http01-listen-address", ":80", "The address to answer ACME HTTP-01 challenges on. Must be reachable on port 80 of the domains. Empty disables HTTP-01, leaving TLS-ALPN-01 on the secure listener.")
+	flagset.StringVar(&cfg.tls.acme.DNS01WebhookURL, "acme-dns01-webhook-url", "", "If set, ACME DNS-01 challenges are used instead of HTTP-01. The webhook receives JSON POST requests with the action (present or cleanup), fqdn and value of the TXT record to manage.")
 
-	//Kubeconfig flag
-	flagset.StringVar(&cfg.kubeconfigLocation, "kubeconfig", "", "Path to a kubeconfig file, specifying how to connect to the API server. If unset, in-cluster configuration will be used")
+	// SPIFFE flags
+	flagset.BoolVar(&cfg.tls.spiffe, "tls-spiffe", false, "Serve the X509-SVID obtained from the SPIFFE Workload API, e.g. of a SPIRE agent, as certificate. It is rotated as the Workload API issues new SVIDs. Cannot be used with other certificate sources.")
+	flagset.BoolVar(&cfg.tls.upstreamSPIFFE, "upstream-spiffe-client-cert", false, "Present the X509-SVID obtained from the SPIFFE Workload API as client certificate to TLS upstreams.")
+	flagset.StringVar(&cfg.tls.spiffeEndpointSocket, "spiffe-endpoint-socket", "", "Address of the SPIFFE Workload API, e.g. unix:///run/spire/sockets/agent.sock. If omitted, the SPIFFE_ENDPOINT_SOCKET environment variable is used.")
 
-	flagset.Parse(os.Args[1:])
-	kcfg := initKubeConfig(cfg.kubeconfigLocation)
-
-	upstreamURL, err := url.Parse(cfg.upstream)
-	if err != nil {
-		klog.Fatalf("Failed to parse upstream URL: %v", err)
-	}
+	// Health and metrics listener flags
+	flagset.StringVar(&cfg.health.listenAddress, "health-listen-address", "", "The address to serve /healthz, /readyz and the kube-rbac-proxy's own /metrics on, without authentication. If omitted, they are not served.")
+	flagset.StringVar(&cfg.health.certFile, "health-tls-cert-file", "", "File containing the x509 Certificate for HTTPS on the health listener. If omitted, the health listener serves plain HTTP.")
+	flagset.StringVar(&cfg.health.keyFile, "health-tls-private-key-file", "", "File containing the x509 private key matching --health-tls-cert-file.")
+	flagset.StringVar(&cfg.health.clientAuth, "health-tls-client-auth", "NoClientCert", "Client certificate policy of the health listener, like --tls-client-auth. Only applies if the health listener serves HTTPS.")
+	flagset.DurationVar(&cfg.health.checkTimeout, "health-check-timeout", 5*time.Second, "The maximum duration of each health check.")
+	flagset.BoolVar(&cfg.health.upstreamCheck, "readyz-upstream", false, "Include the \"upstream\" check in /readyz, which fails if an upstream can't be connected to.")
+	flagset.DurationVar(&cfg.health.kubeAPIServerWindow, "readyz-kube-apiserver-window", 0, "If set, the \"kube-apiserver-connectivity\" check fails /readyz once all TokenReview and SubjectAccessReview requests failed to reach the kube-apiserver for this duration, so load balancers stop sending traffic the proxy can't authorize. Also exposed as kube_rbac_proxy_kube_apiserver_unreachable.")
+	flagset.StringSliceVar(&cfg.health.readyzExclude, "readyz-exclude", nil, "Names of checks to exclude from /readyz, e.g. \"kube-apiserver\" to stay ready while the kube-apiserver can't be reached.")
 
-	if configFileName != "" {
-		klog.Infof("Reading config file: %s", configFileName)
-		b, err := ioutil.ReadFile(configFileName)
-		if err != nil {
-			klog.Fatalf("Failed to read resource-attribute file: %v", err)
-		}
+	// Debug flags
+	flagset.BoolVar(&cfg.debug.endpoints, "debug-endpoints", false, "Serve /debug/pprof, /debug/config, /debug/flags and /debug/flags/v on the health listener, to profile the proxy, show its effective configuration, list its flags and read (GET) or change (PUT) the log verbosity at runtime. Requests must be authenticated and authorized for the non-resource URL of the request path, or --debug-non-resource-url.")
+	flagset.StringVar(&cfg.debug.nonResourceURL, "debug-non-resource-url", "", "If set, requests to the debug endpoints are authorized for this non-resource URL instead of the request path, e.g. \"/debug/kube-rbac-proxy\".")
+	flagset.IntVar(&cfg.debug.verbosity, "debug-verbosity", 5, "The log verbosity SIGUSR2 toggles to. Sending SIGUSR2 again restores the verbosity set with -v.")
 
-		configfile := configfile{}
+	// Metrics flags
+	flagset.BoolVar(&cfg.metricsExemplars, "metrics-exemplars", false, "Attach the trace ID of requests carrying a sampled W3C traceparent header as exemplar to the request and delegated request latency histograms. Exemplars are exposed if /metrics is scraped in the OpenMetrics format.")
 
-		err = yaml.Unmarshal(b, &configfile)
-		if err != nil {
-			klog.Fatalf("Failed to parse config file content: %v", err)
-		}
+	flagset.IntVar(&cfg.metricsSALimit, "metrics-service-account-limit", 0, "If set, count requests by the authenticated service account in kube_rbac_proxy_service_account_requests_total. Service accounts beyond this number are counted as \"other\", to bound the number of series.")
 
-		cfg.auth.Authorization = configfile.AuthorizationConfig
-	}
+	flagset.BoolVar(&cfg.metricsPrometheus, "metrics-prometheus", true, "Serve the metrics at /metrics on the health listener. Disable it if metrics are pushed to statsd instead.")
+	flagset.StringVar(&cfg.statsd.Address, "metrics-statsd-address", "", "If set, metrics are pushed to the statsd server at this host:port via UDP, for environments where the proxy can't be scraped.")
+	flagset.StringVar(&cfg.statsd.Format, "metrics-statsd-format", statsd.FormatStatsd, "The statsd dialect to push metrics in. \"statsd\" appends label values to metric names, \"dogstatsd\" sends labels as tags.")
+	flagset.StringVar(&cfg.statsd.Prefix, "metrics-statsd-prefix", "", "A prefix for the names of metrics pushed to statsd, separated by a dot.")
+	flagset.DurationVar(&cfg.statsd.Interval, "metrics-statsd-interval", 10*time.Second, "The interval to push metrics to statsd in.")
+	flagset.StringVar(&cfg.profiling.ServerURL, "profiling-server-url", "", "If set, CPU and heap profiles of the proxy are continuously pushed to the /ingest endpoint of the Pyroscope-compatible server at this URL. Basic auth credentials can be part of the URL.")
+	flagset.StringVar(&cfg.profiling.ApplicationName, "profiling-application-name", "kube-rbac-proxy", "The application name of the pushed profiles.")
+	flagset.StringToStringVar(&cfg.profiling.Tags, "profiling-tags", nil, "Tags attached to the pushed profiles, e.g. \"namespace=default,pod=foo\" to tell proxies apart.")
+	flagset.DurationVar(&cfg.profiling.Interval, "profiling-interval", 15*time.Second, "The duration of each pushed CPU profile and the interval to push profiles in.")
 
-	kubeClient, err := kubernetes.NewForConfig(kcfg)
-	if err != nil {
-		klog.Fatalf("Failed to instantiate Kubernetes client: %v", err)
-	}
+	// Watchdog flags
+	flagset.BoolVar(&cfg.prewarmConnections, "prewarm-connections", false, "Establish connections to the upstreams and the kube-apiserver at startup, so the first requests don't wait for TCP and TLS handshakes.")
+	flagset.DurationVar(&cfg.prewarmInterval, "prewarm-interval", 60*time.Second, "The interval to warm the connections of --prewarm-connections in again, to keep them from being closed as idle. Zero only warms them at startup.")
+	flagset.IntVar(&cfg.runtimeLimits.GOMAXPROCS, "gomaxprocs", 0, "The maximum number of CPUs executing Go code simultaneously. If zero, it is derived from the CPU quota of the container, unless the GOMAXPROCS environment variable is set.")
+	flagset.Int64Var(&cfg.runtimeLimits.MemoryLimitBytes, "gomemlimit-bytes", 0, "The soft memory limit of the Go runtime in bytes, above which the garbage collector runs more often. If zero, it is derived from the memory limit of the container with --gomemlimit-ratio, unless the GOMEMLIMIT environment variable is set.")
+	flagset.Float64Var(&cfg.runtimeLimits.MemoryLimitRatio, "gomemlimit-ratio", 0.9, "The share of the container's memory limit used as soft memory limit of the Go runtime if --gomemlimit-bytes isn't set. Zero disables deriving it.")
+	flagset.DurationVar(&cfg.watchdog.Interval, "watchdog-interval", 30*time.Second, "The interval to sample the proxy's goroutines, open file descriptors and heap in, for kube_rbac_proxy_heap_watermark_bytes and the --watchdog-max-* thresholds.")
+	flagset.IntVar(&cfg.watchdog.MaxGoroutines, "watchdog-max-goroutines", 0, "If set, log a goroutine dump when the number of goroutines exceeds this value.")
+	flagset.IntVar(&cfg.watchdog.MaxOpenFDs, "watchdog-max-open-fds", 0, "If set, log a warning when the number of open file descriptors exceeds this value.")
+	flagset.Uint64Var(&cfg.watchdog.MaxHeapBytes, "watchdog-max-heap-bytes", 0, "If set, log memory statistics and a goroutine dump when the heap in use exceeds this number of bytes.")
 
-	var authenticator authenticator.Request
-	// If OIDC configuration provided, use oidc authenticator
-	if cfg.auth.Authentication.OIDC.IssuerURL != "" {
-		authenticator, err = authn.NewOIDCAuthenticator(cfg.auth.Authentication.OIDC)
-		if err != nil {
-			klog.Fatalf("Failed to instantiate OIDC authenticator: %v", err)
-		}
+	// Startup flags
+	flagset.StringToStringVar(&cfg.startup.Policies, "startup-failure-policy", nil, "How dependencies unavailable at startup are handled, e.g. \"kube-apiserver=retry,upstream=degraded\". The dependencies are kube-apiserver, upstream (DNS resolution of the upstream hosts) and certificate (the --tls-cert-file and --tls-private-key-file or --tls-secret). The policies are crash, retry (with exponential backoff for at most --startup-retry-timeout) and degraded (start with /readyz failing until the dependency is available). Dependencies without a policy aren't checked at startup.")
+	flagset.DurationVar(&cfg.startup.RetryTimeout, "startup-retry-timeout", 5*time.Minute, "How long dependencies with the retry --startup-failure-policy are retried before the proxy exits.")
 
-	} else {
-		//Use Delegating authenticator
-		klog.Infof("Valid token audiences: %s", strings.Join(cfg.auth.Authentication.Token.Audiences, ", "))
+	// Logging flags
+	flagset.Uint64Var(&cfg.logSampling.EveryN, "log-sample-every", 0, "If set, log the metadata of every Nth request at info level, including the user and the authorization attributes derived for it.")
+	flagset.DurationVar(&cfg.logSlowRequests, "log-slow-requests", 0, "If set, log a warning for requests taking longer than this duration, with the time spent authenticating, authorizing and waiting for the upstream.")
+	flagset.Float64Var(&cfg.logSampling.Probability, "log-sample-probability", 0, "If set, log the metadata of requests with this probability between 0 and 1 at info level, like --log-sample-every.")
 
-		tokenClient := kubeClient.AuthenticationV1().TokenReviews()
-		authenticator, err = authn.NewDelegatingAuthenticator(tokenClient, cfg.auth.Authentication)
-		if err != nil {
-			klog.Fatalf("Failed to instantiate delegating authenticator: %v", err)
-		}
+	// Decision log flags
+	flagset.StringVar(&cfg.decisionLog.URL, "decision-log-url", "", "If set, authorization decisions are shipped to this HTTP endpoint in gzipped batches of JSON documents.")
+	flagset.StringToStringVar(&cfg.decisionLog.Labels, "decision-log-labels", nil, "Labels added to every decision log, e.g. \"cluster=prod,app=prometheus\".")
+	flagset.IntVar(&cfg.decisionLog.BufferSize, "decision-log-buffer-size", 10000, "The number of decisions buffered before shipping. Further decisions are dropped if the buffer is full.")
+	flagset.IntVar(&cfg.decisionLog.MaxBatchSize, "decision-log-max-batch-size", 100, "The maximum number of decisions shipped per request.")
+	flagset.DurationVar(&cfg.decisionLog.FlushInterval, "decision-log-flush-interval", 10*time.Second, "The maximum time decisions are buffered before shipping.")
+	flagset.IntVar(&cfg.decisionLog.Retries, "decision-log-retries", 3, "The number of times shipping a batch of decisions is retried, with exponential backoff, before it is dropped.")
 
-	}
+	// Events flags
+	flagset.IntVar(&cfg.failureEvents.Threshold, "events-failure-threshold", 0, "If set, a Warning Event is emitted on the proxy's Pod when a client receives this many 401 or 403 responses within --events-failure-window. Requires --pod-name and --pod-namespace.")
+	flagset.DurationVar(&cfg.failureEvents.Window, "events-failure-window", 5*time.Minute, "The period failed requests are counted in for --events-failure-threshold. Each client causes at most one Event per period.")
+	flagset.StringVar(&cfg.failureEvents.PodName, "pod-name", os.Getenv("POD_NAME"), "The name of the proxy's Pod, defaults to the POD_NAME environment variable.")
+	flagset.StringVar(&cfg.failureEvents.PodNamespace, "pod-namespace", os.Getenv("POD_NAMESPACE"), "The namespace of the proxy's Pod, defaults to the POD_NAMESPACE environment variable.")
+	flagset.StringVar(&cfg.failureEvents.PodUID, "pod-uid", os.Getenv("POD_UID"), "The UID of the proxy's Pod, defaults to the POD_UID environment variable. Required for Events to be shown by kubectl describe.")
 
-	sarClient := kubeClient.AuthorizationV1().SubjectAccessReviews()
-	authorizer, err := authz.NewAuthorizer(sarClient)
+	// Audit flags
+	cfg.audit.WebhookBatch = audit.DefaultBatchConfig()
+	flagset.StringVar(&cfg.audit.LogPath, "audit-log-path", "", "If set, audit events of all requests are written to this file in JSON lines format. '-' means standard out.")
+	flagset.IntVar(&cfg.auditLogMaxSize, "audit-log-maxsize", 0, "The maximum size in megabytes of the audit log file before it gets rotated. 0 disables rotation.")
+	flagset.IntVar(&cfg.auditLogMaxAge, "audit-log-maxage", 0, "The maximum number of days to retain rotated audit log files. 0 retains them regardless of their age.")
+	flagset.IntVar(&cfg.audit.LogRotation.MaxBackups, "audit-log-maxbackup", 0, "The maximum number of rotated audit log files to retain. 0 retains all.")
+	flagset.BoolVar(&cfg.audit.LogRotation.Compress, "audit-log-compress", false, "If set, rotated audit log files are compressed with gzip.")
+	flagset.StringVar(&cfg.audit.WebhookConfigFile, "audit-webhook-config-file", "", "Path to a kubeconfig formatted file that defines the audit webhook configuration, like the kube-apiserver's --audit-webhook-config-file.")
+	flagset.StringVar(&cfg.audit.WebhookMode, "audit-webhook-mode", audit.ModeBatch, "Strategy for sending audit events to the webhook. \"batch\" buffers events and sends them asynchronously, dropping events if the buffer is full. \"blocking\" sends each event before the request completes.")
+	flagset.DurationVar(&cfg.audit.WebhookInitialBackoff, "audit-webhook-initial-backoff", 10*time.Second, "The amount of time to wait before retrying the first failed request to the audit webhook.")
+	flagset.IntVar(&cfg.audit.WebhookBatch.BufferSize, "audit-webhook-batch-buffer-size", cfg.audit.WebhookBatch.BufferSize, "The size of the buffer to store events before batching and sending them to the webhook.")
+	flagset.IntVar(&cfg.audit.WebhookBatch.MaxBatchSize, "audit-webhook-batch-max-size", cfg.audit.WebhookBatch.MaxBatchSize, "The maximum size of a batch sent to the webhook.")
+	flagset.DurationVar(&cfg.audit.WebhookBatch.MaxBatchWait, "audit-webhook-batch-max-wait", cfg.audit.WebhookBatch.MaxBatchWait, "The amount of time to wait before force sending a batch that hasn't reached the max size.")
+	flagset.Float32Var(&cfg.audit.WebhookBatch.ThrottleQPS, "audit-webhook-batch-throttle-qps", cfg.audit.WebhookBatch.ThrottleQPS, "Maximum average number of batches per second sent to the webhook.")
+	flagset.IntVar(&cfg.audit.WebhookBatch.ThrottleBurst, "audit-webhook-batch-throttle-burst", cfg.audit.WebhookBatch.ThrottleBurst, "Maximum number of batches sent to the webhook at the same moment if ThrottleQPS was not utilized before.")
 
-	if err != nil {
-		klog.Fatalf("Failed to create authorizer: %v", err)
-	}
+	// Auth flags
+	flagset.StringVar(&cfg.auth.Authentication.X509.ClientCAFile, "client-ca-file", "", "If set, any request presenting a client certificate signed by one of the authorities in the client-ca-file is authenticated with an identity corresponding to the CommonName of the client certificate.")
+	addAuthnHeaderFlags(flagset, cfg.auth.Authentication.Header)
+	flagset.StringSliceVar(&cfg.auth.Authentication.Token.Audiences, "auth-token-audiences", []string{}, "Comma-separated list of token audiences to accept. By default a token does not have to have any specific audience. It is recommended to set a specific audience.")
+	flagset.IntVar(&cfg.auth.Authentication.Token.CacheSize, "auth-token-cache-size", authn.DefaultTokenCacheSize, "The maximum number of TokenReview results cached, each for at most two minutes and not beyond the expiry of the token. The least recently used results are evicted first.")
+	flagset.DurationVar(&cfg.auth.LatencyBudget.Total, "auth-latency-budget", 0, "The maximum duration of authenticating and authorizing a request, including TokenReview and SubjectAccessReview requests to the kube-apiserver. Requests exceeding it fail with 504 Gateway Timeout, leaving the rest of the client's timeout to the upstream. Zero means no budget.")
+	flagset.Float64Var(&cfg.auth.LatencyBudget.AuthenticationRatio, "auth-latency-budget-authn-ratio", 0.5, "The share of --auth-latency-budget authentication may take. Authorization may take the rest, including what authentication didn't use.")
 
-	auth, err := proxy.New(kubeClient, cfg.auth, authorizer, authenticator)
+	//Authn OIDC flags
+	flagset.StringVar(&cfg.auth.Authentication.OIDC.IssuerURL, "oidc-issuer", "", "The URL of the OpenID issuer, only HTTPS scheme will be accepted. If set, it will be used to verify the OIDC JSON Web Token (JWT).")
+	flagset.StringVar(&cfg.auth.Authentication.OIDC.ClientID, "oidc-clientID", "", "The client ID for the OpenID Connect client, must be set if oidc-issuer-url is set.")
+	flagset.StringVar(&cfg.auth.Authentication.OIDC.GroupsClaim, "oidc-groups-claim", "groups", "Identifier of groups in JWT claim, by default set to 'groups'")
+	flagset.StringVar(&cfg.auth.Authentication.OIDC.UsernameClaim, "oidc-username-claim", "email", "Identifier of the user in JWT claim, by default set to 'email'")
+	flagset.StringVar(&cfg.auth.Authentication.OIDC.GroupsPrefix, "oidc-groups-prefix", "", "If provided, all groups will be prefixed with this value to prevent conflicts with other authentication strategies.")
+	flagset.StringArrayVar(&cfg.auth.Authentication.OIDC.SupportedSigningAlgs, "oidc-sign-alg", []string{"RS256"}, "Supported signing algorithms, default RS256")
+	flagset.StringVar(&cfg.auth.Authentication.OIDC.CAFile, "oidc-ca-file", "", "If set, the OpenID server's certificate will be verified by one of the authorities in the oidc-ca-file, otherwise the host's root CA set will be used.")
 
-	if err != nil {
-		klog.Fatalf("Failed to create rbac-proxy: %v", err)
-	}
+	//Kubeconfig flag
+	flagset.StringVar(&cfg.kubeconfigLocation, "kubeconfig", "", "Path to a kubeconfig file, specifying how to connect to the API server. If unset, in-cluster configuration will be used")
+	flagset.StringVar(&cfg.kubeconfigContext, "kubeconfig-context", "", "The context of --kubeconfig to use, e.g. to delegate authentication and authorization to a different cluster than the proxy runs in. If omitted, the current context of the kubeconfig is used.")
+	flagset.StringVar(&cfg.profile, "profile", "", fmt.Sprintf("A bundle of defaults for a common deployment, one of %s. Flags set on the command line or in the config file take precedence. Can only be set on the command line.", strings.Join(rbac_proxy_config.ProfileNames(), ", ")))
+	flagset.StringVar(&cfg.windowsServiceName, "windows-service-name", "", "Run as the Windows service of this name, reporting to the service control manager and logging to the event log source of the same name. Relative paths are resolved against the directory of the executable. Can only be set on the command line.")
+	flagset.BoolVar(&cfg.kubeAPIHTTP2.enabled, "kube-api-http2", true, "Share a single HTTP/2 connection to the Kubernetes API server between all requests, health checked with pings. Connections through an HTTP proxy and rotated client certificates use the default transport of client-go.")
+	flagset.DurationVar(&cfg.kubeAPIHTTP2.readIdleTimeout, "kube-api-http2-read-idle-timeout", 30*time.Second, "The time without frames received on the HTTP/2 connection to the Kubernetes API server after which it is health checked with a ping. Zero disables the health checks.")
+	flagset.DurationVar(&cfg.kubeAPIHTTP2.pingTimeout, "kube-api-http2-ping-timeout", 15*time.Second, "The time after which the HTTP/2 connection to the Kubernetes API server is closed if a ping isn't answered.")
+	flagset.StringVar(&cfg.kubeAPIProxyURL, "kube-api-proxy-url", "", "The URL of the HTTP proxy used for connections to the Kubernetes API server. If omitted, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are honored.")
 
-	upstreamTransport, err := initTransport(cfg.upstreamCAFile)
-	if err != nil {
-		klog.Fatalf("Failed to set up upstream TLS connection: %v", err)
-	}
+	return flagset
+}
 
-	if len(cfg.allowPaths) > 0 && len(cfg.ignorePaths) > 0 {
-		klog.Fatal("Cannot use --allow-paths and --ignore-paths together.")
-	}
+// proxyAuthenticator authenticates and authorizes requests before they are proxied.
+type proxyAuthenticator interface {
+	Handle(w http.ResponseWriter, req *http.Request) bool
+}
 
-	proxy := httputil.NewSingleHostReverseProxy(upstreamURL)
-	proxy.Transport = upstreamTransport
-	mux := http.NewServeMux()
-	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		found := len(cfg.allowPaths) == 0
-		for _, path := range cfg.allowPaths {
+// protectedHandler only passes requests to the upstream handler if they are
+// allowed by allowPaths, and either match ignorePaths or are authorized by auth.
+func protectedHandler(auth proxyAuthenticator, upstream http.Handler, allowPaths, ignorePaths []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		found := len(allowPaths) == 0
+		for _, path := range allowPaths {
 			if req.URL.Path == path {
 				found = true
 				break
@@ -236,7 +1626,7 @@ func main() {
 		}
 
 		ignorePathFound := false
-		for _, path := range cfg.ignorePaths {
+		for _, path := range ignorePaths {
 			if req.URL.Path == path {
 				ignorePathFound = true
 				break
@@ -250,152 +1640,301 @@ func main() {
 			}
 		}
 
-		proxy.ServeHTTP(w, req)
-	}))
+		upstream.ServeHTTP(w, req)
+	})
+}
 
-	var gr run.Group
-	{
-		if cfg.secureListenAddress != "" {
-			srv := &http.Server{Handler: mux, TLSConfig: &tls.Config{}}
+// clientCertificateWindow is how long client certificates are reported in
+// the client certificate expiry metric after they were last presented.
+const clientCertificateWindow = time.Hour
 
-			if cfg.tls.certFile == "" && cfg.tls.keyFile == "" {
-				klog.Info("Generating self signed cert as no cert is provided")
-				host, err := os.Hostname()
-				if err != nil {
-					klog.Fatalf("Failed to retrieve hostname for self-signed cert: %v", err)
-				}
-				certBytes, keyBytes, err := certutil.GenerateSelfSignedCertKey(host, nil, nil)
-				if err != nil {
-					klog.Fatalf("Failed to generate self signed cert and key: %v", err)
-				}
-				cert, err := tls.X509KeyPair(certBytes, keyBytes)
-				if err != nil {
-					klog.Fatalf("Failed to load generated self signed cert and key: %v", err)
-				}
+// newServer returns a server for handler, whose connections are tracked in the
+// metrics of the named listener.
+func newServer(cfg serverConfig, listener string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ErrorLog:          metrics.NewServerErrorLog(os.Stderr),
+		ConnState:         metrics.TrackClientConnections(listener),
+		ReadHeaderTimeout: cfg.readHeaderTimeout,
+		ReadTimeout:       cfg.readTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+	}
+}
 
-				srv.TLSConfig.Certificates = []tls.Certificate{cert}
-			} else {
-				klog.Info("Reading certificate files")
-				ctx, cancel := context.WithCancel(context.Background())
-				r, err := rbac_proxy_tls.NewCertReloader(cfg.tls.certFile, cfg.tls.keyFile, cfg.tls.reloadInterval)
-				if err != nil {
-					klog.Fatalf("Failed to initialize certificate reloader: %v", err)
-				}
+// flagsHandler lists the values of all flags, with credentials redacted.
+func flagsHandler(flagset *pflag.FlagSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		flagset.VisitAll(func(f *pflag.Flag) {
+			fmt.Fprintf(w, "--%s=%s\n", f.Name, redactFlagValue(f, f.Value.String()))
+		})
+	})
+}
 
-				srv.TLSConfig.GetCertificate = r.GetCertificate
+// sensitiveAnnotation marks flags whose values must not be shown.
+const sensitiveAnnotation = "kube-rbac-proxy/sensitive"
 
-				gr.Add(func() error {
-					return r.Watch(ctx)
-				}, func(error) {
-					cancel()
-				})
-			}
+// redactFlagValue redacts the value of sensitive flags, and passwords of URLs.
+func redactFlagValue(f *pflag.Flag, value string) string {
+	if _, ok := f.Annotations[sensitiveAnnotation]; ok && value != "" {
+		return "REDACTED"
+	}
+	return redactURL(value)
+}
 
-			version, err := tlsVersion(cfg.tls.minVersion)
-			if err != nil {
-				klog.Fatalf("TLS version invalid: %v", err)
-			}
+// redactURL redacts the password if s is a URL including one.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	return u.String()
+}
 
-			cipherSuiteIDs, err := k8sapiflag.TLSCipherSuites(cfg.tls.cipherSuites)
-			if err != nil {
-				klog.Fatalf("Failed to convert TLS cipher suite name to ID: %v", err)
-			}
+// effectiveFlag describes the value of a flag and where it came from.
+type effectiveFlag struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Default string `json:"default"`
+	Changed bool   `json:"changed"`
+}
 
-			srv.TLSConfig.CipherSuites = cipherSuiteIDs
-			srv.TLSConfig.MinVersion = version
+// configHandler returns the effective configuration as JSON, i.e. all flags
+// including defaults and the parsed config file, with credentials redacted.
+func configHandler(flagset *pflag.FlagSet, fileCfg v1alpha1.KubeRBACProxyConfig) http.Handler {
+	hosts := make([]v1alpha1.Host, len(fileCfg.Hosts))
+	for i, h := range fileCfg.Hosts {
+		h.Upstream = redactURL(h.Upstream)
+		hosts[i] = h
+	}
+	fileCfg.Hosts = hosts
+	routes := make([]v1alpha1.Route, len(fileCfg.Routes))
+	for i, r := range fileCfg.Routes {
+		r.Upstream = redactURL(r.Upstream)
+		routes[i] = r
+	}
+	fileCfg.Routes = routes
+	// The settings of the file are shown, redacted, as the flags they set.
+	fileCfg.Options = v1alpha1.Options{}
 
-			if err := http2.ConfigureServer(srv, nil); err != nil {
-				klog.Fatalf("failed to configure http2 server: %v", err)
-			}
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		flags := []effectiveFlag{}
+		flagset.VisitAll(func(f *pflag.Flag) {
+			flags = append(flags, effectiveFlag{
+				Name:    f.Name,
+				Value:   redactFlagValue(f, f.Value.String()),
+				Default: redactFlagValue(f, f.DefValue),
+				Changed: f.Changed,
+			})
+		})
 
-			klog.Infof("Starting TCP socket on %v", cfg.secureListenAddress)
-			l, err := net.Listen("tcp", cfg.secureListenAddress)
-			if err != nil {
-				klog.Fatalf("failed to listen on secure address: %v", err)
-			}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(struct {
+			Flags      []effectiveFlag              `json:"flags"`
+			ConfigFile v1alpha1.KubeRBACProxyConfig `json:"configFile"`
+		}{flags, fileCfg}); err != nil {
+			klog.Errorf("failed to encode config: %v", err)
+		}
+	})
+}
 
-			gr.Add(func() error {
-				klog.Infof("Listening securely on %v", cfg.secureListenAddress)
-				tlsListener := tls.NewListener(l, srv.TLSConfig)
-				return srv.Serve(tlsListener)
-			}, func(err error) {
-				if err := srv.Shutdown(context.Background()); err != nil {
-					klog.Errorf("failed to gracefully shutdown server: %v", err)
-				}
-				if err := l.Close(); err != nil {
-					klog.Errorf("failed to gracefully close secure listener: %v", err)
-				}
-			})
+// kubeAPIServerCheck checks whether authorization can be delegated to the
+// kube-apiserver by creating a SubjectAccessReview in dry-run mode.
+func kubeAPIServerCheck(client authorizationclient.SubjectAccessReviewInterface) health.CheckFunc {
+	return func(ctx context.Context) error {
+		sar := &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User: "system:kube-rbac-proxy:readiness-check",
+				NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+					Path: "/readyz",
+					Verb: "get",
+				},
+			},
 		}
+		_, err := client.Create(ctx, sar, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+		return err
 	}
-	{
-		if cfg.insecureListenAddress != "" {
-			if cfg.upstreamForceH2C {
-				// Force http/2 for connections to the upstream i.e. do not start with HTTP1.1 UPGRADE req to
-				// initialize http/2 session.
-				// See https://github.com/golang/go/issues/14141#issuecomment-219212895 for more context
-				proxy.Transport = &http2.Transport{
-					// Allow http schema. This doesn't automatically disable TLS
-					AllowHTTP: true,
-					// Do disable TLS.
-					// In combination with the schema check above. We could enforce h2c against the upstream server
-					DialTLS: func(netw, addr string, cfg *tls.Config) (net.Conn, error) {
-						return net.Dial(netw, addr)
-					},
+}
+
+// upstreamCheck checks whether connections to all upstreams can be established.
+func upstreamCheck(upstreams []*url.URL) health.CheckFunc {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		for _, u := range upstreams {
+			addr := u.Host
+			if u.Port() == "" {
+				port := "80"
+				if u.Scheme == "https" {
+					port = "443"
 				}
+				addr = net.JoinHostPort(u.Hostname(), port)
 			}
 
-			srv := &http.Server{Handler: h2c.NewHandler(mux, &http2.Server{})}
-
-			l, err := net.Listen("tcp", cfg.insecureListenAddress)
+			conn, err := d.DialContext(ctx, "tcp", addr)
 			if err != nil {
-				klog.Fatalf("Failed to listen on insecure address: %v", err)
+				return err
 			}
+			conn.Close()
+		}
+		return nil
+	}
+}
 
-			gr.Add(func() error {
-				klog.Infof("Listening insecurely on %v", cfg.insecureListenAddress)
-				return srv.Serve(l)
-			}, func(err error) {
-				if err := srv.Shutdown(context.Background()); err != nil {
-					klog.Errorf("failed to gracefully shutdown server: %v", err)
-				}
-				if err := l.Close(); err != nil {
-					klog.Errorf("failed to gracefully close listener: %v", err)
-				}
-			})
+// upstreamDNSCheck checks whether the host names of all upstreams resolve.
+func upstreamDNSCheck(upstreams []*url.URL) health.CheckFunc {
+	return func(ctx context.Context) error {
+		for _, u := range upstreams {
+			host := u.Hostname()
+			if host == "" || net.ParseIP(host) != nil {
+				continue
+			}
+			if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
-	{
-		sig := make(chan os.Signal)
-		gr.Add(func() error {
-			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
-			<-sig
-			klog.Info("received interrupt, shutting down")
+}
+
+// certificateCheck checks whether the serving certificate files or secret
+// can be read. Other certificate sources aren't checked.
+func certificateCheck(c tlsConfig, client kubernetes.Interface) health.CheckFunc {
+	return func(ctx context.Context) error {
+		switch {
+		case c.secret != "":
+			_, err := rbac_proxy_tls.NewSecretCertificate(client, c.secret)
+			return err
+		case c.certFile != "" && c.keyFile != "":
+			_, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+			return err
+		}
+		return nil
+	}
+}
+
+// waitForCertificate keeps the readiness failing until getCertificate returns a certificate,
+// and then blocks until ctx is done.
+func waitForCertificate(ctx context.Context, getCertificate rbac_proxy_tls.GetCertificateFunc, hello *tls.ClientHelloInfo, readiness *health.Readiness) error {
+	for {
+		cert, err := getCertificate(hello)
+		if err == nil && cert == nil {
+			err = errors.New("no certificate available")
+		}
+		readiness.Set("certificate", err)
+		if err == nil {
+			break
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
 			return nil
-		}, func(err error) {
-			close(sig)
-		})
+		}
 	}
 
-	if err := gr.Run(); err != nil {
-		klog.Fatalf("failed to run groups: %v", err)
+	klog.Info("Serving certificate is available")
+	<-ctx.Done()
+	return nil
+}
+
+// newHTTP2Server returns the HTTP/2 server parameters of the listeners.
+func newHTTP2Server(cfg serverConfig) *http2.Server {
+	return &http2.Server{
+		MaxConcurrentStreams: cfg.http2MaxConcurrentStreams,
+		MaxReadFrameSize:     cfg.http2MaxReadFrameSize,
+		IdleTimeout:          cfg.idleTimeout,
+	}
+}
+
+// shutdownServer stops srv from accepting new connections and waits for
+// in-flight requests to finish for at most timeout, before canceling the
+// remaining requests and closing all connections.
+func shutdownServer(srv *http.Server, drainer *filters.Drainer, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		// Shutdown doesn't wait for hijacked connections.
+		err = drainer.Wait(ctx)
+	}
+	if err != nil {
+		klog.Errorf("failed to gracefully shutdown server within %v: %v", timeout, err)
+		drainer.Abort()
+		if err := srv.Close(); err != nil {
+			klog.Errorf("failed to close server: %v", err)
+		}
+	}
+}
+
+// configureClientAuth sets the named client certificate policy,
+// loading the client CAs from caFile if the policy verifies certificates.
+func configureClientAuth(c *tls.Config, name, caFile string) error {
+	clientAuth, err := rbac_proxy_tls.ClientAuthType(name)
+	if err != nil {
+		return err
+	}
+	c.ClientAuth = clientAuth
+
+	if !rbac_proxy_tls.VerifiesClientCerts(clientAuth) {
+		return nil
+	}
+	if caFile == "" {
+		return fmt.Errorf("client auth type %s requires --client-ca-file", name)
+	}
+
+	c.ClientCAs, err = certutil.NewPool(caFile)
+	if err != nil {
+		return fmt.Errorf("error loading client CAs: %v", err)
+	}
+	return nil
+}
+
+// isLoopbackListener returns whether the listener only accepts local connections,
+// i.e. is a Unix socket or listens on a loopback address.
+func isLoopbackListener(l net.Listener) bool {
+	if l.Addr().Network() == "unix" {
+		return true
+	}
+	return isLoopbackAddress(l.Addr().String())
+}
+
+// isLoopbackAddress returns whether the listen address only accepts connections from the local host.
+func isLoopbackAddress(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
 	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
 // Returns intiliazed config, allows local usage (outside cluster) based on provided kubeconfig or in-cluter
-func initKubeConfig(kcLocation string) *rest.Config {
+func initKubeConfig(kcLocation, kcContext string) *rest.Config {
 
 	if kcLocation != "" {
-		kubeConfig, err := clientcmd.BuildConfigFromFlags("", kcLocation)
+		kubeConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kcLocation},
+			&clientcmd.ConfigOverrides{CurrentContext: kcContext},
+		).ClientConfig()
 		if err != nil {
-			klog.Fatalf("unable to build rest config based on provided path to kubeconfig file: %v",err)
+			klog.Fatalf("unable to build rest config based on provided path to kubeconfig file: %v", err)
 		}
 		return kubeConfig
 	}
 
 	kubeConfig, err := rest.InClusterConfig()
 	if err != nil {
-		klog.Fatalf("cannot find Service Account in pod to build in-cluster rest config: %v",err)
+		klog.Fatalf("cannot find Service Account in pod to build in-cluster rest config: %v", err)
 	}
 
 	return kubeConfig
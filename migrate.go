@@ -0,0 +1,134 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+	rbac_proxy_config "github.com/brancz/kube-rbac-proxy/pkg/config"
+)
+
+const configUsage = `Usage: kube-rbac-proxy config migrate [flags] -- [proxy flags]
+
+migrate converts the proxy flags, and the config file they name in the legacy
+or versioned format, to a single config file in the versioned format. Flags
+the config file can't hold are logged, to be kept on the command line.
+`
+
+// runConfig runs the config subcommand with the given arguments.
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "migrate" {
+		fmt.Fprint(os.Stderr, configUsage)
+		return fmt.Errorf("unknown or missing command, must be \"migrate\"")
+	}
+
+	output := ""
+	flagset := pflag.NewFlagSet("config migrate", pflag.ExitOnError)
+	flagset.Usage = func() {
+		fmt.Fprint(os.Stderr, configUsage+"\n")
+		flagset.PrintDefaults()
+	}
+	flagset.StringVarP(&output, "output", "o", "", "The file to write the config file to. If omitted, it is written to stdout.")
+	flagset.Parse(args[1:])
+
+	proxyArgs := flagset.Args()
+	if dash := flagset.ArgsLenAtDash(); dash >= 0 {
+		proxyArgs = proxyArgs[dash:]
+	}
+	b, remaining, err := migrate(proxyArgs)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	if err := ioutil.WriteFile(output, b, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", output, err)
+	}
+	klog.Infof("Wrote %s, run kube-rbac-proxy with: %s", output, strings.Join(append([]string{"--config-file=" + output}, remaining...), " "))
+	return nil
+}
+
+// migrate returns the versioned config file equivalent to the proxy flags
+// args and the config file they name, and the flags the file can't hold.
+// References to environment variables and pod fields in the authorization,
+// hosts and routes of the config file are kept.
+func migrate(args []string) ([]byte, []string, error) {
+	cfg, flagset, err := parseConfig(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var names, keep []string
+	excluded := sets.NewString(fileExcludedFlags...)
+	flagset.Visit(func(f *pflag.Flag) {
+		switch {
+		case f.Name == "config-file":
+		case excluded.Has(f.Name):
+			keep = append(keep, f.Name)
+		default:
+			names = append(names, f.Name)
+		}
+	})
+
+	o, remaining, err := rbac_proxy_config.FromFlags(flagset, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := v1alpha1.KubeRBACProxyConfig{Options: *o}
+	out.APIVersion, out.Kind = v1alpha1.SchemeGroupVersion.String(), v1alpha1.Kind
+
+	if cfg.configFile != "" {
+		var file v1alpha1.KubeRBACProxyConfig
+		if err := yaml.Unmarshal(cfg.fileContent, &file); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse config file content: %v", err)
+		}
+		out.Authorization, out.Hosts, out.Routes = file.Authorization, file.Hosts, file.Routes
+	}
+
+	b, err := yaml.Marshal(out)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal config file: %v", err)
+	}
+
+	var remainingArgs []string
+	for _, name := range append(keep, remaining...) {
+		f := flagset.Lookup(name)
+		value := f.Value.String()
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			value = strings.Join(sv.GetSlice(), ",")
+		} else {
+			value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+		}
+		remainingArgs = append(remainingArgs, fmt.Sprintf("--%s=%s", name, value))
+	}
+	if len(remainingArgs) > 0 {
+		klog.Warningf("The config file can't hold these flags, keep them on the command line: %s", strings.Join(remainingArgs, " "))
+	}
+	return b, remainingArgs, nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("POD_NAMESPACE", "monitoring")
+	defer os.Unsetenv("POD_NAMESPACE")
+
+	legacy := filepath.Join(dir, "legacy.yaml")
+	if err := ioutil.WriteFile(legacy, []byte(`
+authorization:
+  resourceAttributes:
+    namespace: ${fieldRef:metadata.namespace}
+    resource: services
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{
+		"--upstream=http://127.0.0.1:8081/",
+		"--secure-listen-address=:8443",
+		"--auth-token-audiences=a,b",
+		"--log-slow-requests=1s",
+		"--allow-paths=/metrics,/healthz",
+		"--config-file=" + legacy,
+	}
+
+	b, remaining, err := migrate(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"--allow-paths=/metrics,/healthz"}; !reflect.DeepEqual(remaining, want) {
+		t.Errorf("want remaining flags %v, got %v", want, remaining)
+	}
+	if !strings.Contains(string(b), "${fieldRef:metadata.namespace}") {
+		t.Errorf("want references of the config file to be kept, got:\n%s", b)
+	}
+
+	migrated := filepath.Join(dir, "migrated.yaml")
+	if err := ioutil.WriteFile(migrated, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := parseConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := parseConfig(append(remaining, "--config-file="+migrated))
+	if err != nil {
+		t.Fatalf("failed to parse migrated config:\n%s\n%v", b, err)
+	}
+	if !reflect.DeepEqual(got.secureListenAddresses, want.secureListenAddresses) ||
+		!reflect.DeepEqual(got.auth.Authentication.Token.Audiences, want.auth.Authentication.Token.Audiences) ||
+		!reflect.DeepEqual(got.auth.Authorization, want.auth.Authorization) ||
+		!reflect.DeepEqual(got.allowPaths, want.allowPaths) ||
+		got.upstream != want.upstream || got.logSlowRequests != want.logSlowRequests {
+		t.Errorf("want migrated config to be equivalent, got:\n%s", b)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/authn"
+	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+	"github.com/brancz/kube-rbac-proxy/pkg/proxy"
+)
+
+func addConversionFuncs(scheme *runtime.Scheme) error {
+	if err := scheme.AddConversionFunc((*KubeRBACProxyConfig)(nil), (*proxy.Config)(nil), func(a, b interface{}, s conversion.Scope) error {
+		return Convert_v1alpha1_KubeRBACProxyConfig_To_proxy_Config(a.(*KubeRBACProxyConfig), b.(*proxy.Config), s)
+	}); err != nil {
+		return err
+	}
+	if err := scheme.AddConversionFunc((*AuthorizationConfig)(nil), (*authz.Config)(nil), func(a, b interface{}, s conversion.Scope) error {
+		return Convert_v1alpha1_AuthorizationConfig_To_authz_Config(a.(*AuthorizationConfig), b.(*authz.Config), s)
+	}); err != nil {
+		return err
+	}
+	if err := scheme.AddConversionFunc((*authz.Config)(nil), (*AuthorizationConfig)(nil), func(a, b interface{}, s conversion.Scope) error {
+		return Convert_authz_Config_To_v1alpha1_AuthorizationConfig(a.(*authz.Config), b.(*AuthorizationConfig), s)
+	}); err != nil {
+		return err
+	}
+	if err := scheme.AddConversionFunc((*AuthenticationOptions)(nil), (*authn.AuthnConfig)(nil), func(a, b interface{}, s conversion.Scope) error {
+		return Convert_v1alpha1_AuthenticationOptions_To_authn_AuthnConfig(a.(*AuthenticationOptions), b.(*authn.AuthnConfig), s)
+	}); err != nil {
+		return err
+	}
+	if err := scheme.AddConversionFunc((*authn.AuthnConfig)(nil), (*AuthenticationOptions)(nil), func(a, b interface{}, s conversion.Scope) error {
+		return Convert_authn_AuthnConfig_To_v1alpha1_AuthenticationOptions(a.(*authn.AuthnConfig), b.(*AuthenticationOptions), s)
+	}); err != nil {
+		return err
+	}
+	return scheme.AddConversionFunc((*RouteAuthentication)(nil), (*authn.AuthnConfig)(nil), func(a, b interface{}, s conversion.Scope) error {
+		return Convert_v1alpha1_RouteAuthentication_To_authn_AuthnConfig(a.(*RouteAuthentication), b.(*authn.AuthnConfig), s)
+	})
+}
+
+// Convert_v1alpha1_KubeRBACProxyConfig_To_proxy_Config sets the
+// authentication and authorization settings of out configured by in. Unset
+// settings of in leave those of out as is, so out should hold the defaults.
+func Convert_v1alpha1_KubeRBACProxyConfig_To_proxy_Config(in *KubeRBACProxyConfig, out *proxy.Config, s conversion.Scope) error {
+	if in.Authentication != nil {
+		if out.Authentication == nil {
+			out.Authentication = &authn.AuthnConfig{}
+		}
+		if err := Convert_v1alpha1_AuthenticationOptions_To_authn_AuthnConfig(in.Authentication, out.Authentication, s); err != nil {
+			return err
+		}
+	}
+	if in.Authorization != nil {
+		out.Authorization = &authz.Config{}
+		if err := Convert_v1alpha1_AuthorizationConfig_To_authz_Config(in.Authorization, out.Authorization, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Convert_v1alpha1_AuthorizationConfig_To_authz_Config converts in to the
+// internal authorization config.
+func Convert_v1alpha1_AuthorizationConfig_To_authz_Config(in *AuthorizationConfig, out *authz.Config, _ conversion.Scope) error {
+	out.Rewrites = nil
+	if in.Rewrites != nil {
+		out.Rewrites = &authz.SubjectAccessReviewRewrites{}
+		if q := in.Rewrites.ByQueryParameter; q != nil {
+			out.Rewrites.ByQueryParameter = &authz.QueryParameterRewriteConfig{Name: q.Name}
+		}
+	}
+	out.ResourceAttributes = nil
+	if a := in.ResourceAttributes; a != nil {
+		out.ResourceAttributes = &authz.ResourceAttributes{
+			Namespace:   a.Namespace,
+			APIGroup:    a.APIGroup,
+			APIVersion:  a.APIVersion,
+			Resource:    a.Resource,
+			Subresource: a.Subresource,
+			Name:        a.Name,
+		}
+	}
+	return nil
+}
+
+// Convert_authz_Config_To_v1alpha1_AuthorizationConfig converts the internal
+// authorization config in to v1alpha1. Settings only set by flags are
+// dropped.
+func Convert_authz_Config_To_v1alpha1_AuthorizationConfig(in *authz.Config, out *AuthorizationConfig, _ conversion.Scope) error {
+	out.Rewrites = nil
+	if in.Rewrites != nil {
+		out.Rewrites = &SubjectAccessReviewRewrites{}
+		if q := in.Rewrites.ByQueryParameter; q != nil {
+			out.Rewrites.ByQueryParameter = &QueryParameterRewriteConfig{Name: q.Name}
+		}
+	}
+	out.ResourceAttributes = nil
+	if a := in.ResourceAttributes; a != nil {
+		out.ResourceAttributes = &ResourceAttributes{
+			Namespace:   a.Namespace,
+			APIGroup:    a.APIGroup,
+			APIVersion:  a.APIVersion,
+			Resource:    a.Resource,
+			Subresource: a.Subresource,
+			Name:        a.Name,
+		}
+	}
+	return nil
+}
+
+// Convert_v1alpha1_AuthenticationOptions_To_authn_AuthnConfig sets the
+// settings of out configured by in.
+func Convert_v1alpha1_AuthenticationOptions_To_authn_AuthnConfig(in *AuthenticationOptions, out *authn.AuthnConfig, _ conversion.Scope) error {
+	if out.X509 == nil {
+		out.X509 = &authn.X509Config{}
+	}
+	if out.Header == nil {
+		out.Header = &authn.AuthnHeaderConfig{}
+	}
+	if out.OIDC == nil {
+		out.OIDC = &authn.OIDCConfig{}
+	}
+	if out.Token == nil {
+		out.Token = &authn.TokenConfig{}
+	}
+
+	setString(&out.X509.ClientCAFile, in.ClientCAFile)
+	if in.TokenAudiences != nil {
+		out.Token.Audiences = append([]string(nil), in.TokenAudiences...)
+	}
+	if h := in.Header; h != nil {
+		if h.Enabled != nil {
+			out.Header.Enabled = *h.Enabled
+		}
+		setString(&out.Header.UserFieldName, h.UserFieldName)
+		setString(&out.Header.GroupsFieldName, h.GroupsFieldName)
+		setString(&out.Header.GroupSeparator, h.GroupSeparator)
+	}
+	if o := in.OIDC; o != nil {
+		setString(&out.OIDC.IssuerURL, o.IssuerURL)
+		setString(&out.OIDC.ClientID, o.ClientID)
+		setString(&out.OIDC.UsernameClaim, o.UsernameClaim)
+		setString(&out.OIDC.GroupsClaim, o.GroupsClaim)
+		setString(&out.OIDC.GroupsPrefix, o.GroupsPrefix)
+		setString(&out.OIDC.CAFile, o.CAFile)
+		if o.SigningAlgs != nil {
+			out.OIDC.SupportedSigningAlgs = append([]string(nil), o.SigningAlgs...)
+		}
+	}
+	return nil
+}
+
+// Convert_authn_AuthnConfig_To_v1alpha1_AuthenticationOptions converts the
+// internal authentication config in to v1alpha1, setting all its settings.
+func Convert_authn_AuthnConfig_To_v1alpha1_AuthenticationOptions(in *authn.AuthnConfig, out *AuthenticationOptions, _ conversion.Scope) error {
+	*out = AuthenticationOptions{}
+	if in.X509 != nil {
+		out.ClientCAFile = stringPtr(in.X509.ClientCAFile)
+	}
+	if in.Token != nil {
+		out.TokenAudiences = append([]string(nil), in.Token.Audiences...)
+	}
+	if h := in.Header; h != nil {
+		enabled := h.Enabled
+		out.Header = &AuthnHeaderOptions{
+			Enabled:         &enabled,
+			UserFieldName:   stringPtr(h.UserFieldName),
+			GroupsFieldName: stringPtr(h.GroupsFieldName),
+			GroupSeparator:  stringPtr(h.GroupSeparator),
+		}
+	}
+	if o := in.OIDC; o != nil {
+		out.OIDC = &OIDCOptions{
+			IssuerURL:     stringPtr(o.IssuerURL),
+			ClientID:      stringPtr(o.ClientID),
+			UsernameClaim: stringPtr(o.UsernameClaim),
+			GroupsClaim:   stringPtr(o.GroupsClaim),
+			GroupsPrefix:  stringPtr(o.GroupsPrefix),
+			SigningAlgs:   append([]string(nil), o.SupportedSigningAlgs...),
+			CAFile:        stringPtr(o.CAFile),
+		}
+	}
+	return nil
+}
+
+// Convert_v1alpha1_RouteAuthentication_To_authn_AuthnConfig sets the
+// settings of out configured by in.
+func Convert_v1alpha1_RouteAuthentication_To_authn_AuthnConfig(in *RouteAuthentication, out *authn.AuthnConfig, s conversion.Scope) error {
+	return Convert_v1alpha1_AuthenticationOptions_To_authn_AuthnConfig(&AuthenticationOptions{
+		TokenAudiences: in.TokenAudiences,
+		Header:         in.Header,
+	}, out, s)
+}
+
+func setString(out *string, in *string) {
+	if in != nil {
+		*out = *in
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto copies in into out.
+func (in *KubeRBACProxyConfig) DeepCopyInto(out *KubeRBACProxyConfig) {
+	*out = *in
+	in.Options.DeepCopyInto(&out.Options)
+	out.Authorization = in.Authorization.DeepCopy()
+	if in.Hosts != nil {
+		out.Hosts = make([]Host, len(in.Hosts))
+		for i := range in.Hosts {
+			in.Hosts[i].DeepCopyInto(&out.Hosts[i])
+		}
+	}
+	if in.Routes != nil {
+		out.Routes = make([]Route, len(in.Routes))
+		for i := range in.Routes {
+			in.Routes[i].DeepCopyInto(&out.Routes[i])
+		}
+	}
+}
+
+// DeepCopy returns a deep copy of in.
+func (in *KubeRBACProxyConfig) DeepCopy() *KubeRBACProxyConfig {
+	if in == nil {
+		return nil
+	}
+	out := &KubeRBACProxyConfig{}
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a deep copy of in.
+func (in *KubeRBACProxyConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies in into out.
+func (in *Options) DeepCopyInto(out *Options) {
+	*out = *in
+	out.Kubeconfig = copyString(in.Kubeconfig)
+	out.KubeconfigContext = copyString(in.KubeconfigContext)
+	if in.Listen != nil {
+		l := *in.Listen
+		l.SecureAddresses = copyStrings(in.Listen.SecureAddresses)
+		l.InsecureAddress = copyString(in.Listen.InsecureAddress)
+		l.InsecureAllowNonLoopback = copyBool(in.Listen.InsecureAllowNonLoopback)
+		l.HealthAddress = copyString(in.Listen.HealthAddress)
+		out.Listen = &l
+	}
+	if in.TLS != nil {
+		t := *in.TLS
+		t.CertFile = copyString(in.TLS.CertFile)
+		t.PrivateKeyFile = copyString(in.TLS.PrivateKeyFile)
+		t.SNICertKeys = copyStrings(in.TLS.SNICertKeys)
+		t.MinVersion = copyString(in.TLS.MinVersion)
+		t.MaxVersion = copyString(in.TLS.MaxVersion)
+		t.CipherSuites = copyStrings(in.TLS.CipherSuites)
+		t.CurvePreferences = copyStrings(in.TLS.CurvePreferences)
+		t.ClientAuth = copyString(in.TLS.ClientAuth)
+		t.ReloadInterval = copyDuration(in.TLS.ReloadInterval)
+		out.TLS = &t
+	}
+	if in.Upstream != nil {
+		u := *in.Upstream
+		u.URL = copyString(in.Upstream.URL)
+		u.CAFile = copyString(in.Upstream.CAFile)
+		u.Protocol = copyString(in.Upstream.Protocol)
+		u.ForceH2C = copyBool(in.Upstream.ForceH2C)
+		u.ProxyURL = copyString(in.Upstream.ProxyURL)
+		u.Timeout = copyDuration(in.Upstream.Timeout)
+		u.Retries = copyInt(in.Upstream.Retries)
+		u.FlushInterval = copyDuration(in.Upstream.FlushInterval)
+		out.Upstream = &u
+	}
+	if in.Authentication != nil {
+		a := *in.Authentication
+		a.ClientCAFile = copyString(in.Authentication.ClientCAFile)
+		a.TokenAudiences = copyStrings(in.Authentication.TokenAudiences)
+		a.Header = in.Authentication.Header.DeepCopy()
+		if o := in.Authentication.OIDC; o != nil {
+			a.OIDC = &OIDCOptions{
+				IssuerURL:     copyString(o.IssuerURL),
+				ClientID:      copyString(o.ClientID),
+				UsernameClaim: copyString(o.UsernameClaim),
+				GroupsClaim:   copyString(o.GroupsClaim),
+				GroupsPrefix:  copyString(o.GroupsPrefix),
+				SigningAlgs:   copyStrings(o.SigningAlgs),
+				CAFile:        copyString(o.CAFile),
+			}
+		}
+		out.Authentication = &a
+	}
+	if in.Flags != nil {
+		out.Flags = make(map[string]string, len(in.Flags))
+		for k, v := range in.Flags {
+			out.Flags[k] = v
+		}
+	}
+}
+
+// DeepCopy returns a deep copy of in.
+func (in *AuthorizationConfig) DeepCopy() *AuthorizationConfig {
+	if in == nil {
+		return nil
+	}
+	out := &AuthorizationConfig{}
+	if in.Rewrites != nil {
+		out.Rewrites = &SubjectAccessReviewRewrites{}
+		if in.Rewrites.ByQueryParameter != nil {
+			q := *in.Rewrites.ByQueryParameter
+			out.Rewrites.ByQueryParameter = &q
+		}
+	}
+	if in.ResourceAttributes != nil {
+		a := *in.ResourceAttributes
+		out.ResourceAttributes = &a
+	}
+	return out
+}
+
+// DeepCopyInto copies in into out.
+func (in *Host) DeepCopyInto(out *Host) {
+	*out = *in
+	out.Authorization = in.Authorization.DeepCopy()
+	out.Timeout = copyDuration(in.Timeout)
+	out.Retries = copyInt(in.Retries)
+	out.MaxRequestBodyBytes = copyInt64(in.MaxRequestBodyBytes)
+	out.MaxResponseBodyBytes = copyInt64(in.MaxResponseBodyBytes)
+	out.FlushInterval = copyDuration(in.FlushInterval)
+}
+
+// DeepCopy returns a deep copy of in.
+func (in *AuthnHeaderOptions) DeepCopy() *AuthnHeaderOptions {
+	if in == nil {
+		return nil
+	}
+	return &AuthnHeaderOptions{
+		Enabled:         copyBool(in.Enabled),
+		UserFieldName:   copyString(in.UserFieldName),
+		GroupsFieldName: copyString(in.GroupsFieldName),
+		GroupSeparator:  copyString(in.GroupSeparator),
+	}
+}
+
+// DeepCopyInto copies in into out.
+func (in *Route) DeepCopyInto(out *Route) {
+	*out = *in
+	if in.Authentication != nil {
+		out.Authentication = &RouteAuthentication{
+			TokenAudiences: copyStrings(in.Authentication.TokenAudiences),
+			Header:         in.Authentication.Header.DeepCopy(),
+		}
+	}
+	out.Authorization = in.Authorization.DeepCopy()
+	out.Timeout = copyDuration(in.Timeout)
+	out.Retries = copyInt(in.Retries)
+	out.MaxRequestBodyBytes = copyInt64(in.MaxRequestBodyBytes)
+	out.MaxResponseBodyBytes = copyInt64(in.MaxResponseBodyBytes)
+	out.FlushInterval = copyDuration(in.FlushInterval)
+}
+
+func copyString(in *string) *string {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	return &out
+}
+
+func copyBool(in *bool) *bool {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	return &out
+}
+
+func copyInt(in *int) *int {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	return &out
+}
+
+func copyInt64(in *int64) *int64 {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	return &out
+}
+
+func copyDuration(in *metav1.Duration) *metav1.Duration {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	return &out
+}
+
+func copyStrings(in []string) []string {
+	if in == nil {
+		return nil
+	}
+	return append([]string{}, in...)
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func addDefaultingFuncs(scheme *runtime.Scheme) error {
+	scheme.AddTypeDefaultingFunc(&KubeRBACProxyConfig{}, func(obj interface{}) {
+		SetDefaults_KubeRBACProxyConfig(obj.(*KubeRBACProxyConfig))
+	})
+	return nil
+}
+
+// SetDefaults_KubeRBACProxyConfig defaults the authorization of obj to
+// authorize the request path, and that of hosts and routes without their own
+// to the global one. Options are left unset, so their flags keep their defaults.
+func SetDefaults_KubeRBACProxyConfig(obj *KubeRBACProxyConfig) {
+	if obj.Authorization == nil {
+		obj.Authorization = &AuthorizationConfig{}
+	}
+	for i := range obj.Hosts {
+		if obj.Hosts[i].Authorization == nil {
+			obj.Hosts[i].Authorization = obj.Authorization.DeepCopy()
+		}
+	}
+	for i := range obj.Routes {
+		if obj.Routes[i].Authorization == nil {
+			obj.Routes[i].Authorization = obj.Authorization.DeepCopy()
+		}
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KubeRBACProxyConfig is the configuration of kube-rbac-proxy. Config files
+// without apiVersion and kind are read in the legacy format, which only
+// holds the authorization and hosts sections.
+type KubeRBACProxyConfig struct {
+	metav1.TypeMeta `json:",inline"`
+	Options         `json:",inline"`
+
+	// Authorization configures how requests are authorized.
+	Authorization *AuthorizationConfig `json:"authorization,omitempty"`
+	// Hosts are virtual hosts, routed to by TLS SNI or Host header.
+	Hosts []Host `json:"hosts,omitempty"`
+	// Routes route the requests not matching a virtual host by path prefix.
+	// Requests matching no route are proxied to the global upstream.
+	Routes []Route `json:"routes,omitempty"`
+}
+
+// Options are the settings which can also be set by flags. Fields are tagged
+// with the name of the flag they set, unset fields leave the flag as is.
+type Options struct {
+	// Kubeconfig is the kubeconfig file to connect to the kube-apiserver with.
+	Kubeconfig *string `json:"kubeconfig,omitempty" flag:"kubeconfig"`
+	// KubeconfigContext is the context of the kubeconfig to use.
+	KubeconfigContext *string `json:"kubeconfigContext,omitempty" flag:"kubeconfig-context"`
+
+	Listen         *ListenOptions         `json:"listen,omitempty"`
+	TLS            *TLSOptions            `json:"tls,omitempty"`
+	Upstream       *UpstreamOptions       `json:"upstream,omitempty"`
+	Authentication *AuthenticationOptions `json:"authentication,omitempty"`
+
+	// Flags sets any other flag by its name, e.g. "log-slow-requests: 1s".
+	Flags map[string]string `json:"flags,omitempty"`
+}
+
+// ListenOptions configure the addresses the proxy listens on.
+type ListenOptions struct {
+	SecureAddresses          []string `json:"secureAddresses,omitempty" flag:"secure-listen-address"`
+	InsecureAddress          *string  `json:"insecureAddress,omitempty" flag:"insecure-listen-address"`
+	InsecureAllowNonLoopback *bool    `json:"insecureAllowNonLoopback,omitempty" flag:"insecure-listen-allow-non-loopback"`
+	HealthAddress            *string  `json:"healthAddress,omitempty" flag:"health-listen-address"`
+}
+
+// TLSOptions configure the serving certificate and TLS parameters of the
+// secure listener.
+type TLSOptions struct {
+	CertFile         *string          `json:"certFile,omitempty" flag:"tls-cert-file"`
+	PrivateKeyFile   *string          `json:"privateKeyFile,omitempty" flag:"tls-private-key-file"`
+	SNICertKeys      []string         `json:"sniCertKeys,omitempty" flag:"tls-sni-cert-key"`
+	MinVersion       *string          `json:"minVersion,omitempty" flag:"tls-min-version"`
+	MaxVersion       *string          `json:"maxVersion,omitempty" flag:"tls-max-version"`
+	CipherSuites     []string         `json:"cipherSuites,omitempty" flag:"tls-cipher-suites"`
+	CurvePreferences []string         `json:"curvePreferences,omitempty" flag:"tls-curve-preferences"`
+	ClientAuth       *string          `json:"clientAuth,omitempty" flag:"tls-client-auth"`
+	ReloadInterval   *metav1.Duration `json:"reloadInterval,omitempty" flag:"tls-reload-interval"`
+}
+
+// UpstreamOptions configure how requests are proxied to the upstream.
+type UpstreamOptions struct {
+	URL           *string          `json:"url,omitempty" flag:"upstream"`
+	CAFile        *string          `json:"caFile,omitempty" flag:"upstream-ca-file"`
+	Protocol      *string          `json:"protocol,omitempty" flag:"upstream-protocol"`
+	ForceH2C      *bool            `json:"forceH2C,omitempty" flag:"upstream-force-h2c"`
+	ProxyURL      *string          `json:"proxyURL,omitempty" flag:"upstream-proxy-url"`
+	Timeout       *metav1.Duration `json:"timeout,omitempty" flag:"upstream-timeout"`
+	Retries       *int             `json:"retries,omitempty" flag:"upstream-retries"`
+	FlushInterval *metav1.Duration `json:"flushInterval,omitempty" flag:"upstream-flush-interval"`
+}
+
+// AuthenticationOptions configure how clients are authenticated.
+type AuthenticationOptions struct {
+	ClientCAFile   *string             `json:"clientCAFile,omitempty" flag:"client-ca-file"`
+	TokenAudiences []string            `json:"tokenAudiences,omitempty" flag:"auth-token-audiences"`
+	Header         *AuthnHeaderOptions `json:"header,omitempty"`
+	OIDC           *OIDCOptions        `json:"oidc,omitempty"`
+}
+
+// AuthnHeaderOptions configure the headers telling the upstream about the
+// authenticated user.
+type AuthnHeaderOptions struct {
+	Enabled         *bool   `json:"enabled,omitempty" flag:"auth-header-fields-enabled"`
+	UserFieldName   *string `json:"userFieldName,omitempty" flag:"auth-header-user-field-name"`
+	GroupsFieldName *string `json:"groupsFieldName,omitempty" flag:"auth-header-groups-field-name"`
+	GroupSeparator  *string `json:"groupSeparator,omitempty" flag:"auth-header-groups-field-separator"`
+}
+
+// OIDCOptions configure the authentication of OpenID Connect tokens.
+type OIDCOptions struct {
+	IssuerURL     *string  `json:"issuerURL,omitempty" flag:"oidc-issuer"`
+	ClientID      *string  `json:"clientID,omitempty" flag:"oidc-clientID"`
+	UsernameClaim *string  `json:"usernameClaim,omitempty" flag:"oidc-username-claim"`
+	GroupsClaim   *string  `json:"groupsClaim,omitempty" flag:"oidc-groups-claim"`
+	GroupsPrefix  *string  `json:"groupsPrefix,omitempty" flag:"oidc-groups-prefix"`
+	SigningAlgs   []string `json:"signingAlgs,omitempty" flag:"oidc-sign-alg"`
+	CAFile        *string  `json:"caFile,omitempty" flag:"oidc-ca-file"`
+}
+
+// AuthorizationConfig configures how requests are authorized.
+type AuthorizationConfig struct {
+	// Rewrites configure how SubjectAccessReviews are rewritten per request.
+	Rewrites *SubjectAccessReviewRewrites `json:"rewrites,omitempty"`
+	// ResourceAttributes are authorized instead of the request path. They
+	// may be templates of the rewritten value.
+	ResourceAttributes *ResourceAttributes `json:"resourceAttributes,omitempty"`
+}
+
+// SubjectAccessReviewRewrites describe how SubjectAccessReviews may be
+// rewritten on a given request.
+type SubjectAccessReviewRewrites struct {
+	ByQueryParameter *QueryParameterRewriteConfig `json:"byQueryParameter,omitempty"`
+}
+
+// QueryParameterRewriteConfig describes which HTTP URL query parameter is
+// used to rewrite SubjectAccessReviews.
+type QueryParameterRewriteConfig struct {
+	Name string `json:"name,omitempty"`
+}
+
+// ResourceAttributes are the attributes of resource requests to authorize.
+type ResourceAttributes struct {
+	Namespace   string `json:"namespace,omitempty"`
+	APIGroup    string `json:"apiGroup,omitempty"`
+	APIVersion  string `json:"apiVersion,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+// Host configures a virtual host, routed to by TLS SNI or Host header.
+type Host struct {
+	// Host is the server name, a leading "*." matches any single label.
+	Host string `json:"host"`
+	// Upstream is the URL requests for this host are proxied to.
+	Upstream string `json:"upstream"`
+	// UpstreamCAFile is the CA the upstream uses for TLS connection.
+	UpstreamCAFile string `json:"upstreamCAFile,omitempty"`
+	// TLSCertFile and TLSKeyFile are served to clients requesting this host via SNI.
+	TLSCertFile string `json:"tlsCertFile,omitempty"`
+	TLSKeyFile  string `json:"tlsKeyFile,omitempty"`
+	// ClientCAFile replaces --client-ca-file for clients requesting this host via SNI.
+	ClientCAFile string `json:"clientCAFile,omitempty"`
+	// Authorization overrides the global authorization config for this host.
+	Authorization *AuthorizationConfig `json:"authorization,omitempty"`
+	// ProxyOverrides override the global proxy behavior for this host.
+	ProxyOverrides `json:",inline"`
+}
+
+// Route proxies the requests whose path starts with PathPrefix to its own
+// upstream, with its own authentication and authorization settings.
+type Route struct {
+	// Name identifies the route in logs and metrics.
+	Name string `json:"name"`
+	// PathPrefix selects the requests of this route. It matches whole path
+	// segments, unless it ends with "/", and the longest matching prefix wins.
+	PathPrefix string `json:"pathPrefix"`
+	// Upstream is the URL requests of this route are proxied to.
+	Upstream string `json:"upstream"`
+	// UpstreamCAFile is the CA the upstream uses for TLS connection.
+	UpstreamCAFile string `json:"upstreamCAFile,omitempty"`
+	// Authentication overrides the global authentication settings for this route.
+	Authentication *RouteAuthentication `json:"authentication,omitempty"`
+	// Authorization overrides the global authorization config for this route.
+	Authorization *AuthorizationConfig `json:"authorization,omitempty"`
+	// ProxyOverrides override the global proxy behavior for this route.
+	ProxyOverrides `json:",inline"`
+}
+
+// RouteAuthentication overrides the authentication settings of a route.
+// Unset fields inherit the global value.
+type RouteAuthentication struct {
+	// TokenAudiences are the audiences tokens must be valid for.
+	TokenAudiences []string `json:"tokenAudiences,omitempty"`
+	// Header configures the headers telling the upstream about the user.
+	Header *AuthnHeaderOptions `json:"header,omitempty"`
+}
+
+// ProxyOverrides override the global proxy behavior. Unset fields inherit
+// the global value.
+type ProxyOverrides struct {
+	// Timeout is the maximum duration of proxied requests, zero disables the timeout.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Retries is the number of times idempotent requests are retried if the upstream couldn't be reached.
+	Retries *int `json:"retries,omitempty"`
+	// MaxRequestBodyBytes is the maximum size of request bodies, zero disables the limit.
+	MaxRequestBodyBytes *int64 `json:"maxRequestBodyBytes,omitempty"`
+	// MaxResponseBodyBytes is the maximum size of upstream responses, zero disables the limit.
+	MaxResponseBodyBytes *int64 `json:"maxResponseBodyBytes,omitempty"`
+	// FlushInterval is the interval in which responses are flushed to the client,
+	// a negative value flushes immediately after each write.
+	FlushInterval *metav1.Duration `json:"flushInterval,omitempty"`
+}
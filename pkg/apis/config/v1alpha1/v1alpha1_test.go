@@ -0,0 +1,219 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/authn"
+	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+	"github.com/brancz/kube-rbac-proxy/pkg/proxy"
+)
+
+const config = `apiVersion: kube-rbac-proxy.brancz.com/v1alpha1
+authentication:
+  clientCAFile: /etc/ca.crt
+  header:
+    enabled: true
+    userFieldName: x-user
+  oidc:
+    issuerURL: https://issuer.example.com
+    signingAlgs:
+    - RS256
+  tokenAudiences:
+  - kube-rbac-proxy
+authorization:
+  resourceAttributes:
+    namespace: '{{ .Value }}'
+    resource: services
+  rewrites:
+    byQueryParameter:
+      name: namespace
+flags:
+  log-slow-requests: 1s
+hosts:
+- authorization:
+    resourceAttributes:
+      resource: pods
+  host: a.example.com
+  retries: 2
+  timeout: 30s
+  upstream: http://127.0.0.1:8081/
+kind: KubeRBACProxyConfig
+listen:
+  secureAddresses:
+  - :8443
+upstream:
+  timeout: 1m0s
+  url: http://127.0.0.1:8080/
+`
+
+func TestRoundTrip(t *testing.T) {
+	var c KubeRBACProxyConfig
+	if err := yaml.Unmarshal([]byte(config), &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := yaml.Marshal(c.DeepCopy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != config {
+		t.Errorf("want config to round-trip, got:\n%s", b)
+	}
+
+	internal := proxy.Config{}
+	if err := Convert_v1alpha1_KubeRBACProxyConfig_To_proxy_Config(&c, &internal, nil); err != nil {
+		t.Fatal(err)
+	}
+	if internal.Authorization.ResourceAttributes.Namespace != "{{ .Value }}" || internal.Authentication.Header.UserFieldName != "x-user" || internal.Authentication.OIDC.IssuerURL != "https://issuer.example.com" {
+		t.Errorf("unexpected internal config %+v", internal)
+	}
+
+	var authorization AuthorizationConfig
+	if err := Convert_authz_Config_To_v1alpha1_AuthorizationConfig(internal.Authorization, &authorization, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&authorization, c.Authorization) {
+		t.Errorf("want authorization %+v to round-trip, got %+v", c.Authorization, authorization)
+	}
+
+	var authentication AuthenticationOptions
+	if err := Convert_authn_AuthnConfig_To_v1alpha1_AuthenticationOptions(internal.Authentication, &authentication, nil); err != nil {
+		t.Fatal(err)
+	}
+	roundTripped := &authn.AuthnConfig{}
+	if err := Convert_v1alpha1_AuthenticationOptions_To_authn_AuthnConfig(&authentication, roundTripped, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roundTripped, internal.Authentication) {
+		t.Errorf("want authentication %+v to round-trip, got %+v", internal.Authentication, roundTripped)
+	}
+}
+
+func TestScheme(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &KubeRBACProxyConfig{Hosts: []Host{{Host: "a.example.com", Upstream: "http://a/"}}}
+	scheme.Default(c)
+	if c.Authorization == nil || !reflect.DeepEqual(c.Hosts[0].Authorization, c.Authorization) {
+		t.Errorf("want authorization of hosts to default to the global one, got %+v", c)
+	}
+
+	c.Authorization.ResourceAttributes = &ResourceAttributes{Resource: "services"}
+	out := &authz.Config{}
+	if err := scheme.Convert(c.Authorization, out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.ResourceAttributes == nil || out.ResourceAttributes.Resource != "services" {
+		t.Errorf("want converted resource attributes, got %+v", out)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	url := "http://file/"
+	for _, tc := range []struct {
+		name string
+		c    KubeRBACProxyConfig
+		errs []string
+	}{
+		{name: "legacy"},
+		{
+			name: "options without version",
+			c:    KubeRBACProxyConfig{Options: Options{Upstream: &UpstreamOptions{URL: &url}}},
+			errs: []string{"are required"},
+		},
+		{
+			name: "unknown version",
+			c:    KubeRBACProxyConfig{TypeMeta: metav1.TypeMeta{APIVersion: "v2", Kind: Kind}},
+			errs: []string{`unsupported apiVersion "v2"`},
+		},
+		{
+			name: "invalid",
+			c: KubeRBACProxyConfig{
+				Authorization: &AuthorizationConfig{Rewrites: &SubjectAccessReviewRewrites{ByQueryParameter: &QueryParameterRewriteConfig{Name: "namespace"}}},
+				Hosts: []Host{
+					{Host: "a", Upstream: url, Authorization: &AuthorizationConfig{ResourceAttributes: &ResourceAttributes{Namespace: "{{ .Value }}"}}},
+					{Host: "a", Upstream: url},
+					{Host: "b"},
+				},
+			},
+			errs: []string{
+				"invalid authorization config: rewrites.byQueryParameter requires resourceAttributes",
+				`invalid authorization config of host "a": resourceAttributes.namespace is a template`,
+				`virtual host "a" is configured more than once`,
+				`got host "b" with upstream ""`,
+			},
+		},
+		{
+			name: "routes without version",
+			c:    KubeRBACProxyConfig{Routes: []Route{{Name: "a", PathPrefix: "/a", Upstream: url}}},
+			errs: []string{"are required"},
+		},
+		{
+			name: "invalid routes",
+			c: KubeRBACProxyConfig{
+				TypeMeta: metav1.TypeMeta{APIVersion: SchemeGroupVersion.String(), Kind: Kind},
+				Hosts:    []Host{{Host: "h", Upstream: url}},
+				Routes: []Route{
+					{Name: "a", PathPrefix: "/a", Upstream: url},
+					{Name: "a", PathPrefix: "b", Upstream: "file"},
+					{Name: "h", PathPrefix: "/a", Upstream: url, Authorization: &AuthorizationConfig{ResourceAttributes: &ResourceAttributes{Namespace: "{{ .Value }}"}}},
+					{Name: "c"},
+				},
+			},
+			errs: []string{
+				`route name "a" is used more than once or by a virtual host`,
+				`route name "h" is used more than once or by a virtual host`,
+				`path prefix "b" of route "a" must start with "/"`,
+				`invalid upstream URL "file" of route "a", must be absolute`,
+				`path prefix "/a" of route "h" is used by another route`,
+				`invalid authorization config of route "h": resourceAttributes.namespace is a template`,
+				`got name "c" with path prefix "" and upstream ""`,
+			},
+		},
+	} {
+		err := Validate(&tc.c)
+		if len(tc.errs) == 0 {
+			if err != nil {
+				t.Errorf("%s: want no error, got %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: want errors, got nil", tc.name)
+			continue
+		}
+		for _, want := range tc.errs {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: want error containing %q, got %v", tc.name, want, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+)
+
+// ValidateVersion returns an error if the apiVersion and kind of obj aren't
+// supported, or if settings other than the legacy ones are used without
+// them.
+func ValidateVersion(obj *KubeRBACProxyConfig) error {
+	apiVersion := SchemeGroupVersion.String()
+	if obj.APIVersion == "" && obj.Kind == "" {
+		if !reflect.DeepEqual(obj.Options, Options{}) || len(obj.Routes) > 0 {
+			return fmt.Errorf("apiVersion %q and kind %q are required to configure listeners, TLS, the upstream, authentication, routes or flags", apiVersion, Kind)
+		}
+		return nil
+	}
+	if obj.APIVersion != apiVersion || obj.Kind != Kind {
+		return fmt.Errorf("unsupported apiVersion %q and kind %q, want %q and %q", obj.APIVersion, obj.Kind, apiVersion, Kind)
+	}
+	return nil
+}
+
+// Validate returns all errors of obj: an unsupported version, invalid
+// authorization configs, and virtual hosts and routes which are incomplete
+// or configured more than once.
+func Validate(obj *KubeRBACProxyConfig) error {
+	var errs []error
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if err := ValidateVersion(obj); err != nil {
+		errs = append(errs, err)
+	}
+	if obj.Authorization != nil {
+		if err := validateAuthorization(obj.Authorization); err != nil {
+			addErr("invalid authorization config: %v", err)
+		}
+	}
+
+	hosts := sets.NewString()
+	for _, h := range obj.Hosts {
+		if h.Host == "" || h.Upstream == "" {
+			addErr("virtual hosts require a host and an upstream, got host %q with upstream %q", h.Host, h.Upstream)
+			continue
+		}
+		if hosts.Has(h.Host) {
+			addErr("virtual host %q is configured more than once", h.Host)
+		}
+		hosts.Insert(h.Host)
+		if _, err := url.Parse(h.Upstream); err != nil {
+			addErr("invalid upstream URL of host %q: %v", h.Host, err)
+		}
+		if h.Authorization != nil {
+			if err := validateAuthorization(h.Authorization); err != nil {
+				addErr("invalid authorization config of host %q: %v", h.Host, err)
+			}
+		}
+	}
+
+	names, prefixes := sets.NewString(), sets.NewString()
+	for _, r := range obj.Routes {
+		if r.Name == "" || r.PathPrefix == "" || r.Upstream == "" {
+			addErr("routes require a name, a path prefix and an upstream, got name %q with path prefix %q and upstream %q", r.Name, r.PathPrefix, r.Upstream)
+			continue
+		}
+		if names.Has(r.Name) || hosts.Has(r.Name) || r.Name == "default" {
+			addErr("route name %q is used more than once or by a virtual host", r.Name)
+		}
+		names.Insert(r.Name)
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			addErr("path prefix %q of route %q must start with \"/\"", r.PathPrefix, r.Name)
+		}
+		if prefixes.Has(r.PathPrefix) {
+			addErr("path prefix %q of route %q is used by another route", r.PathPrefix, r.Name)
+		}
+		prefixes.Insert(r.PathPrefix)
+		if u, err := url.Parse(r.Upstream); err != nil || u.Scheme == "" || u.Host == "" {
+			addErr("invalid upstream URL %q of route %q, must be absolute", r.Upstream, r.Name)
+		}
+		if r.Authorization != nil {
+			if err := validateAuthorization(r.Authorization); err != nil {
+				addErr("invalid authorization config of route %q: %v", r.Name, err)
+			}
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+func validateAuthorization(c *AuthorizationConfig) error {
+	internal := &authz.Config{}
+	if err := Convert_v1alpha1_AuthorizationConfig_To_authz_Config(c, internal, nil); err != nil {
+		return err
+	}
+	return internal.Validate()
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	k8saudit "k8s.io/apiserver/pkg/audit"
+	"k8s.io/apiserver/plugin/pkg/audit/buffered"
+	pluginlog "k8s.io/apiserver/plugin/pkg/audit/log"
+	pluginwebhook "k8s.io/apiserver/plugin/pkg/audit/webhook"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/logging"
+)
+
+const (
+	// ModeBatch buffers events and sends them in batches asynchronously.
+	// Events are dropped if the buffer is full.
+	ModeBatch = "batch"
+	// ModeBlocking sends each event before the request completes.
+	ModeBlocking = "blocking"
+)
+
+// Config holds the audit backends to send events to.
+type Config struct {
+	// LogPath is the file to write events to, "-" writes to standard output.
+	LogPath string
+	// LogRotation configures the rotation of the file at LogPath.
+	LogRotation logging.RotationConfig
+
+	// WebhookConfigFile is a kubeconfig file describing the audit webhook,
+	// like the kube-apiserver's --audit-webhook-config-file.
+	WebhookConfigFile string
+	// WebhookMode is the strategy to send events to the webhook with.
+	WebhookMode string
+	// WebhookInitialBackoff is the time to wait before retrying a failed request.
+	WebhookInitialBackoff time.Duration
+	// WebhookBatch configures buffering in ModeBatch.
+	WebhookBatch buffered.BatchConfig
+}
+
+// DefaultBatchConfig returns the kube-apiserver's defaults for batching webhook events.
+func DefaultBatchConfig() buffered.BatchConfig {
+	return buffered.BatchConfig{
+		BufferSize:     10000,
+		MaxBatchSize:   400,
+		MaxBatchWait:   30 * time.Second,
+		ThrottleEnable: true,
+		ThrottleQPS:    10,
+		ThrottleBurst:  15,
+		AsyncDelegate:  true,
+	}
+}
+
+// NewBackend returns a backend sending events to all configured backends,
+// or nil if none is configured.
+func NewBackend(cfg Config) (k8saudit.Backend, error) {
+	var backends []k8saudit.Backend
+
+	if cfg.LogPath != "" {
+		var out io.Writer = os.Stdout
+		if cfg.LogPath != "-" {
+			f, err := logging.OpenRotatingFile(cfg.LogPath, cfg.LogRotation)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open audit log: %v", err)
+			}
+			out = f
+		}
+		backends = append(backends, pluginlog.NewBackend(out, pluginlog.FormatJson, auditv1.SchemeGroupVersion))
+	}
+
+	if cfg.WebhookConfigFile != "" {
+		webhook, err := pluginwebhook.NewBackend(cfg.WebhookConfigFile, auditv1.SchemeGroupVersion, cfg.WebhookInitialBackoff, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audit webhook: %v", err)
+		}
+
+		switch cfg.WebhookMode {
+		case ModeBatch:
+			webhook = buffered.NewBackend(webhook, cfg.WebhookBatch)
+		case ModeBlocking:
+		default:
+			return nil, fmt.Errorf("unknown audit webhook mode %q, must be %q or %q", cfg.WebhookMode, ModeBatch, ModeBlocking)
+		}
+		backends = append(backends, webhook)
+	}
+
+	switch len(backends) {
+	case 0:
+		return nil, nil
+	case 1:
+		return backends[0], nil
+	default:
+		return k8saudit.Union(backends...), nil
+	}
+}
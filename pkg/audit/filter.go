@@ -0,0 +1,140 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	auditinternal "k8s.io/apiserver/pkg/apis/audit"
+	k8saudit "k8s.io/apiserver/pkg/audit"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/klog/v2"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/filters"
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+// Annotation keys of authorization decisions, as used by the kube-apiserver.
+const (
+	decisionAnnotationKey = "authorization.k8s.io/decision"
+	reasonAnnotationKey   = "authorization.k8s.io/reason"
+	routeAnnotationKey    = "kube-rbac-proxy/route"
+)
+
+// WithAudit sends an audit event of metadata level to the backend for every
+// request completed by handler. The user and authorization decision are
+// filled in by LogUser and LogAuthorization.
+func WithAudit(handler http.Handler, backend k8saudit.Backend) http.Handler {
+	if backend == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attrs := authorizer.AttributesRecord{Verb: strings.ToLower(req.Method)}
+		ev, err := k8saudit.NewEventFromRequest(req, auditinternal.LevelMetadata, attrs)
+		if err != nil {
+			klog.Errorf("failed to create audit event: %v", err)
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		ctx, info := requestinfo.WithInfo(req.Context())
+		if info.ID != "" {
+			ev.AuditID = types.UID(info.ID)
+		}
+
+		rw := filters.NewStatusRecorder(w)
+		defer func() {
+			if info.Route != "" {
+				if ev.Annotations == nil {
+					ev.Annotations = map[string]string{}
+				}
+				ev.Annotations[routeAnnotationKey] = info.Route
+			}
+			ev.Stage = auditinternal.StageResponseComplete
+			ev.StageTimestamp = metav1.NewMicroTime(time.Now())
+			ev.ResponseStatus = &metav1.Status{Code: int32(rw.StatusCode())}
+			backend.ProcessEvents(ev)
+		}()
+
+		handler.ServeHTTP(rw, req.WithContext(request.WithAuditEvent(ctx, ev)))
+	})
+}
+
+// LogUser records the authenticated user in the audit event of the request.
+func LogUser(ctx context.Context, u user.Info) {
+	ev := request.AuditEventFrom(ctx)
+	if ev == nil {
+		return
+	}
+
+	ev.User = authnv1.UserInfo{
+		Username: u.GetName(),
+		UID:      u.GetUID(),
+		Groups:   u.GetGroups(),
+	}
+	if extra := u.GetExtra(); len(extra) > 0 {
+		ev.User.Extra = map[string]authnv1.ExtraValue{}
+		for k, v := range extra {
+			ev.User.Extra[k] = authnv1.ExtraValue(v)
+		}
+	}
+}
+
+// LogAuthorization records the authorization decision of the attributes in
+// the audit event of the request. The verb and object of the first attributes
+// are recorded.
+func LogAuthorization(ctx context.Context, attrs authorizer.Attributes, decision authorizer.Decision, reason string) {
+	ev := request.AuditEventFrom(ctx)
+	if ev == nil {
+		return
+	}
+
+	if ev.ObjectRef == nil {
+		ev.Verb = attrs.GetVerb()
+		if attrs.IsResourceRequest() {
+			ev.ObjectRef = &auditinternal.ObjectReference{
+				Namespace:   attrs.GetNamespace(),
+				Name:        attrs.GetName(),
+				Resource:    attrs.GetResource(),
+				Subresource: attrs.GetSubresource(),
+				APIGroup:    attrs.GetAPIGroup(),
+				APIVersion:  attrs.GetAPIVersion(),
+			}
+		}
+	}
+
+	// The last decision made is the one of the request, as the first denial
+	// ends authorization.
+	value := "forbid"
+	if decision == authorizer.DecisionAllow {
+		value = "allow"
+	}
+	if ev.Annotations == nil {
+		ev.Annotations = map[string]string{}
+	}
+	ev.Annotations[decisionAnnotationKey] = value
+	ev.Annotations[reasonAnnotationKey] = reason
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	auditinternal "k8s.io/apiserver/pkg/apis/audit"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+type fakeBackend struct {
+	mu     sync.Mutex
+	events []*auditinternal.Event
+}
+
+func (b *fakeBackend) ProcessEvents(events ...*auditinternal.Event) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, events...)
+	return true
+}
+
+func (b *fakeBackend) Run(stopCh <-chan struct{}) error { return nil }
+func (b *fakeBackend) Shutdown()                        {}
+func (b *fakeBackend) String() string                   { return "fake" }
+
+func TestWithAudit(t *testing.T) {
+	backend := &fakeBackend{}
+	handler := WithAudit(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attrs := authorizer.AttributesRecord{
+			Verb:            "get",
+			Namespace:       "default",
+			Resource:        "services",
+			Subresource:     "proxy",
+			ResourceRequest: true,
+		}
+		_, info := requestinfo.WithInfo(req.Context())
+		info.Route = "metrics"
+		LogUser(req.Context(), &user.DefaultInfo{Name: "alice", Groups: []string{"devs"}})
+		LogAuthorization(req.Context(), attrs, authorizer.DecisionNoOpinion, "no rule")
+		w.WriteHeader(http.StatusForbidden)
+	}), backend)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set(auditinternal.HeaderAuditID, "1234")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(backend.events) != 1 {
+		t.Fatalf("want 1 audit event, got %d", len(backend.events))
+	}
+	ev := backend.events[0]
+
+	if ev.AuditID != "1234" {
+		t.Errorf("want audit ID from header, got %q", ev.AuditID)
+	}
+	if ev.Stage != auditinternal.StageResponseComplete {
+		t.Errorf("want stage %s, got %s", auditinternal.StageResponseComplete, ev.Stage)
+	}
+	if ev.User.Username != "alice" {
+		t.Errorf("want user alice, got %q", ev.User.Username)
+	}
+	if ev.Verb != "get" || ev.ObjectRef == nil || ev.ObjectRef.Resource != "services" {
+		t.Errorf("want verb and object of the authorization attributes, got %q %+v", ev.Verb, ev.ObjectRef)
+	}
+	if got := ev.Annotations[decisionAnnotationKey]; got != "forbid" {
+		t.Errorf("want decision forbid, got %q", got)
+	}
+	if got := ev.Annotations[routeAnnotationKey]; got != "metrics" {
+		t.Errorf("want route metrics, got %q", got)
+	}
+	if ev.ResponseStatus == nil || ev.ResponseStatus.Code != http.StatusForbidden {
+		t.Errorf("want response status %d, got %+v", http.StatusForbidden, ev.ResponseStatus)
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	if b, err := NewBackend(Config{}); b != nil || err != nil {
+		t.Errorf("want no backend without configuration, got %v (err: %v)", b, err)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authn
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/cache"
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+// tokenKey identifies a token and the audiences it is authenticated for.
+type tokenKey [sha256.Size]byte
+
+// newTokenKey returns the SHA-256 of token and audiences, so that tokens
+// aren't kept in memory.
+func newTokenKey(token string, audiences authenticator.Audiences) tokenKey {
+	h := sha256.New()
+	var b [8]byte
+	for _, s := range append([]string{token}, audiences...) {
+		// The length prefix prevents ambiguities like "xy" + "z" == "x" + "yz".
+		binary.BigEndian.PutUint64(b[:], uint64(len(s)))
+		h.Write(b[:])
+		h.Write([]byte(s))
+	}
+
+	var key tokenKey
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// tokenResult is the cached result of authenticating a token.
+type tokenResult struct {
+	resp *authenticator.Response
+	ok   bool
+}
+
+// cachedTokenAuthenticator caches the results of a token authenticator for
+// ttl, or until the token expires if that is earlier.
+type cachedTokenAuthenticator struct {
+	authenticator authenticator.Token
+	cache         *cache.Cache
+	clock         clock.PassiveClock
+	ttl           time.Duration
+}
+
+func newCachedTokenAuthenticator(a authenticator.Token, ttl time.Duration, maxSize int) *cachedTokenAuthenticator {
+	return newCachedTokenAuthenticatorWithClock(a, ttl, maxSize, clock.RealClock{})
+}
+
+func newCachedTokenAuthenticatorWithClock(a authenticator.Token, ttl time.Duration, maxSize int, clock clock.PassiveClock) *cachedTokenAuthenticator {
+	return &cachedTokenAuthenticator{
+		authenticator: a,
+		cache: cache.NewWithClock(maxSize, cache.Metrics{
+			Entries:   metrics.TokenCacheEntries,
+			Evictions: metrics.TokenCacheEvictions,
+			LockWait:  metrics.CacheLockWait.WithLabelValues("token"),
+		}, clock),
+		clock: clock,
+		ttl:   ttl,
+	}
+}
+
+func (a *cachedTokenAuthenticator) AuthenticateToken(ctx context.Context, token string) (*authenticator.Response, bool, error) {
+	audiences, _ := authenticator.AudiencesFrom(ctx)
+	key := newTokenKey(token, audiences)
+	if cached, hit := a.cache.Get(string(key[:])); hit {
+		result := cached.(tokenResult)
+		return result.resp, result.ok, nil
+	}
+
+	resp, ok, err := a.authenticator.AuthenticateToken(ctx, token)
+	if err != nil {
+		// Errors, e.g. of unreachable kube-apiservers, aren't cached.
+		return resp, ok, err
+	}
+
+	expires := a.clock.Now().Add(a.ttl)
+	if exp, found := tokenExpiry(token); found && exp.Before(expires) {
+		expires = exp
+	}
+	a.cache.Add(string(key[:]), tokenResult{resp: resp, ok: ok}, expires)
+	return resp, ok, nil
+}
+
+// tokenExpiry returns the expiry of token if it is a JWT with an exp
+// claim. The signature isn't verified, which is fine as the expiry only
+// shortens how long the result of the TokenReview is cached.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp *json.Number `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == nil {
+		return time.Time{}, false
+	}
+	exp, err := claims.Exp.Int64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(exp, 0), true
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authn
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+// countingAuthenticator authenticates the token "valid", and counts calls.
+type countingAuthenticator struct {
+	calls int
+	err   error
+}
+
+func (a *countingAuthenticator) AuthenticateToken(_ context.Context, token string) (*authenticator.Response, bool, error) {
+	a.calls++
+	if a.err != nil {
+		return nil, false, a.err
+	}
+	if token == "invalid" {
+		return nil, false, nil
+	}
+	return &authenticator.Response{User: &user.DefaultInfo{Name: token}}, true, nil
+}
+
+func jwt(exp int64) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"alice","exp":%d}`, exp)))
+	return "eyJhbGciOiJSUzI1NiJ9." + payload + ".c2ln"
+}
+
+func TestCachedTokenAuthenticator(t *testing.T) {
+	clk := clock.NewFakeClock(time.Unix(1000, 0))
+	delegate := &countingAuthenticator{}
+	a := newCachedTokenAuthenticatorWithClock(delegate, 2*time.Minute, 2, clk)
+	ctx := context.Background()
+
+	authenticate := func(token string, wantOK bool, wantCalls int) {
+		t.Helper()
+		_, ok, err := a.AuthenticateToken(ctx, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != wantOK {
+			t.Errorf("token %q: want authenticated %v, got %v", token, wantOK, ok)
+		}
+		if delegate.calls != wantCalls {
+			t.Errorf("token %q: want %d TokenReviews, got %d", token, wantCalls, delegate.calls)
+		}
+	}
+
+	authenticate("a", true, 1)
+	authenticate("a", true, 1)
+	authenticate("invalid", false, 2)
+	authenticate("invalid", false, 2)
+
+	// "a" was used least recently and is evicted.
+	authenticate("b", true, 3)
+	authenticate("invalid", false, 3)
+	authenticate("a", true, 4)
+
+	// Results expire after the TTL.
+	clk.Step(2 * time.Minute)
+	authenticate("a", true, 5)
+
+	// Results expire with the token.
+	token := jwt(clk.Now().Add(30 * time.Second).Unix())
+	authenticate(token, true, 6)
+	authenticate(token, true, 6)
+	clk.Step(30 * time.Second)
+	authenticate(token, true, 7)
+
+	// Expired tokens aren't cached.
+	authenticate(token, true, 8)
+
+	// Results are cached per audience.
+	_, _, _ = a.AuthenticateToken(authenticator.WithAudiences(ctx, authenticator.Audiences{"other"}), "a")
+	if delegate.calls != 9 {
+		t.Errorf("want results cached per audience, got %d TokenReviews", delegate.calls)
+	}
+
+	// Errors aren't cached.
+	delegate.err = fmt.Errorf("unreachable")
+	for i := 0; i < 2; i++ {
+		if _, _, err := a.AuthenticateToken(ctx, "c"); err == nil {
+			t.Error("want error, got nil")
+		}
+	}
+	if delegate.calls != 11 {
+		t.Errorf("want errors not to be cached, got %d TokenReviews", delegate.calls)
+	}
+}
+
+func TestTokenKey(t *testing.T) {
+	if newTokenKey("xy", authenticator.Audiences{"z"}) == newTokenKey("x", authenticator.Audiences{"yz"}) {
+		t.Error("want keys of different tokens and audiences to differ")
+	}
+	if newTokenKey("x", nil) != newTokenKey("x", nil) {
+		t.Error("want keys of the same token to be equal")
+	}
+}
+
+func BenchmarkCachedTokenAuthenticatorHit(b *testing.B) {
+	a := newCachedTokenAuthenticator(&countingAuthenticator{}, 2*time.Minute, DefaultTokenCacheSize)
+	ctx := authenticator.WithAudiences(context.Background(), authenticator.Audiences{"kube-rbac-proxy"})
+	token := jwt(time.Now().Add(time.Hour).Unix())
+	if _, _, err := a.AuthenticateToken(ctx, token); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok, err := a.AuthenticateToken(ctx, token); err != nil || !ok {
+			b.Fatalf("want authenticated, got %v, %v", ok, err)
+		}
+	}
+}
@@ -46,4 +46,11 @@ type X509Config struct {
 // TokenConfig holds configuration as to how token authentication is to be done
 type TokenConfig struct {
 	Audiences []string
+	// CacheSize is the maximum number of cached TokenReview results. Zero
+	// means DefaultTokenCacheSize.
+	CacheSize int
 }
+
+// DefaultTokenCacheSize is the default maximum number of cached TokenReview
+// results.
+const DefaultTokenCacheSize = 10000
@@ -24,10 +24,19 @@ import (
 
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/authenticatorfactory"
+	"k8s.io/apiserver/pkg/authentication/group"
+	"k8s.io/apiserver/pkg/authentication/request/bearertoken"
+	"k8s.io/apiserver/pkg/authentication/request/union"
+	"k8s.io/apiserver/pkg/authentication/request/websocket"
+	"k8s.io/apiserver/pkg/authentication/request/x509"
 	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+	webhooktoken "k8s.io/apiserver/plugin/pkg/authenticator/token/webhook"
 	authenticationclient "k8s.io/client-go/kubernetes/typed/authentication/v1"
 )
 
+// tokenCacheTTL is how long TokenReview results are cached at most.
+const tokenCacheTTL = 2 * time.Minute
+
 // NewDelegatingAuthenticator creates an authenticator compatible with the kubelet's needs
 func NewDelegatingAuthenticator(client authenticationclient.TokenReviewInterface, authn *AuthnConfig) (authenticator.Request, error) {
 	if client == nil {
@@ -54,14 +63,23 @@ func NewDelegatingAuthenticator(client authenticationclient.TokenReviewInterface
 		}
 	}
 
-	authenticatorConfig := authenticatorfactory.DelegatingAuthenticatorConfig{
-		Anonymous:                          false, // always require authentication
-		CacheTTL:                           2 * time.Minute,
-		ClientCertificateCAContentProvider: p,
-		TokenAccessReviewClient:            client,
-		APIAudiences:                       authenticator.Audiences(authn.Token.Audiences),
+	// The authenticators are combined like by the apiserver's
+	// DelegatingAuthenticatorConfig, but with a bounded token cache.
+	var authenticators []authenticator.Request
+	if p != nil {
+		authenticators = append(authenticators, x509.NewDynamic(p.VerifyOptions, x509.CommonNameUserConversion))
+	}
+
+	tokenAuth, err := webhooktoken.NewFromInterface(instrumentedTokenReviews{client}, authenticator.Audiences(authn.Token.Audiences))
+	if err != nil {
+		return nil, err
+	}
+	cacheSize := authn.Token.CacheSize
+	if cacheSize <= 0 {
+		cacheSize = DefaultTokenCacheSize
 	}
+	cachingTokenAuth := newCachedTokenAuthenticator(tokenAuth, tokenCacheTTL, cacheSize)
+	authenticators = append(authenticators, bearertoken.New(cachingTokenAuth), websocket.NewProtocolAuthenticator(cachingTokenAuth))
 
-	authenticator, _, err := authenticatorConfig.New()
-	return authenticator, err
+	return instrumentedAuthenticator{group.NewAuthenticatedGroupAdder(union.New(authenticators...))}, nil
 }
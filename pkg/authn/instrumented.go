@@ -0,0 +1,70 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authn
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	authenticationclient "k8s.io/client-go/kubernetes/typed/authentication/v1"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+// instrumentedTokenReviews records metrics of TokenReview requests.
+type instrumentedTokenReviews struct {
+	authenticationclient.TokenReviewInterface
+}
+
+func (c instrumentedTokenReviews) Create(ctx context.Context, tokenReview *authenticationv1.TokenReview, opts metav1.CreateOptions) (*authenticationv1.TokenReview, error) {
+	start := time.Now()
+	result, err := c.TokenReviewInterface.Create(ctx, tokenReview, opts)
+	metrics.ObserveDelegatedRequest(ctx, metrics.APITokenReview, start, err)
+	return result, err
+}
+
+// instrumentedAuthenticator records whether token authentication decisions
+// were answered from the cache.
+type instrumentedAuthenticator struct {
+	authenticator.Request
+}
+
+func (a instrumentedAuthenticator) AuthenticateRequest(req *http.Request) (*authenticator.Response, bool, error) {
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		// Client certificates are verified locally.
+		return a.Request.AuthenticateRequest(req)
+	}
+
+	ctx, calls := metrics.WithDelegatedCalls(req.Context())
+	resp, ok, err := a.Request.AuthenticateRequest(req.WithContext(ctx))
+
+	decision := "authenticated"
+	switch {
+	case err != nil:
+		decision = "error"
+	case !ok:
+		decision = "unauthenticated"
+	}
+	metrics.DelegatedDecisions.WithLabelValues(metrics.Route(ctx), metrics.APITokenReview, decision, calls.CacheResult()).Inc()
+
+	return resp, ok, err
+}
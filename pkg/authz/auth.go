@@ -18,8 +18,12 @@ package authz
 
 import (
 	"errors"
-	"time"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
 
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
 	"k8s.io/apiserver/pkg/authorization/authorizerfactory"
 	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
@@ -30,6 +34,9 @@ type Config struct {
 	Rewrites               *SubjectAccessReviewRewrites `json:"rewrites,omitempty"`
 	ResourceAttributes     *ResourceAttributes          `json:"resourceAttributes,omitempty"`
 	ResourceAttributesFile string                       `json:"-"`
+	// NonResourceURL is authorized instead of the request path if no
+	// ResourceAttributes are given.
+	NonResourceURL string `json:"-"`
 }
 
 // SubjectAccessReviewRewrites describes how SubjectAccessReview may be
@@ -54,15 +61,63 @@ type ResourceAttributes struct {
 	Name        string `json:"name,omitempty"`
 }
 
+// Validate returns an error if a resource attribute is an invalid template,
+// or if templates and rewrites aren't configured together.
+func (c *Config) Validate() error {
+	rewrites := c.Rewrites != nil && c.Rewrites.ByQueryParameter != nil
+	if rewrites {
+		if c.ResourceAttributes == nil {
+			return errors.New("rewrites.byQueryParameter requires resourceAttributes to rewrite")
+		}
+		if c.Rewrites.ByQueryParameter.Name == "" {
+			return errors.New("rewrites.byQueryParameter requires the name of a query parameter")
+		}
+	}
+	if c.ResourceAttributes == nil {
+		return nil
+	}
+
+	for _, attr := range []struct{ name, value string }{
+		{"namespace", c.ResourceAttributes.Namespace},
+		{"apiGroup", c.ResourceAttributes.APIGroup},
+		{"apiVersion", c.ResourceAttributes.APIVersion},
+		{"resource", c.ResourceAttributes.Resource},
+		{"subresource", c.ResourceAttributes.Subresource},
+		{"name", c.ResourceAttributes.Name},
+	} {
+		if !strings.Contains(attr.value, "{{") {
+			continue
+		}
+		if !rewrites {
+			return fmt.Errorf("resourceAttributes.%s is a template, which requires rewrites.byQueryParameter", attr.name)
+		}
+		tmpl, err := template.New(attr.name).Parse(attr.value)
+		if err == nil {
+			// Templates referring to anything but .Value fail on every request.
+			err = tmpl.Execute(ioutil.Discard, struct{ Value string }{})
+		}
+		if err != nil {
+			return fmt.Errorf("invalid template in resourceAttributes.%s: %v", attr.name, err)
+		}
+	}
+	return nil
+}
+
 // NewAuthorizer creates an authorizer compatible with the kubelet's needs
 func NewAuthorizer(client authorizationclient.SubjectAccessReviewInterface) (authorizer.Authorizer, error) {
 	if client == nil {
 		return nil, errors.New("no client provided, cannot use webhook authorization")
 	}
 	authorizerConfig := authorizerfactory.DelegatingAuthorizerConfig{
-		SubjectAccessReviewClient: client,
-		AllowCacheTTL:             5 * time.Minute,
-		DenyCacheTTL:              30 * time.Second,
+		SubjectAccessReviewClient: newDedupedSubjectAccessReviews(instrumentedSubjectAccessReviews{client}),
+		// Decisions are cached by cachedAuthorizer instead, whose cache is
+		// sharded rather than guarded by a single mutex.
+		AllowCacheTTL: 0,
+		DenyCacheTTL:  0,
+	}
+	authorizer, err := authorizerConfig.New()
+	if err != nil {
+		return nil, err
 	}
-	return authorizerConfig.New()
+	return instrumentedAuthorizer{newCachedAuthorizer(authorizer, clock.RealClock{})}, nil
 }
@@ -0,0 +1,45 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import "testing"
+
+func TestConfigValidate(t *testing.T) {
+	rewrites := &SubjectAccessReviewRewrites{ByQueryParameter: &QueryParameterRewriteConfig{Name: "namespace"}}
+
+	for _, tc := range []struct {
+		name  string
+		cfg   Config
+		valid bool
+	}{
+		{name: "empty", cfg: Config{}, valid: true},
+		{name: "static", cfg: Config{ResourceAttributes: &ResourceAttributes{Namespace: "default"}}, valid: true},
+		{name: "template", cfg: Config{Rewrites: rewrites, ResourceAttributes: &ResourceAttributes{Namespace: "{{ .Value }}"}}, valid: true},
+		{name: "invalid template", cfg: Config{Rewrites: rewrites, ResourceAttributes: &ResourceAttributes{Namespace: "{{ .Value"}}},
+		{name: "unknown template field", cfg: Config{Rewrites: rewrites, ResourceAttributes: &ResourceAttributes{Namespace: "{{ .Namespace }}"}}},
+		{name: "template without rewrites", cfg: Config{ResourceAttributes: &ResourceAttributes{Namespace: "{{ .Value }}"}}},
+		{name: "rewrites without attributes", cfg: Config{Rewrites: rewrites}},
+		{name: "rewrites without name", cfg: Config{
+			Rewrites:           &SubjectAccessReviewRewrites{ByQueryParameter: &QueryParameterRewriteConfig{}},
+			ResourceAttributes: &ResourceAttributes{Namespace: "{{ .Value }}"},
+		}},
+	} {
+		if err := tc.cfg.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: want valid %v, got error %v", tc.name, tc.valid, err)
+		}
+	}
+}
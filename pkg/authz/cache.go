@@ -0,0 +1,135 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/cache"
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+const (
+	allowCacheTTL = 5 * time.Minute
+	denyCacheTTL  = 30 * time.Second
+	// decisionCacheSize is the number of cached decisions, like in the
+	// webhook authorizer of the apiserver.
+	decisionCacheSize = 8192
+	// maxCachedAttributesSize bounds the size of the attributes of cached
+	// decisions, like in the webhook authorizer, so that clients can't fill
+	// the cache with large ones.
+	maxCachedAttributesSize = 10000
+)
+
+// decisionKey identifies the attributes of an authorization decision.
+type decisionKey struct {
+	User            string              `json:"u"`
+	UID             string              `json:"uid"`
+	Groups          []string            `json:"g"`
+	Extra           map[string][]string `json:"e"`
+	ResourceRequest bool                `json:"rr"`
+	Verb            string              `json:"v"`
+	Namespace       string              `json:"ns"`
+	APIGroup        string              `json:"ag"`
+	APIVersion      string              `json:"av"`
+	Resource        string              `json:"r"`
+	Subresource     string              `json:"sr"`
+	Name            string              `json:"n"`
+	Path            string              `json:"p"`
+}
+
+type cachedDecision struct {
+	decision authorizer.Decision
+	reason   string
+}
+
+// cachedAuthorizer caches the decisions of an authorizer in a sharded cache,
+// allowed decisions for allowCacheTTL and others for denyCacheTTL. Errors
+// aren't cached.
+type cachedAuthorizer struct {
+	authorizer authorizer.Authorizer
+	cache      *cache.Cache
+	clock      clock.PassiveClock
+}
+
+func newCachedAuthorizer(a authorizer.Authorizer, clock clock.PassiveClock) *cachedAuthorizer {
+	return &cachedAuthorizer{
+		authorizer: a,
+		cache: cache.NewWithClock(decisionCacheSize, cache.Metrics{
+			Entries:   metrics.AuthorizationCacheEntries,
+			Evictions: metrics.AuthorizationCacheEvictions,
+			LockWait:  metrics.CacheLockWait.WithLabelValues("authorization"),
+		}, clock),
+		clock: clock,
+	}
+}
+
+func (a *cachedAuthorizer) Authorize(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	key, cacheable := newDecisionKey(attrs)
+	if cacheable {
+		if cached, ok := a.cache.Get(key); ok {
+			d := cached.(cachedDecision)
+			return d.decision, d.reason, nil
+		}
+	}
+
+	decision, reason, err := a.authorizer.Authorize(ctx, attrs)
+	if err != nil || !cacheable {
+		return decision, reason, err
+	}
+
+	ttl := denyCacheTTL
+	if decision == authorizer.DecisionAllow {
+		ttl = allowCacheTTL
+	}
+	a.cache.Add(key, cachedDecision{decision: decision, reason: reason}, a.clock.Now().Add(ttl))
+	return decision, reason, nil
+}
+
+// newDecisionKey returns the cache key of attrs, and whether their decision
+// may be cached.
+func newDecisionKey(attrs authorizer.Attributes) (string, bool) {
+	k := decisionKey{
+		ResourceRequest: attrs.IsResourceRequest(),
+		Verb:            attrs.GetVerb(),
+		Namespace:       attrs.GetNamespace(),
+		APIGroup:        attrs.GetAPIGroup(),
+		APIVersion:      attrs.GetAPIVersion(),
+		Resource:        attrs.GetResource(),
+		Subresource:     attrs.GetSubresource(),
+		Name:            attrs.GetName(),
+		Path:            attrs.GetPath(),
+	}
+	size := len(k.Verb) + len(k.Namespace) + len(k.APIGroup) + len(k.APIVersion) + len(k.Resource) + len(k.Subresource) + len(k.Name) + len(k.Path)
+	if size >= maxCachedAttributesSize {
+		return "", false
+	}
+	if u := attrs.GetUser(); u != nil {
+		k.User, k.UID, k.Groups, k.Extra = u.GetName(), u.GetUID(), u.GetGroups(), u.GetExtra()
+	}
+
+	b, err := json.Marshal(k)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// countingAuthorizer allows requests of alice, and counts calls.
+type countingAuthorizer struct {
+	calls int
+	err   error
+}
+
+func (a *countingAuthorizer) Authorize(_ context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	a.calls++
+	if a.err != nil {
+		return authorizer.DecisionNoOpinion, "", a.err
+	}
+	if attrs.GetUser().GetName() == "alice" {
+		return authorizer.DecisionAllow, "", nil
+	}
+	return authorizer.DecisionNoOpinion, "not alice", nil
+}
+
+func TestCachedAuthorizer(t *testing.T) {
+	clk := clock.NewFakeClock(time.Unix(1000, 0))
+	delegate := &countingAuthorizer{}
+	a := newCachedAuthorizer(delegate, clk)
+
+	attrs := func(name string) authorizer.Attributes {
+		return authorizer.AttributesRecord{User: &user.DefaultInfo{Name: name}, Verb: "get", Path: "/metrics"}
+	}
+	authorize := func(attrs authorizer.Attributes, want authorizer.Decision, wantCalls int) {
+		t.Helper()
+		decision, _, err := a.Authorize(context.Background(), attrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision != want {
+			t.Errorf("want decision %v, got %v", want, decision)
+		}
+		if delegate.calls != wantCalls {
+			t.Errorf("want %d calls, got %d", wantCalls, delegate.calls)
+		}
+	}
+
+	authorize(attrs("alice"), authorizer.DecisionAllow, 1)
+	authorize(attrs("alice"), authorizer.DecisionAllow, 1)
+	authorize(attrs("bob"), authorizer.DecisionNoOpinion, 2)
+	authorize(attrs("bob"), authorizer.DecisionNoOpinion, 2)
+
+	// Denials expire earlier than allowed decisions.
+	clk.Step(denyCacheTTL)
+	authorize(attrs("alice"), authorizer.DecisionAllow, 2)
+	authorize(attrs("bob"), authorizer.DecisionNoOpinion, 3)
+	clk.Step(allowCacheTTL)
+	authorize(attrs("alice"), authorizer.DecisionAllow, 4)
+
+	// Decisions of large attributes aren't cached.
+	large := authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "alice"}, Verb: "get", Path: strings.Repeat("a", maxCachedAttributesSize)}
+	authorize(large, authorizer.DecisionAllow, 5)
+	authorize(large, authorizer.DecisionAllow, 6)
+
+	// Errors aren't cached.
+	delegate.err = errors.New("unreachable")
+	for i := 0; i < 2; i++ {
+		if _, _, err := a.Authorize(context.Background(), attrs("carol")); err == nil {
+			t.Error("want error, got nil")
+		}
+	}
+	if delegate.calls != 8 {
+		t.Errorf("want errors not to be cached, got %d calls", delegate.calls)
+	}
+}
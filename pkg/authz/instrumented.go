@@ -0,0 +1,63 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"context"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+// instrumentedSubjectAccessReviews records metrics of SubjectAccessReview requests.
+type instrumentedSubjectAccessReviews struct {
+	authorizationclient.SubjectAccessReviewInterface
+}
+
+func (c instrumentedSubjectAccessReviews) Create(ctx context.Context, sar *authorizationv1.SubjectAccessReview, opts metav1.CreateOptions) (*authorizationv1.SubjectAccessReview, error) {
+	start := time.Now()
+	result, err := c.SubjectAccessReviewInterface.Create(ctx, sar, opts)
+	metrics.ObserveDelegatedRequest(ctx, metrics.APISubjectAccessReview, start, err)
+	return result, err
+}
+
+// instrumentedAuthorizer records whether authorization decisions were
+// answered from the cache.
+type instrumentedAuthorizer struct {
+	authorizer.Authorizer
+}
+
+func (a instrumentedAuthorizer) Authorize(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	ctx, calls := metrics.WithDelegatedCalls(ctx)
+	decision, reason, err := a.Authorizer.Authorize(ctx, attrs)
+
+	label := "deny"
+	switch {
+	case err != nil:
+		label = "error"
+	case decision == authorizer.DecisionAllow:
+		label = "allow"
+	}
+	metrics.DelegatedDecisions.WithLabelValues(metrics.Route(ctx), metrics.APISubjectAccessReview, label, calls.CacheResult()).Inc()
+
+	return decision, reason, err
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+func TestInstrumentedAuthorizer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		sar.Status.Allowed = true
+		return true, sar, nil
+	})
+
+	a, err := NewAuthorizer(client.AuthorizationV1().SubjectAccessReviews())
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	attrs := authorizer.AttributesRecord{
+		User:            &user.DefaultInfo{Name: "alice"},
+		Verb:            "get",
+		ResourceRequest: true,
+		Resource:        "services",
+	}
+	for i := 0; i < 3; i++ {
+		decision, _, err := a.Authorize(context.Background(), attrs)
+		if err != nil || decision != authorizer.DecisionAllow {
+			t.Fatalf("want allowed decision, got %v (err: %v)", decision, err)
+		}
+	}
+
+	for cache, want := range map[string]float64{"miss": 1, "hit": 2} {
+		got := testutil.ToFloat64(metrics.DelegatedDecisions.WithLabelValues("", metrics.APISubjectAccessReview, "allow", cache))
+		if got != want {
+			t.Errorf("want %v cache %ss, got %v", want, cache, got)
+		}
+	}
+	if got := testutil.CollectAndCount(metrics.DelegatedRequestDuration); got != 1 {
+		t.Errorf("want request latencies of one api, got %d", got)
+	}
+}
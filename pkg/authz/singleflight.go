@@ -0,0 +1,79 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"golang.org/x/sync/singleflight"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+// dedupedSubjectAccessReviews collapses concurrent identical
+// SubjectAccessReviews, e.g. of parallel scrapes by the same user, into a
+// single request to the kube-apiserver.
+type dedupedSubjectAccessReviews struct {
+	authorizationclient.SubjectAccessReviewInterface
+	group *singleflight.Group
+}
+
+func newDedupedSubjectAccessReviews(client authorizationclient.SubjectAccessReviewInterface) dedupedSubjectAccessReviews {
+	return dedupedSubjectAccessReviews{SubjectAccessReviewInterface: client, group: &singleflight.Group{}}
+}
+
+func (c dedupedSubjectAccessReviews) Create(ctx context.Context, sar *authorizationv1.SubjectAccessReview, opts metav1.CreateOptions) (*authorizationv1.SubjectAccessReview, error) {
+	key, err := json.Marshal(struct {
+		Spec authorizationv1.SubjectAccessReviewSpec
+		Opts metav1.CreateOptions
+	}{sar.Spec, opts})
+	if err != nil {
+		return c.SubjectAccessReviewInterface.Create(ctx, sar, opts)
+	}
+
+	ch := c.group.DoChan(string(key), func() (interface{}, error) {
+		return c.SubjectAccessReviewInterface.Create(ctx, sar, opts)
+	})
+	// Callers joining the request in flight stop waiting on their own context.
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	result, err, shared := res.Val, res.Err, res.Shared
+	if err != nil {
+		if shared && ctx.Err() == nil && isContextError(err) {
+			// The request was canceled by the caller which made it.
+			return c.SubjectAccessReviewInterface.Create(ctx, sar, opts)
+		}
+		return nil, err
+	}
+	if shared {
+		// The result is read by each caller's authorizer.
+		return result.(*authorizationv1.SubjectAccessReview).DeepCopy(), nil
+	}
+	return result.(*authorizationv1.SubjectAccessReview), nil
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
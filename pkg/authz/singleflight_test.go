@@ -0,0 +1,123 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+// blockingSubjectAccessReviews allows SubjectAccessReviews once released.
+type blockingSubjectAccessReviews struct {
+	authorizationclient.SubjectAccessReviewInterface
+	calls   int32
+	release chan struct{}
+}
+
+func (c *blockingSubjectAccessReviews) Create(_ context.Context, sar *authorizationv1.SubjectAccessReview, _ metav1.CreateOptions) (*authorizationv1.SubjectAccessReview, error) {
+	atomic.AddInt32(&c.calls, 1)
+	<-c.release
+	sar = sar.DeepCopy()
+	sar.Status.Allowed = true
+	return sar, nil
+}
+
+func TestDedupedSubjectAccessReviews(t *testing.T) {
+	client := &blockingSubjectAccessReviews{release: make(chan struct{})}
+	a, err := NewAuthorizer(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	authorize := func(name string) {
+		defer wg.Done()
+		attrs := authorizer.AttributesRecord{
+			User:            &user.DefaultInfo{Name: name},
+			Verb:            "get",
+			ResourceRequest: true,
+			Resource:        "services",
+		}
+		decision, _, err := a.Authorize(context.Background(), attrs)
+		if err != nil || decision != authorizer.DecisionAllow {
+			t.Errorf("want allowed decision for %s, got %v (err: %v)", name, decision, err)
+		}
+	}
+
+	// Parallel requests of one user wait for the first SubjectAccessReview,
+	// those of another user make their own.
+	wg.Add(11)
+	for i := 0; i < 10; i++ {
+		go authorize("alice")
+	}
+	go authorize("bob")
+	for atomic.LoadInt32(&client.calls) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// Give the other requests time to join the SubjectAccessReview in flight.
+	time.Sleep(100 * time.Millisecond)
+	close(client.release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&client.calls); got != 2 {
+		t.Errorf("want 2 SubjectAccessReviews, got %d", got)
+	}
+}
+
+func TestDedupedSubjectAccessReviewsCanceled(t *testing.T) {
+	client := &blockingSubjectAccessReviews{release: make(chan struct{})}
+	defer close(client.release)
+	reviews := newDedupedSubjectAccessReviews(client)
+
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{User: "alice"},
+	}
+	go func() {
+		_, _ = reviews.Create(context.Background(), sar, metav1.CreateOptions{})
+	}()
+	for atomic.LoadInt32(&client.calls) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A caller joining the blocked SubjectAccessReview stops waiting when
+	// its context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := reviews.Create(ctx, sar, metav1.CreateOptions{})
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != context.DeadlineExceeded {
+			t.Errorf("want %v, got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the canceled caller to return")
+	}
+}
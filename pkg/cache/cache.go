@@ -0,0 +1,190 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cache provides a size-bounded LRU cache of expiring entries. It is
+// sharded by the hash of the keys, so that concurrent requests on nodes with
+// many cores don't serialize on a single mutex.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+const (
+	// minShardSize is the capacity below which caches aren't split into
+	// more shards, as small shards evict entries long before the cache is
+	// full if keys aren't distributed evenly.
+	minShardSize = 128
+	// maxShards bounds the number of shards of large caches.
+	maxShards = 64
+)
+
+// Metrics are updated by a cache. Unset metrics aren't recorded.
+type Metrics struct {
+	// Entries is the number of cached entries.
+	Entries prometheus.Gauge
+	// Evictions counts removed entries by reason, capacity or expired.
+	Evictions *prometheus.CounterVec
+	// LockWait counts the seconds spent waiting for the locks of shards.
+	LockWait prometheus.Counter
+}
+
+// Cache is a sharded LRU cache of expiring entries.
+type Cache struct {
+	shards  []*shard
+	metrics Metrics
+	clock   clock.PassiveClock
+}
+
+// New returns a cache of at most maxSize entries.
+func New(maxSize int, m Metrics) *Cache {
+	return NewWithClock(maxSize, m, clock.RealClock{})
+}
+
+// NewWithClock returns a cache of at most maxSize entries, which expires
+// entries according to clock.
+func NewWithClock(maxSize int, m Metrics, clock clock.PassiveClock) *Cache {
+	n := maxSize / minShardSize
+	if n < 1 {
+		n = 1
+	}
+	if n > maxShards {
+		n = maxShards
+	}
+
+	c := &Cache{shards: make([]*shard, n), metrics: m, clock: clock}
+	for i := range c.shards {
+		// Shards are rounded up, so the cache holds at least maxSize entries.
+		c.shards[i] = &shard{
+			maxSize: (maxSize + n - 1) / n,
+			lru:     list.New(),
+			entries: map[string]*list.Element{},
+		}
+	}
+	return c
+}
+
+// Get returns the value cached for key, and whether there is one which
+// hasn't expired.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	s := c.lock(key)
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*entry)
+	if !c.clock.Now().Before(entry.expires) {
+		c.remove(s, e, "expired")
+		return nil, false
+	}
+	s.lru.MoveToFront(e)
+	return entry.value, true
+}
+
+// Add caches value for key until expires, evicting the least recently used
+// entries of the key's shard if it is full.
+func (c *Cache) Add(key string, value interface{}, expires time.Time) {
+	if !c.clock.Now().Before(expires) {
+		return
+	}
+
+	s := c.lock(key)
+	defer s.mu.Unlock()
+
+	if e, exists := s.entries[key]; exists {
+		// Concurrent misses of the same key.
+		*e.Value.(*entry) = entry{key: key, value: value, expires: expires}
+		s.lru.MoveToFront(e)
+		return
+	}
+	for s.lru.Len() >= s.maxSize {
+		c.remove(s, s.lru.Back(), "capacity")
+	}
+
+	s.entries[key] = s.lru.PushFront(&entry{key: key, value: value, expires: expires})
+	if c.metrics.Entries != nil {
+		c.metrics.Entries.Inc()
+	}
+}
+
+// Len returns the number of cached entries, including expired ones which
+// weren't removed yet.
+func (c *Cache) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += s.lru.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// lock locks and returns the shard of key.
+func (c *Cache) lock(key string) *shard {
+	s := c.shards[hash(key)%uint32(len(c.shards))]
+	if c.metrics.LockWait == nil {
+		s.mu.Lock()
+		return s
+	}
+
+	start := time.Now()
+	s.mu.Lock()
+	c.metrics.LockWait.Add(time.Since(start).Seconds())
+	return s
+}
+
+func (c *Cache) remove(s *shard, e *list.Element, reason string) {
+	s.lru.Remove(e)
+	delete(s.entries, e.Value.(*entry).key)
+	if c.metrics.Entries != nil {
+		c.metrics.Entries.Dec()
+	}
+	if c.metrics.Evictions != nil {
+		c.metrics.Evictions.WithLabelValues(reason).Inc()
+	}
+}
+
+type shard struct {
+	maxSize int
+
+	mu      sync.Mutex
+	lru     *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// hash is the 32-bit FNV-1a hash of key, which unlike hash/fnv doesn't
+// allocate.
+func hash(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestCache(t *testing.T) {
+	clk := clock.NewFakeClock(time.Unix(1000, 0))
+	entries := prometheus.NewGauge(prometheus.GaugeOpts{Name: "entries"})
+	evictions := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "evictions"}, []string{"reason"})
+	c := NewWithClock(2, Metrics{Entries: entries, Evictions: evictions}, clk)
+	expires := clk.Now().Add(time.Minute)
+
+	c.Add("a", 1, expires)
+	c.Add("b", 2, expires)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("want a=1, got %v, %v", v, ok)
+	}
+
+	// b was used least recently and is evicted.
+	c.Add("c", 3, expires)
+	if _, ok := c.Get("b"); ok {
+		t.Error("want b to be evicted")
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Errorf("want c=3, got %v, %v", v, ok)
+	}
+
+	// Entries expire.
+	c.Add("d", 4, clk.Now().Add(time.Second))
+	clk.Step(time.Second)
+	if _, ok := c.Get("d"); ok {
+		t.Error("want d to be expired")
+	}
+	c.Add("e", 5, clk.Now())
+	if _, ok := c.Get("e"); ok {
+		t.Error("want expired entries not to be added")
+	}
+
+	if got := testutil.ToFloat64(entries); got != float64(c.Len()) {
+		t.Errorf("want %d entries, got %v", c.Len(), got)
+	}
+	if got := testutil.ToFloat64(evictions.WithLabelValues("capacity")); got != 2 {
+		t.Errorf("want 2 evictions for capacity, got %v", got)
+	}
+	if got := testutil.ToFloat64(evictions.WithLabelValues("expired")); got != 1 {
+		t.Errorf("want 1 expired eviction, got %v", got)
+	}
+}
+
+func TestCacheSharding(t *testing.T) {
+	for _, tc := range []struct {
+		maxSize int
+		shards  int
+	}{
+		{maxSize: 1, shards: 1},
+		{maxSize: 2 * minShardSize, shards: 2},
+		{maxSize: 5000, shards: 5000 / minShardSize},
+		{maxSize: 100000, shards: maxShards},
+	} {
+		c := New(tc.maxSize, Metrics{})
+		if len(c.shards) != tc.shards {
+			t.Errorf("size %d: want %d shards, got %d", tc.maxSize, tc.shards, len(c.shards))
+		}
+
+		expires := time.Now().Add(time.Hour)
+		for i := 0; i < tc.maxSize; i++ {
+			c.Add(fmt.Sprint(i), i, expires)
+		}
+		// Keys aren't distributed perfectly evenly across the shards.
+		if n := c.Len(); tc.maxSize > 1 && n < tc.maxSize*3/4 {
+			t.Errorf("size %d: want most entries to be kept, got %d", tc.maxSize, n)
+		}
+	}
+}
+
+func BenchmarkCacheGetParallel(b *testing.B) {
+	for _, maxSize := range []int{minShardSize, 10000} {
+		c := New(maxSize, Metrics{})
+		expires := time.Now().Add(time.Hour)
+		keys := make([]string, 100)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key-%d", i)
+			c.Add(keys[i], i, expires)
+		}
+
+		b.Run(fmt.Sprintf("shards=%d", len(c.shards)), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					c.Get(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
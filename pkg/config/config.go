@@ -0,0 +1,257 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config applies the settings of the versioned configuration to
+// flags, and loads it from files, ConfigMaps and KubeRBACProxyConfig objects.
+package config
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+)
+
+// Values returns the values of the flags set by o by their name. Slice
+// flags may have several values.
+func Values(o *v1alpha1.Options) (map[string][]string, error) {
+	values := map[string][]string{}
+	flagValues(reflect.ValueOf(o).Elem(), values)
+	for name, value := range o.Flags {
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("flag %q is set both in flags and its own setting", name)
+		}
+		values[name] = []string{value}
+	}
+	return values, nil
+}
+
+// Apply sets the flags of fs configured by o. Flags set on the command line
+// take precedence and are left as is.
+func Apply(o *v1alpha1.Options, fs *pflag.FlagSet) error {
+	values, err := Values(o)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown flag %q", name)
+		}
+		if f.Changed {
+			continue
+		}
+		if err := Set(fs, name, values[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set sets the named flag of fs to values, as if it was passed once per value
+// on the command line.
+func Set(fs *pflag.FlagSet, name string, values []string) error {
+	f := fs.Lookup(name)
+	if f == nil {
+		return fmt.Errorf("unknown flag %q", name)
+	}
+	for _, value := range values {
+		if f.Value.Type() == "stringSlice" {
+			value = quoteCSV(value)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for flag %q: %v", value, name, err)
+		}
+	}
+	return nil
+}
+
+// flagValues adds the values of the fields of the struct v, and of the
+// structs it points to, to values by the name of their flag.
+func flagValues(v reflect.Value, values map[string][]string) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		name := v.Type().Field(i).Tag.Get("flag")
+		if name == "" {
+			if field.Kind() == reflect.Ptr && !field.IsNil() && field.Elem().Kind() == reflect.Struct {
+				flagValues(field.Elem(), values)
+			}
+			continue
+		}
+
+		switch x := field.Interface().(type) {
+		case *string:
+			if x != nil {
+				values[name] = []string{*x}
+			}
+		case *bool:
+			if x != nil {
+				values[name] = []string{strconv.FormatBool(*x)}
+			}
+		case *int:
+			if x != nil {
+				values[name] = []string{strconv.Itoa(*x)}
+			}
+		case *metav1.Duration:
+			if x != nil {
+				values[name] = []string{x.Duration.String()}
+			}
+		case []string:
+			if len(x) > 0 {
+				values[name] = x
+			}
+		default:
+			panic(fmt.Sprintf("unsupported type %T of flag %q", x, name))
+		}
+	}
+}
+
+// FromFlags returns the Options setting the flags of fs named in names to
+// their current value, the inverse of Apply. Flags without their own setting
+// are set in Flags, except for those with several values or map values, which
+// Flags can't hold. Their names are returned as remaining.
+func FromFlags(fs *pflag.FlagSet, names []string) (o *v1alpha1.Options, remaining []string, err error) {
+	o = &v1alpha1.Options{}
+	want := map[string]bool{}
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			return nil, nil, fmt.Errorf("unknown flag %q", name)
+		}
+		want[name] = true
+	}
+
+	if _, err := setFields(reflect.ValueOf(o).Elem(), fs, want); err != nil {
+		return nil, nil, err
+	}
+
+	for _, name := range names {
+		if !want[name] {
+			continue
+		}
+		f := fs.Lookup(name)
+		value := f.Value.String()
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			values := sv.GetSlice()
+			if len(values) > 1 {
+				remaining = append(remaining, name)
+				continue
+			}
+			value = ""
+			if len(values) == 1 {
+				value = values[0]
+			}
+		} else if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			remaining = append(remaining, name)
+			continue
+		}
+		if o.Flags == nil {
+			o.Flags = map[string]string{}
+		}
+		o.Flags[name] = value
+	}
+	sort.Strings(remaining)
+	return o, remaining, nil
+}
+
+// setFields sets the fields of the struct v, and of the structs it points
+// to, whose flag is in want to the value of the flag, and removes the flag
+// from want. It returns whether any field was set.
+func setFields(v reflect.Value, fs *pflag.FlagSet, want map[string]bool) (bool, error) {
+	set := false
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		name := v.Type().Field(i).Tag.Get("flag")
+		if name == "" {
+			if field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct {
+				s := reflect.New(field.Type().Elem())
+				ok, err := setFields(s.Elem(), fs, want)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					field.Set(s)
+					set = true
+				}
+			}
+			continue
+		}
+		if !want[name] {
+			continue
+		}
+		delete(want, name)
+		set = true
+
+		f := fs.Lookup(name)
+		value := f.Value.String()
+		var err error
+		switch field.Interface().(type) {
+		case *string:
+			field.Set(reflect.ValueOf(&value))
+		case *bool:
+			var b bool
+			b, err = strconv.ParseBool(value)
+			field.Set(reflect.ValueOf(&b))
+		case *int:
+			var n int
+			n, err = strconv.Atoi(value)
+			field.Set(reflect.ValueOf(&n))
+		case *metav1.Duration:
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			field.Set(reflect.ValueOf(&metav1.Duration{Duration: d}))
+		case []string:
+			var values []string
+			if sv, ok := f.Value.(pflag.SliceValue); ok {
+				values = sv.GetSlice()
+			} else if value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"); value != "" {
+				// Named certificate keys are separated by semicolons.
+				values = strings.Split(value, ";")
+			}
+			field.Set(reflect.ValueOf(values))
+		default:
+			panic(fmt.Sprintf("unsupported type %T of flag %q", field.Interface(), name))
+		}
+		if err != nil {
+			return false, fmt.Errorf("invalid value %q of flag %q: %v", value, name, err)
+		}
+	}
+	return set, nil
+}
+
+// quoteCSV quotes s so string slice flags read it as a single value.
+func quoteCSV(s string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{s})
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
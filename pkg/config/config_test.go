@@ -0,0 +1,147 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/pflag"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+)
+
+func TestApply(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	upstream := fs.String("upstream", "", "")
+	timeout := fs.Duration("upstream-timeout", 0, "")
+	addresses := fs.StringSlice("secure-listen-address", []string{":8443"}, "")
+	audiences := fs.StringSlice("auth-token-audiences", nil, "")
+	slow := fs.Duration("log-slow-requests", 0, "")
+	enabled := fs.Bool("auth-header-fields-enabled", false, "")
+	if err := fs.Parse([]string{"--upstream=http://flag/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var f v1alpha1.KubeRBACProxyConfig
+	if err := yaml.Unmarshal([]byte(`
+apiVersion: kube-rbac-proxy.brancz.com/v1alpha1
+kind: KubeRBACProxyConfig
+listen:
+  secureAddresses: ["0.0.0.0:8443", "[::]:8443"]
+upstream:
+  url: http://file/
+  timeout: 30s
+authentication:
+  tokenAudiences: ["a,b"]
+  header:
+    enabled: true
+flags:
+  log-slow-requests: 1s
+`), &f); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.ValidateVersion(&f); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(&f.Options, fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if *upstream != "http://flag/" {
+		t.Errorf("want flag to take precedence, got upstream %q", *upstream)
+	}
+	if *timeout != 30*time.Second || *slow != time.Second || !*enabled {
+		t.Errorf("want values of the file, got timeout %v, slow requests %v and header fields %v", *timeout, *slow, *enabled)
+	}
+	if want := []string{"0.0.0.0:8443", "[::]:8443"}; !reflect.DeepEqual(*addresses, want) {
+		t.Errorf("want addresses %v replacing the default, got %v", want, *addresses)
+	}
+	if want := []string{"a,b"}; !reflect.DeepEqual(*audiences, want) {
+		t.Errorf("want audiences %v, got %v", want, *audiences)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	url := "http://file/"
+	for name, o := range map[string]v1alpha1.Options{
+		"unknown flag":  {Flags: map[string]string{"unknown": "1"}},
+		"invalid value": {Upstream: &v1alpha1.UpstreamOptions{URL: &url}, Flags: map[string]string{"upstream-timeout": "10"}},
+		"set twice":     {Upstream: &v1alpha1.UpstreamOptions{URL: &url}, Flags: map[string]string{"upstream": "http://file/"}},
+	} {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.String("upstream", "", "")
+		fs.Duration("upstream-timeout", 0, "")
+		o := o
+		if err := Apply(&o, fs); err == nil {
+			t.Errorf("%s: want error, got nil", name)
+		}
+	}
+}
+
+func TestFromFlags(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("upstream", "", "")
+	fs.Duration("upstream-timeout", 0, "")
+	fs.StringSlice("secure-listen-address", nil, "")
+	fs.Bool("auth-header-fields-enabled", false, "")
+	fs.Duration("log-slow-requests", 0, "")
+	fs.StringSlice("allow-paths", nil, "")
+	fs.StringToString("decision-log-labels", nil, "")
+	args := []string{
+		"--upstream=http://flag/",
+		"--upstream-timeout=30s",
+		"--secure-listen-address=0.0.0.0:8443,[::]:8443",
+		"--auth-header-fields-enabled",
+		"--log-slow-requests=1s",
+		"--allow-paths=/metrics,/healthz",
+		"--decision-log-labels=cluster=a",
+	}
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	fs.Visit(func(f *pflag.Flag) {
+		names = append(names, f.Name)
+	})
+
+	o, remaining, err := FromFlags(fs, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"allow-paths", "decision-log-labels"}; !reflect.DeepEqual(remaining, want) {
+		t.Errorf("want remaining flags %v, got %v", want, remaining)
+	}
+
+	// Applying the options to fresh flags restores their values.
+	values, err := Values(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"upstream":                   {"http://flag/"},
+		"upstream-timeout":           {"30s"},
+		"secure-listen-address":      {"0.0.0.0:8443", "[::]:8443"},
+		"auth-header-fields-enabled": {"true"},
+		"log-slow-requests":          {"1s"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("want values %v, got %v", want, values)
+	}
+}
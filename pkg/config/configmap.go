@@ -0,0 +1,87 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConfigMapKey is the key of a ConfigMap holding config file content.
+type ConfigMapKey struct {
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// ParseConfigMapKey parses a ConfigMap key of the form namespace/name/key.
+func ParseConfigMapKey(s string) (ConfigMapKey, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return ConfigMapKey{}, fmt.Errorf("%q must be of the form namespace/name/key", s)
+	}
+	return ConfigMapKey{Namespace: parts[0], Name: parts[1], Key: parts[2]}, nil
+}
+
+func (k ConfigMapKey) String() string {
+	return fmt.Sprintf("key %s of ConfigMap %s/%s", k.Key, k.Namespace, k.Name)
+}
+
+// GetConfigMap returns the config file content of the ConfigMap key k.
+func GetConfigMap(ctx context.Context, client kubernetes.Interface, k ConfigMapKey) ([]byte, error) {
+	cm, err := client.CoreV1().ConfigMaps(k.Namespace).Get(ctx, k.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ConfigMap %s/%s: %v", k.Namespace, k.Name, err)
+	}
+	return k.content(cm)
+}
+
+// WatchConfigMap calls reload with the config file content of the ConfigMap
+// key k whenever it differs from the content last loaded, until ctx is done.
+// content is the content loaded at startup.
+func WatchConfigMap(ctx context.Context, client kubernetes.Interface, k ConfigMapKey, content []byte, reload func([]byte) error) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(k.Namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", k.Name).String()
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+
+	return watchInformer(ctx, informer, k.String(), content, func(obj interface{}) ([]byte, error) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object %T", obj)
+		}
+		return k.content(cm)
+	}, reload)
+}
+
+func (k ConfigMapKey) content(cm *corev1.ConfigMap) ([]byte, error) {
+	data, ok := cm.Data[k.Key]
+	if !ok {
+		return nil, fmt.Errorf("ConfigMap %s/%s has no key %s", k.Namespace, k.Name, k.Key)
+	}
+	return []byte(data), nil
+}
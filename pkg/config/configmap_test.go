@@ -0,0 +1,105 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWatchConfigMap(t *testing.T) {
+	newConfigMap := func(data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "proxy"},
+			Data:       data,
+		}
+	}
+	client := fake.NewSimpleClientset(newConfigMap(map[string]string{"config.yaml": "a"}))
+
+	k, err := ParseConfigMapKey("default/proxy/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseConfigMapKey("default/proxy"); err == nil {
+		t.Error("want error parsing a key without namespace, name and key, got nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	content, err := GetConfigMap(ctx, client, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a" {
+		t.Fatalf("want content %q, got %q", "a", content)
+	}
+
+	var (
+		mu       sync.Mutex
+		reloaded []string
+	)
+	last := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(reloaded) == 0 {
+			return ""
+		}
+		return reloaded[len(reloaded)-1]
+	}
+	done := make(chan error)
+	go func() {
+		done <- WatchConfigMap(ctx, client, k, content, func(b []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			reloaded = append(reloaded, string(b))
+			return nil
+		})
+	}()
+
+	for _, data := range []map[string]string{
+		{"other": "c"},
+		{"config.yaml": "b"},
+	} {
+		if _, err := client.CoreV1().ConfigMaps("default").Update(ctx, newConfigMap(data), metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return last() == "b", nil
+	}); err != nil {
+		t.Errorf("want %q to be reloaded, got %q", "b", last())
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reloaded) != 1 {
+		t.Errorf("want only changes of the key to be reloaded, got reloads %q", reloaded)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+)
+
+// WriteDefaults writes a KubeRBACProxyConfig setting every flag of fs to its
+// default, preceded by the usage of the flag as comment. Settings of flags
+// which aren't in fs are omitted, as are the flags named in exclude. Flags
+// of fs are set to their default, to check if their default can be set.
+func WriteDefaults(w io.Writer, fs *pflag.FlagSet, exclude ...string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "apiVersion: %s\n", v1alpha1.SchemeGroupVersion)
+	fmt.Fprintf(bw, "kind: %s\n", v1alpha1.Kind)
+
+	written := sets.NewString(exclude...)
+	if err := writeDefaults(bw, reflect.TypeOf(v1alpha1.Options{}), fs, "", written); err != nil {
+		return err
+	}
+
+	var rest []*pflag.Flag
+	fs.VisitAll(func(f *pflag.Flag) {
+		if !written.Has(f.Name) && !f.Hidden && f.Deprecated == "" {
+			rest = append(rest, f)
+		}
+	})
+	sort.Slice(rest, func(i, j int) bool { return rest[i].Name < rest[j].Name })
+	fmt.Fprintln(bw, "# flags sets any other flag by its name.")
+	fmt.Fprintln(bw, "flags:")
+	for _, f := range rest {
+		writeComment(bw, "  ", f.Usage)
+		if f.DefValue == "[]" || f.Value.Set(f.DefValue) != nil {
+			// Some defaults, e.g. empty lists and maps, can't be set as
+			// flag value, they are left unset.
+			fmt.Fprintf(bw, "  # %s: \"\"\n", f.Name)
+			continue
+		}
+		fmt.Fprintf(bw, "  %s: %s\n", f.Name, quote(f.DefValue))
+	}
+
+	fmt.Fprint(bw, `# authorization configures how requests are authorized. By default the
+# request path is authorized as non-resource URL with the verb of the request
+# method. resourceAttributes authorize a resource instead, e.g.:
+#   resourceAttributes:
+#     namespace: monitoring
+#     apiVersion: v1
+#     resource: services
+#     subresource: proxy
+#     name: prometheus
+authorization: {}
+# hosts are virtual hosts routed to by TLS SNI or Host header, each with its
+# own upstream and authorization.
+hosts: []
+# routes route the requests not matching a virtual host by path prefix, each
+# with its own upstream, authentication and authorization.
+routes: []
+`)
+	return bw.Flush()
+}
+
+// writeDefaults writes the settings of the struct type t, indented by indent.
+func writeDefaults(w io.Writer, t reflect.Type, fs *pflag.FlagSet, indent string, written sets.String) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		name := field.Tag.Get("flag")
+
+		if name == "" {
+			if field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct {
+				fmt.Fprintf(w, "%s%s:\n", indent, key)
+				if err := writeDefaults(w, field.Type.Elem(), fs, indent+"  ", written); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		f := fs.Lookup(name)
+		if f == nil || written.Has(name) {
+			continue
+		}
+		written.Insert(name)
+
+		writeComment(w, indent, f.Usage)
+		if field.Type.Kind() != reflect.Slice {
+			value := f.DefValue
+			if field.Type.Elem().Kind() == reflect.String || field.Type.Elem() == reflect.TypeOf(metav1.Duration{}) {
+				value = quote(value)
+			}
+			fmt.Fprintf(w, "%s%s: %s\n", indent, key, value)
+			continue
+		}
+
+		values, err := sliceDefault(f)
+		if err != nil {
+			return fmt.Errorf("failed to read default of flag %q: %v", name, err)
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s%s: %s\n", indent, key, b)
+	}
+	return nil
+}
+
+// sliceDefault returns the default values of the slice flag f.
+func sliceDefault(f *pflag.Flag) ([]string, error) {
+	s := strings.TrimSuffix(strings.TrimPrefix(f.DefValue, "["), "]")
+	if s == "" {
+		return []string{}, nil
+	}
+	return readAsCSV(s)
+}
+
+func readAsCSV(s string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(s))
+	return r.Read()
+}
+
+func writeComment(w io.Writer, indent, usage string) {
+	for _, line := range strings.Split(usage, "\n") {
+		fmt.Fprintf(w, "%s# %s\n", indent, line)
+	}
+}
+
+// quote returns s as YAML string.
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
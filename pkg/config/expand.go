@@ -0,0 +1,116 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// serviceAccountNamespaceFile holds the namespace of the pod when running
+// in-cluster.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// fieldRefPrefix prefixes references to fields of the pod, like the
+// fieldRef of a downward API volume.
+const fieldRefPrefix = "fieldRef:"
+
+var referenceRegexp = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// Expand replaces references in the config file content:
+//
+//   - ${VAR} is replaced with the value of the environment variable VAR.
+//   - ${fieldRef:metadata.namespace} and ${fieldRef:metadata.name} are
+//     replaced with the namespace and name of the pod kube-rbac-proxy runs in.
+//     They are read from the POD_NAMESPACE and POD_NAME environment variables,
+//     falling back to the namespace of the ServiceAccount and the hostname.
+//   - $${ is replaced with a literal ${.
+//
+// An error is returned for references which cannot be resolved.
+func Expand(content []byte) ([]byte, error) {
+	return expand(content, os.LookupEnv, podField)
+}
+
+func expand(content []byte, lookupEnv func(string) (string, bool), field func(string) (string, error)) ([]byte, error) {
+	unresolved := map[string]string{}
+
+	out := referenceRegexp.ReplaceAllFunc(content, func(ref []byte) []byte {
+		if string(ref) == "$${" {
+			return []byte("${")
+		}
+
+		name := string(ref[2 : len(ref)-1])
+		if strings.HasPrefix(name, fieldRefPrefix) {
+			v, err := field(strings.TrimPrefix(name, fieldRefPrefix))
+			if err != nil {
+				unresolved[name] = err.Error()
+				return ref
+			}
+			return []byte(v)
+		}
+
+		v, ok := lookupEnv(name)
+		if !ok {
+			unresolved[name] = "environment variable is not set"
+			return ref
+		}
+		return []byte(v)
+	})
+
+	if len(unresolved) > 0 {
+		names := make([]string, 0, len(unresolved))
+		for name := range unresolved {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		msgs := make([]string, 0, len(names))
+		for _, name := range names {
+			msgs = append(msgs, fmt.Sprintf("${%s}: %s", name, unresolved[name]))
+		}
+		return nil, fmt.Errorf("failed to expand references: %s", strings.Join(msgs, ", "))
+	}
+
+	return out, nil
+}
+
+// podField returns the value of the given field path of the pod
+// kube-rbac-proxy runs in.
+func podField(path string) (string, error) {
+	switch path {
+	case "metadata.namespace":
+		if ns, ok := os.LookupEnv("POD_NAMESPACE"); ok {
+			return ns, nil
+		}
+		b, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return "", fmt.Errorf("POD_NAMESPACE is not set and reading the namespace failed: %v", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	case "metadata.name":
+		if name, ok := os.LookupEnv("POD_NAME"); ok {
+			return name, nil
+		}
+		return os.Hostname()
+	default:
+		return "", fmt.Errorf("unsupported field path, must be one of metadata.namespace, metadata.name")
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	env := map[string]string{"TENANT": "team-a", "EMPTY": ""}
+	lookupEnv := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	field := func(path string) (string, error) {
+		if path == "metadata.namespace" {
+			return "monitoring", nil
+		}
+		return "", errors.New("unsupported field path")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    string
+		err     string
+	}{
+		{
+			name:    "env",
+			content: "namespace: ${TENANT}-${EMPTY}x",
+			want:    "namespace: team-a-x",
+		},
+		{
+			name:    "field ref",
+			content: "namespace: ${fieldRef:metadata.namespace}",
+			want:    "namespace: monitoring",
+		},
+		{
+			name:    "escaped and untouched",
+			content: `a: $${TENANT} b: $TENANT c: "{{ .Value }}"`,
+			want:    `a: ${TENANT} b: $TENANT c: "{{ .Value }}"`,
+		},
+		{
+			name:    "unresolved",
+			content: "${MISSING} ${fieldRef:spec.nodeName} ${MISSING}",
+			err:     "${MISSING}: environment variable is not set, ${fieldRef:spec.nodeName}: unsupported field path",
+		},
+	} {
+		got, err := expand([]byte(tc.content), lookupEnv, field)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: want error containing %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: want %q, got %q", tc.name, tc.want, string(got))
+		}
+	}
+}
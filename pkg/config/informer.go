@@ -0,0 +1,67 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// watchInformer runs informer until ctx is done, and calls reload with the
+// config file content of its objects, returned by objectContent, whenever
+// it differs from the content last loaded. content is the content loaded at
+// startup, and desc describes the watched object in logs.
+//
+// Failed reloads are logged and counted like those of Watch. The last
+// config stays in effect when the object is deleted.
+func watchInformer(ctx context.Context, informer cache.SharedIndexInformer, desc string, content []byte, objectContent func(obj interface{}) ([]byte, error), reload func([]byte) error) error {
+	update := func(obj interface{}) {
+		b, err := objectContent(obj)
+		if err != nil {
+			klog.Errorf("Failed to read %s: %v", desc, err)
+			observeReload(false)
+			return
+		}
+		if bytes.Equal(b, content) {
+			return
+		}
+		content = b
+
+		if err := reload(b); err != nil {
+			klog.Errorf("Failed to reload %s: %v", desc, err)
+			observeReload(false)
+			return
+		}
+		klog.Infof("Reloaded %s", desc)
+		observeReload(true)
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+		UpdateFunc: func(_, obj interface{}) {
+			update(obj)
+		},
+		DeleteFunc: func(interface{}) {
+			klog.Warningf("%s was deleted, keeping the last config", desc)
+		},
+	})
+
+	informer.Run(ctx.Done())
+	return nil
+}
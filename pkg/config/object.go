@@ -0,0 +1,98 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+)
+
+// ObjectResource is the resource of KubeRBACProxyConfig objects. Their spec
+// holds the settings of a config file.
+var ObjectResource = schema.GroupVersionResource{
+	Group:    v1alpha1.GroupName,
+	Version:  v1alpha1.SchemeGroupVersion.Version,
+	Resource: "kuberbacproxyconfigs",
+}
+
+// ParseObjectName parses the namespace/name of a KubeRBACProxyConfig object.
+func ParseObjectName(s string) (namespace, name string, err error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%q must be of the form namespace/name", s)
+	}
+	return parts[0], parts[1], nil
+}
+
+// GetObject returns the spec of the KubeRBACProxyConfig object
+// namespace/name as config file content.
+func GetObject(ctx context.Context, client dynamic.Interface, namespace, name string) ([]byte, error) {
+	u, err := client.Resource(ObjectResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get KubeRBACProxyConfig %s/%s: %v", namespace, name, err)
+	}
+	return objectContent(u)
+}
+
+// WatchObject calls reload with the spec of the KubeRBACProxyConfig object
+// namespace/name as config file content whenever it differs from the content
+// last loaded, until ctx is done. content is the content loaded at startup.
+func WatchObject(ctx context.Context, client dynamic.Interface, namespace, name string, content []byte, reload func([]byte) error) error {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 0, namespace, func(o *metav1.ListOptions) {
+		o.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	})
+	informer := factory.ForResource(ObjectResource).Informer()
+
+	desc := fmt.Sprintf("KubeRBACProxyConfig %s/%s", namespace, name)
+	return watchInformer(ctx, informer, desc, content, func(obj interface{}) ([]byte, error) {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object %T", obj)
+		}
+		return objectContent(u)
+	}, reload)
+}
+
+// objectContent returns the spec of u as config file content.
+func objectContent(u *unstructured.Unstructured) ([]byte, error) {
+	spec, _, err := unstructured.NestedMap(u.Object, "spec")
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec: %v", err)
+	}
+
+	file := map[string]interface{}{}
+	for k, v := range spec {
+		file[k] = v
+	}
+	file["apiVersion"] = v1alpha1.SchemeGroupVersion.String()
+	file["kind"] = v1alpha1.Kind
+
+	// JSON is valid YAML, and encoding/json sorts the keys, so the content
+	// only changes with the spec.
+	return json.Marshal(file)
+}
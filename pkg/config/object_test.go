@@ -0,0 +1,119 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+)
+
+func newObject(namespace string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": v1alpha1.SchemeGroupVersion.String(),
+		"kind":       v1alpha1.Kind,
+		"metadata": map[string]interface{}{
+			"namespace": "default",
+			"name":      "proxy",
+		},
+		"spec": map[string]interface{}{
+			"authorization": map[string]interface{}{
+				"resourceAttributes": map[string]interface{}{
+					"namespace": namespace,
+				},
+			},
+		},
+	}}
+}
+
+func TestWatchObject(t *testing.T) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(ObjectResource.GroupVersion().WithKind(v1alpha1.Kind+"List"), &unstructured.UnstructuredList{})
+	client := dynamicfake.NewSimpleDynamicClient(scheme, newObject("a"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	content, err := GetObject(ctx, client, "default", "proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f struct {
+		metav1.TypeMeta
+		Authorization struct {
+			ResourceAttributes struct {
+				Namespace string `json:"namespace"`
+			} `json:"resourceAttributes"`
+		} `json:"authorization"`
+	}
+	if err := yaml.Unmarshal(content, &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.APIVersion != v1alpha1.SchemeGroupVersion.String() || f.Kind != v1alpha1.Kind || f.Authorization.ResourceAttributes.Namespace != "a" {
+		t.Fatalf("unexpected content %s", content)
+	}
+
+	var (
+		mu       sync.Mutex
+		reloaded []string
+	)
+	done := make(chan error)
+	go func() {
+		done <- WatchObject(ctx, client, "default", "proxy", content, func(b []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			reloaded = append(reloaded, string(b))
+			return nil
+		})
+	}()
+
+	if _, err := client.Resource(ObjectResource).Namespace("default").Update(ctx, newObject("b"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want, err := objectContent(newObject("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reloaded) > 0 && reloaded[len(reloaded)-1] == string(want), nil
+	}); err != nil {
+		t.Errorf("want the updated object to be reloaded")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reloaded) != 1 {
+		t.Errorf("want only changes to be reloaded, got reloads %q", reloaded)
+	}
+}
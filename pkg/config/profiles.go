@@ -0,0 +1,104 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// Profiles are named bundles of flag defaults for common deployments.
+// They don't map request methods to other authorization verbs, as the
+// deployments expect the fixed mapping of the proxy, e.g. GET to get.
+var Profiles = map[string]map[string][]string{
+	// metrics-sidecar protects the /metrics endpoint of the container it
+	// runs next to, as scraped by Prometheus.
+	"metrics-sidecar": {
+		"allow-paths":      {"/metrics"},
+		"upstream-timeout": {"30s"},
+		"upstream-retries": {"1"},
+		"readyz-upstream":  {"true"},
+	},
+	// kubelet-frontend protects a kubelet-like node API, whose health
+	// endpoint stays unauthenticated and whose logs are streamed.
+	"kubelet-frontend": {
+		"ignore-paths":            {"/healthz"},
+		"upstream-flush-interval": {"-1s"},
+		"upstream-retries":        {"1"},
+	},
+	// debug-endpoints protects pprof and similar debug handlers, whose
+	// profiles take long to collect, and logs every access.
+	"debug-endpoints": {
+		"upstream-timeout": {"2m"},
+		"log-sample-every": {"1"},
+	},
+}
+
+// exclusiveFlags are the flags which can't be used with the mapped flag.
+var exclusiveFlags = map[string]string{
+	"allow-paths":  "ignore-paths",
+	"ignore-paths": "allow-paths",
+}
+
+// ProfileNames returns the sorted names of the profiles.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyProfile sets the defaults of the named profile for the flags of fs
+// which weren't set yet, so it must be applied after the command line and
+// config file. Flags which can't be used with a set flag are skipped, too.
+// The flags aren't marked as changed.
+func ApplyProfile(fs *pflag.FlagSet, name string) error {
+	profile, ok := Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, must be one of %s", name, strings.Join(ProfileNames(), ", "))
+	}
+
+	for flag, values := range profile {
+		f := fs.Lookup(flag)
+		if f == nil {
+			return fmt.Errorf("unknown flag %q in profile %q", flag, name)
+		}
+		if f.Changed {
+			continue
+		}
+		if other, ok := exclusiveFlags[flag]; ok && fs.Changed(other) {
+			continue
+		}
+
+		var err error
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			err = sv.Replace(values)
+		} else {
+			err = f.Value.Set(values[0])
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value %q for flag %q in profile %q: %v", values, flag, name, err)
+		}
+		f.DefValue = f.Value.String()
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+// Watch calls reload with the content of the file at path whenever it
+// differs from the content last loaded, checked every interval, or when the
+// process receives SIGHUP, until ctx is done. content is the content loaded
+// at startup. A zero interval only reloads on SIGHUP.
+//
+// Failed reloads are logged and counted, the file is reloaded again once it
+// changes.
+func Watch(ctx context.Context, path string, content []byte, interval time.Duration, reload func([]byte) error) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		force := false
+		select {
+		case <-tick:
+		case <-sig:
+			klog.Infof("Received SIGHUP, reloading config file %s", path)
+			force = true
+		case <-ctx.Done():
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			klog.Errorf("Failed to read config file %s: %v", path, err)
+			observeReload(false)
+			continue
+		}
+		if !force && bytes.Equal(b, content) {
+			continue
+		}
+		content = b
+
+		if err := reload(b); err != nil {
+			klog.Errorf("Failed to reload config file %s: %v", path, err)
+			observeReload(false)
+			continue
+		}
+		klog.Infof("Reloaded config file %s", path)
+		observeReload(true)
+	}
+}
+
+func observeReload(success bool) {
+	if success {
+		metrics.ConfigReloads.WithLabelValues("success").Inc()
+		metrics.ConfigLastReloadSuccessful.Set(1)
+		return
+	}
+	metrics.ConfigReloads.WithLabelValues("failure").Inc()
+	metrics.ConfigLastReloadSuccessful.Set(0)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu       sync.Mutex
+		reloaded []string
+	)
+	reload := func(b []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		reloaded = append(reloaded, string(b))
+		if string(b) == "invalid" {
+			return errors.New("invalid")
+		}
+		return nil
+	}
+	last := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(reloaded) == 0 {
+			return ""
+		}
+		return reloaded[len(reloaded)-1]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, path, []byte("a"), 10*time.Millisecond, reload)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, content := range []string{"invalid", "b"} {
+		// Replace the file atomically, so that the watcher never reads it
+		// truncated.
+		tmp := path + ".tmp"
+		if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+		if err := wait.Poll(10*time.Millisecond, 2*time.Second, func() (bool, error) {
+			return last() == content, nil
+		}); err != nil {
+			t.Fatalf("want %q to be reloaded, got %q", content, last())
+		}
+
+		want := 1.0
+		if content == "invalid" {
+			want = 0
+		}
+		if err := wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+			return testutil.ToFloat64(metrics.ConfigLastReloadSuccessful) == want, nil
+		}); err != nil {
+			t.Errorf("want last reload successful %v after reloading %q", want, content)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reloaded) != 2 {
+		t.Errorf("want unchanged content not to be reloaded, got reloads %q", reloaded)
+	}
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package decisionlog ships authorization decisions to an HTTP sink, in
+// batches of JSON documents similar to Open Policy Agent's decision logs.
+package decisionlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/klog/v2"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/filters"
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+// Decision is an authorization decision as shipped to the sink.
+type Decision struct {
+	DecisionID string            `json:"decision_id"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Input      Input             `json:"input"`
+	Result     Result            `json:"result"`
+	Metrics    Metrics           `json:"metrics"`
+}
+
+// Input holds the request and the attributes authorized for it.
+type Input struct {
+	Route      string       `json:"route,omitempty"`
+	Method     string       `json:"method"`
+	Host       string       `json:"host"`
+	Path       string       `json:"path"`
+	User       User         `json:"user"`
+	Attributes []Attributes `json:"attributes"`
+}
+
+// User is the authenticated user of the request.
+type User struct {
+	Name   string   `json:"name"`
+	UID    string   `json:"uid,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Attributes are the attributes of a SubjectAccessReview.
+type Attributes struct {
+	Verb        string `json:"verb"`
+	Namespace   string `json:"namespace,omitempty"`
+	APIGroup    string `json:"apiGroup,omitempty"`
+	APIVersion  string `json:"apiVersion,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Path        string `json:"path,omitempty"`
+}
+
+// Result is the outcome of the authorization.
+type Result struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+	Status  int    `json:"status"`
+}
+
+// Metrics holds timings of the decision.
+type Metrics struct {
+	TimerAuthorizationNS int64 `json:"timer_authorization_ns"`
+}
+
+// Config configures shipping decision logs.
+type Config struct {
+	// URL is the HTTP endpoint batches of decisions are POSTed to.
+	URL string
+	// Labels are added to every decision, e.g. to identify the proxy.
+	Labels map[string]string
+	// BufferSize is the number of decisions buffered, further decisions are dropped.
+	BufferSize int
+	// MaxBatchSize is the maximum number of decisions per request.
+	MaxBatchSize int
+	// FlushInterval is the maximum time decisions are buffered.
+	FlushInterval time.Duration
+	// Retries is the number of times failed requests are retried.
+	Retries int
+	// RetryBackoff is the time to wait before the first retry, doubling with each retry.
+	RetryBackoff time.Duration
+}
+
+// Logger buffers decisions and ships them in batches.
+type Logger struct {
+	cfg    Config
+	client *http.Client
+	buffer chan *Decision
+}
+
+// NewLogger returns a Logger shipping decisions to the sink with client.
+func NewLogger(cfg Config, client *http.Client) *Logger {
+	return &Logger{
+		cfg:    cfg,
+		client: client,
+		buffer: make(chan *Decision, cfg.BufferSize),
+	}
+}
+
+// Log buffers the decision, or drops it if the buffer is full.
+func (l *Logger) Log(d *Decision) {
+	select {
+	case l.buffer <- d:
+	default:
+		metrics.DecisionLogsDropped.WithLabelValues("buffer_full").Inc()
+	}
+}
+
+// Run ships buffered decisions until ctx is done, then ships the remaining
+// decisions once.
+func (l *Logger) Run(ctx context.Context) error {
+	ticker := time.NewTicker(l.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Decision, 0, l.cfg.MaxBatchSize)
+	flush := func(ctx context.Context, retries int) {
+		if len(batch) == 0 {
+			return
+		}
+		l.ship(ctx, batch, retries)
+		batch = make([]*Decision, 0, l.cfg.MaxBatchSize)
+	}
+
+	for {
+		select {
+		case d := <-l.buffer:
+			batch = append(batch, d)
+			if len(batch) >= l.cfg.MaxBatchSize {
+				flush(ctx, l.cfg.Retries)
+			}
+		case <-ticker.C:
+			flush(ctx, l.cfg.Retries)
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), l.cfg.FlushInterval)
+			defer cancel()
+
+			// Ship what is left once, without retrying.
+			for {
+				select {
+				case d := <-l.buffer:
+					batch = append(batch, d)
+					if len(batch) >= l.cfg.MaxBatchSize {
+						flush(ctx, 0)
+					}
+				default:
+					flush(ctx, 0)
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func (l *Logger) ship(ctx context.Context, batch []*Decision, retries int) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(batch); err != nil {
+		klog.Errorf("failed to encode decision logs: %v", err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		klog.Errorf("failed to compress decision logs: %v", err)
+		return
+	}
+
+	backoff := wait.Backoff{Duration: l.cfg.RetryBackoff, Factor: 2, Jitter: 0.1, Steps: retries + 1}
+	var lastErr error
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		lastErr = l.post(ctx, body.Bytes())
+		if lastErr != nil && ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		klog.Errorf("failed to ship %d decision logs: %v", len(batch), lastErr)
+		metrics.DecisionLogsDropped.WithLabelValues("sink_failed").Add(float64(len(batch)))
+		return
+	}
+	metrics.DecisionLogsSent.Add(float64(len(batch)))
+}
+
+func (l *Logger) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, l.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// WithDecisionLogs logs the authorization decisions of handler's requests.
+// Requests which weren't authorized, e.g. because authentication failed,
+// aren't logged.
+func WithDecisionLogs(handler http.Handler, l *Logger) http.Handler {
+	if l == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, info := requestinfo.WithInfo(req.Context())
+		rw := filters.NewStatusRecorder(w)
+		start := time.Now()
+
+		handler.ServeHTTP(rw, req.WithContext(ctx))
+
+		if len(info.Attributes) == 0 {
+			return
+		}
+		l.Log(newDecision(req, info, rw.StatusCode(), start, l.cfg.Labels))
+	})
+}
+
+// decisionID returns the ID of the request, so decisions can be matched to
+// error responses and logs, or a new UUID if the request has none.
+func decisionID(info *requestinfo.Info) string {
+	if info.ID != "" {
+		return info.ID
+	}
+	return string(uuid.NewUUID())
+}
+
+func newDecision(req *http.Request, info *requestinfo.Info, status int, start time.Time, labels map[string]string) *Decision {
+	return &Decision{
+		DecisionID: decisionID(info),
+		Timestamp:  start.UTC(),
+		Labels:     labels,
+		Input: Input{
+			Route:      info.Route,
+			Method:     req.Method,
+			Host:       req.Host,
+			Path:       req.URL.Path,
+			User:       newUser(info.User),
+			Attributes: newAttributes(info.Attributes),
+		},
+		Result: Result{
+			Allowed: info.Decision == authorizer.DecisionAllow,
+			Reason:  info.Reason,
+			Status:  status,
+		},
+		Metrics: Metrics{
+			TimerAuthorizationNS: info.AuthorizationDuration.Nanoseconds(),
+		},
+	}
+}
+
+func newUser(u user.Info) User {
+	if u == nil {
+		return User{}
+	}
+	return User{Name: u.GetName(), UID: u.GetUID(), Groups: u.GetGroups()}
+}
+
+func newAttributes(attrs []authorizer.Attributes) []Attributes {
+	res := make([]Attributes, 0, len(attrs))
+	for _, a := range attrs {
+		res = append(res, Attributes{
+			Verb:        a.GetVerb(),
+			Namespace:   a.GetNamespace(),
+			APIGroup:    a.GetAPIGroup(),
+			APIVersion:  a.GetAPIVersion(),
+			Resource:    a.GetResource(),
+			Subresource: a.GetSubresource(),
+			Name:        a.GetName(),
+			Path:        a.GetPath(),
+		})
+	}
+	return res
+}
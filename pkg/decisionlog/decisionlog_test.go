@@ -0,0 +1,114 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decisionlog
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+func TestDecisionLogs(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		decisions []Decision
+		failures  = 1
+	)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if failures > 0 {
+			failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			t.Errorf("want gzipped body, got %v", err)
+			return
+		}
+		var batch []Decision
+		if err := json.NewDecoder(gz).Decode(&batch); err != nil {
+			t.Errorf("failed to decode batch: %v", err)
+			return
+		}
+		decisions = append(decisions, batch...)
+	}))
+	defer sink.Close()
+
+	l := NewLogger(Config{
+		URL:           sink.URL,
+		Labels:        map[string]string{"app": "kube-rbac-proxy"},
+		BufferSize:    10,
+		MaxBatchSize:  2,
+		FlushInterval: time.Hour,
+		Retries:       1,
+		RetryBackoff:  10 * time.Millisecond,
+	}, sink.Client())
+
+	handler := WithDecisionLogs(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/unauthenticated" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requestinfo.SetUser(req.Context(), &user.DefaultInfo{Name: "alice"})
+		attrs := authorizer.AttributesRecord{Verb: "get", Path: req.URL.Path}
+		requestinfo.AddDecision(req.Context(), attrs, authorizer.DecisionAllow, "", time.Millisecond)
+	}), l)
+
+	for _, path := range []string{"/metrics", "/unauthenticated", "/healthz", "/federate"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- l.Run(ctx)
+	}()
+
+	// The first batch is full, the remaining decision is shipped on shutdown.
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(decisions) != 3 {
+		t.Fatalf("want 3 decisions of authorized requests, got %d", len(decisions))
+	}
+	d := decisions[0]
+	if d.Input.User.Name != "alice" || d.Input.Attributes[0].Path != "/metrics" || !d.Result.Allowed || d.Result.Status != http.StatusOK {
+		t.Errorf("unexpected decision %+v", d)
+	}
+	if d.Metrics.TimerAuthorizationNS != int64(time.Millisecond) || d.Labels["app"] != "kube-rbac-proxy" || d.DecisionID == "" {
+		t.Errorf("unexpected decision metadata %+v", d)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/filters"
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+// maxTrackedIdentities bounds the number of identities failures are counted for.
+const maxTrackedIdentities = 10000
+
+// FailureConfig configures Events on sustained authentication and authorization failures.
+type FailureConfig struct {
+	// Threshold is the number of failures of an identity within Window
+	// emitting an Event.
+	Threshold int
+	// Window is the period failures are counted in.
+	Window time.Duration
+
+	// PodName, PodNamespace and PodUID identify the Pod the Events are emitted on.
+	PodName      string
+	PodNamespace string
+	PodUID       string
+}
+
+// FailureRecorder emits Events on the proxy's Pod if an identity receives
+// Threshold 401 or 403 responses within Window. Each identity causes at most
+// one Event per Window.
+type FailureRecorder struct {
+	cfg         FailureConfig
+	ref         *corev1.ObjectReference
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	now         func() time.Time
+
+	mu       sync.Mutex
+	failures map[failureKey]*failureWindow
+}
+
+type failureKey struct {
+	identity string
+	code     int
+}
+
+type failureWindow struct {
+	start time.Time
+	count int
+}
+
+// NewFailureRecorder returns a FailureRecorder emitting Events with client.
+func NewFailureRecorder(client kubernetes.Interface, cfg FailureConfig) (*FailureRecorder, error) {
+	if cfg.PodName == "" || cfg.PodNamespace == "" {
+		return nil, fmt.Errorf("the name and namespace of the Pod to emit Events on are required")
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events(cfg.PodNamespace)})
+
+	return &FailureRecorder{
+		cfg: cfg,
+		ref: &corev1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Name:       cfg.PodName,
+			Namespace:  cfg.PodNamespace,
+			UID:        types.UID(cfg.PodUID),
+		},
+		broadcaster: broadcaster,
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "kube-rbac-proxy"}),
+		now:         time.Now,
+		failures:    map[failureKey]*failureWindow{},
+	}, nil
+}
+
+// Shutdown stops emitting Events.
+func (r *FailureRecorder) Shutdown() {
+	r.broadcaster.Shutdown()
+}
+
+// Observe counts a response with the given status code to identity.
+func (r *FailureRecorder) Observe(identity string, code int) {
+	if code != http.StatusUnauthorized && code != http.StatusForbidden {
+		return
+	}
+
+	now := r.now()
+	key := failureKey{identity: identity, code: code}
+
+	r.mu.Lock()
+	w, ok := r.failures[key]
+	if !ok || now.Sub(w.start) > r.cfg.Window {
+		if len(r.failures) >= maxTrackedIdentities {
+			r.pruneLocked(now)
+		}
+		w = &failureWindow{start: now}
+		r.failures[key] = w
+	}
+	w.count++
+	emit := w.count == r.cfg.Threshold
+	r.mu.Unlock()
+
+	if !emit {
+		return
+	}
+
+	if code == http.StatusUnauthorized {
+		r.recorder.Eventf(r.ref, corev1.EventTypeWarning, "AuthenticationFailed", "%d requests of %s failed to authenticate within %v", r.cfg.Threshold, identity, r.cfg.Window)
+		return
+	}
+	r.recorder.Eventf(r.ref, corev1.EventTypeWarning, "AuthorizationFailed", "%d requests of %s were forbidden within %v", r.cfg.Threshold, identity, r.cfg.Window)
+}
+
+func (r *FailureRecorder) pruneLocked(now time.Time) {
+	for key, w := range r.failures {
+		if now.Sub(w.start) > r.cfg.Window {
+			delete(r.failures, key)
+		}
+	}
+}
+
+// WithFailureEvents observes the responses of handler with the recorder. Requests
+// are identified by their user if authenticated, or their client IP otherwise.
+func WithFailureEvents(handler http.Handler, r *FailureRecorder) http.Handler {
+	if r == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, info := requestinfo.WithInfo(req.Context())
+		rw := filters.NewStatusRecorder(w)
+
+		handler.ServeHTTP(rw, req.WithContext(ctx))
+
+		r.Observe(identity(req, info), rw.StatusCode())
+	})
+}
+
+func identity(req *http.Request, info *requestinfo.Info) string {
+	if info.User != nil {
+		return fmt.Sprintf("user %q", info.User.GetName())
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return fmt.Sprintf("client %s", host)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+func TestFailureRecorder(t *testing.T) {
+	r, err := NewFailureRecorder(fake.NewSimpleClientset(), FailureConfig{
+		Threshold:    3,
+		Window:       time.Minute,
+		PodName:      "kube-rbac-proxy-0",
+		PodNamespace: "monitoring",
+	})
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	defer r.Shutdown()
+
+	fakeRecorder := record.NewFakeRecorder(10)
+	r.recorder = fakeRecorder
+	now := time.Unix(0, 0)
+	r.now = func() time.Time { return now }
+
+	handler := WithFailureEvents(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestinfo.SetUser(req.Context(), &user.DefaultInfo{Name: "system:serviceaccount:monitoring:prometheus"})
+		w.WriteHeader(http.StatusForbidden)
+	}), r)
+
+	request := func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	}
+
+	for i := 0; i < 5; i++ {
+		request()
+	}
+	if got := len(fakeRecorder.Events); got != 1 {
+		t.Fatalf("want 1 event within the window, got %d", got)
+	}
+	ev := <-fakeRecorder.Events
+	if !strings.Contains(ev, "AuthorizationFailed") || !strings.Contains(ev, "system:serviceaccount:monitoring:prometheus") {
+		t.Errorf("want authorization failure event of the user, got %q", ev)
+	}
+
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 2; i++ {
+		request()
+	}
+	if got := len(fakeRecorder.Events); got != 0 {
+		t.Errorf("want no event below the threshold of a new window, got %d", got)
+	}
+
+	r.Observe("client 10.0.0.1", http.StatusOK)
+	r.Observe("client 10.0.0.1", http.StatusUnauthorized)
+	r.Observe("client 10.0.0.1", http.StatusUnauthorized)
+	r.Observe("client 10.0.0.1", http.StatusUnauthorized)
+	if ev := <-fakeRecorder.Events; !strings.Contains(ev, "AuthenticationFailed") {
+		t.Errorf("want authentication failure event, got %q", ev)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+// WithSizeAccounting counts the request and response body bytes of handler
+// in the size metrics of the given route.
+func WithSizeAccounting(handler http.Handler, route string) http.Handler {
+	requestBytes := metrics.RequestBytes.WithLabelValues(route)
+	responseBytes := metrics.ResponseBytes.WithLabelValues(route)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = &countingReader{ReadCloser: req.Body}
+		}
+		cw := &countingResponseWriter{ResponseWriter: w}
+
+		defer func() {
+			if cr, ok := req.Body.(*countingReader); ok {
+				requestBytes.Add(float64(cr.n))
+			}
+			responseBytes.Add(float64(cw.n))
+		}()
+
+		handler.ServeHTTP(cw, req)
+	})
+}
+
+// WithDurationAccounting observes the latency of handler in the request
+// duration metric of the given route.
+func WithDurationAccounting(handler http.Handler, route string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := NewStatusRecorder(w)
+
+		defer func() {
+			metrics.ObserveRequest(req.Context(), route, sw.StatusCode(), start)
+		}()
+
+		handler.ServeHTTP(sw, req)
+	})
+}
+
+// WithServiceAccountAccounting counts the requests of handler by the service
+// account making them, in the metrics of the given route. If labels is nil,
+// handler is returned as is.
+func WithServiceAccountAccounting(handler http.Handler, route string, labels *metrics.ServiceAccountLabels) http.Handler {
+	if labels == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, info := requestinfo.WithInfo(req.Context())
+		sw := NewStatusRecorder(w)
+
+		defer func() {
+			metrics.ServiceAccountRequests.WithLabelValues(route, labels.Label(info.User), strconv.Itoa(sw.StatusCode())).Inc()
+		}()
+
+		handler.ServeHTTP(sw, req.WithContext(ctx))
+	})
+}
+
+// WithHijackAccounting tracks the connections handler hijacks in the
+// hijacked connections metric, until handler returns. This is accurate for
+// httputil.ReverseProxy, which serves upgraded connections until they are
+// closed.
+func WithHijackAccounting(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := w.(http.Hijacker); !ok {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		hw := &hijackTrackingResponseWriter{ResponseWriter: w}
+		defer func() {
+			if hw.hijacked {
+				metrics.HijackedConnections.Dec()
+			}
+		}()
+
+		handler.ServeHTTP(hw, req)
+	})
+}
+
+// WithTraceExemplars attaches the trace ID of sampled requests, as
+// propagated in the W3C traceparent header, to the latency metrics observed
+// while serving them.
+func WithTraceExemplars(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if id := metrics.TraceIDFromHeader(req.Header); id != "" {
+			req = req.WithContext(metrics.WithTraceID(req.Context(), id))
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingResponseWriter counts the bytes written, it supports flushing and
+// hijacking if the underlying http.ResponseWriter does.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+// hijackTrackingResponseWriter records whether the connection was hijacked,
+// it supports flushing if the underlying http.ResponseWriter does.
+type hijackTrackingResponseWriter struct {
+	http.ResponseWriter
+	hijacked bool
+}
+
+func (w *hijackTrackingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *hijackTrackingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && !w.hijacked {
+		w.hijacked = true
+		metrics.HijackedConnections.Inc()
+	}
+	return conn, rw, err
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Drainer tracks requests in flight, including upgraded (e.g. websocket)
+// connections, which http.Server.Shutdown doesn't wait for.
+type Drainer struct {
+	wg sync.WaitGroup
+
+	once      sync.Once
+	abortOnce sync.Once
+	aborted   chan struct{}
+}
+
+func (d *Drainer) init() {
+	d.once.Do(func() {
+		d.aborted = make(chan struct{})
+	})
+}
+
+// WithDraining makes handler's requests count towards the requests d waits for.
+// The contexts of the requests are canceled by Abort.
+func (d *Drainer) WithDraining(handler http.Handler) http.Handler {
+	d.init()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		d.wg.Add(1)
+		defer d.wg.Done()
+
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-d.aborted:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		handler.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// Abort cancels the contexts of all tracked requests, which makes
+// httputil.ReverseProxy close streaming responses and upgraded connections.
+func (d *Drainer) Abort() {
+	d.init()
+	d.abortOnce.Do(func() {
+		close(d.aborted)
+	})
+}
+
+// Wait blocks until all tracked requests finished or ctx is done.
+func (d *Drainer) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
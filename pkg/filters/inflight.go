@@ -0,0 +1,88 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"net/http"
+	"strconv"
+
+	"k8s.io/klog/v2"
+)
+
+// retryAfter is the number of seconds clients are asked to wait before
+// retrying a request that was rejected because too many requests were in flight.
+const retryAfter = 1
+
+// InFlightConfig holds the limits applied to concurrently served requests.
+type InFlightConfig struct {
+	// MaxRequests is the maximum number of requests served at the same time.
+	// If MaxMutatingRequests is set, it only applies to non-mutating requests.
+	// Zero disables the limit.
+	MaxRequests int
+	// MaxMutatingRequests is the maximum number of mutating (non GET, HEAD
+	// and OPTIONS) requests served at the same time. Zero means mutating
+	// requests share the MaxRequests limit.
+	MaxMutatingRequests int
+}
+
+// WithMaxInFlightLimit limits the number of requests served concurrently by handler.
+// Requests exceeding the limit are rejected with 429 Too Many Requests and a Retry-After header.
+func WithMaxInFlightLimit(handler http.Handler, cfg InFlightConfig) http.Handler {
+	if cfg.MaxRequests <= 0 && cfg.MaxMutatingRequests <= 0 {
+		return handler
+	}
+
+	var readOnly, mutating chan struct{}
+	if cfg.MaxRequests > 0 {
+		readOnly = make(chan struct{}, cfg.MaxRequests)
+	}
+	if cfg.MaxMutatingRequests > 0 {
+		mutating = make(chan struct{}, cfg.MaxMutatingRequests)
+	} else {
+		mutating = readOnly
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c := readOnly
+		if isMutating(req) {
+			c = mutating
+		}
+
+		if c == nil {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		select {
+		case c <- struct{}{}:
+			defer func() { <-c }()
+			handler.ServeHTTP(w, req)
+		default:
+			klog.V(4).Infof("Too many requests in flight, rejecting %s %s", req.Method, req.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			Error(w, req, "Too many requests, please try again later.", http.StatusTooManyRequests)
+		}
+	})
+}
+
+func isMutating(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestMaxInFlightLimit(t *testing.T) {
+	cases := []struct {
+		name    string
+		cfg     InFlightConfig
+		blocked string
+		method  string
+		status  int
+	}{
+		{
+			name:    "unlimited",
+			cfg:     InFlightConfig{},
+			blocked: http.MethodGet,
+			method:  http.MethodGet,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "shared limit exceeded",
+			cfg:     InFlightConfig{MaxRequests: 1},
+			blocked: http.MethodGet,
+			method:  http.MethodPost,
+			status:  http.StatusTooManyRequests,
+		},
+		{
+			name:    "mutating limit separate from read-only limit",
+			cfg:     InFlightConfig{MaxRequests: 1, MaxMutatingRequests: 1},
+			blocked: http.MethodGet,
+			method:  http.MethodPost,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "mutating limit exceeded",
+			cfg:     InFlightConfig{MaxRequests: 1, MaxMutatingRequests: 1},
+			blocked: http.MethodDelete,
+			method:  http.MethodPost,
+			status:  http.StatusTooManyRequests,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			entered := make(chan struct{})
+			release := make(chan struct{})
+
+			handler := WithMaxInFlightLimit(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Header.Get("block") != "" {
+					close(entered)
+					<-release
+				}
+			}), c.cfg)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := httptest.NewRequest(c.blocked, "/", nil)
+				req.Header.Set("block", "true")
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}()
+			<-entered
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(c.method, "/", nil))
+			close(release)
+			wg.Wait()
+
+			if w.Code != c.status {
+				t.Errorf("want status %d, got %d", c.status, w.Code)
+			}
+			if c.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Error("expected Retry-After header to be set")
+			}
+		})
+	}
+}
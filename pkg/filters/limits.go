@@ -0,0 +1,61 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// WithTimeout cancels requests served by handler after timeout.
+// A zero timeout disables the limit.
+func WithTimeout(handler http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+
+		handler.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// WithMaxBodySize rejects requests with bodies larger than maxBytes with
+// 413 Request Entity Too Large. A zero limit disables the check.
+//
+// Requests without a known content length are cut off after maxBytes.
+func WithMaxBodySize(handler http.Handler, maxBytes int64) http.Handler {
+	if maxBytes <= 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ContentLength > maxBytes {
+			Error(w, req, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if req.Body != nil {
+			req.Body = http.MaxBytesReader(w, req.Body, maxBytes)
+		}
+
+		handler.ServeHTTP(w, req)
+	})
+}
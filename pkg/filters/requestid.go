@@ -0,0 +1,73 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"fmt"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+// RequestIDHeader carries the ID of a request in requests and responses.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength bounds the length of request IDs accepted from clients.
+const maxRequestIDLength = 128
+
+// WithRequestID identifies each request by the ID in its X-Request-Id header,
+// or a generated UUID if it has none, so users reporting errors can be
+// matched to the exact log lines and audit events. The ID is returned in the
+// X-Request-Id response header.
+func WithRequestID(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, info := requestinfo.WithInfo(req.Context())
+
+		info.ID = req.Header.Get(RequestIDHeader)
+		if !validRequestID(info.ID) {
+			info.ID = string(uuid.NewUUID())
+		}
+		w.Header().Set(RequestIDHeader, info.ID)
+
+		handler.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts IDs of printable ASCII characters without spaces,
+// so they can be logged safely.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Error replies to the request like http.Error, appending the ID of the
+// request to the message.
+func Error(w http.ResponseWriter, req *http.Request, msg string, code int) {
+	if info := requestinfo.From(req.Context()); info != nil && info.ID != "" {
+		msg = fmt.Sprintf("%s (request ID: %s)", msg, info.ID)
+	}
+	http.Error(w, msg, code)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	handler := WithRequestID(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		Error(w, req, "Forbidden", http.StatusForbidden)
+	}))
+
+	for _, tc := range []struct {
+		name, header string
+		keep         bool
+	}{
+		{name: "generated"},
+		{name: "from client", header: "abc-123", keep: true},
+		{name: "invalid", header: "abc 123\n"},
+		{name: "too long", header: strings.Repeat("a", maxRequestIDLength+1)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set(RequestIDHeader, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(RequestIDHeader)
+			if id == "" {
+				t.Fatal("want request ID header, got none")
+			}
+			if tc.keep && id != tc.header {
+				t.Errorf("want request ID %q of the client, got %q", tc.header, id)
+			}
+			if !tc.keep && id == tc.header {
+				t.Errorf("want generated request ID, got %q of the client", id)
+			}
+			if want := "Forbidden (request ID: " + id + ")\n"; rec.Body.String() != want {
+				t.Errorf("want body %q, got %q", want, rec.Body.String())
+			}
+		})
+	}
+}
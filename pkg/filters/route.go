@@ -0,0 +1,33 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"net/http"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+// WithRoute records the name of the route served by handler in the request
+// info, so metrics, logs and audit events can be labelled by it.
+func WithRoute(handler http.Handler, route string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, info := requestinfo.WithInfo(req.Context())
+		info.Route = route
+		handler.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// CheckFunc returns an error if the checked dependency isn't healthy.
+// Failing checks are answered with 503 Service Unavailable.
+type CheckFunc func(ctx context.Context) error
+
+// Checks is a set of named checks served like the kube-apiserver's
+// /healthz, /livez and /readyz endpoints. It is safe for concurrent use.
+type Checks struct {
+	timeout time.Duration
+
+	mu       sync.RWMutex
+	names    []string
+	checks   map[string]CheckFunc
+	excluded map[string]bool
+}
+
+// NewChecks returns an empty set of checks, each of which may take up to timeout.
+func NewChecks(timeout time.Duration) *Checks {
+	return &Checks{
+		timeout:  timeout,
+		checks:   map[string]CheckFunc{},
+		excluded: map[string]bool{},
+	}
+}
+
+// Add adds the named check. Checks are run in the order they were added.
+func (c *Checks) Add(name string, check CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Exclude permanently excludes the named checks, like the exclude query parameter.
+func (c *Checks) Exclude(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, name := range names {
+		c.excluded[name] = true
+	}
+}
+
+// Names returns the names of all checks.
+func (c *Checks) Names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.names...)
+}
+
+// Handler serves the result of all checks on path, and of each check on
+// path/<name>. The "verbose" query parameter lists the result of every check,
+// checks given by "exclude" query parameters are skipped.
+func (c *Checks) Handler(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		if name := strings.TrimPrefix(req.URL.Path, path+"/"); name != req.URL.Path {
+			c.serveCheck(w, req, name)
+			return
+		}
+
+		excluded := map[string]bool{}
+		for _, name := range req.URL.Query()["exclude"] {
+			excluded[strings.TrimSpace(name)] = true
+		}
+
+		c.mu.RLock()
+		var (
+			names, skipped []string
+			checks         []CheckFunc
+		)
+		for _, name := range c.names {
+			if excluded[name] || c.excluded[name] {
+				skipped = append(skipped, name)
+				continue
+			}
+			names = append(names, name)
+			checks = append(checks, c.checks[name])
+		}
+		c.mu.RUnlock()
+
+		var (
+			out    bytes.Buffer
+			failed bool
+		)
+		for i, name := range names {
+			if err := c.run(req.Context(), checks[i]); err != nil {
+				klog.V(2).Infof("%s check %q failed: %v", path, name, err)
+				fmt.Fprintf(&out, "[-]%s failed: %v\n", name, err)
+				failed = true
+				continue
+			}
+			fmt.Fprintf(&out, "[+]%s ok\n", name)
+		}
+		for _, name := range skipped {
+			fmt.Fprintf(&out, "[+]%s excluded: ok\n", name)
+		}
+
+		if failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			out.WriteTo(w)
+			fmt.Fprintf(w, "%s check failed\n", strings.TrimPrefix(path, "/"))
+			return
+		}
+
+		if _, verbose := req.URL.Query()["verbose"]; verbose {
+			out.WriteTo(w)
+			fmt.Fprintf(w, "%s check passed\n", strings.TrimPrefix(path, "/"))
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+}
+
+func (c *Checks) serveCheck(w http.ResponseWriter, req *http.Request, name string) {
+	check, ok := c.lookup(name)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	if err := c.run(req.Context(), check); err != nil {
+		http.Error(w, fmt.Sprintf("%s failed: %v", name, err), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "ok")
+}
+
+func (c *Checks) lookup(name string) (CheckFunc, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	check, ok := c.checks[name]
+	return check, ok
+}
+
+func (c *Checks) run(ctx context.Context, check CheckFunc) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	return check(ctx)
+}
+
+// Ping is a check which always succeeds.
+func Ping(context.Context) error {
+	return nil
+}
+
+// CheckFunc returns a check failing while any readiness condition isn't met.
+func (r *Readiness) CheckFunc() CheckFunc {
+	return func(context.Context) error {
+		return r.Check()
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecksHandler(t *testing.T) {
+	r := NewReadiness()
+	upstreamErr := errors.New("connection refused")
+
+	c := NewChecks(time.Second)
+	c.Add("ping", Ping)
+	c.Add("conditions", r.CheckFunc())
+	c.Add("upstream", func(context.Context) error { return upstreamErr })
+	h := c.Handler("/readyz")
+
+	check := func(target string, want int, wantBody string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != want {
+			t.Errorf("%s: want status %d, got %d: %s", target, want, rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), wantBody) {
+			t.Errorf("%s: want body containing %q, got %q", target, wantBody, rec.Body.String())
+		}
+	}
+
+	check("/readyz", http.StatusServiceUnavailable, "[-]upstream failed: connection refused")
+	check("/readyz?exclude=upstream", http.StatusOK, "ok")
+	check("/readyz?exclude=upstream&verbose", http.StatusOK, "[+]upstream excluded: ok")
+	check("/readyz/ping", http.StatusOK, "ok")
+	check("/readyz/upstream", http.StatusServiceUnavailable, "connection refused")
+	check("/readyz/foo", http.StatusNotFound, "")
+
+	c.Exclude("upstream")
+	check("/readyz", http.StatusOK, "ok")
+
+	r.Set("shutdown", errors.New("shutting down"))
+	r.Set("certificates", errors.New("not loaded"))
+	check("/readyz", http.StatusServiceUnavailable, "[-]conditions failed: certificates: not loaded")
+
+	r.Set("shutdown", nil)
+	r.Set("certificates", nil)
+	check("/readyz/conditions", http.StatusOK, "ok")
+}
+
+func TestChecksTimeout(t *testing.T) {
+	c := NewChecks(10 * time.Millisecond)
+	c.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	rec := httptest.NewRecorder()
+	c.Handler("/livez").ServeHTTP(rec, httptest.NewRequest("GET", "/livez", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d for timed out check, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
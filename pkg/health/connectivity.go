@@ -0,0 +1,92 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Connectivity tracks whether the TokenReview and SubjectAccessReview
+// requests of the proxy reach the kube-apiserver. It considers the
+// kube-apiserver unreachable once requests failed without a response for
+// longer than a window, without any request succeeding in between.
+// It is safe for concurrent use.
+type Connectivity struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu           sync.Mutex
+	failures     int
+	failingSince time.Time
+	lastErr      error
+}
+
+// NewConnectivity returns a Connectivity tolerating failures for window.
+func NewConnectivity(window time.Duration) *Connectivity {
+	return &Connectivity{
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// Observe records the result of a request to the kube-apiserver. Errors
+// returned by the kube-apiserver prove it is reachable and count as success.
+func (c *Connectivity) Observe(err error) {
+	if _, ok := err.(apierrors.APIStatus); ok {
+		err = nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		c.failures = 0
+		c.lastErr = nil
+		return
+	}
+
+	if c.failures == 0 {
+		c.failingSince = c.now()
+	}
+	c.failures++
+	c.lastErr = err
+}
+
+// Unreachable returns whether requests failed for longer than the window.
+func (c *Connectivity) Unreachable() bool {
+	return c.Check(context.Background()) != nil
+}
+
+// Check returns an error if the kube-apiserver is unreachable, it is a
+// CheckFunc.
+func (c *Connectivity) Check(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures == 0 {
+		return nil
+	}
+	if failing := c.now().Sub(c.failingSince); failing > c.window {
+		return fmt.Errorf("%d consecutive requests to the kube-apiserver failed in the last %v: %v", c.failures, failing.Round(time.Second), c.lastErr)
+	}
+	return nil
+}
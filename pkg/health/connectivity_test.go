@@ -0,0 +1,60 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestConnectivity(t *testing.T) {
+	now := time.Date(2020, 11, 3, 10, 0, 0, 0, time.UTC)
+	c := NewConnectivity(30 * time.Second)
+	c.now = func() time.Time { return now }
+
+	refused := errors.New("dial tcp 10.0.0.1:443: connect: connection refused")
+
+	c.Observe(refused)
+	if err := c.Check(context.Background()); err != nil {
+		t.Errorf("want failures within the window to be tolerated, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	c.Observe(refused)
+	if err := c.Check(context.Background()); err == nil {
+		t.Error("want failures beyond the window to fail the check, got nil")
+	}
+	if !c.Unreachable() {
+		t.Error("want kube-apiserver to be unreachable")
+	}
+
+	c.Observe(apierrors.NewForbidden(schema.GroupResource{Resource: "subjectaccessreviews"}, "", errors.New("denied")))
+	if err := c.Check(context.Background()); err != nil {
+		t.Errorf("want errors of the kube-apiserver to prove it is reachable, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	c.Observe(refused)
+	if err := c.Check(context.Background()); err != nil {
+		t.Errorf("want the window to restart after a success, got %v", err)
+	}
+}
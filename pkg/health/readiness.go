@@ -0,0 +1,65 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Readiness tracks named conditions which all have to be met for the proxy
+// to be ready to receive traffic. It is safe for concurrent use.
+type Readiness struct {
+	mu       sync.RWMutex
+	failures map[string]error
+}
+
+// NewReadiness returns a Readiness without any failing conditions.
+func NewReadiness() *Readiness {
+	return &Readiness{failures: map[string]error{}}
+}
+
+// Set records the state of the named condition, a nil error meaning it is met.
+func (r *Readiness) Set(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		delete(r.failures, name)
+		return
+	}
+	r.failures[name] = err
+}
+
+// Check returns an error describing the failing conditions, if any.
+func (r *Readiness) Check() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.failures) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(r.failures))
+	for name := range r.failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("%s: %v", names[0], r.failures[names[0]])
+}
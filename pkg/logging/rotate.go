@@ -0,0 +1,271 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// backupTimeFormat is the timestamp in the names of rotated files.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotationConfig configures the rotation of log files.
+type RotationConfig struct {
+	// MaxSize is the size in bytes after which the file is rotated,
+	// 0 disables rotation.
+	MaxSize int64
+	// MaxAge is the duration rotated files are kept, 0 keeps them regardless
+	// of their age.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept, 0 keeps all.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// RotatingFile is a file, which is rotated when it grows beyond a size.
+// Rotated files are renamed to include the time of rotation, e.g.
+// "audit-2020-11-03T10-00-00.000.log" for "audit.log", and are optionally
+// compressed and pruned in the background.
+type RotatingFile struct {
+	path string
+	cfg  RotationConfig
+	now  func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	// mill serializes compressing and pruning rotated files.
+	mill sync.Mutex
+	wg   sync.WaitGroup
+}
+
+// OpenRotatingFile opens or creates the file at path for appending.
+func OpenRotatingFile(path string, cfg RotationConfig) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path: path,
+		cfg:  cfg,
+		now:  time.Now,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat %s: %v", f.path, err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would make it exceed
+// its maximum size. Writes larger than the maximum size aren't split.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.cfg.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.cfg.MaxSize {
+		if err := f.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file regardless of its size.
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.rotateLocked()
+}
+
+func (f *RotatingFile) rotateLocked() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", f.path, err)
+	}
+	f.file = nil
+
+	backup := f.backupName(f.now())
+	if err := os.Rename(f.path, backup); err != nil {
+		// Keep appending to the current file.
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rotate %s: %v", f.path, err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.millBackups(backup)
+	}()
+	return nil
+}
+
+// Close waits for rotated files to be processed and closes the file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.wg.Wait()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) prefixAndExt() (string, string) {
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-", ext
+}
+
+func (f *RotatingFile) backupName(t time.Time) string {
+	prefix, ext := f.prefixAndExt()
+	return prefix + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// millBackups compresses the given rotated file and prunes rotated files
+// exceeding the maximum age or number.
+func (f *RotatingFile) millBackups(backup string) {
+	f.mill.Lock()
+	defer f.mill.Unlock()
+
+	if f.cfg.Compress {
+		if err := compressFile(backup); err != nil {
+			klog.Errorf("Failed to compress rotated file %s: %v", backup, err)
+		}
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		klog.Errorf("Failed to list rotated files of %s: %v", f.path, err)
+		return
+	}
+
+	cutoff := f.now().Add(-f.cfg.MaxAge)
+	for i, b := range backups {
+		expired := f.cfg.MaxAge > 0 && b.time.Before(cutoff)
+		tooMany := f.cfg.MaxBackups > 0 && i >= f.cfg.MaxBackups
+		if !expired && !tooMany {
+			continue
+		}
+		if err := os.Remove(b.path); err != nil {
+			klog.Errorf("Failed to remove rotated file %s: %v", b.path, err)
+		}
+	}
+}
+
+type backupFile struct {
+	path string
+	time time.Time
+}
+
+// backups returns the rotated files, newest first.
+func (f *RotatingFile) backups() ([]backupFile, error) {
+	prefix, ext := f.prefixAndExt()
+	files, err := ioutil.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []backupFile
+	for _, fi := range files {
+		path := filepath.Join(filepath.Dir(f.path), fi.Name())
+		ts := strings.TrimPrefix(strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ext), prefix)
+		if !strings.HasPrefix(path, prefix) || ts == path {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, ts)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: path, time: t})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.After(backups[j].time)
+	})
+	return backups, nil
+}
+
+// compressFile replaces the file at path with a gzipped copy ending in ".gz".
+func compressFile(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(path + ".gz")
+		}
+	}()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/klog/v2"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/filters"
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+// SamplingConfig selects the requests logged by WithSampledLogging.
+type SamplingConfig struct {
+	// EveryN logs every Nth request.
+	EveryN uint64
+	// Probability logs each request with the given probability.
+	Probability float64
+}
+
+// Enabled returns whether any request is sampled.
+func (c SamplingConfig) Enabled() bool {
+	return c.EveryN > 0 || c.Probability > 0
+}
+
+// Validate returns an error if the configuration is invalid.
+func (c SamplingConfig) Validate() error {
+	if c.EveryN > 0 && c.Probability > 0 {
+		return fmt.Errorf("sampling every Nth request and by probability are mutually exclusive")
+	}
+	if c.Probability < 0 || c.Probability > 1 {
+		return fmt.Errorf("sampling probability must be between 0 and 1, got %v", c.Probability)
+	}
+	return nil
+}
+
+type sampler struct {
+	cfg SamplingConfig
+	n   uint64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (s *sampler) sample() bool {
+	if s.cfg.EveryN > 0 {
+		return atomic.AddUint64(&s.n, 1)%s.cfg.EveryN == 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64() < s.cfg.Probability
+}
+
+// WithSampledLogging logs the metadata of sampled requests at info level,
+// including the user and the authorization attributes derived for them.
+func WithSampledLogging(handler http.Handler, cfg SamplingConfig) http.Handler {
+	if !cfg.Enabled() {
+		return handler
+	}
+
+	s := &sampler{cfg: cfg, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !s.sample() {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		ctx, info := requestinfo.WithInfo(req.Context())
+		rw := filters.NewStatusRecorder(w)
+		start := time.Now()
+
+		handler.ServeHTTP(rw, req.WithContext(ctx))
+
+		klog.InfoS("Sampled request",
+			"requestID", info.ID,
+			"route", info.Route,
+			"method", req.Method,
+			"host", req.Host,
+			"path", req.URL.Path,
+			"proto", req.Proto,
+			"remoteAddr", req.RemoteAddr,
+			"userAgent", req.UserAgent(),
+			"status", rw.StatusCode(),
+			"duration", time.Since(start),
+			"user", userName(info),
+			"groups", userGroups(info),
+			"attributes", FormatAttributes(info.Attributes),
+			"decision", formatDecision(info),
+			"reason", info.Reason,
+		)
+	})
+}
+
+func userName(info *requestinfo.Info) string {
+	if info.User == nil {
+		return ""
+	}
+	return info.User.GetName()
+}
+
+func userGroups(info *requestinfo.Info) []string {
+	if info.User == nil {
+		return nil
+	}
+	return info.User.GetGroups()
+}
+
+func formatDecision(info *requestinfo.Info) string {
+	if len(info.Attributes) == 0 {
+		return ""
+	}
+	if info.Decision == authorizer.DecisionAllow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// FormatAttributes formats attributes like the kube-apiserver's audit object references,
+// e.g. "get namespaces/monitoring/services/prometheus/proxy" or "get /metrics".
+func FormatAttributes(attrs []authorizer.Attributes) []string {
+	formatted := make([]string, 0, len(attrs))
+	for _, a := range attrs {
+		if !a.IsResourceRequest() {
+			formatted = append(formatted, fmt.Sprintf("%s %s", a.GetVerb(), a.GetPath()))
+			continue
+		}
+
+		var parts []string
+		if a.GetNamespace() != "" {
+			parts = append(parts, "namespaces", a.GetNamespace())
+		}
+		resource := a.GetResource()
+		if a.GetAPIGroup() != "" {
+			resource += "." + a.GetAPIGroup()
+		}
+		parts = append(parts, resource)
+		if a.GetName() != "" {
+			parts = append(parts, a.GetName())
+		}
+		if a.GetSubresource() != "" {
+			parts = append(parts, a.GetSubresource())
+		}
+		formatted = append(formatted, fmt.Sprintf("%s %s", a.GetVerb(), strings.Join(parts, "/")))
+	}
+	return formatted
+}
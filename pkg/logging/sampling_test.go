@@ -0,0 +1,82 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+func TestSampler(t *testing.T) {
+	s := &sampler{cfg: SamplingConfig{EveryN: 3}}
+	var sampled int
+	for i := 0; i < 9; i++ {
+		if s.sample() {
+			sampled++
+		}
+	}
+	if sampled != 3 {
+		t.Errorf("want every 3rd of 9 requests sampled, got %d", sampled)
+	}
+
+	s = &sampler{cfg: SamplingConfig{Probability: 0.5}, rnd: rand.New(rand.NewSource(1))}
+	sampled = 0
+	for i := 0; i < 1000; i++ {
+		if s.sample() {
+			sampled++
+		}
+	}
+	if sampled < 400 || sampled > 600 {
+		t.Errorf("want about half of 1000 requests sampled, got %d", sampled)
+	}
+
+	if err := (SamplingConfig{EveryN: 2, Probability: 0.5}).Validate(); err == nil {
+		t.Error("expected error for both sampling strategies, got nil")
+	}
+}
+
+func TestFormatAttributes(t *testing.T) {
+	got := FormatAttributes([]authorizer.Attributes{
+		authorizer.AttributesRecord{
+			Verb:            "get",
+			Namespace:       "monitoring",
+			Resource:        "services",
+			Subresource:     "proxy",
+			Name:            "prometheus",
+			ResourceRequest: true,
+		},
+		authorizer.AttributesRecord{
+			Verb:            "list",
+			APIGroup:        "apps",
+			Resource:        "deployments",
+			ResourceRequest: true,
+		},
+		authorizer.AttributesRecord{Verb: "get", Path: "/metrics"},
+	})
+
+	want := []string{
+		"get namespaces/monitoring/services/prometheus/proxy",
+		"list deployments.apps",
+		"get /metrics",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/filters"
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+// WithSlowRequestLogging logs a warning for requests taking longer than
+// threshold, with the time spent authenticating, authorizing and waiting
+// for the upstream. A threshold of 0 disables logging.
+func WithSlowRequestLogging(handler http.Handler, threshold time.Duration) http.Handler {
+	if threshold <= 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, info := requestinfo.WithInfo(req.Context())
+		rw := filters.NewStatusRecorder(w)
+		start := time.Now()
+
+		handler.ServeHTTP(rw, req.WithContext(ctx))
+
+		duration := time.Since(start)
+		if duration < threshold {
+			return
+		}
+
+		klog.Warningf("Slow request: %s", formatKeysAndValues(
+			"requestID", info.ID,
+			"route", info.Route,
+			"method", req.Method,
+			"host", req.Host,
+			"path", req.URL.Path,
+			"status", rw.StatusCode(),
+			"duration", duration,
+			"cause", slowPhase(info, duration),
+			"authentication", info.AuthenticationDuration,
+			"authorization", info.AuthorizationDuration,
+			"upstream", info.UpstreamDuration,
+			"user", userName(info),
+			"attributes", FormatAttributes(info.Attributes),
+			"decision", formatDecision(info),
+		))
+	})
+}
+
+// slowPhase returns the phase of the request which took the longest:
+// "authentication", "authorization", "upstream" until the response headers
+// were received, or "response" for the remaining time, mostly spent
+// streaming the response body.
+func slowPhase(info *requestinfo.Info, duration time.Duration) string {
+	phase, longest := "response", duration-info.AuthenticationDuration-info.AuthorizationDuration-info.UpstreamDuration
+	for _, p := range []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"authentication", info.AuthenticationDuration},
+		{"authorization", info.AuthorizationDuration},
+		{"upstream", info.UpstreamDuration},
+	} {
+		if p.duration > longest {
+			phase, longest = p.name, p.duration
+		}
+	}
+	return phase
+}
+
+// formatKeysAndValues formats key value pairs like klog.InfoS.
+func formatKeysAndValues(keysAndValues ...interface{}) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		switch v := keysAndValues[i+1].(type) {
+		case string, []string:
+			fmt.Fprintf(&b, "%s=%q", keysAndValues[i], v)
+		default:
+			fmt.Fprintf(&b, "%s=%v", keysAndValues[i], v)
+		}
+	}
+	return b.String()
+}
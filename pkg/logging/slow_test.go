@@ -0,0 +1,66 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+func TestSlowPhase(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		info     requestinfo.Info
+		duration time.Duration
+		want     string
+	}{
+		{
+			name:     "authorization",
+			info:     requestinfo.Info{AuthenticationDuration: time.Millisecond, AuthorizationDuration: 2 * time.Second, UpstreamDuration: 100 * time.Millisecond},
+			duration: 2200 * time.Millisecond,
+			want:     "authorization",
+		},
+		{
+			name:     "upstream",
+			info:     requestinfo.Info{AuthenticationDuration: 10 * time.Millisecond, AuthorizationDuration: 10 * time.Millisecond, UpstreamDuration: 3 * time.Second},
+			duration: 3100 * time.Millisecond,
+			want:     "upstream",
+		},
+		{
+			name:     "streaming response",
+			info:     requestinfo.Info{AuthenticationDuration: 10 * time.Millisecond, UpstreamDuration: 100 * time.Millisecond},
+			duration: 5 * time.Second,
+			want:     "response",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := slowPhase(&tc.info, tc.duration); got != tc.want {
+				t.Errorf("want phase %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFormatKeysAndValues(t *testing.T) {
+	got := formatKeysAndValues("path", "/metrics", "status", 200, "attributes", []string{"get /metrics"})
+	want := `path="/metrics" status=200 attributes=["get /metrics"]`
+	if got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Verbosity changes the klog verbosity at runtime.
+type Verbosity struct {
+	mu       sync.Mutex
+	v        flag.Value
+	base     string
+	elevated string
+}
+
+// NewVerbosity returns a Verbosity changing the "v" flag of the flag set klog
+// was initialized with. Toggling switches between the verbosity at the time of
+// the call and elevated.
+func NewVerbosity(klogFlags *flag.FlagSet, elevated int) (*Verbosity, error) {
+	f := klogFlags.Lookup("v")
+	if f == nil {
+		return nil, fmt.Errorf("flag set has no verbosity flag")
+	}
+
+	return &Verbosity{
+		v:        f.Value,
+		base:     f.Value.String(),
+		elevated: strconv.Itoa(elevated),
+	}, nil
+}
+
+// Set sets the verbosity to level.
+func (v *Verbosity) Set(level string) (string, error) {
+	level = strings.TrimSpace(level)
+	if _, err := strconv.ParseUint(level, 10, 32); err != nil {
+		return "", fmt.Errorf("invalid verbosity %q", level)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.v.Set(level); err != nil {
+		return "", fmt.Errorf("failed to set verbosity: %v", err)
+	}
+	return fmt.Sprintf("successfully set verbosity to %s", level), nil
+}
+
+// Toggle switches to the elevated verbosity, or back to the base verbosity if
+// the elevated verbosity is set. It returns the new verbosity.
+func (v *Verbosity) Toggle() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	level := v.elevated
+	if v.v.String() == v.elevated {
+		level = v.base
+	}
+	// Both levels were validated by their flags.
+	_ = v.v.Set(level)
+	return level
+}
+
+// Level returns the current verbosity.
+func (v *Verbosity) Level() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v.String()
+}
+
+// ServeHTTP returns the verbosity on GET and sets it to the request body on PUT.
+func (v *Verbosity) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	switch req.Method {
+	case http.MethodGet:
+		fmt.Fprintln(w, v.Level())
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 64))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error reading request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		msg, err := v.Set(string(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, msg)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "unsupported http method", http.StatusMethodNotAllowed)
+	}
+}
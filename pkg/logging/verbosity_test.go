@@ -0,0 +1,65 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/klog/v2"
+)
+
+func TestVerbosity(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	if err := fs.Set("v", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVerbosity(fs, 5)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	if got := v.Toggle(); got != "5" || !klog.V(5).Enabled() {
+		t.Errorf("want toggle to elevate verbosity to 5, got %s", got)
+	}
+	if got := v.Toggle(); got != "2" || klog.V(3).Enabled() {
+		t.Errorf("want toggle to restore verbosity 2, got %s", got)
+	}
+
+	rec := httptest.NewRecorder()
+	v.ServeHTTP(rec, httptest.NewRequest("PUT", "/debug/flags/v", strings.NewReader("4\n")))
+	if rec.Code != http.StatusOK || v.Level() != "4" {
+		t.Errorf("want verbosity 4 after PUT, got %s (status %d)", v.Level(), rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	v.ServeHTTP(rec, httptest.NewRequest("PUT", "/debug/flags/v", strings.NewReader("foo")))
+	if rec.Code != http.StatusBadRequest || v.Level() != "4" {
+		t.Errorf("want invalid verbosity to be rejected, got %s (status %d)", v.Level(), rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	v.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/flags/v", nil))
+	if got := strings.TrimSpace(rec.Body.String()); got != "4" {
+		t.Errorf("want GET to return verbosity 4, got %q", got)
+	}
+}
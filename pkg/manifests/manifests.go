@@ -0,0 +1,289 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package manifests generates the Kubernetes manifests to run kube-rbac-proxy
+// as a sidecar: its ServiceAccount, the RBAC objects granting what it needs,
+// and a patch adding its container to a Deployment.
+package manifests
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ghodss/yaml"
+)
+
+// ConfigFileDir is the directory the config file is mounted to.
+const ConfigFileDir = "/etc/kube-rbac-proxy"
+
+// Config parameterizes the generated manifests.
+type Config struct {
+	// Name names the ServiceAccount, the RBAC objects and the container.
+	Name string
+	// Namespace is the namespace of the workload and its ServiceAccount.
+	Namespace string
+	// Image is the image of the kube-rbac-proxy container.
+	Image string
+	// Args are the arguments of the kube-rbac-proxy container.
+	Args []string
+	// Port is the port of the secure listener, it is omitted if zero.
+	Port int32
+	// ConfigFileName and ConfigFile are the name and content of the config
+	// file. If set, it is put into a ConfigMap mounted to ConfigFileDir.
+	ConfigFileName string
+	ConfigFile     []byte
+	// Rules are the namespaced permissions kube-rbac-proxy needs besides
+	// creating TokenReviews and SubjectAccessReviews.
+	Rules []Rule
+}
+
+// Rule grants verbs on resources in a namespace.
+type Rule struct {
+	Namespace     string
+	APIGroup      string
+	Resource      string
+	ResourceNames []string
+	Verbs         []string
+}
+
+type object struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   metadata          `json:"metadata"`
+	Rules      []policyRule      `json:"rules,omitempty"`
+	RoleRef    *roleRef          `json:"roleRef,omitempty"`
+	Subjects   []subject         `json:"subjects,omitempty"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+type metadata struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type policyRule struct {
+	APIGroups     []string `json:"apiGroups"`
+	Resources     []string `json:"resources"`
+	ResourceNames []string `json:"resourceNames,omitempty"`
+	Verbs         []string `json:"verbs"`
+}
+
+type roleRef struct {
+	APIGroup string `json:"apiGroup"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+}
+
+type subject struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// Manifests returns the ServiceAccount, the ClusterRole and
+// ClusterRoleBinding allowing to create TokenReviews and
+// SubjectAccessReviews, a Role and RoleBinding per namespace of cfg.Rules,
+// and the ConfigMap holding the config file, as a multi-document YAML.
+func Manifests(cfg Config) ([]byte, error) {
+	sa := subject{Kind: "ServiceAccount", Name: cfg.Name, Namespace: cfg.Namespace}
+	objects := []object{
+		{
+			APIVersion: "v1",
+			Kind:       "ServiceAccount",
+			Metadata:   metadata{Name: cfg.Name, Namespace: cfg.Namespace},
+		},
+		{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "ClusterRole",
+			Metadata:   metadata{Name: cfg.Name},
+			Rules: []policyRule{
+				{APIGroups: []string{"authentication.k8s.io"}, Resources: []string{"tokenreviews"}, Verbs: []string{"create"}},
+				{APIGroups: []string{"authorization.k8s.io"}, Resources: []string{"subjectaccessreviews"}, Verbs: []string{"create"}},
+			},
+		},
+		{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "ClusterRoleBinding",
+			Metadata:   metadata{Name: cfg.Name},
+			RoleRef:    &roleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: cfg.Name},
+			Subjects:   []subject{sa},
+		},
+	}
+
+	rules := map[string][]policyRule{}
+	for _, r := range cfg.Rules {
+		rules[r.Namespace] = append(rules[r.Namespace], policyRule{
+			APIGroups:     []string{r.APIGroup},
+			Resources:     []string{r.Resource},
+			ResourceNames: r.ResourceNames,
+			Verbs:         r.Verbs,
+		})
+	}
+	namespaces := make([]string, 0, len(rules))
+	for ns := range rules {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		meta := metadata{Name: cfg.Name, Namespace: ns}
+		objects = append(objects,
+			object{
+				APIVersion: "rbac.authorization.k8s.io/v1",
+				Kind:       "Role",
+				Metadata:   meta,
+				Rules:      rules[ns],
+			},
+			object{
+				APIVersion: "rbac.authorization.k8s.io/v1",
+				Kind:       "RoleBinding",
+				Metadata:   meta,
+				RoleRef:    &roleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: cfg.Name},
+				Subjects:   []subject{sa},
+			},
+		)
+	}
+
+	if cfg.ConfigFileName != "" {
+		objects = append(objects, object{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Metadata:   metadata{Name: configMapName(cfg), Namespace: cfg.Namespace},
+			Data:       map[string]string{cfg.ConfigFileName: string(cfg.ConfigFile)},
+		})
+	}
+
+	var buf bytes.Buffer
+	for i, o := range objects {
+		b, err := yaml.Marshal(o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s %s: %v", o.Kind, o.Metadata.Name, err)
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+type deploymentPatch struct {
+	Spec struct {
+		Template struct {
+			Spec podSpec `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+type podSpec struct {
+	ServiceAccountName string      `json:"serviceAccountName"`
+	Containers         []container `json:"containers"`
+	Volumes            []volume    `json:"volumes,omitempty"`
+}
+
+type container struct {
+	Name            string          `json:"name"`
+	Image           string          `json:"image"`
+	Args            []string        `json:"args,omitempty"`
+	Env             []envVar        `json:"env"`
+	Ports           []port          `json:"ports,omitempty"`
+	VolumeMounts    []volumeMount   `json:"volumeMounts,omitempty"`
+	SecurityContext securityContext `json:"securityContext"`
+}
+
+type envVar struct {
+	Name      string `json:"name"`
+	ValueFrom struct {
+		FieldRef struct {
+			FieldPath string `json:"fieldPath"`
+		} `json:"fieldRef"`
+	} `json:"valueFrom"`
+}
+
+type port struct {
+	Name          string `json:"name"`
+	ContainerPort int32  `json:"containerPort"`
+}
+
+type volumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly"`
+}
+
+type securityContext struct {
+	AllowPrivilegeEscalation bool  `json:"allowPrivilegeEscalation"`
+	ReadOnlyRootFilesystem   bool  `json:"readOnlyRootFilesystem"`
+	RunAsNonRoot             bool  `json:"runAsNonRoot"`
+	RunAsUser                int64 `json:"runAsUser"`
+}
+
+type volume struct {
+	Name      string `json:"name"`
+	ConfigMap struct {
+		Name string `json:"name"`
+	} `json:"configMap"`
+}
+
+// DeploymentPatch returns a strategic merge patch adding the kube-rbac-proxy
+// container to a Deployment, e.g. to be applied with
+// `kubectl patch deployment <name> --patch-file <file>`.
+func DeploymentPatch(cfg Config) ([]byte, error) {
+	c := container{
+		Name:  cfg.Name,
+		Image: cfg.Image,
+		Args:  cfg.Args,
+		SecurityContext: securityContext{
+			ReadOnlyRootFilesystem: true,
+			RunAsNonRoot:           true,
+			RunAsUser:              65532,
+		},
+	}
+	// The pod's name and namespace are used by the config file's field
+	// references and by failure events.
+	for name, fieldPath := range map[string]string{
+		"POD_NAME":      "metadata.name",
+		"POD_NAMESPACE": "metadata.namespace",
+	} {
+		e := envVar{Name: name}
+		e.ValueFrom.FieldRef.FieldPath = fieldPath
+		c.Env = append(c.Env, e)
+	}
+	sort.Slice(c.Env, func(i, j int) bool { return c.Env[i].Name < c.Env[j].Name })
+	if cfg.Port != 0 {
+		c.Ports = []port{{Name: "https", ContainerPort: cfg.Port}}
+	}
+
+	var p deploymentPatch
+	p.Spec.Template.Spec.ServiceAccountName = cfg.Name
+	if cfg.ConfigFileName != "" {
+		c.VolumeMounts = []volumeMount{{Name: configMapName(cfg), MountPath: ConfigFileDir, ReadOnly: true}}
+		v := volume{Name: configMapName(cfg)}
+		v.ConfigMap.Name = configMapName(cfg)
+		p.Spec.Template.Spec.Volumes = []volume{v}
+	}
+	p.Spec.Template.Spec.Containers = []container{c}
+
+	b, err := yaml.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal deployment patch: %v", err)
+	}
+	return b, nil
+}
+
+func configMapName(cfg Config) string {
+	return cfg.Name + "-config"
+}
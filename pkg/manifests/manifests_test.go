@@ -0,0 +1,95 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+func TestManifests(t *testing.T) {
+	cfg := Config{
+		Name:           "proxy",
+		Namespace:      "monitoring",
+		ConfigFileName: "config.yaml",
+		ConfigFile:     []byte("authorization: {}\n"),
+		Rules: []Rule{
+			{Namespace: "kube-system", Resource: "configmaps", ResourceNames: []string{"config"}, Verbs: []string{"get"}},
+			{Namespace: "monitoring", Resource: "secrets", ResourceNames: []string{"tls"}, Verbs: []string{"get"}},
+			{Namespace: "kube-system", Resource: "configmaps", Verbs: []string{"create"}},
+		},
+	}
+	b, err := Manifests(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []string
+	for _, doc := range strings.Split(string(b), "---\n") {
+		var o object
+		if err := yaml.Unmarshal([]byte(doc), &o); err != nil {
+			t.Fatalf("failed to unmarshal %q: %v", doc, err)
+		}
+		kinds = append(kinds, o.Kind+" "+o.Metadata.Namespace+"/"+o.Metadata.Name)
+		if o.Kind == "Role" && o.Metadata.Namespace == "kube-system" && len(o.Rules) != 2 {
+			t.Errorf("want 2 rules in the kube-system Role, got %d", len(o.Rules))
+		}
+	}
+	want := []string{
+		"ServiceAccount monitoring/proxy",
+		"ClusterRole /proxy",
+		"ClusterRoleBinding /proxy",
+		"Role kube-system/proxy",
+		"RoleBinding kube-system/proxy",
+		"Role monitoring/proxy",
+		"RoleBinding monitoring/proxy",
+		"ConfigMap monitoring/proxy-config",
+	}
+	if strings.Join(kinds, ",") != strings.Join(want, ",") {
+		t.Errorf("want objects %v, got %v", want, kinds)
+	}
+}
+
+func TestDeploymentPatch(t *testing.T) {
+	b, err := DeploymentPatch(Config{
+		Name:           "proxy",
+		Image:          "example.com/proxy:latest",
+		Args:           []string{"--config-file=/etc/kube-rbac-proxy/config.yaml"},
+		Port:           8443,
+		ConfigFileName: "config.yaml",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p deploymentPatch
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		t.Fatal(err)
+	}
+	spec := p.Spec.Template.Spec
+	if spec.ServiceAccountName != "proxy" {
+		t.Errorf("want service account proxy, got %q", spec.ServiceAccountName)
+	}
+	if len(spec.Containers) != 1 || spec.Containers[0].Ports[0].ContainerPort != 8443 {
+		t.Fatalf("want one container with port 8443, got %+v", spec.Containers)
+	}
+	if len(spec.Volumes) != 1 || spec.Volumes[0].ConfigMap.Name != "proxy-config" {
+		t.Errorf("want config file volume from proxy-config, got %+v", spec.Volumes)
+	}
+}
@@ -0,0 +1,36 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/version"
+)
+
+// BuildInfo is always 1, its labels identify the running build.
+var BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "build_info",
+	Help:      "A metric with a constant '1' value labeled by the version, revision, build date and Go version kube-rbac-proxy was built with.",
+}, []string{"version", "revision", "build_date", "goversion"})
+
+func init() {
+	info := version.Get()
+	BuildInfo.WithLabelValues(info.Version, info.Revision, info.BuildDate, info.GoVersion).Set(1)
+	Registry.MustRegister(BuildInfo)
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ClientConnections tracks the connections of clients to the proxy.
+	ClientConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "client_connections",
+		Help:      "Number of open client connections, by listener and state (new, active or idle). Hijacked connections are tracked separately.",
+	}, []string{"listener", "state"})
+
+	// HijackedConnections tracks client connections taken over by a
+	// handler, e.g. for WebSockets or other upgraded protocols.
+	HijackedConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "hijacked_connections",
+		Help:      "Number of open client connections upgraded to another protocol, like WebSockets.",
+	})
+
+	// UpstreamConnections tracks the connections of the proxy to upstreams.
+	UpstreamConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_connections",
+		Help:      "Number of open upstream connections, by state: active if serving a request, idle if waiting in the connection pool. HTTP/2 connections are always active.",
+	}, []string{"state"})
+)
+
+func init() {
+	Registry.MustRegister(
+		ClientConnections,
+		HijackedConnections,
+		UpstreamConnections,
+	)
+}
+
+// TrackClientConnections returns a function for http.Server.ConnState, which
+// tracks the connections of the given listener in ClientConnections.
+func TrackClientConnections(listener string) func(net.Conn, http.ConnState) {
+	var (
+		mu     sync.Mutex
+		states = map[net.Conn]http.ConnState{}
+	)
+
+	return func(conn net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if prev, ok := states[conn]; ok {
+			ClientConnections.WithLabelValues(listener, prev.String()).Dec()
+		}
+
+		switch state {
+		case http.StateNew, http.StateActive, http.StateIdle:
+			states[conn] = state
+			ClientConnections.WithLabelValues(listener, state.String()).Inc()
+		default:
+			delete(states, conn)
+		}
+	}
+}
+
+// upstreamConn tracks the state of an upstream connection in UpstreamConnections.
+type upstreamConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	state  string
+	closed bool
+}
+
+// TrackUpstreamConn tracks conn in UpstreamConnections until it is closed.
+// New connections are active, as they are dialed to serve a request.
+func TrackUpstreamConn(conn net.Conn) net.Conn {
+	UpstreamConnections.WithLabelValues("active").Inc()
+	return &upstreamConn{Conn: conn, state: "active"}
+}
+
+// TrackUpstreamDial tracks the connections dial returns, see TrackUpstreamConn.
+func TrackUpstreamDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return TrackUpstreamConn(conn), nil
+	}
+}
+
+func (c *upstreamConn) setState(state string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.state == state {
+		return
+	}
+	UpstreamConnections.WithLabelValues(c.state).Dec()
+	UpstreamConnections.WithLabelValues(state).Inc()
+	c.state = state
+}
+
+func (c *upstreamConn) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		UpstreamConnections.WithLabelValues(c.state).Dec()
+	}
+	c.mu.Unlock()
+
+	return c.Conn.Close()
+}
+
+// InstrumentUpstreamConnections traces the requests of rt, to track whether
+// the connections dialed with TrackUpstreamDial are active or idle.
+func InstrumentUpstreamConnections(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var conn *upstreamConn
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				conn = unwrapUpstreamConn(info.Conn)
+				if conn != nil {
+					conn.setState("active")
+				}
+			},
+			PutIdleConn: func(err error) {
+				if conn != nil && err == nil {
+					conn.setState("idle")
+				}
+			},
+		}
+		return rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	})
+}
+
+func unwrapUpstreamConn(conn net.Conn) *upstreamConn {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	c, _ := conn.(*upstreamConn)
+	return c
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
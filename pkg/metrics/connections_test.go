@@ -0,0 +1,91 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestTrackClientConnections(t *testing.T) {
+	track := TrackClientConnections("test")
+	c1, c2 := &net.TCPConn{}, &net.TCPConn{}
+
+	track(c1, http.StateNew)
+	track(c2, http.StateNew)
+	track(c1, http.StateActive)
+	track(c2, http.StateActive)
+	track(c2, http.StateIdle)
+	track(c1, http.StateHijacked)
+
+	for state, want := range map[string]float64{"new": 0, "active": 0, "idle": 1} {
+		if got := testutil.ToFloat64(ClientConnections.WithLabelValues("test", state)); got != want {
+			t.Errorf("want %v %s connections, got %v", want, state, got)
+		}
+	}
+
+	track(c2, http.StateClosed)
+	if got := testutil.ToFloat64(ClientConnections.WithLabelValues("test", "idle")); got != 0 {
+		t.Errorf("want no idle connections after close, got %v", got)
+	}
+}
+
+func TestUpstreamConnections(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	transport := &http.Transport{DialContext: TrackUpstreamDial((&net.Dialer{}).DialContext)}
+	client := &http.Client{Transport: InstrumentUpstreamConnections(transport)}
+
+	active := UpstreamConnections.WithLabelValues("active")
+	idle := UpstreamConnections.WithLabelValues("idle")
+	activeBefore, idleBefore := testutil.ToFloat64(active), testutil.ToFloat64(idle)
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(active) - activeBefore; got != 1 {
+		t.Errorf("want 1 active connection while reading the response, got %v", got)
+	}
+	_, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The connection is returned to the pool asynchronously.
+	if err := wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return testutil.ToFloat64(idle)-idleBefore == 1, nil
+	}); err != nil {
+		t.Errorf("want 1 idle connection after the response, got %v", testutil.ToFloat64(idle)-idleBefore)
+	}
+	if got := testutil.ToFloat64(active) - activeBefore; got != 0 {
+		t.Errorf("want no active connection after the response, got %v", got)
+	}
+
+	transport.CloseIdleConnections()
+	if got := testutil.ToFloat64(idle) - idleBefore; got != 0 {
+		t.Errorf("want no idle connection after closing them, got %v", got)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+)
+
+const (
+	// APITokenReview labels metrics of TokenReview calls.
+	APITokenReview = "tokenreview"
+	// APISubjectAccessReview labels metrics of SubjectAccessReview calls.
+	APISubjectAccessReview = "subjectaccessreview"
+)
+
+var (
+	// DelegatedRequestDuration observes the latency of calls delegated to the kube-apiserver.
+	DelegatedRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "delegated_request_duration_seconds",
+		Help:      "Latency of TokenReview and SubjectAccessReview requests to the kube-apiserver.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"route", "api"})
+
+	// DelegatedRequestErrors counts failed calls delegated to the kube-apiserver.
+	DelegatedRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "delegated_request_errors_total",
+		Help:      "Total number of failed TokenReview and SubjectAccessReview requests to the kube-apiserver.",
+	}, []string{"route", "api"})
+
+	// DelegatedDecisions counts authentication and authorization decisions by
+	// whether they were answered from the cache.
+	DelegatedDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "delegated_decisions_total",
+		Help:      "Total number of delegated authentication and authorization decisions, by decision and whether the kube-apiserver was asked (miss) or the cache answered (hit).",
+	}, []string{"route", "api", "decision", "cache"})
+
+	// TokenCacheEntries tracks the number of cached TokenReview results.
+	TokenCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "token_cache_entries",
+		Help:      "Number of TokenReview results in the token caches.",
+	})
+
+	// TokenCacheEvictions counts TokenReview results removed from the cache.
+	TokenCacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_cache_evictions_total",
+		Help:      "Total number of TokenReview results removed from the token caches, by reason (capacity or expired).",
+	}, []string{"reason"})
+
+	// AuthorizationCacheEntries tracks the number of cached authorization
+	// decisions.
+	AuthorizationCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "authorization_cache_entries",
+		Help:      "Number of SubjectAccessReview results in the authorization cache.",
+	})
+
+	// AuthorizationCacheEvictions counts authorization decisions removed
+	// from the cache.
+	AuthorizationCacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "authorization_cache_evictions_total",
+		Help:      "Total number of SubjectAccessReview results removed from the authorization cache, by reason (capacity or expired).",
+	}, []string{"reason"})
+
+	// CacheLockWait counts the time spent waiting for the locks of cache
+	// shards.
+	CacheLockWait = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lock_wait_seconds_total",
+		Help:      "Total time spent waiting for the locks of the token and authorization cache shards, by cache.",
+	}, []string{"cache"})
+
+	// LatencyBudgetExceeded counts requests failed because authentication or
+	// authorization took longer than their latency budget.
+	LatencyBudgetExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "latency_budget_exceeded_total",
+		Help:      "Total number of requests failed because authentication or authorization exceeded the latency budget, by stage.",
+	}, []string{"route", "stage"})
+)
+
+func init() {
+	Registry.MustRegister(
+		DelegatedRequestDuration,
+		DelegatedRequestErrors,
+		DelegatedDecisions,
+		TokenCacheEntries,
+		TokenCacheEvictions,
+		AuthorizationCacheEntries,
+		AuthorizationCacheEvictions,
+		CacheLockWait,
+		LatencyBudgetExceeded,
+	)
+}
+
+// NewKubeAPIServerUnreachable returns a gauge which is 1 while unreachable
+// returns true, and 0 otherwise.
+func NewKubeAPIServerUnreachable(unreachable func() bool) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kube_apiserver_unreachable",
+		Help:      "Whether TokenReview and SubjectAccessReview requests failed to reach the kube-apiserver for longer than the configured window.",
+	}, func() float64 {
+		if unreachable() {
+			return 1
+		}
+		return 0
+	})
+}
+
+// delegatedRequestObservers are called with the result of every request to
+// the kube-apiserver.
+var delegatedRequestObservers []func(api string, err error)
+
+// OnDelegatedRequest registers f to be called with the result of every
+// request to the kube-apiserver. It must be called before any request is made.
+func OnDelegatedRequest(f func(api string, err error)) {
+	delegatedRequestObservers = append(delegatedRequestObservers, f)
+}
+
+// Route returns the route of the request made with ctx, or an empty string
+// if it isn't known.
+func Route(ctx context.Context) string {
+	if info := requestinfo.From(ctx); info != nil {
+		return info.Route
+	}
+	return ""
+}
+
+type delegatedCallsKey struct{}
+
+// DelegatedCalls tracks whether calls were delegated to the kube-apiserver
+// while making a decision.
+type DelegatedCalls struct {
+	n int32
+}
+
+// WithDelegatedCalls returns a context tracking delegated calls made with it.
+func WithDelegatedCalls(ctx context.Context) (context.Context, *DelegatedCalls) {
+	calls := &DelegatedCalls{}
+	return context.WithValue(ctx, delegatedCallsKey{}, calls), calls
+}
+
+// CacheResult returns "miss" if a call was delegated, "hit" otherwise.
+func (c *DelegatedCalls) CacheResult() string {
+	if atomic.LoadInt32(&c.n) > 0 {
+		return "miss"
+	}
+	return "hit"
+}
+
+// ObserveDelegatedRequest records a request to the kube-apiserver made with
+// the given context and started at start.
+func ObserveDelegatedRequest(ctx context.Context, api string, start time.Time, err error) {
+	route := Route(ctx)
+	observe(ctx, DelegatedRequestDuration.WithLabelValues(route, api), time.Since(start).Seconds())
+	if err != nil {
+		DelegatedRequestErrors.WithLabelValues(route, api).Inc()
+	}
+	for _, f := range delegatedRequestObservers {
+		f(api, err)
+	}
+
+	if calls, ok := ctx.Value(delegatedCallsKey{}).(*DelegatedCalls); ok {
+		atomic.AddInt32(&calls.n, 1)
+	}
+}
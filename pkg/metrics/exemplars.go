@@ -0,0 +1,90 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TraceparentHeader carries the W3C trace context of a request.
+const TraceparentHeader = "traceparent"
+
+type traceIDKey struct{}
+
+// WithTraceID returns a context whose histogram observations carry an
+// exemplar referencing the given trace.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFrom returns the trace ID stored in ctx, if any.
+func TraceIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// TraceIDFromHeader returns the trace ID of a valid W3C traceparent header,
+// e.g. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01". Requests
+// which aren't part of a sampled trace return an empty string, as no
+// backend would have recorded the trace.
+func TraceIDFromHeader(h http.Header) string {
+	parts := strings.Split(strings.TrimSpace(h.Get(TraceparentHeader)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return ""
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return ""
+	}
+	traceID, parentID, flags := parts[1], parts[2], parts[3]
+	if len(traceID) != 32 || len(parentID) != 16 || len(flags) != 2 {
+		return ""
+	}
+	if !isLowerHex(traceID) || !isLowerHex(parentID) || traceID == strings.Repeat("0", 32) {
+		return ""
+	}
+	f, err := hex.DecodeString(flags)
+	if err != nil || f[0]&0x01 == 0 {
+		return ""
+	}
+	return traceID
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// observe records v in o, attaching the trace ID of ctx as exemplar if
+// there is one.
+func observe(ctx context.Context, o prometheus.Observer, v float64) {
+	if id := TraceIDFrom(ctx); id != "" {
+		if eo, ok := o.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": id})
+			return
+		}
+	}
+	o.Observe(v)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+const (
+	// ServiceAccountNone labels requests which weren't made by an
+	// authenticated service account.
+	ServiceAccountNone = "none"
+	// ServiceAccountOther labels requests of service accounts seen after
+	// the cardinality limit was reached.
+	ServiceAccountOther = "other"
+)
+
+// ServiceAccountRequests counts requests by the service account making them.
+var ServiceAccountRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "service_account_requests_total",
+	Help:      "Total number of requests by the authenticated service account and status code. Service accounts beyond the configured limit are counted as \"other\".",
+}, []string{"route", "service_account", "code"})
+
+func init() {
+	Registry.MustRegister(ServiceAccountRequests)
+}
+
+// ServiceAccountLabels bounds the number of distinct service accounts
+// metrics are labelled with.
+type ServiceAccountLabels struct {
+	limit int
+
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// NewServiceAccountLabels returns ServiceAccountLabels labelling at most
+// limit service accounts by name.
+func NewServiceAccountLabels(limit int) *ServiceAccountLabels {
+	return &ServiceAccountLabels{
+		limit: limit,
+		seen:  map[string]struct{}{},
+	}
+}
+
+// Label returns "<namespace>/<name>" for service accounts, as long as the
+// service account was seen before or the limit isn't reached yet. Other
+// service accounts are labelled ServiceAccountOther, other or
+// unauthenticated users ServiceAccountNone.
+func (l *ServiceAccountLabels) Label(u user.Info) string {
+	if u == nil {
+		return ServiceAccountNone
+	}
+	namespace, name, err := serviceaccount.SplitUsername(u.GetName())
+	if err != nil {
+		return ServiceAccountNone
+	}
+	label := namespace + "/" + name
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.seen[label]; ok {
+		return label
+	}
+	if len(l.seen) >= l.limit {
+		return ServiceAccountOther
+	}
+	l.seen[label] = struct{}{}
+	return label
+}
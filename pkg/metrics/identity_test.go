@@ -0,0 +1,43 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+func TestServiceAccountLabels(t *testing.T) {
+	l := NewServiceAccountLabels(2)
+
+	for _, tc := range []struct {
+		user user.Info
+		want string
+	}{
+		{user: nil, want: ServiceAccountNone},
+		{user: &user.DefaultInfo{Name: "jane"}, want: ServiceAccountNone},
+		{user: &user.DefaultInfo{Name: "system:serviceaccount:monitoring:prometheus"}, want: "monitoring/prometheus"},
+		{user: &user.DefaultInfo{Name: "system:serviceaccount:default:default"}, want: "default/default"},
+		{user: &user.DefaultInfo{Name: "system:serviceaccount:default:other"}, want: ServiceAccountOther},
+		{user: &user.DefaultInfo{Name: "system:serviceaccount:monitoring:prometheus"}, want: "monitoring/prometheus"},
+	} {
+		if got := l.Label(tc.user); got != tc.want {
+			t.Errorf("want label %q for %v, got %q", tc.want, tc.user, got)
+		}
+	}
+}
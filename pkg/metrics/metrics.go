@@ -0,0 +1,126 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "kube_rbac_proxy"
+
+// Registry holds the kube-rbac-proxy's own metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// RequestDuration observes the latency of requests served by the proxy.
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests served by the proxy, including authentication, authorization and the upstream request.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"route", "code"})
+
+	// RequestBytes counts the bytes of request bodies received from clients.
+	RequestBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "request_bytes_total",
+		Help:      "Total number of bytes of request bodies received from clients.",
+	}, []string{"route"})
+
+	// ResponseBytes counts the bytes of response bodies sent to clients.
+	ResponseBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "response_bytes_total",
+		Help:      "Total number of bytes of response bodies sent to clients.",
+	}, []string{"route"})
+
+	// ResponseSizeLimitExceeded counts upstream responses exceeding the configured size limit.
+	ResponseSizeLimitExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "response_size_limit_exceeded_total",
+		Help:      "Total number of upstream responses terminated because they exceeded the response size limit.",
+	}, []string{"route"})
+
+	// HeapWatermark tracks the largest heap observed by the watchdog.
+	HeapWatermark = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "heap_watermark_bytes",
+		Help:      "The largest size in bytes of the heap in use observed since the proxy started.",
+	})
+
+	// WatchdogThresholdExceeded counts how often resource usage exceeded the watchdog's thresholds.
+	WatchdogThresholdExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watchdog_threshold_exceeded_total",
+		Help:      "Total number of times the usage of a resource (goroutines, open_fds or heap) exceeded the watchdog's threshold.",
+	}, []string{"resource"})
+
+	// DecisionLogsSent counts authorization decisions shipped to the decision log sink.
+	DecisionLogsSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decision_logs_sent_total",
+		Help:      "Total number of authorization decisions shipped to the decision log sink.",
+	})
+
+	// DecisionLogsDropped counts authorization decisions which couldn't be shipped.
+	DecisionLogsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decision_logs_dropped_total",
+		Help:      "Total number of authorization decisions dropped, because the buffer was full or the sink failed.",
+	}, []string{"reason"})
+
+	// ConfigReloads counts reloads of the config file by their result.
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Total number of config file reloads by result (success or failure).",
+	}, []string{"result"})
+
+	// ConfigLastReloadSuccessful tracks whether the last reload of the config file succeeded.
+	ConfigLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last reload of the config file succeeded (1) or failed (0).",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		RequestDuration,
+		RequestBytes,
+		ResponseBytes,
+		ResponseSizeLimitExceeded,
+		DecisionLogsSent,
+		DecisionLogsDropped,
+		HeapWatermark,
+		WatchdogThresholdExceeded,
+		ConfigReloads,
+		ConfigLastReloadSuccessful,
+	)
+}
+
+// ObserveRequest records a request of the given route made with ctx, which
+// was answered with code and started at start.
+func ObserveRequest(ctx context.Context, route string, code int, start time.Time) {
+	observe(ctx, RequestDuration.WithLabelValues(route, strconv.Itoa(code)), time.Since(start).Seconds())
+}
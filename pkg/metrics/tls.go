@@ -0,0 +1,244 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TLSHandshakeErrors counts failed TLS handshakes with clients.
+var TLSHandshakeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "tls_handshake_errors_total",
+	Help:      "Total number of failed TLS handshakes with clients, by reason.",
+}, []string{"reason"})
+
+func init() {
+	Registry.MustRegister(TLSHandshakeErrors)
+}
+
+const handshakeErrorPrefix = "http: TLS handshake error from "
+
+// handshakeErrorReasons maps substrings of TLS handshake errors to reasons,
+// the first match wins.
+var handshakeErrorReasons = []struct {
+	substr, reason string
+}{
+	{"first record does not look like a TLS handshake", "not_tls"},
+	{"unsupported versions", "protocol_version"},
+	{"protocol version not supported", "protocol_version"},
+	{"no cipher suite supported", "cipher_suite"},
+	{"no mutually supported", "cipher_suite"},
+	{"client didn't provide a certificate", "no_certificate"},
+	{"certificate has expired", "certificate_expired"},
+	{"expired certificate", "certificate_expired"},
+	{"failed to verify certificate", "bad_certificate"},
+	{"bad certificate", "bad_certificate"},
+	{"unknown certificate authority", "bad_certificate"},
+	{"i/o timeout", "timeout"},
+	{"EOF", "eof"},
+	{"connection reset by peer", "connection_reset"},
+}
+
+// HandshakeErrorReason classifies the error message of a failed TLS
+// handshake.
+func HandshakeErrorReason(msg string) string {
+	for _, r := range handshakeErrorReasons {
+		if strings.Contains(msg, r.substr) {
+			return r.reason
+		}
+	}
+	return "other"
+}
+
+// NewServerErrorLog returns a logger for http.Server.ErrorLog, which counts
+// TLS handshake errors before writing all messages to out.
+func NewServerErrorLog(out io.Writer) *log.Logger {
+	return log.New(&handshakeErrorWriter{out: out}, "", log.LstdFlags)
+}
+
+type handshakeErrorWriter struct {
+	out io.Writer
+}
+
+func (w *handshakeErrorWriter) Write(p []byte) (int, error) {
+	if i := bytes.Index(p, []byte(handshakeErrorPrefix)); i >= 0 {
+		TLSHandshakeErrors.WithLabelValues(HandshakeErrorReason(string(p[i+len(handshakeErrorPrefix):]))).Inc()
+	}
+	return w.out.Write(p)
+}
+
+var (
+	servingCertificateExpiryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "serving_certificate_expiry_days"),
+		"Days until the serving certificate presented for the server name expires. The default certificate has an empty server name.",
+		[]string{"server_name"}, nil,
+	)
+	clientCertificateExpiryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "client_certificate_expiry_days"),
+		"Days until the nearest-expiring client certificate observed recently expires.",
+		nil, nil,
+	)
+)
+
+// ServingCertificates reports the expiry of the serving certificates
+// returned for a set of server names.
+type ServingCertificates struct {
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	serverNames    []string
+	now            func() time.Time
+}
+
+// NewServingCertificates returns a collector reporting the expiry of the
+// certificates getCertificate returns for serverNames.
+func NewServingCertificates(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), serverNames []string) *ServingCertificates {
+	return &ServingCertificates{
+		getCertificate: getCertificate,
+		serverNames:    serverNames,
+		now:            time.Now,
+	}
+}
+
+func (c *ServingCertificates) Describe(ch chan<- *prometheus.Desc) {
+	ch <- servingCertificateExpiryDesc
+}
+
+func (c *ServingCertificates) Collect(ch chan<- prometheus.Metric) {
+	for _, name := range c.serverNames {
+		cert, err := c.getCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil || cert == nil {
+			continue
+		}
+		leaf, err := leafCertificate(cert)
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(servingCertificateExpiryDesc, prometheus.GaugeValue, daysUntil(c.now(), leaf.NotAfter), name)
+	}
+}
+
+// maxClientCertificates bounds the number of client certificates tracked.
+const maxClientCertificates = 10000
+
+// ClientCertificates reports the expiry of the nearest-expiring client
+// certificate observed within a window.
+type ClientCertificates struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu       sync.Mutex
+	lastSeen map[clientCertificate]time.Time
+}
+
+type clientCertificate struct {
+	issuer, serial string
+	notAfter       time.Time
+}
+
+// NewClientCertificates returns a collector reporting the expiry of client
+// certificates observed within window.
+func NewClientCertificates(window time.Duration) *ClientCertificates {
+	return &ClientCertificates{
+		window:   window,
+		now:      time.Now,
+		lastSeen: map[clientCertificate]time.Time{},
+	}
+}
+
+// VerifyConnection observes the client certificate of a TLS connection, it
+// never fails and is meant for tls.Config.VerifyConnection.
+func (c *ClientCertificates) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	c.Observe(cs.PeerCertificates[0])
+	return nil
+}
+
+// Observe records the client certificate as seen now.
+func (c *ClientCertificates) Observe(cert *x509.Certificate) {
+	key := clientCertificate{
+		issuer:   string(cert.RawIssuer),
+		serial:   cert.SerialNumber.String(),
+		notAfter: cert.NotAfter,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.lastSeen[key]; !ok && len(c.lastSeen) >= maxClientCertificates {
+		c.pruneLocked()
+		if len(c.lastSeen) >= maxClientCertificates {
+			return
+		}
+	}
+	c.lastSeen[key] = c.now()
+}
+
+func (c *ClientCertificates) pruneLocked() {
+	now := c.now()
+	for key, seen := range c.lastSeen {
+		if now.Sub(seen) > c.window {
+			delete(c.lastSeen, key)
+		}
+	}
+}
+
+func (c *ClientCertificates) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clientCertificateExpiryDesc
+}
+
+func (c *ClientCertificates) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	c.pruneLocked()
+	var nearest time.Time
+	for key := range c.lastSeen {
+		if nearest.IsZero() || key.notAfter.Before(nearest) {
+			nearest = key.notAfter
+		}
+	}
+	c.mu.Unlock()
+
+	if nearest.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(clientCertificateExpiryDesc, prometheus.GaugeValue, daysUntil(c.now(), nearest))
+}
+
+func leafCertificate(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("certificate chain is empty")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+func daysUntil(now, t time.Time) float64 {
+	return t.Sub(now).Hours() / 24
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHandshakeErrorReason(t *testing.T) {
+	for msg, want := range map[string]string{
+		"10.0.0.1:1234: tls: first record does not look like a TLS handshake":             "not_tls",
+		"10.0.0.1:1234: tls: client offered only unsupported versions: [302 301]":         "protocol_version",
+		"10.0.0.1:1234: remote error: tls: bad certificate":                               "bad_certificate",
+		"10.0.0.1:1234: tls: failed to verify certificate: x509: certificate has expired": "certificate_expired",
+		"10.0.0.1:1234: EOF":            "eof",
+		"10.0.0.1:1234: something else": "other",
+	} {
+		if got := HandshakeErrorReason(msg); got != want {
+			t.Errorf("want reason %q for %q, got %q", want, msg, got)
+		}
+	}
+}
+
+func TestServerErrorLog(t *testing.T) {
+	before := testutil.ToFloat64(TLSHandshakeErrors.WithLabelValues("eof"))
+
+	out := &bytes.Buffer{}
+	l := NewServerErrorLog(out)
+	l.Printf("http: TLS handshake error from 10.0.0.1:1234: EOF")
+	l.Printf("http: Accept error: too many open files")
+
+	if got := testutil.ToFloat64(TLSHandshakeErrors.WithLabelValues("eof")) - before; got != 1 {
+		t.Errorf("want 1 handshake error counted, got %v", got)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("Accept error")) {
+		t.Errorf("want all messages written, got %q", out.String())
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewClientCertificates(time.Hour)
+	c.now = func() time.Time { return now }
+
+	if n := testutil.CollectAndCount(c); n != 0 {
+		t.Fatalf("want no metric without observed certificates, got %d", n)
+	}
+
+	c.Observe(&x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: now.Add(48 * time.Hour)})
+	now = now.Add(30 * time.Minute)
+	c.Observe(&x509.Certificate{SerialNumber: big.NewInt(2), NotAfter: now.Add(240 * time.Hour)})
+
+	if got := testutil.ToFloat64(c); got < 1.9 || got > 2 {
+		t.Errorf("want nearest expiry in about 2 days, got %v", got)
+	}
+
+	now = now.Add(45 * time.Minute)
+	if got := testutil.ToFloat64(c); got < 9.9 || got > 10 {
+		t.Errorf("want expiry of the certificate seen within the window, got %v", got)
+	}
+}
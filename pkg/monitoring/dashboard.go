@@ -0,0 +1,237 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"encoding/json"
+)
+
+type dashboard struct {
+	Title         string     `json:"title"`
+	UID           string     `json:"uid"`
+	Tags          []string   `json:"tags"`
+	Timezone      string     `json:"timezone"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          timeRange  `json:"time"`
+	Templating    templating `json:"templating"`
+	Panels        []panel    `json:"panels"`
+}
+
+type timeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type variable struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+type panel struct {
+	ID          int           `json:"id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Type        string        `json:"type"`
+	Datasource  datasourceRef `json:"datasource"`
+	GridPos     gridPos       `json:"gridPos"`
+	FieldConfig fieldConfig   `json:"fieldConfig"`
+	Targets     []target      `json:"targets"`
+}
+
+type datasourceRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type fieldConfig struct {
+	Defaults fieldDefaults `json:"defaults"`
+}
+
+type fieldDefaults struct {
+	Unit string `json:"unit"`
+}
+
+type target struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+// dashboardPanels are laid out in rows of two.
+var dashboardPanels = []struct {
+	title, description, unit string
+	targets                  []target
+}{
+	{
+		title: "Requests",
+		unit:  "reqps",
+		targets: []target{{
+			Expr:         `sum by (code) (rate(kube_rbac_proxy_request_duration_seconds_count{{{selector}}}[5m]))`,
+			LegendFormat: "{{code}}",
+		}},
+	},
+	{
+		title: "Request latency",
+		unit:  "s",
+		targets: []target{
+			{
+				Expr:         `histogram_quantile(0.99, sum by (le) (rate(kube_rbac_proxy_request_duration_seconds_bucket{{{selector}}}[5m])))`,
+				LegendFormat: "p99",
+			},
+			{
+				Expr:         `histogram_quantile(0.5, sum by (le) (rate(kube_rbac_proxy_request_duration_seconds_bucket{{{selector}}}[5m])))`,
+				LegendFormat: "p50",
+			},
+		},
+	},
+	{
+		title:       "Denied requests",
+		description: "Requests failing authentication (401) or authorization (403).",
+		unit:        "reqps",
+		targets: []target{{
+			Expr:         `sum by (code) (rate(kube_rbac_proxy_request_duration_seconds_count{{{selector}},code=~"401|403"}[5m]))`,
+			LegendFormat: "{{code}}",
+		}},
+	},
+	{
+		title:       "Delegated request latency",
+		description: "Latency of TokenReview and SubjectAccessReview requests to the kube-apiserver.",
+		unit:        "s",
+		targets: []target{{
+			Expr:         `histogram_quantile(0.99, sum by (le, api) (rate(kube_rbac_proxy_delegated_request_duration_seconds_bucket{{{selector}}}[5m])))`,
+			LegendFormat: "p99 {{api}}",
+		}},
+	},
+	{
+		title: "Delegated request errors",
+		unit:  "reqps",
+		targets: []target{{
+			Expr:         `sum by (api) (rate(kube_rbac_proxy_delegated_request_errors_total{{{selector}}}[5m]))`,
+			LegendFormat: "{{api}}",
+		}},
+	},
+	{
+		title:       "Cache hit ratio",
+		description: "Share of authentication and authorization decisions answered from the cache.",
+		unit:        "percentunit",
+		targets: []target{{
+			Expr:         `sum by (api) (rate(kube_rbac_proxy_delegated_decisions_total{{{selector}},cache="hit"}[5m])) / sum by (api) (rate(kube_rbac_proxy_delegated_decisions_total{{{selector}}}[5m]))`,
+			LegendFormat: "{{api}}",
+		}},
+	},
+	{
+		title: "Client connections",
+		unit:  "short",
+		targets: []target{
+			{
+				Expr:         `sum by (state) (kube_rbac_proxy_client_connections{{{selector}}})`,
+				LegendFormat: "{{state}}",
+			},
+			{
+				Expr:         `sum(kube_rbac_proxy_hijacked_connections{{{selector}}})`,
+				LegendFormat: "hijacked",
+			},
+		},
+	},
+	{
+		title: "Upstream connections",
+		unit:  "short",
+		targets: []target{{
+			Expr:         `sum by (state) (kube_rbac_proxy_upstream_connections{{{selector}}})`,
+			LegendFormat: "{{state}}",
+		}},
+	},
+	{
+		title: "TLS handshake errors",
+		unit:  "short",
+		targets: []target{{
+			Expr:         `sum by (reason) (rate(kube_rbac_proxy_tls_handshake_errors_total{{{selector}}}[5m]))`,
+			LegendFormat: "{{reason}}",
+		}},
+	},
+	{
+		title: "Certificate expiry",
+		unit:  "d",
+		targets: []target{
+			{
+				Expr:         `min by (server_name) (kube_rbac_proxy_serving_certificate_expiry_days{{{selector}}})`,
+				LegendFormat: "serving {{server_name}}",
+			},
+			{
+				Expr:         `min(kube_rbac_proxy_client_certificate_expiry_days{{{selector}}})`,
+				LegendFormat: "nearest client",
+			},
+		},
+	},
+}
+
+// Dashboard returns a Grafana dashboard as JSON.
+func Dashboard(cfg Config) ([]byte, error) {
+	d := dashboard{
+		Title:         cfg.Name,
+		UID:           cfg.Name,
+		Tags:          []string{"kube-rbac-proxy"},
+		Timezone:      "browser",
+		SchemaVersion: 27,
+		Refresh:       "30s",
+		Time:          timeRange{From: "now-1h", To: "now"},
+		Templating: templating{List: []variable{{
+			Name:  "datasource",
+			Label: "Data source",
+			Type:  "datasource",
+			Query: "prometheus",
+		}}},
+	}
+
+	for i, p := range dashboardPanels {
+		targets := make([]target, len(p.targets))
+		for j, t := range p.targets {
+			targets[j] = target{
+				RefID:        string(rune('A' + j)),
+				Expr:         cfg.query(t.Expr),
+				LegendFormat: t.LegendFormat,
+			}
+		}
+
+		d.Panels = append(d.Panels, panel{
+			ID:          i + 1,
+			Title:       p.title,
+			Description: p.description,
+			Type:        "timeseries",
+			Datasource:  datasourceRef{Type: "prometheus", UID: "${datasource}"},
+			GridPos:     gridPos{H: 8, W: 12, X: (i % 2) * 12, Y: (i / 2) * 8},
+			FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: p.unit}},
+			Targets:     targets,
+		})
+	}
+
+	return json.MarshalIndent(d, "", "  ")
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package monitoring generates a Grafana dashboard and Prometheus alerting
+// rules for the kube-rbac-proxy's metrics.
+package monitoring
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Config parameterizes the generated assets.
+type Config struct {
+	// Name is the name of the PrometheusRule and the title of the dashboard.
+	Name string
+	// Namespace is the namespace of the PrometheusRule, it is omitted if empty.
+	Namespace string
+	// Selector selects the proxy's series, e.g. `job="kube-rbac-proxy"`.
+	Selector string
+}
+
+// query replaces the {{selector}} placeholder in expr with the selector of
+// cfg, joined with the matchers already given.
+func (cfg Config) query(expr string) string {
+	expr = strings.ReplaceAll(expr, "{{selector}},", selectorPrefix(cfg.Selector))
+	return strings.ReplaceAll(expr, "{{selector}}", cfg.Selector)
+}
+
+func selectorPrefix(selector string) string {
+	if selector == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s,", selector)
+}
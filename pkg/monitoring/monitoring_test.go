@@ -0,0 +1,122 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+var (
+	metricNameRE = regexp.MustCompile(`kube_rbac_proxy_[a-z_]+`)
+	fqNameRE     = regexp.MustCompile(`fqName: "([^"]+)"`)
+)
+
+// TestMetricNames ensures the generated assets only reference metrics the
+// proxy exposes.
+func TestMetricNames(t *testing.T) {
+	exposed := map[string]bool{}
+	for _, c := range []prometheus.Collector{
+		metrics.RequestDuration,
+		metrics.DelegatedRequestDuration,
+		metrics.DelegatedRequestErrors,
+		metrics.DelegatedDecisions,
+		metrics.ClientConnections,
+		metrics.HijackedConnections,
+		metrics.UpstreamConnections,
+		metrics.TLSHandshakeErrors,
+		metrics.DecisionLogsDropped,
+		metrics.NewServingCertificates(nil, nil),
+		metrics.NewClientCertificates(time.Hour),
+	} {
+		ch := make(chan *prometheus.Desc, 10)
+		c.Describe(ch)
+		close(ch)
+		for d := range ch {
+			m := fqNameRE.FindStringSubmatch(d.String())
+			if m == nil {
+				t.Fatalf("failed to parse name of %s", d)
+			}
+			exposed[m[1]] = true
+		}
+	}
+
+	cfg := Config{Name: "kube-rbac-proxy", Selector: `job="kube-rbac-proxy"`}
+	dashboard, err := Dashboard(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := Rules(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range metricNameRE.FindAllString(string(dashboard)+string(rules), -1) {
+		base := name
+		for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+			base = strings.TrimSuffix(base, suffix)
+		}
+		if !exposed[name] && !exposed[base] {
+			t.Errorf("metric %q is not exposed by the proxy", name)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	for _, tc := range []struct {
+		selector, want string
+	}{
+		{
+			selector: `job="kube-rbac-proxy"`,
+			want:     `kube_rbac_proxy_delegated_decisions_total{job="kube-rbac-proxy",cache="hit"}`,
+		},
+		{
+			selector: "",
+			want:     `kube_rbac_proxy_delegated_decisions_total{cache="hit"}`,
+		},
+	} {
+		dashboard, err := Dashboard(Config{Name: "test", Selector: tc.selector})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(dashboard, &map[string]interface{}{}); err != nil {
+			t.Fatalf("invalid dashboard JSON: %v", err)
+		}
+		if !strings.Contains(string(dashboard), strings.ReplaceAll(tc.want, `"`, `\"`)) {
+			t.Errorf("want dashboard to contain %s", tc.want)
+		}
+
+		rules, err := Rules(Config{Name: "test", Selector: tc.selector})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := yaml.Unmarshal(rules, &prometheusRule{}); err != nil {
+			t.Fatalf("invalid rules YAML: %v", err)
+		}
+		if strings.Contains(string(rules), "{{selector}}") {
+			t.Errorf("want selector placeholder to be replaced, got %s", rules)
+		}
+	}
+}
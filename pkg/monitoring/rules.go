@@ -0,0 +1,132 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"github.com/ghodss/yaml"
+)
+
+type prometheusRule struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Metadata   metadata           `json:"metadata"`
+	Spec       prometheusRuleSpec `json:"spec"`
+}
+
+type metadata struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type prometheusRuleSpec struct {
+	Groups []ruleGroup `json:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `json:"name"`
+	Rules []rule `json:"rules"`
+}
+
+type rule struct {
+	Alert       string            `json:"alert"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+var alertingRules = []rule{
+	{
+		Alert:  "KubeRbacProxyErrors",
+		Expr:   `sum by (namespace, pod) (rate(kube_rbac_proxy_request_duration_seconds_count{{{selector}},code=~"5.."}[5m])) / sum by (namespace, pod) (rate(kube_rbac_proxy_request_duration_seconds_count{{{selector}}}[5m])) > 0.05`,
+		For:    "15m",
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "kube-rbac-proxy responds with server errors.",
+			"description": "{{ $value | humanizePercentage }} of the requests to kube-rbac-proxy in {{ $labels.namespace }}/{{ $labels.pod }} fail with a server error.",
+		},
+	},
+	{
+		Alert:  "KubeRbacProxyDelegatedRequestErrors",
+		Expr:   `sum by (namespace, pod, api) (rate(kube_rbac_proxy_delegated_request_errors_total{{{selector}}}[5m])) / sum by (namespace, pod, api) (rate(kube_rbac_proxy_delegated_request_duration_seconds_count{{{selector}}}[5m])) > 0.05`,
+		For:    "15m",
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "kube-rbac-proxy fails to reach the kube-apiserver.",
+			"description": "{{ $value | humanizePercentage }} of the {{ $labels.api }} requests of kube-rbac-proxy in {{ $labels.namespace }}/{{ $labels.pod }} to the kube-apiserver fail.",
+		},
+	},
+	{
+		Alert:  "KubeRbacProxyServingCertificateExpiry",
+		Expr:   `min by (namespace, pod, server_name) (kube_rbac_proxy_serving_certificate_expiry_days{{{selector}}}) < 7`,
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "The serving certificate of kube-rbac-proxy is about to expire.",
+			"description": "The serving certificate of kube-rbac-proxy in {{ $labels.namespace }}/{{ $labels.pod }} for server name {{ $labels.server_name }} expires in {{ $value | humanize }} days.",
+		},
+	},
+	{
+		Alert:  "KubeRbacProxyServingCertificateExpiry",
+		Expr:   `min by (namespace, pod, server_name) (kube_rbac_proxy_serving_certificate_expiry_days{{{selector}}}) < 1`,
+		Labels: map[string]string{"severity": "critical"},
+		Annotations: map[string]string{
+			"summary":     "The serving certificate of kube-rbac-proxy is about to expire.",
+			"description": "The serving certificate of kube-rbac-proxy in {{ $labels.namespace }}/{{ $labels.pod }} for server name {{ $labels.server_name }} expires in {{ $value | humanize }} days.",
+		},
+	},
+	{
+		Alert:  "KubeRbacProxyClientCertificateExpiry",
+		Expr:   `min by (namespace, pod) (kube_rbac_proxy_client_certificate_expiry_days{{{selector}}}) < 7`,
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "A client certificate presented to kube-rbac-proxy is about to expire.",
+			"description": "A client of kube-rbac-proxy in {{ $labels.namespace }}/{{ $labels.pod }} presents a certificate expiring in {{ $value | humanize }} days.",
+		},
+	},
+	{
+		Alert:  "KubeRbacProxyDecisionLogsDropped",
+		Expr:   `sum by (namespace, pod) (increase(kube_rbac_proxy_decision_logs_dropped_total{{{selector}}}[15m])) > 0`,
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "kube-rbac-proxy drops decision logs.",
+			"description": "kube-rbac-proxy in {{ $labels.namespace }}/{{ $labels.pod }} dropped {{ $value | humanize }} authorization decisions in the last 15 minutes, because the buffer was full or the sink failed.",
+		},
+	},
+}
+
+// Rules returns a PrometheusRule of the Prometheus Operator as YAML.
+func Rules(cfg Config) ([]byte, error) {
+	rules := make([]rule, len(alertingRules))
+	for i, r := range alertingRules {
+		r.Expr = cfg.query(r.Expr)
+		rules[i] = r
+	}
+
+	return yaml.Marshal(prometheusRule{
+		APIVersion: "monitoring.coreos.com/v1",
+		Kind:       "PrometheusRule",
+		Metadata: metadata{
+			Name:      cfg.Name,
+			Namespace: cfg.Namespace,
+			Labels:    map[string]string{"app.kubernetes.io/name": "kube-rbac-proxy"},
+		},
+		Spec: prometheusRuleSpec{
+			Groups: []ruleGroup{{Name: "kube-rbac-proxy", Rules: rules}},
+		},
+	})
+}
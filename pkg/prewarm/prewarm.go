@@ -0,0 +1,135 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package prewarm establishes connections to the upstreams and the
+// kube-apiserver ahead of requests and keeps them warm, so the first
+// requests after a deployment or an idle period don't wait for TCP and TLS
+// handshakes.
+package prewarm
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// timeout is the maximum duration of warming a single target.
+const timeout = 10 * time.Second
+
+// Target is a destination whose connections are kept warm.
+type Target struct {
+	// Name identifies the target in logs.
+	Name string
+	// Warm sends a cheap request to the target, which leaves an idle
+	// connection in the connection pool of the target's client.
+	Warm func(ctx context.Context) error
+}
+
+// HTTPTarget warms the connections of rt to u with HEAD requests. The
+// status of the responses is irrelevant, only the connection is.
+func HTTPTarget(name string, rt http.RoundTripper, u *url.URL) Target {
+	return Target{
+		Name: name,
+		Warm: func(ctx context.Context) error {
+			req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+			if err != nil {
+				return err
+			}
+			resp, err := rt.RoundTrip(req.WithContext(ctx))
+			if err != nil {
+				return err
+			}
+			// The body is drained for the connection to be reused.
+			io.Copy(ioutil.Discard, resp.Body)
+			return resp.Body.Close()
+		},
+	}
+}
+
+// KubeAPIServerTarget warms the connections of client to the kube-apiserver
+// by requesting its version, which every client is allowed to read.
+func KubeAPIServerTarget(client rest.Interface) Target {
+	return Target{
+		Name: "kube-apiserver",
+		Warm: func(ctx context.Context) error {
+			return client.Get().AbsPath("/version").Do(ctx).Error()
+		},
+	}
+}
+
+// Prewarmer warms the connections of its targets.
+type Prewarmer struct {
+	interval time.Duration
+	targets  []Target
+}
+
+// New returns a prewarmer of targets, which warms them again every interval
+// to keep their connections from being closed as idle. An interval of 0 only
+// warms them once.
+func New(interval time.Duration, targets ...Target) *Prewarmer {
+	return &Prewarmer{interval: interval, targets: targets}
+}
+
+// Run warms the targets right away and then every interval until ctx is
+// done.
+func (p *Prewarmer) Run(ctx context.Context) error {
+	p.Warm(ctx)
+	if p.interval <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Warm(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Warm warms all targets concurrently. Failures are only logged, as
+// requests establish the connections themselves if warming failed.
+func (p *Prewarmer) Warm(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range p.targets {
+		wg.Add(1)
+		go func(t Target) {
+			defer wg.Done()
+			warmCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if err := t.Warm(warmCtx); err != nil {
+				// Failures because of shutting down aren't worth a warning.
+				if ctx.Err() == nil {
+					klog.Warningf("Failed to warm connections to %s: %v", t.Name, err)
+				}
+				return
+			}
+			klog.V(4).Infof("Warmed connections to %s", t.Name)
+		}(t)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prewarm
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestHTTPTargetLeavesConnectionForRequests(t *testing.T) {
+	var conns, heads int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			atomic.AddInt32(&heads, 1)
+		}
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	rt := srv.Client().Transport
+	New(0, HTTPTarget("upstream", rt, u)).Warm(context.Background())
+
+	if got := atomic.LoadInt32(&heads); got != 1 {
+		t.Fatalf("want 1 HEAD request, got %d", got)
+	}
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := atomic.LoadInt32(&conns); got != 1 {
+		t.Errorf("want the request to reuse the warmed connection, got %d connections", got)
+	}
+}
+
+func TestKubeAPIServerTarget(t *testing.T) {
+	var versions int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/version" {
+			http.NotFound(w, req)
+			return
+		}
+		atomic.AddInt32(&versions, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"major":"1","minor":"19"}`))
+	}))
+	defer srv.Close()
+
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := KubeAPIServerTarget(client.Discovery().RESTClient()).Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&versions); got != 1 {
+		t.Errorf("want 1 version request, got %d", got)
+	}
+}
+
+func TestRunWarmsEveryInterval(t *testing.T) {
+	warmed := make(chan struct{})
+	p := New(time.Millisecond, Target{Name: "test", Warm: func(ctx context.Context) error {
+		select {
+		case warmed <- struct{}{}:
+		case <-ctx.Done():
+		}
+		return nil
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-warmed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("target was warmed %d times, want 3", i)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
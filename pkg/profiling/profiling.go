@@ -0,0 +1,202 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package profiling continuously pushes CPU and heap profiles of the proxy to
+// a Pyroscope-compatible server, so hotspots across a fleet of proxies can be
+// analyzed without exec'ing into pods.
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Config configures pushing profiles.
+type Config struct {
+	// ServerURL is the URL of the server, profiles are pushed to its /ingest
+	// endpoint.
+	ServerURL string
+	// ApplicationName identifies the profiles of the proxy.
+	ApplicationName string
+	// Tags are attached to all profiles, e.g. the pod and namespace.
+	Tags map[string]string
+	// Interval is the duration of each CPU profile and the time between pushes.
+	Interval time.Duration
+}
+
+// Pusher continuously profiles the process and pushes a CPU and a heap
+// profile every interval.
+type Pusher struct {
+	cfg       Config
+	client    *http.Client
+	ingestURL string
+	name      string
+}
+
+// NewPusher returns a pusher uploading profiles with client.
+func NewPusher(cfg Config, client *http.Client) (*Pusher, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("profiling interval must be positive, got %v", cfg.Interval)
+	}
+	if cfg.ApplicationName == "" {
+		return nil, fmt.Errorf("profiling application name must not be empty")
+	}
+
+	u, err := url.Parse(cfg.ServerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profiling server URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid profiling server URL %q: scheme must be http or https", cfg.ServerURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/ingest"
+
+	return &Pusher{
+		cfg:       cfg,
+		client:    client,
+		ingestURL: u.String(),
+		name:      profileName(cfg.ApplicationName, cfg.Tags),
+	}, nil
+}
+
+// Run profiles and pushes until ctx is done, pushing the last, shorter
+// profiles before returning.
+func (p *Pusher) Run(ctx context.Context) error {
+	timer := time.NewTimer(p.cfg.Interval)
+	defer timer.Stop()
+
+	for {
+		from := time.Now()
+		cpu := &bytes.Buffer{}
+		// Fails while a CPU profile is taken by other means, e.g.
+		// /debug/pprof/profile, in which case only the heap is pushed.
+		cpuErr := pprof.StartCPUProfile(cpu)
+		if cpuErr != nil {
+			klog.V(2).Infof("Skipping continuous CPU profile: %v", cpuErr)
+		}
+
+		done := false
+		select {
+		case <-timer.C:
+			timer.Reset(p.cfg.Interval)
+		case <-ctx.Done():
+			done = true
+		}
+		if cpuErr == nil {
+			pprof.StopCPUProfile()
+		}
+		until := time.Now()
+
+		if cpuErr == nil {
+			p.pushLogged("cpu", cpu, from, until)
+		}
+		heap := &bytes.Buffer{}
+		if err := pprof.Lookup("heap").WriteTo(heap, 0); err != nil {
+			klog.Errorf("Failed to write heap profile: %v", err)
+		} else {
+			p.pushLogged("heap", heap, from, until)
+		}
+
+		if done {
+			return nil
+		}
+	}
+}
+
+func (p *Pusher) pushLogged(kind string, profile io.Reader, from, until time.Time) {
+	if err := p.push(profile, from, until); err != nil {
+		klog.Errorf("Failed to push %s profile: %v", kind, err)
+	}
+}
+
+// push uploads a pprof profile covering from to until.
+func (p *Pusher) push(profile io.Reader, from, until time.Time) error {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, profile); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	q := url.Values{}
+	q.Set("name", p.name)
+	q.Set("from", strconv.FormatInt(from.Unix(), 10))
+	q.Set("until", strconv.FormatInt(until.Unix(), 10))
+	q.Set("format", "pprof")
+	q.Set("spyName", "gospy")
+	q.Set("sampleRate", "100")
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Interval)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, p.ingestURL+"?"+q.Encode(), body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// profileName returns the name of the profiles with their tags, e.g.
+// "kube-rbac-proxy{namespace=default,pod=foo}".
+func profileName(app string, tags map[string]string) string {
+	if len(tags) == 0 {
+		return app
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+tags[k])
+	}
+	return app + "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPusher(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		names   []string
+		invalid []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path != "/pyroscope/ingest" || r.URL.Query().Get("format") != "pprof" {
+			invalid = append(invalid, r.URL.String())
+		}
+		f, _, err := r.FormFile("profile")
+		if err != nil {
+			invalid = append(invalid, err.Error())
+		} else {
+			b, _ := ioutil.ReadAll(f)
+			// pprof profiles are gzipped.
+			if !bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+				invalid = append(invalid, "profile isn't gzipped")
+			}
+		}
+		names = append(names, r.URL.Query().Get("name"))
+	}))
+	defer srv.Close()
+
+	p, err := NewPusher(Config{
+		ServerURL:       srv.URL + "/pyroscope/",
+		ApplicationName: "kube-rbac-proxy",
+		Tags:            map[string]string{"pod": "foo", "namespace": "default"},
+		Interval:        50 * time.Millisecond,
+	}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	if err := p.Run(ctx); err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(invalid) > 0 {
+		t.Errorf("invalid uploads: %v", invalid)
+	}
+	// A CPU and a heap profile for each interval, including the last one
+	// cut short.
+	if len(names) < 4 || len(names)%2 != 0 {
+		t.Errorf("want a CPU and a heap profile for each of at least 2 intervals, got %d uploads", len(names))
+	}
+	for _, name := range names {
+		if want := "kube-rbac-proxy{namespace=default,pod=foo}"; name != want {
+			t.Errorf("want name %q, got %q", want, name)
+		}
+	}
+}
+
+func TestNewPusher(t *testing.T) {
+	for _, cfg := range []Config{
+		{ServerURL: "http://pyroscope:4040", ApplicationName: "kube-rbac-proxy"},
+		{ServerURL: "pyroscope:4040", ApplicationName: "kube-rbac-proxy", Interval: time.Second},
+		{ServerURL: "http://pyroscope:4040", Interval: time.Second},
+	} {
+		if _, err := NewPusher(cfg, http.DefaultClient); err == nil {
+			t.Errorf("expected error for %+v, got nil", cfg)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+const (
+	stageAuthentication = "authentication"
+	stageAuthorization  = "authorization"
+)
+
+// LatencyBudget bounds the time spent on authenticating and authorizing a
+// request, so a slow TokenReview or SubjectAccessReview fails the request
+// quickly instead of using up the client's timeout.
+type LatencyBudget struct {
+	// Total is the maximum duration of authentication and authorization
+	// together. Zero disables the budget.
+	Total time.Duration
+	// AuthenticationRatio is the share of Total authentication may take.
+	// Authorization may take the rest, including what authentication didn't
+	// use.
+	AuthenticationRatio float64
+}
+
+// authentication returns the context to authenticate a request started at
+// start with.
+func (b LatencyBudget) authentication(ctx context.Context, start time.Time) (context.Context, context.CancelFunc) {
+	if b.Total <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, start.Add(time.Duration(float64(b.Total)*b.AuthenticationRatio)))
+}
+
+// authorization returns the context to authorize a request started at start
+// with.
+func (b LatencyBudget) authorization(ctx context.Context, start time.Time) (context.Context, context.CancelFunc) {
+	if b.Total <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, start.Add(b.Total))
+}
+
+// budgetExceeded returns whether stageCtx, derived from ctx, ran out of its
+// budget, as opposed to ctx being done, and records it.
+func budgetExceeded(ctx, stageCtx context.Context, stage string) bool {
+	if ctx.Err() != nil || stageCtx.Err() != context.DeadlineExceeded {
+		return false
+	}
+	metrics.LatencyBudgetExceeded.WithLabelValues(metrics.Route(ctx), stage).Inc()
+	return true
+}
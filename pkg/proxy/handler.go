@@ -0,0 +1,151 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/authn"
+	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+	"github.com/brancz/kube-rbac-proxy/pkg/filters"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// HandlerConfig configures the handler returned by NewHandler.
+type HandlerConfig struct {
+	Config
+
+	// Client creates the TokenReviews and SubjectAccessReviews. It is only
+	// required if Authenticator or Authorizer is unset.
+	Client clientset.Interface
+	// Authenticator, if set, authenticates requests instead of TokenReviews
+	// or the configured OIDC provider.
+	Authenticator authenticator.Request
+	// Authorizer, if set, authorizes requests instead of
+	// SubjectAccessReviews.
+	Authorizer authorizer.Authorizer
+	// Hooks are called while requests are handled.
+	Hooks Hooks
+}
+
+// NewHandler returns a handler which authenticates and authorizes requests
+// like kube-rbac-proxy, and passes the requests which are allowed on to
+// upstream. It lets Go services embed kube-rbac-proxy as middleware instead
+// of running it as a sidecar.
+//
+// Unset parts of cfg.Authentication and cfg.Authorization are defaulted like
+// the flags of kube-rbac-proxy.
+func NewHandler(cfg HandlerConfig, upstream http.Handler) (http.Handler, error) {
+	if upstream == nil {
+		return nil, errors.New("no upstream handler provided")
+	}
+
+	config := withDefaults(cfg.Config)
+	if err := config.Authorization.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid authorization config: %v", err)
+	}
+
+	authenticator := cfg.Authenticator
+	if authenticator == nil {
+		if cfg.Client == nil {
+			return nil, errors.New("either a client or an authenticator must be provided")
+		}
+		var err error
+		authenticator, err = NewAuthenticator(cfg.Client, config.Authentication)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	authorizer := cfg.Authorizer
+	if authorizer == nil {
+		if cfg.Client == nil {
+			return nil, errors.New("either a client or an authorizer must be provided")
+		}
+		var err error
+		authorizer, err = authz.NewAuthorizer(cfg.Client.AuthorizationV1().SubjectAccessReviews())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create authorizer: %v", err)
+		}
+	}
+
+	p := new(authenticator, authorizer, config)
+	p.hooks = cfg.Hooks
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !p.Handle(w, req) {
+			return
+		}
+		if cfg.Hooks.BeforeProxy != nil {
+			if err := cfg.Hooks.BeforeProxy(req); err != nil {
+				klog.Errorf("Failed to prepare the request for the upstream: %v", err)
+				filters.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		upstream.ServeHTTP(w, req)
+	}), nil
+}
+
+// NewAuthenticator returns the authenticator kube-rbac-proxy uses for the
+// config: the OIDC provider if an issuer is configured, and TokenReviews
+// and client certificates otherwise.
+func NewAuthenticator(client clientset.Interface, config *authn.AuthnConfig) (authenticator.Request, error) {
+	if config.OIDC != nil && config.OIDC.IssuerURL != "" {
+		a, err := authn.NewOIDCAuthenticator(config.OIDC)
+		if err != nil {
+			return nil, fmt.Errorf("failed to instantiate OIDC authenticator: %v", err)
+		}
+		return a, nil
+	}
+
+	a, err := authn.NewDelegatingAuthenticator(client.AuthenticationV1().TokenReviews(), config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate delegating authenticator: %v", err)
+	}
+	return a, nil
+}
+
+// withDefaults returns a copy of c with its unset parts defaulted.
+func withDefaults(c Config) Config {
+	authentication := authn.AuthnConfig{}
+	if c.Authentication != nil {
+		authentication = *c.Authentication
+	}
+	if authentication.X509 == nil {
+		authentication.X509 = &authn.X509Config{}
+	}
+	if authentication.Header == nil {
+		authentication.Header = &authn.AuthnHeaderConfig{}
+	}
+	if authentication.OIDC == nil {
+		authentication.OIDC = &authn.OIDCConfig{}
+	}
+	if authentication.Token == nil {
+		authentication.Token = &authn.TokenConfig{}
+	}
+	c.Authentication = &authentication
+
+	if c.Authorization == nil {
+		c.Authorization = &authz.Config{}
+	}
+	return c
+}
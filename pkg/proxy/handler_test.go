@@ -0,0 +1,147 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/authn"
+	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+func TestNewHandler(t *testing.T) {
+	fakeUser := user.DefaultInfo{Name: "Foo Bar"}
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Header.Get("x-remote-user")))
+	})
+
+	for _, tc := range setupTestScenario() {
+		h, err := NewHandler(HandlerConfig{
+			Config: Config{
+				Authentication: &authn.AuthnConfig{
+					Header: &authn.AuthnHeaderConfig{Enabled: true, UserFieldName: "x-remote-user"},
+				},
+			},
+			Authenticator: fakeOIDCAuthenticator(t, &fakeUser),
+			Authorizer:    tc.authorizer,
+		}, upstream)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tc.req)
+		if w.Code != tc.status {
+			t.Errorf("%s: want status %d, got %d", tc.description, tc.status, w.Code)
+		}
+		if tc.verifyUser && w.Body.String() != fakeUser.Name {
+			t.Errorf("%s: want upstream to get user %q, got %q", tc.description, fakeUser.Name, w.Body.String())
+		}
+	}
+
+	for _, cfg := range []HandlerConfig{
+		// Neither a client nor an authenticator and authorizer.
+		{},
+		// A template without rewrites.
+		{
+			Config:        Config{Authorization: &authz.Config{ResourceAttributes: &authz.ResourceAttributes{Namespace: "{{ .Value }}"}}},
+			Authenticator: fakeOIDCAuthenticator(t, &fakeUser),
+			Authorizer:    approver{},
+		},
+	} {
+		if _, err := NewHandler(cfg, upstream); err == nil {
+			t.Errorf("want error for config %+v, got nil", cfg)
+		}
+	}
+}
+
+func TestNewHandlerHooks(t *testing.T) {
+	fakeUser := user.DefaultInfo{Name: "Foo Bar"}
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Header.Get("x-tenant")))
+	})
+
+	for _, tc := range []struct {
+		description string
+		authorizer  authorizer.Authorizer
+		proxyErr    error
+		status      int
+		body        string
+		calls       []string
+	}{
+		{
+			description: "allowed requests call all hooks",
+			authorizer:  approver{},
+			status:      http.StatusOK,
+			body:        "Foo Bar",
+			calls:       []string{"authenticated", "authorized", "proxy"},
+		},
+		{
+			description: "denied requests are not authorized",
+			authorizer:  denier{},
+			status:      http.StatusForbidden,
+			calls:       []string{"authenticated"},
+		},
+		{
+			description: "BeforeProxy errors reject requests",
+			authorizer:  approver{},
+			proxyErr:    errors.New("no tenant"),
+			status:      http.StatusInternalServerError,
+			calls:       []string{"authenticated", "authorized", "proxy"},
+		},
+	} {
+		var calls []string
+		h, err := NewHandler(HandlerConfig{
+			Authenticator: fakeOIDCAuthenticator(t, &fakeUser),
+			Authorizer:    tc.authorizer,
+			Hooks: Hooks{
+				OnAuthenticated: func(req *http.Request, u user.Info) {
+					calls = append(calls, "authenticated")
+				},
+				OnAuthorized: func(req *http.Request, u user.Info, attrs []authorizer.Attributes) {
+					calls = append(calls, "authorized")
+					req.Header.Set("x-tenant", u.GetName())
+				},
+				BeforeProxy: func(req *http.Request) error {
+					calls = append(calls, "proxy")
+					return tc.proxyErr
+				},
+			},
+		}, upstream)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, fakeJWTRequest("GET", "/accounts", "Bearer VALID"))
+		if w.Code != tc.status {
+			t.Errorf("%s: want status %d, got %d", tc.description, tc.status, w.Code)
+		}
+		if tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%s: want body %q, got %q", tc.description, tc.body, w.Body.String())
+		}
+		if strings.Join(calls, ",") != strings.Join(tc.calls, ",") {
+			t.Errorf("%s: want hooks %v called, got %v", tc.description, tc.calls, calls)
+		}
+	}
+}
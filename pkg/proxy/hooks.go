@@ -0,0 +1,40 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// Hooks are called while a request is handled, to let embedders mutate the
+// request passed on to the upstream or record custom telemetry. Unset hooks
+// are skipped. Hooks must be safe for concurrent use.
+type Hooks struct {
+	// OnAuthenticated is called once the user of req is authenticated,
+	// before it is authorized.
+	OnAuthenticated func(req *http.Request, u user.Info)
+	// OnAuthorized is called once req is allowed for all of attrs, before
+	// the identity headers are set.
+	OnAuthorized func(req *http.Request, u user.Info, attrs []authorizer.Attributes)
+	// BeforeProxy is called with the request passed on to the upstream,
+	// which it may modify, e.g. by setting headers. If it returns an error,
+	// the request is answered with 500 Internal Server Error instead.
+	BeforeProxy func(req *http.Request) error
+}
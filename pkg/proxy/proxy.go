@@ -18,13 +18,19 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
+	"github.com/brancz/kube-rbac-proxy/pkg/audit"
 	"github.com/brancz/kube-rbac-proxy/pkg/authn"
 	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+	"github.com/brancz/kube-rbac-proxy/pkg/filters"
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
@@ -36,6 +42,7 @@ import (
 type Config struct {
 	Authentication *authn.AuthnConfig
 	Authorization  *authz.Config
+	LatencyBudget  LatencyBudget
 }
 
 type kubeRBACProxy struct {
@@ -47,10 +54,17 @@ type kubeRBACProxy struct {
 	authorizerAttributesGetter *krpAuthorizerAttributesGetter
 	// config for kube-rbac-proxy
 	Config Config
+	// hooks registered by embedders
+	hooks Hooks
 }
 
 func new(authenticator authenticator.Request, authorizer authorizer.Authorizer, config Config) *kubeRBACProxy {
-	return &kubeRBACProxy{authenticator, authorizer, newKubeRBACProxyAuthorizerAttributesGetter(config.Authorization), config}
+	return &kubeRBACProxy{
+		Request:                    authenticator,
+		Authorizer:                 authorizer,
+		authorizerAttributesGetter: newKubeRBACProxyAuthorizerAttributesGetter(config.Authorization),
+		Config:                     config,
+	}
 }
 
 // New creates an authenticator, an authorizer, and a matching authorizer attributes getter compatible with the kube-rbac-proxy
@@ -62,47 +76,85 @@ func New(client clientset.Interface, config Config, authorizer authorizer.Author
 // If the authn fails, a 401 error is returned. If the authz fails, a 403 error is returned
 func (h *kubeRBACProxy) Handle(w http.ResponseWriter, req *http.Request) bool {
 	ctx := req.Context()
-	if len(h.Config.Authentication.Token.Audiences) > 0 {
+	if h.Config.Authentication.Token != nil && len(h.Config.Authentication.Token.Audiences) > 0 {
 		ctx = authenticator.WithAudiences(ctx, h.Config.Authentication.Token.Audiences)
 		req = req.WithContext(ctx)
 	}
 
 	// Authenticate
-	u, ok, err := h.AuthenticateRequest(req)
+	start := time.Now()
+	authnCtx, cancel := h.Config.LatencyBudget.authentication(ctx, start)
+	u, ok, err := h.AuthenticateRequest(req.WithContext(authnCtx))
+	exceeded := budgetExceeded(ctx, authnCtx, stageAuthentication)
+	cancel()
+	requestinfo.AddAuthenticationDuration(ctx, time.Since(start))
+	if exceeded {
+		klog.Errorf("Authentication exceeded the latency budget: %v", err)
+		filters.Error(w, req, "Authentication timed out", http.StatusGatewayTimeout)
+		return false
+	}
 	if err != nil {
 		klog.Errorf("Unable to authenticate the request due to an error: %v", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		filters.Error(w, req, "Unauthorized", http.StatusUnauthorized)
 		return false
 	}
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		filters.Error(w, req, "Unauthorized", http.StatusUnauthorized)
 		return false
 	}
 
+	audit.LogUser(ctx, u.User)
+	requestinfo.SetUser(ctx, u.User)
+	if h.hooks.OnAuthenticated != nil {
+		h.hooks.OnAuthenticated(req, u.User)
+	}
+
 	// Get authorization attributes
 	allAttrs := h.authorizerAttributesGetter.GetRequestAttributes(u.User, req)
 	if len(allAttrs) == 0 {
 		msg := fmt.Sprintf("Bad Request. The request or configuration is malformed.")
 		klog.V(2).Info(msg)
-		http.Error(w, msg, http.StatusBadRequest)
+		filters.Error(w, req, msg, http.StatusBadRequest)
 		return false
 	}
 
-	for _, attrs := range allAttrs {
-		// Authorize
-		authorized, reason, err := h.Authorize(ctx, attrs)
-		if err != nil {
-			msg := fmt.Sprintf("Authorization error (user=%s, verb=%s, resource=%s, subresource=%s)", u.User.GetName(), attrs.GetVerb(), attrs.GetResource(), attrs.GetSubresource())
-			klog.Errorf("%s: %s", msg, err)
-			http.Error(w, msg, http.StatusInternalServerError)
+	authzCtx, cancel := h.Config.LatencyBudget.authorization(ctx, start)
+	results, failed := h.authorizeAll(authzCtx, allAttrs)
+	exceeded = failed >= 0 && results[failed].err != nil && budgetExceeded(ctx, authzCtx, stageAuthorization)
+	cancel()
+	for i, attrs := range allAttrs {
+		// Checks cancelled because of another denial are not part of the
+		// outcome, and the failing check is recorded last.
+		if i == failed || !results[i].allowed() {
+			continue
+		}
+		audit.LogAuthorization(ctx, attrs, results[i].decision, results[i].reason)
+		requestinfo.AddDecision(ctx, attrs, results[i].decision, results[i].reason, results[i].duration)
+	}
+	if failed >= 0 {
+		attrs, result := allAttrs[failed], results[failed]
+		audit.LogAuthorization(ctx, attrs, result.decision, result.reason)
+		requestinfo.AddDecision(ctx, attrs, result.decision, result.reason, result.duration)
+		if result.err != nil && exceeded {
+			msg := fmt.Sprintf("Authorization timed out (user=%s, verb=%s, resource=%s, subresource=%s)", u.User.GetName(), attrs.GetVerb(), attrs.GetResource(), attrs.GetSubresource())
+			klog.Errorf("%s: exceeded the latency budget: %s", msg, result.err)
+			filters.Error(w, req, msg, http.StatusGatewayTimeout)
 			return false
 		}
-		if authorized != authorizer.DecisionAllow {
-			msg := fmt.Sprintf("Forbidden (user=%s, verb=%s, resource=%s, subresource=%s)", u.User.GetName(), attrs.GetVerb(), attrs.GetResource(), attrs.GetSubresource())
-			klog.V(2).Infof("%s. Reason: %q.", msg, reason)
-			http.Error(w, msg, http.StatusForbidden)
+		if result.err != nil {
+			msg := fmt.Sprintf("Authorization error (user=%s, verb=%s, resource=%s, subresource=%s)", u.User.GetName(), attrs.GetVerb(), attrs.GetResource(), attrs.GetSubresource())
+			klog.Errorf("%s: %s", msg, result.err)
+			filters.Error(w, req, msg, http.StatusInternalServerError)
 			return false
 		}
+		msg := fmt.Sprintf("Forbidden (user=%s, verb=%s, resource=%s, subresource=%s)", u.User.GetName(), attrs.GetVerb(), attrs.GetResource(), attrs.GetSubresource())
+		klog.V(2).Infof("%s. Reason: %q.", msg, result.reason)
+		filters.Error(w, req, msg, http.StatusForbidden)
+		return false
+	}
+
+	if h.hooks.OnAuthorized != nil {
+		h.hooks.OnAuthorized(req, u.User, allAttrs)
 	}
 
 	if h.Config.Authentication.Header.Enabled {
@@ -116,12 +168,101 @@ func (h *kubeRBACProxy) Handle(w http.ResponseWriter, req *http.Request) bool {
 	return true
 }
 
+type authorizationResult struct {
+	decision authorizer.Decision
+	reason   string
+	err      error
+	duration time.Duration
+}
+
+func (r authorizationResult) allowed() bool {
+	return r.err == nil && r.decision == authorizer.DecisionAllow
+}
+
+// authorizeAll authorizes every attribute set of a request. When there is
+// more than one, the checks run concurrently and the ones still in flight
+// are cancelled as soon as one of them is denied or fails, as the request is
+// going to be rejected anyway. The index of the check that rejected the
+// request is returned, or -1 if all of them were allowed.
+func (h *kubeRBACProxy) authorizeAll(ctx context.Context, allAttrs []authorizer.Attributes) ([]authorizationResult, int) {
+	results := make([]authorizationResult, len(allAttrs))
+	authorize := func(ctx context.Context, i int) {
+		start := time.Now()
+		decision, reason, err := h.Authorize(ctx, allAttrs[i])
+		results[i] = authorizationResult{decision: decision, reason: reason, err: err, duration: time.Since(start)}
+	}
+
+	if len(allAttrs) == 1 {
+		authorize(ctx, 0)
+		if !results[0].allowed() {
+			return results, 0
+		}
+		return results, -1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg     sync.WaitGroup
+		once   sync.Once
+		failed = -1
+	)
+	for i := range allAttrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			authorize(ctx, i)
+			if !results[i].allowed() {
+				once.Do(func() {
+					failed = i
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return results, failed
+}
+
 func newKubeRBACProxyAuthorizerAttributesGetter(authzConfig *authz.Config) *krpAuthorizerAttributesGetter {
-	return &krpAuthorizerAttributesGetter{authzConfig}
+	n := &krpAuthorizerAttributesGetter{authzConfig: authzConfig}
+	if ra := authzConfig.ResourceAttributes; ra != nil {
+		// The templates are parsed once instead of on every request.
+		for _, t := range []struct {
+			tmpl  **template.Template
+			value string
+		}{
+			{&n.templates.namespace, ra.Namespace},
+			{&n.templates.apiGroup, ra.APIGroup},
+			{&n.templates.apiVersion, ra.APIVersion},
+			{&n.templates.resource, ra.Resource},
+			{&n.templates.subresource, ra.Subresource},
+			{&n.templates.name, ra.Name},
+		} {
+			*t.tmpl, n.templateErr = template.New("valueTemplate").Parse(t.value)
+			if n.templateErr != nil {
+				klog.Errorf("Invalid resource attribute template %q, requests are rejected: %v", t.value, n.templateErr)
+				break
+			}
+		}
+	}
+	return n
 }
 
 type krpAuthorizerAttributesGetter struct {
 	authzConfig *authz.Config
+	// templates are the parsed resource attributes, templateErr the error
+	// parsing them.
+	templates   resourceAttributeTemplates
+	templateErr error
+}
+
+// resourceAttributeTemplates are templates of resource attributes, which are
+// rendered with the value of the rewrite.
+type resourceAttributeTemplates struct {
+	namespace, apiGroup, apiVersion, resource, subresource, name *template.Template
 }
 
 // GetRequestAttributes populates authorizer attributes for the requests to kube-rbac-proxy.
@@ -149,18 +290,42 @@ func (n krpAuthorizerAttributesGetter) GetRequestAttributes(u user.Info, r *http
 				return nil
 			}
 
+			if n.templateErr != nil {
+				return nil
+			}
+
+			// Repeated values would only cause redundant SubjectAccessReviews.
+			// The user is the same for all attributes, and may not be comparable.
+			seen := map[authorizer.AttributesRecord]bool{}
 			for _, param := range params {
 				attrs := authorizer.AttributesRecord{
 					User:            u,
 					Verb:            apiVerb,
-					Namespace:       templateWithValue(n.authzConfig.ResourceAttributes.Namespace, param),
-					APIGroup:        templateWithValue(n.authzConfig.ResourceAttributes.APIGroup, param),
-					APIVersion:      templateWithValue(n.authzConfig.ResourceAttributes.APIVersion, param),
-					Resource:        templateWithValue(n.authzConfig.ResourceAttributes.Resource, param),
-					Subresource:     templateWithValue(n.authzConfig.ResourceAttributes.Subresource, param),
-					Name:            templateWithValue(n.authzConfig.ResourceAttributes.Name, param),
 					ResourceRequest: true,
 				}
+				var err error
+				for _, t := range []struct {
+					tmpl  *template.Template
+					value *string
+				}{
+					{n.templates.namespace, &attrs.Namespace},
+					{n.templates.apiGroup, &attrs.APIGroup},
+					{n.templates.apiVersion, &attrs.APIVersion},
+					{n.templates.resource, &attrs.Resource},
+					{n.templates.subresource, &attrs.Subresource},
+					{n.templates.name, &attrs.Name},
+				} {
+					if *t.value, err = executeTemplate(t.tmpl, param); err != nil {
+						klog.V(2).Infof("Failed to render resource attribute template: %v", err)
+						return nil
+					}
+				}
+				key := attrs
+				key.User = nil
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
 				allAttrs = append(allAttrs, attrs)
 			}
 		} else {
@@ -179,6 +344,9 @@ func (n krpAuthorizerAttributesGetter) GetRequestAttributes(u user.Info, r *http
 		}
 	} else {
 		requestPath := r.URL.Path
+		if n.authzConfig.NonResourceURL != "" {
+			requestPath = n.authzConfig.NonResourceURL
+		}
 		// Default attributes mirror the API attributes that would allow this access to kube-rbac-proxy
 		attrs := authorizer.AttributesRecord{
 			User:            u,
@@ -245,9 +413,11 @@ func (c *Config) DeepCopy() *Config {
 	return res
 }
 
-func templateWithValue(templateString, value string) string {
-	tmpl, _ := template.New("valueTemplate").Parse(templateString)
+// executeTemplate renders tmpl with the value of a rewrite.
+func executeTemplate(tmpl *template.Template, value string) (string, error) {
 	out := bytes.NewBuffer(nil)
-	tmpl.Execute(out, struct{ Value string }{Value: value})
-	return out.String()
+	if err := tmpl.Execute(out, struct{ Value string }{Value: value}); err != nil {
+		return "", err
+	}
+	return out.String(), nil
 }
@@ -18,10 +18,14 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"text/template"
+	"time"
 
 	"github.com/brancz/kube-rbac-proxy/pkg/authn"
 	"github.com/brancz/kube-rbac-proxy/pkg/authz"
@@ -164,3 +168,237 @@ type testCase struct {
 	expected
 	description string
 }
+
+func TestGetRequestAttributesNonResourceURL(t *testing.T) {
+	u := &user.DefaultInfo{Name: "alice"}
+	req := httptest.NewRequest("GET", "/debug/pprof/heap", nil)
+
+	for _, tc := range []struct {
+		nonResourceURL string
+		want           string
+	}{
+		{"", "/debug/pprof/heap"},
+		{"/debug/kube-rbac-proxy", "/debug/kube-rbac-proxy"},
+	} {
+		attrs := newKubeRBACProxyAuthorizerAttributesGetter(&authz.Config{NonResourceURL: tc.nonResourceURL}).GetRequestAttributes(u, req)
+		if len(attrs) != 1 || attrs[0].GetPath() != tc.want || attrs[0].IsResourceRequest() {
+			t.Errorf("want non-resource attributes for %q, got %+v", tc.want, attrs)
+		}
+	}
+}
+
+func TestGetRequestAttributesDeduplicated(t *testing.T) {
+	u := &user.DefaultInfo{Name: "alice", Groups: []string{"a"}}
+	req := httptest.NewRequest("GET", "/metrics?namespace=a&namespace=b&namespace=a", nil)
+	cfg := &authz.Config{
+		Rewrites:           &authz.SubjectAccessReviewRewrites{ByQueryParameter: &authz.QueryParameterRewriteConfig{Name: "namespace"}},
+		ResourceAttributes: &authz.ResourceAttributes{Namespace: "{{ .Value }}", Resource: "pods"},
+	}
+
+	attrs := newKubeRBACProxyAuthorizerAttributesGetter(cfg).GetRequestAttributes(u, req)
+	var namespaces []string
+	for _, a := range attrs {
+		namespaces = append(namespaces, a.GetNamespace())
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(namespaces, want) {
+		t.Errorf("want attributes for namespaces %v, got %v", want, namespaces)
+	}
+}
+
+func TestGetRequestAttributesInvalidTemplate(t *testing.T) {
+	u := &user.DefaultInfo{Name: "alice"}
+	req := httptest.NewRequest("GET", "/metrics?namespace=a", nil)
+	rewrites := &authz.SubjectAccessReviewRewrites{ByQueryParameter: &authz.QueryParameterRewriteConfig{Name: "namespace"}}
+
+	for _, namespace := range []string{"{{ .Value", "{{ .Namespace }}"} {
+		cfg := &authz.Config{Rewrites: rewrites, ResourceAttributes: &authz.ResourceAttributes{Namespace: namespace, Resource: "pods"}}
+		if attrs := newKubeRBACProxyAuthorizerAttributesGetter(cfg).GetRequestAttributes(u, req); attrs != nil {
+			t.Errorf("want no attributes for template %q, got %+v", namespace, attrs)
+		}
+	}
+}
+
+func TestReloadable(t *testing.T) {
+	cfg := Config{
+		Authentication: &authn.AuthnConfig{Header: &authn.AuthnHeaderConfig{}},
+		Authorization:  &authz.Config{},
+	}
+	fakeUser := user.DefaultInfo{Name: "Foo Bar"}
+	proxy := NewReloadable(cfg, approver{}, fakeOIDCAuthenticator(t, &fakeUser))
+
+	req := fakeJWTRequest("GET", "/accounts", "Bearer VALID")
+	if !proxy.Handle(httptest.NewRecorder(), req) || req.Header.Get("user") != "" {
+		t.Fatalf("want request to be allowed without user header, got %q", req.Header.Get("user"))
+	}
+
+	proxy.Reload(Config{
+		Authentication: &authn.AuthnConfig{Header: &authn.AuthnHeaderConfig{Enabled: true, UserFieldName: "user"}},
+		Authorization:  &authz.Config{},
+	})
+
+	req = fakeJWTRequest("GET", "/accounts", "Bearer VALID")
+	if !proxy.Handle(httptest.NewRecorder(), req) || req.Header.Get("user") != fakeUser.Name {
+		t.Errorf("want reloaded config to set user header, got %q", req.Header.Get("user"))
+	}
+}
+
+func TestHandleCancelsRemainingChecksOnDenial(t *testing.T) {
+	cfg := Config{
+		Authentication: &authn.AuthnConfig{OIDC: &authn.OIDCConfig{}, Header: &authn.AuthnHeaderConfig{}},
+		Authorization: &authz.Config{
+			Rewrites:           &authz.SubjectAccessReviewRewrites{ByQueryParameter: &authz.QueryParameterRewriteConfig{Name: "namespace"}},
+			ResourceAttributes: &authz.ResourceAttributes{Namespace: "{{ .Value }}", Resource: "pods"},
+		},
+	}
+
+	slowErr := make(chan error, 1)
+	auth := authorizerFunc(func(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+		if a.GetNamespace() == "denied" {
+			return authorizer.DecisionDeny, "", nil
+		}
+		select {
+		case <-ctx.Done():
+			slowErr <- ctx.Err()
+			return authorizer.DecisionNoOpinion, "", ctx.Err()
+		case <-time.After(10 * time.Second):
+			slowErr <- nil
+			return authorizer.DecisionAllow, "", nil
+		}
+	})
+
+	proxy, err := New(testclient.NewSimpleClientset(), cfg, auth, fakeOIDCAuthenticator(t, &user.DefaultInfo{Name: "alice"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	proxy.Handle(w, fakeJWTRequest("GET", "/metrics?namespace=slow&namespace=denied", "Bearer VALID"))
+
+	if got := w.Result().StatusCode; got != http.StatusForbidden {
+		t.Errorf("want status %d, got %d", http.StatusForbidden, got)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("denial took %v, want the slow check to be cancelled", elapsed)
+	}
+	if err := <-slowErr; err != context.Canceled {
+		t.Errorf("want the slow check to see %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestHandleLatencyBudget(t *testing.T) {
+	waitForDeadline := func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			return errors.New("no deadline")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	allow := authorizerFunc(func(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+		return authorizer.DecisionAllow, "", nil
+	})
+	slowAuthz := authorizerFunc(func(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+		return authorizer.DecisionNoOpinion, "", waitForDeadline(ctx)
+	})
+	alice := authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		return &authenticator.Response{User: &user.DefaultInfo{Name: "alice"}}, true, nil
+	})
+	slowAuthn := authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		return nil, false, waitForDeadline(req.Context())
+	})
+
+	for _, tc := range []struct {
+		name          string
+		authenticator authenticator.Request
+		authorizer    authorizer.Authorizer
+		want          int
+	}{
+		{name: "within budget", authenticator: alice, authorizer: allow, want: http.StatusOK},
+		{name: "slow authentication", authenticator: slowAuthn, authorizer: allow, want: http.StatusGatewayTimeout},
+		{name: "slow authorization", authenticator: alice, authorizer: slowAuthz, want: http.StatusGatewayTimeout},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{
+				Authentication: &authn.AuthnConfig{Header: &authn.AuthnHeaderConfig{}},
+				Authorization:  &authz.Config{},
+				LatencyBudget:  LatencyBudget{Total: 100 * time.Millisecond, AuthenticationRatio: 0.5},
+			}
+			proxy, err := New(testclient.NewSimpleClientset(), cfg, tc.authorizer, tc.authenticator)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			start := time.Now()
+			if proxy.Handle(w, httptest.NewRequest("GET", "/metrics", nil)) {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			if got := w.Result().StatusCode; got != tc.want {
+				t.Errorf("want status %d, got %d", tc.want, got)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("request took %v, want it to fail within the budget", elapsed)
+			}
+		})
+	}
+}
+
+type authorizerFunc func(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error)
+
+func (f authorizerFunc) Authorize(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+	return f(ctx, a)
+}
+
+func BenchmarkGetRequestAttributes(b *testing.B) {
+	u := &user.DefaultInfo{Name: "alice", Groups: []string{"system:authenticated"}}
+	for _, bc := range []struct {
+		name string
+		cfg  *authz.Config
+		url  string
+	}{
+		{
+			name: "non-resource",
+			cfg:  &authz.Config{},
+			url:  "/metrics",
+		},
+		{
+			name: "resource",
+			cfg:  &authz.Config{ResourceAttributes: &authz.ResourceAttributes{Namespace: "default", Resource: "services", Subresource: "metrics"}},
+			url:  "/metrics",
+		},
+		{
+			name: "rewrites",
+			cfg: &authz.Config{
+				Rewrites:           &authz.SubjectAccessReviewRewrites{ByQueryParameter: &authz.QueryParameterRewriteConfig{Name: "namespace"}},
+				ResourceAttributes: &authz.ResourceAttributes{Namespace: "{{ .Value }}", Resource: "pods"},
+			},
+			url: "/metrics?namespace=a&namespace=b&namespace=c",
+		},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			getter := newKubeRBACProxyAuthorizerAttributesGetter(bc.cfg)
+			req := httptest.NewRequest("GET", bc.url, nil)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if attrs := getter.GetRequestAttributes(u, req); len(attrs) == 0 {
+					b.Fatal("no attributes")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkExecuteTemplate(b *testing.B) {
+	tmpl := template.Must(template.New("namespace").Parse("{{ .Value }}-metrics"))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := executeTemplate(tmpl, "monitoring"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
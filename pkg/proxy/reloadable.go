@@ -0,0 +1,52 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// Reloadable authenticates and authorizes requests like the proxy returned
+// by New, but its config can be replaced while serving requests. Each
+// request is handled entirely with the config current when it started.
+type Reloadable struct {
+	authenticator authenticator.Request
+	authorizer    authorizer.Authorizer
+	proxy         atomic.Value // *kubeRBACProxy
+}
+
+// NewReloadable returns a Reloadable proxy with the given initial config.
+func NewReloadable(config Config, authorizer authorizer.Authorizer, authenticator authenticator.Request) *Reloadable {
+	r := &Reloadable{authenticator: authenticator, authorizer: authorizer}
+	r.Reload(config)
+	return r
+}
+
+// Reload replaces the config of requests started from now on.
+func (r *Reloadable) Reload(config Config) {
+	r.proxy.Store(new(r.authenticator, r.authorizer, config))
+}
+
+// Handle authenticates and authorizes the request with the current config,
+// like the proxy returned by New.
+func (r *Reloadable) Handle(w http.ResponseWriter, req *http.Request) bool {
+	return r.proxy.Load().(*kubeRBACProxy).Handle(w, req)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package requestinfo passes what the proxy learned about a request while
+// authenticating and authorizing it to the filters handling the request.
+package requestinfo
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// Info describes the authentication and authorization of a request. It is
+// filled in while handling the request and not safe for concurrent use.
+type Info struct {
+	// ID identifies the request in responses, logs and audit events.
+	ID string
+	// Route is the name of the route the request matched.
+	Route string
+	// User is the authenticated user, nil if authentication failed.
+	User user.Info
+	// Attributes are the attributes authorized so far.
+	Attributes []authorizer.Attributes
+	// Decision is the last authorization decision made.
+	Decision authorizer.Decision
+	// Reason is the reason of the last authorization decision.
+	Reason string
+	// AuthenticationDuration is the time spent authenticating the request.
+	AuthenticationDuration time.Duration
+	// AuthorizationDuration is the time spent authorizing the request.
+	AuthorizationDuration time.Duration
+	// UpstreamDuration is the time until the upstream responded with headers,
+	// including retries.
+	UpstreamDuration time.Duration
+}
+
+type infoKey struct{}
+
+// WithInfo returns a context carrying an empty Info for the request.
+// If ctx already carries an Info, it is reused.
+func WithInfo(ctx context.Context) (context.Context, *Info) {
+	if info := From(ctx); info != nil {
+		return ctx, info
+	}
+
+	info := &Info{}
+	return context.WithValue(ctx, infoKey{}, info), info
+}
+
+// From returns the Info of the request, or nil if none is tracked.
+func From(ctx context.Context) *Info {
+	info, _ := ctx.Value(infoKey{}).(*Info)
+	return info
+}
+
+// SetUser records the authenticated user.
+func SetUser(ctx context.Context, u user.Info) {
+	if info := From(ctx); info != nil {
+		info.User = u
+	}
+}
+
+// AddDecision records an authorization decision on the attributes, which took duration.
+func AddDecision(ctx context.Context, attrs authorizer.Attributes, decision authorizer.Decision, reason string, duration time.Duration) {
+	if info := From(ctx); info != nil {
+		info.Attributes = append(info.Attributes, attrs)
+		info.Decision = decision
+		info.Reason = reason
+		info.AuthorizationDuration += duration
+	}
+}
+
+// AddAuthenticationDuration records time spent authenticating the request.
+func AddAuthenticationDuration(ctx context.Context, duration time.Duration) {
+	if info := From(ctx); info != nil {
+		info.AuthenticationDuration += duration
+	}
+}
+
+// AddUpstreamDuration records time spent waiting for the upstream.
+func AddUpstreamDuration(ctx context.Context, duration time.Duration) {
+	if info := From(ctx); info != nil {
+		info.UpstreamDuration += duration
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routing
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Hosts routes requests to handlers by the server name the client requested
+// via TLS SNI or, for requests without SNI, by the Host header.
+type Hosts struct {
+	handlers map[string]http.Handler
+	fallback http.Handler
+}
+
+// NewHosts creates a host router serving requests for unknown hosts with fallback.
+// If fallback is nil, requests for unknown hosts are answered with 404.
+func NewHosts(fallback http.Handler) *Hosts {
+	if fallback == nil {
+		fallback = http.NotFoundHandler()
+	}
+
+	return &Hosts{
+		handlers: map[string]http.Handler{},
+		fallback: fallback,
+	}
+}
+
+// Add registers handler for the given host name. A leading "*." matches
+// exactly one arbitrary label, e.g. "*.example.com" matches "foo.example.com".
+func (h *Hosts) Add(host string, handler http.Handler) {
+	h.handlers[strings.ToLower(host)] = handler
+}
+
+// Handler returns the handler registered for the given host name, or nil.
+func (h *Hosts) Handler(host string) http.Handler {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if handler, ok := h.handlers[host]; ok {
+		return handler
+	}
+
+	if i := strings.Index(host, "."); i > 0 {
+		if handler, ok := h.handlers["*"+host[i:]]; ok {
+			return handler
+		}
+	}
+
+	return nil
+}
+
+func (h *Hosts) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if handler := h.Handler(RequestHost(req)); handler != nil {
+		handler.ServeHTTP(w, req)
+		return
+	}
+
+	h.fallback.ServeHTTP(w, req)
+}
+
+// RequestHost returns the server name requested via TLS SNI, or the Host
+// header without port if the client didn't send SNI.
+func RequestHost(req *http.Request) string {
+	if req.TLS != nil && req.TLS.ServerName != "" {
+		return req.TLS.ServerName
+	}
+
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		return req.Host
+	}
+	return host
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routing
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHosts(t *testing.T) {
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			io.WriteString(w, name)
+		})
+	}
+
+	hosts := NewHosts(named("fallback"))
+	hosts.Add("a.example.com", named("a"))
+	hosts.Add("*.b.example.com", named("b"))
+
+	cases := []struct {
+		name string
+		host string
+		sni  string
+		want string
+	}{
+		{name: "exact host", host: "a.example.com", want: "a"},
+		{name: "host with port", host: "a.example.com:8443", want: "a"},
+		{name: "host case insensitive", host: "A.Example.com", want: "a"},
+		{name: "wildcard host", host: "foo.b.example.com", want: "b"},
+		{name: "wildcard matches single label only", host: "foo.bar.b.example.com", want: "fallback"},
+		{name: "unknown host", host: "c.example.com", want: "fallback"},
+		{name: "sni takes precedence", host: "c.example.com", sni: "a.example.com", want: "a"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = c.host
+			if c.sni != "" {
+				req.TLS = &tls.ConnectionState{ServerName: c.sni}
+			}
+
+			w := httptest.NewRecorder()
+			hosts.ServeHTTP(w, req)
+
+			if got := w.Body.String(); got != c.want {
+				t.Errorf("want request to be routed to %q, got %q", c.want, got)
+			}
+		})
+	}
+}
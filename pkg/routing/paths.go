@@ -0,0 +1,80 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routing
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Paths routes requests to handlers by the longest prefix matching the
+// request path.
+type Paths struct {
+	prefixes []string
+	handlers map[string]http.Handler
+	fallback http.Handler
+}
+
+// NewPaths creates a path router serving requests matching no prefix with
+// fallback. If fallback is nil, these requests are answered with 404.
+func NewPaths(fallback http.Handler) *Paths {
+	if fallback == nil {
+		fallback = http.NotFoundHandler()
+	}
+	return &Paths{
+		handlers: map[string]http.Handler{},
+		fallback: fallback,
+	}
+}
+
+// Add registers handler for the given path prefix. Unless the prefix ends
+// with "/", it only matches whole path segments, e.g. "/api" matches "/api"
+// and "/api/v1" but not "/apis".
+func (p *Paths) Add(prefix string, handler http.Handler) {
+	if _, ok := p.handlers[prefix]; !ok {
+		p.prefixes = append(p.prefixes, prefix)
+		sort.Slice(p.prefixes, func(i, j int) bool { return len(p.prefixes[i]) > len(p.prefixes[j]) })
+	}
+	p.handlers[prefix] = handler
+}
+
+// Handler returns the handler registered for the longest prefix matching
+// path, or nil.
+func (p *Paths) Handler(path string) http.Handler {
+	for _, prefix := range p.prefixes {
+		if matchesPrefix(path, prefix) {
+			return p.handlers[prefix]
+		}
+	}
+	return nil
+}
+
+func (p *Paths) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if handler := p.Handler(req.URL.Path); handler != nil {
+		handler.ServeHTTP(w, req)
+		return
+	}
+	p.fallback.ServeHTTP(w, req)
+}
+
+func matchesPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return strings.HasSuffix(prefix, "/") || len(path) == len(prefix) || path[len(prefix)] == '/'
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routing
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPaths(t *testing.T) {
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			io.WriteString(w, name)
+		})
+	}
+
+	paths := NewPaths(named("fallback"))
+	paths.Add("/api", named("api"))
+	paths.Add("/api/v2", named("v2"))
+	paths.Add("/static/", named("static"))
+
+	cases := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "exact prefix", path: "/api", want: "api"},
+		{name: "below prefix", path: "/api/v1/query", want: "api"},
+		{name: "longest prefix", path: "/api/v2/query", want: "v2"},
+		{name: "partial segment", path: "/apis", want: "fallback"},
+		{name: "trailing slash prefix", path: "/static/app.js", want: "static"},
+		{name: "trailing slash prefix without slash", path: "/static", want: "fallback"},
+		{name: "unknown path", path: "/metrics", want: "fallback"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			paths.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+			if got := w.Body.String(); got != c.want {
+				t.Errorf("want request to be routed to %q, got %q", c.want, got)
+			}
+		})
+	}
+}
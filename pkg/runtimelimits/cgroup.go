@@ -0,0 +1,109 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtimelimits
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup hierarchy of the container is mounted.
+// With cgroup namespaces, as is the default for containers on cgroup v2,
+// its root is the container's cgroup.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// unlimited is the threshold above which cgroup v1 limits are unset, they
+// are then the maximum int64 rounded down to the page size.
+const unlimited = 1 << 62
+
+// cpuQuota returns the number of CPUs the cgroup may use, or 0 if it isn't
+// limited.
+func cpuQuota(root string) (float64, error) {
+	if isCgroupV2(root) {
+		fields, err := readFields(filepath.Join(root, "cpu.max"))
+		if err != nil || len(fields) != 2 || fields[0] == "max" {
+			return 0, err
+		}
+		return quotaOf(fields[0], fields[1])
+	}
+
+	quota, err := readFields(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+	if err != nil || len(quota) != 1 || quota[0] == "-1" {
+		return 0, err
+	}
+	period, err := readFields(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if err != nil || len(period) != 1 {
+		return 0, err
+	}
+	return quotaOf(quota[0], period[0])
+}
+
+// memoryLimit returns the memory limit of the cgroup in bytes, or 0 if it
+// isn't limited.
+func memoryLimit(root string) (int64, error) {
+	path := filepath.Join(root, "memory", "memory.limit_in_bytes")
+	if isCgroupV2(root) {
+		path = filepath.Join(root, "memory.max")
+	}
+
+	fields, err := readFields(path)
+	if err != nil || len(fields) != 1 || fields[0] == "max" {
+		return 0, err
+	}
+	limit, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory limit in %s: %v", path, err)
+	}
+	if limit >= unlimited {
+		return 0, nil
+	}
+	return limit, nil
+}
+
+func isCgroupV2(root string) bool {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return err == nil
+}
+
+func quotaOf(quota, period string) (float64, error) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CPU quota %q: %v", quota, err)
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, fmt.Errorf("invalid CPU period %q", period)
+	}
+	return q / p, nil
+}
+
+// readFields returns the whitespace separated fields of a file, or none if
+// it doesn't exist, e.g. outside of containers.
+func readFields(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(b)), nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtimelimits
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroup(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestLimits(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		files  map[string]string
+		cpu    float64
+		memory int64
+	}{
+		{
+			name: "no cgroup",
+		},
+		{
+			name: "cgroup v2",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory\n",
+				"cpu.max":            "150000 100000\n",
+				"memory.max":         "268435456\n",
+			},
+			cpu:    1.5,
+			memory: 268435456,
+		},
+		{
+			name: "cgroup v2 unlimited",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory\n",
+				"cpu.max":            "max 100000\n",
+				"memory.max":         "max\n",
+			},
+		},
+		{
+			name: "cgroup v1",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "50000\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"memory/memory.limit_in_bytes": "134217728\n",
+			},
+			cpu:    0.5,
+			memory: 134217728,
+		},
+		{
+			name: "cgroup v1 unlimited",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "-1\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			root := writeCgroup(t, c.files)
+			defer os.RemoveAll(root)
+
+			cpu, err := cpuQuota(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cpu != c.cpu {
+				t.Errorf("want CPU quota %v, got %v", c.cpu, cpu)
+			}
+
+			memory, err := memoryLimit(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if memory != c.memory {
+				t.Errorf("want memory limit %d, got %d", c.memory, memory)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runtimelimits sizes the Go runtime to the resource limits of the
+// container, as read from its cgroup: GOMAXPROCS to the CPU quota, so a
+// sidecar with a small CPU limit isn't throttled for running more threads
+// than its quota allows, and the soft memory limit to a share of the memory
+// limit, so the garbage collector works harder before the container is
+// OOM-killed.
+package runtimelimits
+
+import (
+	"math"
+	"os"
+	"runtime"
+
+	"k8s.io/klog/v2"
+)
+
+// Config configures the runtime limits.
+type Config struct {
+	// GOMAXPROCS, if not 0, is used instead of deriving it from the CPU
+	// quota.
+	GOMAXPROCS int
+	// MemoryLimitBytes, if not 0, is used instead of deriving the soft
+	// memory limit from the container's memory limit.
+	MemoryLimitBytes int64
+	// MemoryLimitRatio is the share of the container's memory limit used as
+	// the soft memory limit. 0 disables deriving it.
+	MemoryLimitRatio float64
+}
+
+// Apply sets GOMAXPROCS and the soft memory limit of the Go runtime. Values
+// derived from the container's limits don't override the GOMAXPROCS and
+// GOMEMLIMIT environment variables.
+func Apply(cfg Config) {
+	procs := cfg.GOMAXPROCS
+	if procs == 0 && os.Getenv("GOMAXPROCS") == "" {
+		quota, err := cpuQuota(cgroupRoot)
+		if err != nil {
+			klog.Warningf("Failed to read the CPU quota: %v", err)
+		}
+		if quota > 0 {
+			procs = int(math.Max(1, math.Floor(quota)))
+		}
+	}
+	if procs > 0 {
+		runtime.GOMAXPROCS(procs)
+		klog.Infof("Set GOMAXPROCS to %d", procs)
+	}
+
+	limit := cfg.MemoryLimitBytes
+	if limit == 0 && cfg.MemoryLimitRatio > 0 && os.Getenv("GOMEMLIMIT") == "" {
+		memory, err := memoryLimit(cgroupRoot)
+		if err != nil {
+			klog.Warningf("Failed to read the memory limit: %v", err)
+		}
+		limit = int64(float64(memory) * cfg.MemoryLimitRatio)
+	}
+	if limit > 0 {
+		if !setMemoryLimit(limit) {
+			klog.Warningf("Not setting the memory limit of %d bytes, this requires Go 1.19 or later", limit)
+			return
+		}
+		klog.Infof("Set the memory limit of the Go runtime to %d bytes", limit)
+	}
+}
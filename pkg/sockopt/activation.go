@@ -0,0 +1,171 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sockopt
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// InheritedPrefix marks listen addresses referring to inherited listeners,
+	// e.g. "fd:https" or "fd:3".
+	InheritedPrefix = "fd:"
+
+	// listenFDsStart is the first file descriptor passed by the service manager.
+	listenFDsStart = 3
+)
+
+// Listeners hands out listeners for listen addresses. Addresses prefixed with
+// InheritedPrefix refer to listeners passed by the service manager following the
+// systemd socket activation convention (LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES),
+// all other addresses are listened on, unless a listener for the address was
+// handed off by the previous process, see Handoff.
+type Listeners struct {
+	cfg       Config
+	inherited map[string]*os.File
+
+	mu        sync.Mutex
+	handedOff map[string]*os.File
+	ready     *os.File
+	listening []handoffListener
+}
+
+// NewListeners returns Listeners applying the given socket options. Inherited
+// and handed off file descriptors are taken over from the environment, which is
+// cleared so child processes don't pick them up again.
+func NewListeners(cfg Config) (*Listeners, error) {
+	inherited, err := inheritedFiles(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	handedOff, ready, handoffErr := handedOffFiles(os.Getenv(handoffPIDEnv), os.Getenv(handoffAddressesEnv), os.Getenv(handoffReadyFDEnv))
+
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", handoffPIDEnv, handoffAddressesEnv, handoffReadyFDEnv} {
+		_ = os.Unsetenv(name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if handoffErr != nil {
+		return nil, handoffErr
+	}
+
+	return &Listeners{cfg: cfg, inherited: inherited, handedOff: handedOff, ready: ready}, nil
+}
+
+// Listen returns a listener for the address. Inherited listeners are selected by
+// their name in LISTEN_FDNAMES, or their file descriptor number, and can only be
+// taken once.
+func (ls *Listeners) Listen(address string) (net.Listener, error) {
+	l, err := ls.listen(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ls.mu.Lock()
+	ls.listening = append(ls.listening, handoffListener{address: address, listener: l})
+	ls.mu.Unlock()
+
+	return l, nil
+}
+
+func (ls *Listeners) listen(address string) (net.Listener, error) {
+	ls.mu.Lock()
+	f, ok := ls.handedOff[address]
+	delete(ls.handedOff, address)
+	ls.mu.Unlock()
+
+	if ok {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("handed off file descriptor for %s is not a listening socket: %v", address, err)
+		}
+		return &listener{Listener: l, noDelay: ls.cfg.NoDelay}, nil
+	}
+
+	if !strings.HasPrefix(address, InheritedPrefix) {
+		return Listen(TCPNetwork(address), address, ls.cfg)
+	}
+
+	name := strings.TrimPrefix(address, InheritedPrefix)
+	f, ok = ls.inherited[name]
+	if !ok {
+		return nil, fmt.Errorf("no inherited listener named %q", name)
+	}
+	for n, other := range ls.inherited {
+		if other == f {
+			delete(ls.inherited, n)
+		}
+	}
+
+	l, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("inherited file descriptor %q is not a listening socket: %v", name, err)
+	}
+
+	return &listener{Listener: l, noDelay: ls.cfg.NoDelay}, nil
+}
+
+// inheritedFiles returns the files passed by the service manager keyed by their
+// file descriptor number and, if unique, their name.
+func inheritedFiles(pid, fds, names string) (map[string]*os.File, error) {
+	files := map[string]*os.File{}
+	if pid == "" || fds == "" {
+		return files, nil
+	}
+
+	p, err := strconv.Atoi(pid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_PID %q: %v", pid, err)
+	}
+	if p != os.Getpid() {
+		// Passed to another process, e.g. our parent.
+		return files, nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	counts := map[string]int{}
+	for _, name := range fdNames {
+		counts[name]++
+	}
+
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		files[strconv.Itoa(fd)] = f
+
+		if i < len(fdNames) && fdNames[i] != "" && counts[fdNames[i]] == 1 {
+			files[fdNames[i]] = f
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sockopt
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestInheritedFiles(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	files, err := inheritedFiles(pid, "3", "https:metrics:metrics")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	for _, name := range []string{"3", "4", "5", "https"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("want inherited file %q, got none", name)
+		}
+	}
+	if files["https"] != files["3"] {
+		t.Error("want name and number to refer to the same file")
+	}
+	if _, ok := files["metrics"]; ok {
+		t.Error("want ambiguous name to be skipped")
+	}
+
+	files, err = inheritedFiles("1", "3", "")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("want no files for another process, got %d", len(files))
+	}
+
+	if _, err := inheritedFiles(pid, "foo", ""); err == nil {
+		t.Error("expected error for invalid LISTEN_FDS, got nil")
+	}
+}
+
+func TestListenersInherited(t *testing.T) {
+	ls := &Listeners{inherited: map[string]*os.File{}}
+	if _, err := ls.Listen("fd:https"); err == nil {
+		t.Error("expected error for unknown inherited listener, got nil")
+	}
+
+	l, err := ls.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	defer l.Close()
+
+	f, err := l.(*listener).Listener.(interface{ File() (*os.File, error) }).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls.inherited = map[string]*os.File{"3": f, "https": f}
+
+	inherited, err := ls.Listen("fd:https")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	defer inherited.Close()
+
+	if inherited.Addr().String() != l.Addr().String() {
+		t.Errorf("want inherited listener on %v, got %v", l.Addr(), inherited.Addr())
+	}
+	if _, err := ls.Listen("fd:3"); err == nil {
+		t.Error("expected error for listener taken twice, got nil")
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package sockopt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// handoffPIDEnv holds the PID of the process handing off its listeners.
+	handoffPIDEnv = "KUBE_RBAC_PROXY_HANDOFF_PID"
+	// handoffAddressesEnv holds the comma separated listen addresses of the
+	// handed off listeners, passed as file descriptors starting at 3.
+	handoffAddressesEnv = "KUBE_RBAC_PROXY_HANDOFF_ADDRESSES"
+	// handoffReadyFDEnv holds the file descriptor the new process closes once
+	// it took over the listeners.
+	handoffReadyFDEnv = "KUBE_RBAC_PROXY_HANDOFF_READY_FD"
+)
+
+// handoffCommand returns the command started to take over the listeners.
+// Replaced in tests.
+var handoffCommand = defaultHandoffCommand
+
+// defaultHandoffCommand runs the running binary with the same arguments.
+func defaultHandoffCommand() (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(exe, os.Args[1:]...), nil
+}
+
+type handoffListener struct {
+	address  string
+	listener net.Listener
+}
+
+// Handoff starts a new process of the running binary with the same arguments
+// and passes it all listeners handed out so far. It returns once the new process
+// signals that it took over the listeners and returns its PID, after which the caller should stop
+// accepting and drain its connections. If the new process exits or doesn't
+// become ready within timeout, it is killed and an error is returned, the
+// listeners stay with the caller.
+func (ls *Listeners) Handoff(timeout time.Duration) (int, error) {
+	ls.mu.Lock()
+	listening := append([]handoffListener(nil), ls.listening...)
+	ls.mu.Unlock()
+
+	files := make([]*os.File, 0, len(listening)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	addresses := make([]string, 0, len(listening))
+	for _, hl := range listening {
+		f, err := listenerFile(hl.listener)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get file of listener %s: %v", hl.address, err)
+		}
+		files = append(files, f)
+		addresses = append(addresses, hl.address)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create ready pipe: %v", err)
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	cmd, err := handoffCommand()
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine command: %v", err)
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	cmd.ExtraFiles = files
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env,
+		handoffPIDEnv+"="+strconv.Itoa(os.Getpid()),
+		handoffAddressesEnv+"="+strings.Join(addresses, ","),
+		handoffReadyFDEnv+"="+strconv.Itoa(listenFDsStart+len(files)-1),
+	)
+
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start new process: %v", err)
+	}
+	// Only the new process must hold the write end, so the read below ends
+	// once it closes it or exits.
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		// The new process writes a byte once ready, the pipe is closed
+		// without one if it exits before.
+		_, err := io.ReadFull(readyR, make([]byte, 1))
+		ready <- err
+	}()
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			_ = cmd.Process.Kill()
+			return 0, fmt.Errorf("new process didn't take over the listeners: %v", err)
+		}
+		return cmd.Process.Pid, nil
+	case err := <-exited:
+		return 0, fmt.Errorf("new process exited before taking over the listeners: %v", err)
+	case <-timer.C:
+		_ = cmd.Process.Kill()
+		return 0, fmt.Errorf("new process didn't take over the listeners within %v", timeout)
+	}
+}
+
+// Ready signals the process which handed off its listeners that they were taken
+// over, once all listeners are set up. Handed off listeners which weren't taken
+// over, e.g. because the listen address was changed, are closed.
+func (ls *Listeners) Ready() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for address, f := range ls.handedOff {
+		f.Close()
+		delete(ls.handedOff, address)
+	}
+	if ls.ready != nil {
+		if _, err := ls.ready.Write([]byte{1}); err != nil {
+			klog.Errorf("failed to signal taking over the handed off listeners: %v", err)
+		}
+		ls.ready.Close()
+		ls.ready = nil
+	}
+}
+
+// handedOffFiles returns the listener files passed by the process with the
+// given PID keyed by their listen address, and the file to close once they were
+// taken over.
+func handedOffFiles(pid, addresses, readyFD string) (map[string]*os.File, *os.File, error) {
+	files := map[string]*os.File{}
+	if pid == "" {
+		return files, nil, nil
+	}
+
+	p, err := strconv.Atoi(pid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s %q: %v", handoffPIDEnv, pid, err)
+	}
+	if p != os.Getppid() {
+		// Passed to our parent by another process.
+		return files, nil, nil
+	}
+
+	fd, err := strconv.Atoi(readyFD)
+	if err != nil || fd < listenFDsStart {
+		return nil, nil, fmt.Errorf("invalid %s %q", handoffReadyFDEnv, readyFD)
+	}
+
+	var addrs []string
+	if addresses != "" {
+		addrs = strings.Split(addresses, ",")
+	}
+	if listenFDsStart+len(addrs) != fd {
+		return nil, nil, fmt.Errorf("%s %q doesn't match the %d handed off listeners", handoffReadyFDEnv, readyFD, len(addrs))
+	}
+
+	for i, address := range addrs {
+		fd := listenFDsStart + i
+		files[address] = os.NewFile(uintptr(fd), fmt.Sprintf("HANDOFF_FD_%d", fd))
+	}
+
+	return files, os.NewFile(uintptr(fd), "HANDOFF_READY"), nil
+}
+
+// listenerFile returns a duplicate of the listener's file descriptor.
+func listenerFile(l net.Listener) (*os.File, error) {
+	if w, ok := l.(*listener); ok {
+		l = w.Listener
+	}
+
+	filer, ok := l.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, errors.New("listener has no file descriptor")
+	}
+	return filer.File()
+}
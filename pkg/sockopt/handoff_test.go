@@ -0,0 +1,150 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sockopt
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const handoffHelperEnv = "SOCKOPT_HANDOFF_HELPER_ADDRESS"
+
+// TestHandoffHelperProcess takes over the listener when run by TestHandoff and
+// answers a single connection.
+func TestHandoffHelperProcess(t *testing.T) {
+	address := os.Getenv(handoffHelperEnv)
+	if address == "" {
+		t.Skip("only run as helper process")
+	}
+
+	ls, err := NewListeners(Config{})
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if len(ls.handedOff) != 1 {
+		t.Fatalf("want 1 handed off listener, got %d", len(ls.handedOff))
+	}
+	l, err := ls.Listen(address)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	ls.Ready()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte(strconv.Itoa(os.Getpid()) + "\n"))
+}
+
+func TestHandoff(t *testing.T) {
+	address := "127.0.0.1:0"
+	handoffCommand = func() (*exec.Cmd, error) {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHandoffHelperProcess$")
+		cmd.Env = append(os.Environ(), handoffHelperEnv+"="+address)
+		cmd.Stdout, cmd.Stderr = ioutil.Discard, ioutil.Discard
+		return cmd, nil
+	}
+	defer func() { handoffCommand = defaultHandoffCommand }()
+
+	ls, err := NewListeners(Config{})
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	l, err := ls.Listen(address)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	pid, err := ls.Handoff(10 * time.Second)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	defer conn.Close()
+
+	got, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if want := strconv.Itoa(pid) + "\n"; got != want {
+		t.Errorf("want connection answered by process %q, got %q", want, got)
+	}
+}
+
+func TestHandoffFailure(t *testing.T) {
+	handoffCommand = func() (*exec.Cmd, error) {
+		cmd := exec.Command(os.Args[0], "-test.run=^$")
+		cmd.Stdout, cmd.Stderr = ioutil.Discard, ioutil.Discard
+		return cmd, nil
+	}
+	defer func() { handoffCommand = defaultHandoffCommand }()
+
+	ls, err := NewListeners(Config{})
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	l, err := ls.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	defer l.Close()
+
+	if _, err := ls.Handoff(10 * time.Second); err == nil {
+		t.Error("expected error for a process exiting without taking over, got nil")
+	}
+}
+
+func TestHandedOffFiles(t *testing.T) {
+	ppid := strconv.Itoa(os.Getppid())
+
+	files, ready, err := handedOffFiles(ppid, ":8443,:9090", "5")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if len(files) != 2 || files[":8443"] == nil || files[":9090"] == nil || ready == nil {
+		t.Errorf("want 2 handed off files and a ready file, got %v and %v", files, ready)
+	}
+
+	files, _, err = handedOffFiles("1", ":8443", "4")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("want no files for another process, got %d", len(files))
+	}
+
+	if _, _, err := handedOffFiles(ppid, ":8443", "5"); err == nil {
+		t.Error("expected error for mismatching ready file descriptor, got nil")
+	}
+}
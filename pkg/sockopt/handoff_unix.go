@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sockopt
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// HandoffOnSignal hands off the listeners to a new process whenever the process
+// receives SIGUSR1, until ctx is done. It returns once the listeners were handed
+// off, failed handoffs are logged and the listeners kept.
+func (ls *Listeners) HandoffOnSignal(ctx context.Context, timeout time.Duration) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-sig:
+			klog.Info("received SIGUSR1, handing off listeners to a new process")
+			pid, err := ls.Handoff(timeout)
+			if err != nil {
+				klog.Errorf("failed to hand off listeners, continuing to serve: %v", err)
+				continue
+			}
+			klog.Infof("handed off listeners to process %d, shutting down", pid)
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
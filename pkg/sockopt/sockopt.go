@@ -0,0 +1,124 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sockopt
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Config holds socket options applied to listeners and dialed connections.
+type Config struct {
+	// KeepAlive is the TCP keep-alive period. Zero uses the Go default,
+	// a negative value disables keep-alives.
+	KeepAlive time.Duration
+	// NoDelay controls TCP_NODELAY, i.e. whether Nagle's algorithm is disabled.
+	NoDelay bool
+	// ReusePort sets SO_REUSEPORT on listening sockets, allowing several
+	// processes to bind the same address.
+	ReusePort bool
+}
+
+// Listen announces on the local network address applying the configured socket options.
+func Listen(network, address string, cfg Config) (net.Listener, error) {
+	lc := net.ListenConfig{
+		KeepAlive: cfg.KeepAlive,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if !cfg.ReusePort {
+				return nil
+			}
+			return setReusePort(c)
+		},
+	}
+
+	l, err := lc.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &listener{Listener: l, noDelay: cfg.NoDelay}, nil
+}
+
+// TCPNetwork returns the network to listen on the address with, "tcp4" or "tcp6" for
+// addresses with an IPv4 or IPv6 literal host, "tcp" otherwise.
+//
+// Sockets listening with "tcp6" only accept IPv6 connections, so the IPv4 and IPv6
+// wildcard addresses of the same port can be listened on side by side.
+func TCPNetwork(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "tcp"
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+// DialContext returns a dial function for the given dialer applying the configured socket options.
+func DialContext(d *net.Dialer, cfg Config) func(ctx context.Context, network, address string) (net.Conn, error) {
+	d.KeepAlive = cfg.KeepAlive
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := setNoDelay(conn, cfg.NoDelay); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+type listener struct {
+	net.Listener
+	noDelay bool
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setNoDelay(conn, l.noDelay); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func setNoDelay(conn net.Conn, noDelay bool) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	return tcpConn.SetNoDelay(noDelay)
+}
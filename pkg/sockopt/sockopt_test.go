@@ -0,0 +1,35 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sockopt
+
+import "testing"
+
+func TestTCPNetwork(t *testing.T) {
+	for addr, want := range map[string]string{
+		":8443":          "tcp",
+		"localhost:8443": "tcp",
+		"0.0.0.0:8443":   "tcp4",
+		"10.0.0.1:8443":  "tcp4",
+		"[::]:8443":      "tcp6",
+		"[fd00::1]:8443": "tcp6",
+		"invalid":        "tcp",
+	} {
+		if got := TCPNetwork(addr); got != want {
+			t.Errorf("TCPNetwork(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
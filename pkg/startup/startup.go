@@ -0,0 +1,178 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package startup decides what happens when a dependency of the proxy is
+// unavailable while it starts up.
+package startup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Policy is how a failing dependency is handled at startup.
+type Policy string
+
+// Policies for failing dependencies.
+const (
+	// Crash exits immediately.
+	Crash Policy = "crash"
+	// Retry retries with exponential backoff and exits once the retry
+	// timeout is exceeded.
+	Retry Policy = "retry"
+	// Degraded starts anyway, with readiness failing until the dependency
+	// becomes available.
+	Degraded Policy = "degraded"
+)
+
+// Dependencies checked at startup.
+const (
+	KubeAPIServer = "kube-apiserver"
+	Upstream      = "upstream"
+	Certificate   = "certificate"
+)
+
+var (
+	dependencies = []string{KubeAPIServer, Upstream, Certificate}
+	policies     = []Policy{Crash, Retry, Degraded}
+)
+
+// Backoff between retries, variables to be overridden in tests.
+var (
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// Config configures the startup failure policies.
+type Config struct {
+	// Policies maps dependencies to their policy. Dependencies without a
+	// policy aren't checked at startup.
+	Policies map[string]string
+	// RetryTimeout is how long the retry policy retries before giving up.
+	RetryTimeout time.Duration
+}
+
+// Validate returns an error if cfg has unknown dependencies or policies.
+func (cfg Config) Validate() error {
+	for dep, p := range cfg.Policies {
+		if !contains(dependencies, dep) {
+			return fmt.Errorf("unknown dependency %q, must be one of %s", dep, strings.Join(dependencies, ", "))
+		}
+		if !validPolicy(Policy(p)) {
+			return fmt.Errorf("unknown policy %q for %s, must be one of crash, retry, degraded", p, dep)
+		}
+	}
+	if cfg.RetryTimeout <= 0 {
+		return fmt.Errorf("retry timeout must be positive")
+	}
+	return nil
+}
+
+// Policy returns the policy of dep, and whether one is configured.
+func (cfg Config) Policy(dep string) (Policy, bool) {
+	p, ok := cfg.Policies[dep]
+	return Policy(p), ok
+}
+
+// SetFunc records the state of a readiness condition, a nil error meaning it
+// is met.
+type SetFunc func(name string, err error)
+
+// Check runs check for dep and applies its policy if it fails. An error is
+// returned if the proxy must not start. With the degraded policy, the
+// readiness condition dep is set until check succeeds, which is retried in
+// the background until ctx is done.
+func (cfg Config) Check(ctx context.Context, dep string, check func(context.Context) error, set SetFunc) error {
+	policy, ok := cfg.Policy(dep)
+	if !ok {
+		return nil
+	}
+
+	err := check(ctx)
+	if err == nil {
+		return nil
+	}
+
+	switch policy {
+	case Retry:
+		klog.Warningf("%s is unavailable, retrying for up to %v: %v", dep, cfg.RetryTimeout, err)
+		ctx, cancel := context.WithTimeout(ctx, cfg.RetryTimeout)
+		defer cancel()
+		if err := retry(ctx, check, err); err != nil {
+			return fmt.Errorf("%s is unavailable after %v: %v", dep, cfg.RetryTimeout, err)
+		}
+		klog.Infof("%s is available", dep)
+		return nil
+	case Degraded:
+		klog.Warningf("%s is unavailable, starting degraded: %v", dep, err)
+		set(dep, err)
+		go func() {
+			if err := retry(ctx, check, err); err != nil {
+				return
+			}
+			klog.Infof("%s is available", dep)
+			set(dep, nil)
+		}()
+		return nil
+	default:
+		return fmt.Errorf("%s is unavailable: %v", dep, err)
+	}
+}
+
+// retry calls check with exponential backoff until it succeeds, returning the
+// last error once ctx is done. err is the error of the first, failed check.
+func retry(ctx context.Context, check func(context.Context) error, err error) error {
+	backoff := initialBackoff
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+
+		if err = check(ctx); err == nil {
+			return nil
+		}
+		klog.V(4).Infof("Startup check failed, retrying in %v: %v", backoff, err)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func validPolicy(p Policy) bool {
+	for _, valid := range policies {
+		if p == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package startup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	initialBackoff = time.Millisecond
+	maxBackoff = 5 * time.Millisecond
+}
+
+// failingCheck fails n times before succeeding.
+func failingCheck(n int) func(context.Context) error {
+	var mu sync.Mutex
+	return func(context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if n > 0 {
+			n--
+			return errors.New("unavailable")
+		}
+		return nil
+	}
+}
+
+type conditions struct {
+	mu  sync.Mutex
+	set map[string]error
+}
+
+func (c *conditions) Set(name string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set[name] = err
+}
+
+func (c *conditions) Get(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.set[name]
+}
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   string
+		failures int
+		wantErr  bool
+	}{
+		{name: "no policy", failures: 1000},
+		{name: "crash available", policy: "crash"},
+		{name: "crash", policy: "crash", failures: 1, wantErr: true},
+		{name: "retry", policy: "retry", failures: 3},
+		{name: "retry timeout", policy: "retry", failures: 1000, wantErr: true},
+		{name: "degraded", policy: "degraded", failures: 1000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{RetryTimeout: 100 * time.Millisecond}
+			if tc.policy != "" {
+				cfg.Policies = map[string]string{Upstream: tc.policy}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			c := &conditions{set: map[string]error{}}
+			err := cfg.Check(ctx, Upstream, failingCheck(tc.failures), c.Set)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("want error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckDegradedRecovers(t *testing.T) {
+	cfg := Config{
+		Policies:     map[string]string{KubeAPIServer: string(Degraded)},
+		RetryTimeout: time.Minute,
+	}
+	c := &conditions{set: map[string]error{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := cfg.Check(ctx, KubeAPIServer, failingCheck(3), c.Set); err != nil {
+		t.Fatal(err)
+	}
+	if c.Get(KubeAPIServer) == nil {
+		t.Fatal("expected readiness condition to fail")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Get(KubeAPIServer) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("readiness condition wasn't cleared")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		policies map[string]string
+		wantErr  bool
+	}{
+		{policies: nil},
+		{policies: map[string]string{KubeAPIServer: "retry", Upstream: "degraded", Certificate: "crash"}},
+		{policies: map[string]string{"database": "retry"}, wantErr: true},
+		{policies: map[string]string{Upstream: "ignore"}, wantErr: true},
+	} {
+		err := Config{Policies: tc.policies, RetryTimeout: time.Minute}.Validate()
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%v: want error %v, got %v", tc.policies, tc.wantErr, err)
+		}
+	}
+}
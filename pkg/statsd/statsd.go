@@ -0,0 +1,272 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package statsd pushes the proxy's Prometheus metrics to a statsd or
+// DogStatsD server, for environments where metrics can't be scraped.
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// FormatStatsd appends label values to metric names, e.g.
+	// "kube_rbac_proxy.request_bytes_total.default:42|c".
+	FormatStatsd = "statsd"
+	// FormatDogStatsD sends labels as tags, e.g.
+	// "kube_rbac_proxy.request_bytes_total:42|c|#route:default".
+	FormatDogStatsD = "dogstatsd"
+
+	// maxPacketSize keeps packets below the common MTU of 1500 bytes.
+	maxPacketSize = 1432
+)
+
+// Config configures pushing metrics.
+type Config struct {
+	// Address is the host:port of the statsd server.
+	Address string
+	// Format is FormatStatsd or FormatDogStatsD.
+	Format string
+	// Prefix is prepended to all metric names.
+	Prefix string
+	// Interval is the time between pushes.
+	Interval time.Duration
+}
+
+// Pusher periodically pushes metrics to a statsd server. Counters are sent
+// as the increase since the last push, gauges with their current value.
+// Histograms and summaries are sent as their count and sum.
+type Pusher struct {
+	cfg      Config
+	gatherer prometheus.Gatherer
+	conn     net.Conn
+
+	// last holds the values of counters at the last push.
+	last map[string]float64
+}
+
+// NewPusher returns a pusher sending the metrics of gatherer over UDP.
+func NewPusher(cfg Config, gatherer prometheus.Gatherer) (*Pusher, error) {
+	if cfg.Format != FormatStatsd && cfg.Format != FormatDogStatsD {
+		return nil, fmt.Errorf("unknown statsd format %q, must be %q or %q", cfg.Format, FormatStatsd, FormatDogStatsD)
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("statsd push interval must be positive, got %v", cfg.Interval)
+	}
+
+	conn, err := net.Dial("udp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to statsd server: %v", err)
+	}
+
+	return &Pusher{
+		cfg:      cfg,
+		gatherer: gatherer,
+		conn:     conn,
+		last:     map[string]float64{},
+	}, nil
+}
+
+// Run pushes metrics every interval until ctx is done, and a last time
+// before returning.
+func (p *Pusher) Run(ctx context.Context) error {
+	defer p.conn.Close()
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			p.pushLogged()
+			return nil
+		}
+		p.pushLogged()
+	}
+}
+
+func (p *Pusher) pushLogged() {
+	if err := p.Push(); err != nil {
+		klog.Errorf("Failed to push metrics to statsd: %v", err)
+	}
+}
+
+// Push sends the current metrics.
+func (p *Pusher) Push() error {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %v", err)
+	}
+
+	var lines []string
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			lines = append(lines, p.lines(mf, m)...)
+		}
+	}
+	return p.send(lines)
+}
+
+func (p *Pusher) lines(mf *dto.MetricFamily, m *dto.Metric) []string {
+	name := mf.GetName()
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		return p.counter(name, m.GetLabel(), m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		return p.gauge(name, m.GetLabel(), m.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		return p.gauge(name, m.GetLabel(), m.GetUntyped().GetValue())
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		return append(
+			p.counter(name+"_count", m.GetLabel(), float64(h.GetSampleCount())),
+			p.counter(name+"_sum", m.GetLabel(), h.GetSampleSum())...,
+		)
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		return append(
+			p.counter(name+"_count", m.GetLabel(), float64(s.GetSampleCount())),
+			p.counter(name+"_sum", m.GetLabel(), s.GetSampleSum())...,
+		)
+	}
+	return nil
+}
+
+func (p *Pusher) gauge(name string, labels []*dto.LabelPair, value float64) []string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return []string{p.line(name, labels, value, "g")}
+}
+
+// counter returns the line of the increase of a counter since the last push.
+func (p *Pusher) counter(name string, labels []*dto.LabelPair, value float64) []string {
+	key := seriesKey(name, labels)
+	delta := value - p.last[key]
+	if delta < 0 {
+		// The counter was reset.
+		delta = value
+	}
+	p.last[key] = value
+
+	if delta == 0 {
+		return nil
+	}
+	return []string{p.line(name, labels, delta, "c")}
+}
+
+func (p *Pusher) line(name string, labels []*dto.LabelPair, value float64, typ string) string {
+	var b strings.Builder
+	if p.cfg.Prefix != "" {
+		b.WriteString(p.cfg.Prefix)
+		b.WriteByte('.')
+	}
+	b.WriteString(name)
+
+	if p.cfg.Format == FormatStatsd {
+		for _, l := range labels {
+			b.WriteByte('.')
+			b.WriteString(sanitize(l.GetValue()))
+		}
+	}
+
+	b.WriteByte(':')
+	b.WriteString(formatValue(value))
+	b.WriteByte('|')
+	b.WriteString(typ)
+
+	if p.cfg.Format == FormatDogStatsD && len(labels) > 0 {
+		b.WriteString("|#")
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l.GetName())
+			b.WriteByte(':')
+			b.WriteString(sanitize(l.GetValue()))
+		}
+	}
+	return b.String()
+}
+
+// send writes lines in packets of at most maxPacketSize bytes.
+func (p *Pusher) send(lines []string) error {
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		_, err := p.conn.Write(buf.Bytes())
+		buf.Reset()
+		return err
+	}
+
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > maxPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	return flush()
+}
+
+func seriesKey(name string, labels []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, l.GetName()+"="+l.GetValue())
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sanitize replaces characters with a meaning in the statsd protocol.
+func sanitize(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '.', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statsd
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPush(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"}, []string{"route"})
+	connections := prometheus.NewGauge(prometheus.GaugeOpts{Name: "connections"})
+	reg.MustRegister(requests, connections)
+
+	for _, tc := range []struct {
+		format string
+		want   [][]string
+	}{
+		{
+			format: FormatStatsd,
+			want: [][]string{
+				{"krp.connections:3|g", "krp.requests_total.default:2|c"},
+				{"krp.connections:3|g", "krp.requests_total.default:1|c"},
+			},
+		},
+		{
+			format: FormatDogStatsD,
+			want: [][]string{
+				{"krp.connections:3|g", "krp.requests_total:2|c|#route:default"},
+				{"krp.connections:3|g", "krp.requests_total:1|c|#route:default"},
+			},
+		},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			requests.Reset()
+			connections.Set(3)
+
+			p, err := NewPusher(Config{Address: l.LocalAddr().String(), Format: tc.format, Prefix: "krp", Interval: time.Second}, reg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.conn.Close()
+
+			requests.WithLabelValues("default").Add(2)
+			push(t, p, l, tc.want[0])
+
+			requests.WithLabelValues("default").Inc()
+			push(t, p, l, tc.want[1])
+		})
+	}
+}
+
+func push(t *testing.T, p *Pusher, l net.PacketConn, want []string) {
+	t.Helper()
+
+	if err := p.Push(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, maxPacketSize)
+	if err := l.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	n, _, err := l.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Split(string(buf[:n]), "\n")
+	sort.Strings(got)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want lines %q, got %q", want, got)
+	}
+}
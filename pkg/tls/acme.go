@@ -0,0 +1,404 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"k8s.io/klog/v2"
+)
+
+// ACMEConfig holds the settings to obtain serving certificates from an ACME CA, e.g. Let's Encrypt.
+type ACMEConfig struct {
+	// Domains the certificate is requested for.
+	Domains []string
+	// Email is the contact address of the ACME account.
+	Email string
+	// DirectoryURL is the ACME directory endpoint, Let's Encrypt production if empty.
+	DirectoryURL string
+	// CacheDir persists the account key and certificates across restarts.
+	CacheDir string
+	// DNS01WebhookURL, if set, selects the DNS-01 challenge. The webhook is
+	// called to present and clean up the challenge TXT records.
+	DNS01WebhookURL string
+	// RenewBefore is the time before expiry at which certificates are renewed.
+	RenewBefore time.Duration
+}
+
+// ACMEManager obtains and renews serving certificates from an ACME CA.
+type ACMEManager interface {
+	// GetCertificate is compatible with https://golang.org/pkg/crypto/tls/#Config.GetCertificate.
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// HTTPHandler answers HTTP-01 challenges, it must be served on port 80 of the domains.
+	HTTPHandler() http.Handler
+	// Run obtains and renews certificates until ctx is done.
+	Run(ctx context.Context) error
+}
+
+// NewACMEManager returns a manager solving HTTP-01 and TLS-ALPN-01 challenges,
+// or DNS-01 challenges if a DNS-01 webhook is configured.
+func NewACMEManager(cfg ACMEConfig) (ACMEManager, error) {
+	if len(cfg.Domains) == 0 {
+		return nil, errors.New("at least one domain is required for ACME")
+	}
+	if cfg.CacheDir == "" {
+		return nil, errors.New("a cache directory is required for ACME")
+	}
+	if cfg.DirectoryURL == "" {
+		cfg.DirectoryURL = acme.LetsEncryptURL
+	}
+
+	if cfg.DNS01WebhookURL != "" {
+		return &dns01Manager{cfg: cfg, client: &acme.Client{DirectoryURL: cfg.DirectoryURL}}, nil
+	}
+
+	return &autocertManager{&autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(cfg.CacheDir),
+		HostPolicy:  autocert.HostWhitelist(cfg.Domains...),
+		RenewBefore: cfg.RenewBefore,
+		Email:       cfg.Email,
+		Client:      &acme.Client{DirectoryURL: cfg.DirectoryURL},
+	}}, nil
+}
+
+// autocertManager obtains certificates on demand during TLS handshakes.
+type autocertManager struct {
+	m *autocert.Manager
+}
+
+func (a *autocertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return a.m.GetCertificate(hello)
+}
+
+func (a *autocertManager) HTTPHandler() http.Handler {
+	return a.m.HTTPHandler(nil)
+}
+
+func (a *autocertManager) Run(ctx context.Context) error {
+	// autocert renews certificates in the background by itself.
+	<-ctx.Done()
+	return nil
+}
+
+// dns01Manager obtains a single certificate for all domains using DNS-01
+// challenges presented by an external webhook.
+type dns01Manager struct {
+	cfg    ACMEConfig
+	client *acme.Client
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// dns01WebhookRequest is sent to the DNS-01 webhook to present or clean up a challenge record.
+type dns01WebhookRequest struct {
+	// Action is either "present" or "cleanup".
+	Action string `json:"action"`
+	// Domain the challenge is solved for.
+	Domain string `json:"domain"`
+	// FQDN of the TXT record, i.e. "_acme-challenge.<domain>.".
+	FQDN string `json:"fqdn"`
+	// Value of the TXT record.
+	Value string `json:"value"`
+}
+
+func (m *dns01Manager) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.cert == nil {
+		return nil, errors.New("no ACME certificate obtained yet")
+	}
+	return m.cert, nil
+}
+
+func (m *dns01Manager) HTTPHandler() http.Handler {
+	return http.NotFoundHandler()
+}
+
+func (m *dns01Manager) Run(ctx context.Context) error {
+	if cert, err := m.loadCached(); err == nil {
+		m.setCert(cert)
+	}
+
+	for {
+		wait := time.Hour
+		if m.needsRenewal() {
+			if err := m.obtain(ctx); err != nil {
+				klog.Errorf("Failed to obtain ACME certificate: %v", err)
+				wait = time.Minute
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (m *dns01Manager) needsRenewal() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.cert == nil || time.Now().Add(m.cfg.RenewBefore).After(m.cert.Leaf.NotAfter)
+}
+
+func (m *dns01Manager) setCert(cert *tls.Certificate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cert = cert
+}
+
+func (m *dns01Manager) obtain(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	if m.client.Key == nil {
+		key, err := m.accountKey()
+		if err != nil {
+			return fmt.Errorf("error loading account key: %v", err)
+		}
+		m.client.Key = key
+
+		acct := &acme.Account{}
+		if m.cfg.Email != "" {
+			acct.Contact = []string{"mailto:" + m.cfg.Email}
+		}
+		if _, err := m.client.Register(ctx, acct, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+			m.client.Key = nil
+			return fmt.Errorf("error registering account: %v", err)
+		}
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.cfg.Domains...))
+	if err != nil {
+		return fmt.Errorf("error creating order: %v", err)
+	}
+
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, u); err != nil {
+			return err
+		}
+	}
+
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("error waiting for order: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.cfg.Domains[0]},
+		DNSNames: m.cfg.Domains,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("error creating certificate request: %v", err)
+	}
+
+	der, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("error finalizing order: %v", err)
+	}
+
+	certPEM, keyPEM, err := encodeCert(der, key)
+	if err != nil {
+		return err
+	}
+	cert, err := parseCert(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(m.cfg.CacheDir, "tls.crt"), certPEM, 0600); err != nil {
+		klog.Errorf("Failed to cache ACME certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(m.cfg.CacheDir, "tls.key"), keyPEM, 0600); err != nil {
+		klog.Errorf("Failed to cache ACME key: %v", err)
+	}
+
+	klog.Infof("Obtained ACME certificate for %v valid until %v", m.cfg.Domains, cert.Leaf.NotAfter)
+	m.setCert(cert)
+	return nil
+}
+
+func (m *dns01Manager) authorize(ctx context.Context, authzURL string) error {
+	z, err := m.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("error fetching authorization: %v", err)
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no dns-01 challenge offered for %s", z.Identifier.Value)
+	}
+
+	value, err := m.client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+
+	record := dns01WebhookRequest{
+		Domain: z.Identifier.Value,
+		FQDN:   "_acme-challenge." + z.Identifier.Value + ".",
+		Value:  value,
+	}
+
+	record.Action = "present"
+	if err := m.callWebhook(ctx, record); err != nil {
+		return fmt.Errorf("error presenting dns-01 challenge for %s: %v", z.Identifier.Value, err)
+	}
+	defer func() {
+		record.Action = "cleanup"
+		if err := m.callWebhook(context.Background(), record); err != nil {
+			klog.Errorf("Failed to clean up dns-01 challenge for %s: %v", z.Identifier.Value, err)
+		}
+	}()
+
+	if _, err := m.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("error accepting challenge for %s: %v", z.Identifier.Value, err)
+	}
+	if _, err := m.client.WaitAuthorization(ctx, z.URI); err != nil {
+		return fmt.Errorf("error waiting for authorization of %s: %v", z.Identifier.Value, err)
+	}
+	return nil
+}
+
+func (m *dns01Manager) callWebhook(ctx context.Context, record dns01WebhookRequest) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, m.cfg.DNS01WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (m *dns01Manager) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.cfg.CacheDir, "acme_account.key")
+
+	if b, err := ioutil.ReadFile(path); err == nil {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM data found in %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (m *dns01Manager) loadCached() (*tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(filepath.Join(m.cfg.CacheDir, "tls.crt"))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(filepath.Join(m.cfg.CacheDir, "tls.key"))
+	if err != nil {
+		return nil, err
+	}
+	return parseCert(certPEM, keyPEM)
+}
+
+func encodeCert(der [][]byte, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	var certPEM []byte
+	for _, b := range der {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// parseCert parses a PEM encoded certificate/key pair, populating the leaf certificate.
+func parseCert(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate: %v", err)
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing leaf certificate: %v", err)
+	}
+	return &cert, nil
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeACMEServer is an RFC 8555 ACME CA which accepts every challenge
+// and issues certificates of a fixed validity.
+type fakeACMEServer struct {
+	*httptest.Server
+	ca       *x509.Certificate
+	caKey    *ecdsa.PrivateKey
+	validity time.Duration
+
+	mu       sync.Mutex
+	accounts map[string]bool
+	orders   int
+	valid    map[string]bool
+	certs    map[string][]byte
+}
+
+func newFakeACMEServer(t *testing.T, validity time.Duration) *fakeACMEServer {
+	ca, caKey, err := generateCA("acme", time.Now(), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeACMEServer{
+		ca:       ca,
+		caKey:    caKey,
+		validity: validity,
+		accounts: map[string]bool{},
+		valid:    map[string]bool{},
+		certs:    map[string][]byte{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *fakeACMEServer) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+
+	switch {
+	case req.URL.Path == "/directory":
+		s.reply(w, http.StatusOK, map[string]string{
+			"newNonce":   s.URL + "/nonce",
+			"newAccount": s.URL + "/account",
+			"newOrder":   s.URL + "/order",
+		})
+		return
+	case req.URL.Path == "/nonce":
+		return
+	}
+
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&jws); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var header struct {
+		JWK json.RawMessage `json:"jwk"`
+	}
+	var payload struct {
+		Identifiers []struct{ Value string } `json:"identifiers"`
+		CSR         string                   `json:"csr"`
+	}
+	if err := decodeSegment(jws.Protected, &header); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if jws.Payload != "" {
+		if err := decodeSegment(jws.Payload, &payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	switch parts[0] {
+	case "account":
+		status := http.StatusOK
+		if !s.accounts[string(header.JWK)] {
+			s.accounts[string(header.JWK)] = true
+			status = http.StatusCreated
+		}
+		w.Header().Set("Location", s.URL+"/account/1")
+		s.reply(w, status, map[string]string{"status": "valid"})
+	case "order":
+		if len(parts) == 1 {
+			s.orders++
+			s.valid = map[string]bool{}
+			var authzs []string
+			for _, id := range payload.Identifiers {
+				authzs = append(authzs, s.URL+"/authz/"+id.Value)
+			}
+			w.Header().Set("Location", fmt.Sprintf("%s/order/%d", s.URL, s.orders))
+			s.reply(w, http.StatusCreated, map[string]interface{}{
+				"status":         "pending",
+				"authorizations": authzs,
+				"finalize":       fmt.Sprintf("%s/finalize/%d", s.URL, s.orders),
+			})
+			return
+		}
+		s.reply(w, http.StatusOK, map[string]string{"status": "ready", "finalize": s.URL + "/finalize/" + parts[1]})
+	case "authz":
+		status := "pending"
+		if s.valid[parts[1]] {
+			status = "valid"
+		}
+		s.reply(w, http.StatusOK, map[string]interface{}{
+			"identifier": map[string]string{"type": "dns", "value": parts[1]},
+			"status":     status,
+			"challenges": []map[string]string{{
+				"type":   "dns-01",
+				"url":    s.URL + "/challenge/" + parts[1],
+				"token":  "token-" + parts[1],
+				"status": "pending",
+			}},
+		})
+	case "challenge":
+		s.valid[parts[1]] = true
+		s.reply(w, http.StatusOK, map[string]string{"type": "dns-01", "url": s.URL + req.URL.Path, "status": "valid"})
+	case "finalize":
+		der, err := s.issue(payload.CSR)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.certs[parts[1]] = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), encodeCABundle([]*x509.Certificate{s.ca})...)
+		s.reply(w, http.StatusOK, map[string]string{"status": "valid", "certificate": s.URL + "/cert/" + parts[1]})
+	case "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(s.certs[parts[1]])
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (s *fakeACMEServer) issue(b64CSR string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(b64CSR)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(s.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return x509.CreateCertificate(rand.Reader, tmpl, s.ca, csr.PublicKey, s.caKey)
+}
+
+func (s *fakeACMEServer) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *fakeACMEServer) counts() (accounts, orders int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.accounts), s.orders
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// runACMEManager runs m until it serves a certificate other than the one of
+// serial, returning the certificate and a function stopping m.
+func runACMEManager(t *testing.T, m ACMEManager, serial *big.Int) (*tls.Certificate, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Run(ctx)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+
+	for i := 0; i < 500; i++ {
+		cert, err := m.GetCertificate(nil)
+		if err == nil && (serial == nil || cert.Leaf.SerialNumber.Cmp(serial) != 0) {
+			return cert, stop
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	t.Fatal("timed out waiting for ACME certificate")
+	return nil, nil
+}
+
+func TestACMEDNS01(t *testing.T) {
+	acmeServer := newFakeACMEServer(t, time.Hour)
+	defer acmeServer.Close()
+
+	var (
+		mu      sync.Mutex
+		records []dns01WebhookRequest
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var record dns01WebhookRequest
+		if err := json.NewDecoder(req.Body).Decode(&record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		records = append(records, record)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	dir, err := ioutil.TempDir("", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := ACMEConfig{
+		Domains:         []string{"foo.example.com"},
+		Email:           "admin@example.com",
+		DirectoryURL:    acmeServer.URL + "/directory",
+		CacheDir:        dir,
+		DNS01WebhookURL: webhook.URL,
+		RenewBefore:     30 * time.Minute,
+	}
+	m, err := NewACMEManager(cfg)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if _, err := m.GetCertificate(nil); err == nil {
+		t.Error("want error before a certificate is obtained, got nil")
+	}
+
+	// The certificate is issued after the challenge records are presented.
+	issued, stop := runACMEManager(t, m, nil)
+	stop()
+	if got := issued.Leaf.DNSNames; len(got) != 1 || got[0] != "foo.example.com" {
+		t.Errorf("want certificate for foo.example.com, got %v", got)
+	}
+	mu.Lock()
+	if len(records) != 2 || records[0].Action != "present" || records[1].Action != "cleanup" ||
+		records[0].FQDN != "_acme-challenge.foo.example.com." || records[0].Value == "" {
+		t.Errorf("want the challenge record to be presented and cleaned up, got %+v", records)
+	}
+	mu.Unlock()
+
+	// A cached certificate outside of the renewal window is reused.
+	m, err = NewACMEManager(cfg)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	cached, stop := runACMEManager(t, m, nil)
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	if cached.Leaf.SerialNumber.Cmp(issued.Leaf.SerialNumber) != 0 {
+		t.Errorf("want the cached certificate, got serial %v", cached.Leaf.SerialNumber)
+	}
+	if _, orders := acmeServer.counts(); orders != 1 {
+		t.Errorf("want 1 order, got %d", orders)
+	}
+
+	// A cached certificate expiring within the renewal window is renewed
+	// with the cached account key.
+	cfg.RenewBefore = 2 * time.Hour
+	m, err = NewACMEManager(cfg)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	_, stop = runACMEManager(t, m, issued.Leaf.SerialNumber)
+	stop()
+	if accounts, orders := acmeServer.counts(); accounts != 1 || orders != 2 {
+		t.Errorf("want 1 account and 2 orders, got %d accounts and %d orders", accounts, orders)
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// ClientAuthType returns the client certificate policy for the given name,
+// e.g. "RequireAndVerifyClientCert" as in https://golang.org/pkg/crypto/tls/#ClientAuthType.
+func ClientAuthType(name string) (tls.ClientAuthType, error) {
+	if t, ok := clientAuthTypes[name]; ok {
+		return t, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unknown client auth type %q", name)
+}
+
+// VerifiesClientCerts returns whether the policy verifies client certificates
+// against the client CAs during the handshake.
+func VerifiesClientCerts(t tls.ClientAuthType) bool {
+	return t == tls.VerifyClientCertIfGiven || t == tls.RequireAndVerifyClientCert
+}
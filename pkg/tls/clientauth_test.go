@@ -0,0 +1,39 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestClientAuthType(t *testing.T) {
+	got, err := ClientAuthType("RequireAndVerifyClientCert")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if got != tls.RequireAndVerifyClientCert {
+		t.Errorf("want %v, got %v", tls.RequireAndVerifyClientCert, got)
+	}
+	if !VerifiesClientCerts(got) {
+		t.Error("want RequireAndVerifyClientCert to verify client certificates")
+	}
+
+	if _, err := ClientAuthType("RequireClientCert"); err == nil {
+		t.Error("expected error for unknown client auth type, got nil")
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+var curves = map[string]tls.CurveID{
+	"CurveP256": tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"X25519":    tls.X25519,
+}
+
+// CurvePreferences returns the elliptic curve IDs for the given names,
+// e.g. "CurveP256" as in https://golang.org/pkg/crypto/tls/#CurveID,
+// in the given order of preference.
+func CurvePreferences(curveNames []string) ([]tls.CurveID, error) {
+	if len(curveNames) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]tls.CurveID, 0, len(curveNames))
+	for _, name := range curveNames {
+		id, ok := curves[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+)
+
+func TestCurvePreferences(t *testing.T) {
+	got, err := CurvePreferences([]string{"X25519", "CurveP256"})
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if want := []tls.CurveID{tls.X25519, tls.CurveP256}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want curves %v, got %v", want, got)
+	}
+
+	got, err = CurvePreferences(nil)
+	if err != nil || got != nil {
+		t.Errorf("want default curves for empty names, got %v, %v", got, err)
+	}
+
+	if _, err := CurvePreferences([]string{"CurveP224"}); err == nil {
+		t.Error("expected error for unknown curve, got nil")
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// fipsCipherSuites are the FIPS 140-2 approved TLS 1.2 cipher suites.
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// fipsCurves are the FIPS 140-2 approved elliptic curves.
+var fipsCurves = []tls.CurveID{
+	tls.CurveP256,
+	tls.CurveP384,
+	tls.CurveP521,
+}
+
+// FIPSAvailable returns whether the binary was built with a FIPS 140-2
+// validated cryptographic module, i.e. with Go+BoringCrypto.
+func FIPSAvailable() bool {
+	return boringEnabled()
+}
+
+// ApplyFIPS restricts c to FIPS 140-2 approved TLS versions, cipher suites and curves.
+// Settings already restricted further are kept, non-compliant settings are refused.
+func ApplyFIPS(c *tls.Config) error {
+	if c.MinVersion == 0 {
+		c.MinVersion = tls.VersionTLS12
+	}
+	if c.MinVersion < tls.VersionTLS12 {
+		return fmt.Errorf("TLS versions below 1.2 are not FIPS approved")
+	}
+	if c.MaxVersion != 0 && c.MaxVersion < tls.VersionTLS12 {
+		return fmt.Errorf("TLS versions below 1.2 are not FIPS approved")
+	}
+
+	if len(c.CipherSuites) == 0 {
+		c.CipherSuites = append([]uint16(nil), fipsCipherSuites...)
+	}
+	for _, id := range c.CipherSuites {
+		if !containsCipherSuite(fipsCipherSuites, id) {
+			return fmt.Errorf("cipher suite %#04x is not FIPS approved", id)
+		}
+	}
+
+	if len(c.CurvePreferences) == 0 {
+		c.CurvePreferences = append([]tls.CurveID(nil), fipsCurves...)
+	}
+	for _, id := range c.CurvePreferences {
+		if !containsCurve(fipsCurves, id) {
+			return fmt.Errorf("curve %v is not FIPS approved", id)
+		}
+	}
+
+	return nil
+}
+
+func containsCipherSuite(ids []uint16, id uint16) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCurve(ids []tls.CurveID, id tls.CurveID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
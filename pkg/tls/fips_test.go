@@ -0,0 +1,53 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestApplyFIPS(t *testing.T) {
+	c := &tls.Config{}
+	if err := ApplyFIPS(c); err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if c.MinVersion != tls.VersionTLS12 || len(c.CipherSuites) != len(fipsCipherSuites) || len(c.CurvePreferences) != len(fipsCurves) {
+		t.Errorf("want FIPS defaults, got %+v", c)
+	}
+
+	c = &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+	}
+	if err := ApplyFIPS(c); err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if c.MinVersion != tls.VersionTLS13 || len(c.CipherSuites) != 1 {
+		t.Errorf("want stricter settings to be kept, got %+v", c)
+	}
+
+	for name, c := range map[string]*tls.Config{
+		"version":      {MinVersion: tls.VersionTLS11},
+		"cipher suite": {CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305}},
+		"curve":        {CurvePreferences: []tls.CurveID{tls.X25519}},
+	} {
+		if err := ApplyFIPS(c); err == nil {
+			t.Errorf("expected error for non-compliant %s, got nil", name)
+		}
+	}
+}
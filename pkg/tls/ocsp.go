@@ -0,0 +1,246 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	"k8s.io/klog/v2"
+)
+
+// OCSPStapler staples OCSP responses to the certificates returned by a GetCertificateFunc.
+//
+// Responses are fetched in the background from the OCSP responders named in the certificates
+// and refreshed halfway through their validity. Certificates without a current response
+// are served without staple. For fetching and refreshing the Run method must be started explicitly.
+type OCSPStapler struct {
+	get    GetCertificateFunc
+	client *http.Client
+
+	pending chan *tls.Certificate
+
+	mu    sync.RWMutex // protects the entries
+	certs map[[sha256.Size]byte]*ocspEntry
+}
+
+var errNoOCSPResponder = errors.New("certificate names no OCSP responder")
+
+type ocspEntry struct {
+	cert      *tls.Certificate
+	notAfter  time.Time
+	staple    []byte
+	refreshAt time.Time
+}
+
+// NewOCSPStapler returns an OCSPStapler for the certificates returned by get.
+func NewOCSPStapler(get GetCertificateFunc) *OCSPStapler {
+	return &OCSPStapler{
+		get:     get,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		pending: make(chan *tls.Certificate, 16),
+		certs:   map[[sha256.Size]byte]*ocspEntry{},
+	}
+}
+
+// GetCertificate returns the certificate of the underlying GetCertificateFunc
+// with the current OCSP response stapled, if any.
+// Its signature is compatible with https://golang.org/pkg/crypto/tls/#Config.GetCertificate.
+func (s *OCSPStapler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := s.get(hello)
+	if err != nil || cert == nil || len(cert.Certificate) == 0 {
+		return cert, err
+	}
+
+	key := sha256.Sum256(cert.Certificate[0])
+
+	var staple []byte
+	s.mu.RLock()
+	e, ok := s.certs[key]
+	if ok {
+		staple = e.staple
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		s.mu.Lock()
+		if _, ok := s.certs[key]; !ok {
+			e := &ocspEntry{cert: cert}
+			if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+				e.notAfter = leaf.NotAfter
+			}
+			s.certs[key] = e
+			select {
+			case s.pending <- cert:
+			default:
+				// Picked up by the next refresh.
+			}
+		}
+		s.mu.Unlock()
+		return cert, nil
+	}
+
+	if staple == nil {
+		return cert, nil
+	}
+
+	stapled := *cert
+	stapled.OCSPStaple = staple
+	return &stapled, nil
+}
+
+// Run fetches and refreshes OCSP responses until ctx is done.
+func (s *OCSPStapler) Run(ctx context.Context) error {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+
+	for {
+		select {
+		case cert := <-s.pending:
+			s.refresh(ctx, cert, time.Now())
+		case <-t.C:
+			s.refreshAll(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *OCSPStapler) refreshAll(ctx context.Context) {
+	now := time.Now()
+
+	var certs []*tls.Certificate
+	s.mu.Lock()
+	for key, e := range s.certs {
+		// Forget expired certificates, e.g. after rotation.
+		if !e.notAfter.IsZero() && now.After(e.notAfter) {
+			delete(s.certs, key)
+			continue
+		}
+		if now.After(e.refreshAt) {
+			certs = append(certs, e.cert)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, cert := range certs {
+		s.refresh(ctx, cert, now)
+	}
+}
+
+func (s *OCSPStapler) refresh(ctx context.Context, cert *tls.Certificate, now time.Time) {
+	key := sha256.Sum256(cert.Certificate[0])
+
+	staple, refreshAt, err := s.fetch(ctx, cert)
+	switch {
+	case err == errNoOCSPResponder:
+		// Nothing to staple for the lifetime of the certificate, e.g. for self-signed certificates.
+		klog.V(2).Info("Not stapling OCSP responses for certificate without OCSP responder")
+		refreshAt = time.Unix(1<<62, 0)
+	case err != nil:
+		klog.Errorf("Failed to fetch OCSP response: %v", err)
+		// Retry with the next refresh, keeping the current staple until then.
+		refreshAt = now
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.certs[key]
+	if !ok {
+		return
+	}
+	if staple != nil {
+		e.staple = staple
+	}
+	e.refreshAt = refreshAt
+}
+
+func (s *OCSPStapler) fetch(ctx context.Context, cert *tls.Certificate) ([]byte, time.Time, error) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error parsing certificate: %v", err)
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, time.Time{}, errNoOCSPResponder
+	}
+	if len(cert.Certificate) < 2 {
+		return nil, time.Time{}, fmt.Errorf("certificate chain of %s doesn't contain the issuer", leaf.Subject.CommonName)
+	}
+
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error parsing issuer certificate: %v", err)
+	}
+
+	body, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error creating OCSP request: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(body))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error requesting OCSP response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("OCSP responder returned status %d", resp.StatusCode)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error reading OCSP response: %v", err)
+	}
+
+	r, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error parsing OCSP response: %v", err)
+	}
+
+	switch r.Status {
+	case ocsp.Good:
+	case ocsp.Revoked:
+		klog.Errorf("Serving certificate %s was revoked at %v", leaf.Subject.CommonName, r.RevokedAt)
+	default:
+		return nil, time.Time{}, fmt.Errorf("OCSP responder doesn't know certificate %s", leaf.Subject.CommonName)
+	}
+
+	refreshAt := r.ThisUpdate.Add(r.NextUpdate.Sub(r.ThisUpdate) / 2)
+	if r.NextUpdate.IsZero() {
+		refreshAt = time.Now().Add(time.Hour)
+	}
+
+	klog.V(4).Infof("Fetched OCSP response for %s, refreshing at %v", leaf.Subject.CommonName, refreshAt)
+	return raw, refreshAt, nil
+}
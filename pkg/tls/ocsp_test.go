@@ -0,0 +1,132 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPStapler(t *testing.T) {
+	ca, caKey, err := generateCA("test", time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := 0
+	var leaf *x509.Certificate
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil || req.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		responses++
+		resp, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, caKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(resp)
+	}))
+	defer responder.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := newSerial()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "foo.example.com"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{responder.URL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ = x509.ParseCertificate(der)
+	cert := &tls.Certificate{Certificate: [][]byte{der, ca.Raw}, PrivateKey: key}
+
+	s := NewOCSPStapler(staticCertificate(cert))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	got, err := s.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if got.OCSPStaple != nil {
+		t.Error("want no staple before the response is fetched")
+	}
+
+	if err := poll(10*time.Millisecond, 2*time.Second, func() error {
+		got, _ := s.GetCertificate(&tls.ClientHelloInfo{})
+		if got.OCSPStaple == nil {
+			return errors.New("no OCSP response stapled yet")
+		}
+		r, err := ocsp.ParseResponseForCert(got.OCSPStaple, leaf, ca)
+		if err != nil {
+			return err
+		}
+		if r.Status != ocsp.Good {
+			t.Errorf("want good OCSP status, got %v", r.Status)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if responses != 1 {
+		t.Errorf("want 1 OCSP request, got %d", responses)
+	}
+	if cert.OCSPStaple != nil {
+		t.Error("want the underlying certificate to be left unmodified")
+	}
+}
@@ -0,0 +1,226 @@
+//go:build pkcs11 && cgo
+// +build pkcs11,cgo
+
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+func init() {
+	RegisterKeyProvider("pkcs11", newPKCS11Signer)
+}
+
+// digestInfoPrefixes are the DER encoded DigestInfo prefixes for RSA PKCS #1 v1.5 signatures,
+// see https://tools.ietf.org/html/rfc8017#section-9.2.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+var pssParams = map[crypto.Hash]struct{ hash, mgf uint }{
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// pkcs11Signer signs with a private key stored in a PKCS #11 token, e.g. a HSM.
+type pkcs11Signer struct {
+	pub crypto.PublicKey
+
+	mu      sync.Mutex // serializes operations on the session
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+}
+
+// newPKCS11Signer loads the private key referenced by a PKCS #11 URI as in
+// https://tools.ietf.org/html/rfc7512, e.g.
+// "pkcs11:token=proxy;object=serving?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/etc/hsm/pin".
+//
+// The token and object path attributes, and the module-path query attribute are required.
+// The PIN is read from the file given by pin-source, or taken from pin-value.
+func newPKCS11Signer(uri *url.URL, pub crypto.PublicKey) (crypto.Signer, error) {
+	attrs := map[string]string{}
+	for _, attr := range strings.Split(uri.Opaque, ";") {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v, err := url.PathUnescape(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute %q: %v", kv[0], err)
+		}
+		attrs[kv[0]] = v
+	}
+
+	query := uri.Query()
+	modulePath := query.Get("module-path")
+	if modulePath == "" || attrs["token"] == "" || attrs["object"] == "" {
+		return nil, fmt.Errorf("token, object and module-path are required")
+	}
+
+	pin := query.Get("pin-value")
+	if source := query.Get("pin-source"); source != "" {
+		b, err := ioutil.ReadFile(strings.TrimPrefix(source, "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("error reading pin: %v", err)
+		}
+		pin = strings.TrimSpace(string(b))
+	}
+
+	ctx := pkcs11.New(modulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("error loading module %s", modulePath)
+	}
+	if err := ctx.Initialize(); err != nil {
+		return nil, fmt.Errorf("error initializing module: %v", err)
+	}
+
+	s := &pkcs11Signer{pub: pub, ctx: ctx}
+	if err := s.open(attrs["token"], attrs["object"], pin); err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *pkcs11Signer) open(token, object, pin string) error {
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return fmt.Errorf("error listing slots: %v", err)
+	}
+
+	slot, found := uint(0), false
+	for _, id := range slots {
+		info, err := s.ctx.GetTokenInfo(id)
+		if err == nil && strings.TrimSpace(info.Label) == token {
+			slot, found = id, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("token %q not found", token)
+	}
+
+	s.session, err = s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("error opening session: %v", err)
+	}
+
+	if err := s.ctx.Login(s.session, pkcs11.CKU_USER, pin); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		return fmt.Errorf("error logging in: %v", err)
+	}
+
+	if err := s.ctx.FindObjectsInit(s.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, object),
+	}); err != nil {
+		return fmt.Errorf("error finding private key: %v", err)
+	}
+	keys, _, err := s.ctx.FindObjects(s.session, 1)
+	if finalErr := s.ctx.FindObjectsFinal(s.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return fmt.Errorf("error finding private key: %v", err)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("private key %q not found", object)
+	}
+	s.key = keys[0]
+
+	return nil
+}
+
+// Public returns the public key of the certificate, as the token might not
+// store the public key. NewSignerCertificate checks the private key matches it.
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var (
+		mechanism *pkcs11.Mechanism
+		data      = digest
+	)
+
+	switch s.pub.(type) {
+	case *ecdsa.PublicKey:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			p, ok := pssParams[pss.Hash]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash %v", pss.Hash)
+			}
+			saltLength := pss.SaltLength
+			if saltLength == rsa.PSSSaltLengthEqualsHash || saltLength == rsa.PSSSaltLengthAuto {
+				saltLength = pss.Hash.Size()
+			}
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(p.hash, p.mgf, uint(saltLength)))
+		} else {
+			prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+			}
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+			data = append(append([]byte{}, prefix...), digest...)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", s.pub)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{mechanism}, s.key); err != nil {
+		return nil, fmt.Errorf("error initializing signature: %v", err)
+	}
+	sig, err := s.ctx.Sign(s.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("error signing: %v", err)
+	}
+
+	if _, ok := s.pub.(*ecdsa.PublicKey); ok {
+		// PKCS #11 returns the raw concatenation of r and s, Go expects ASN.1.
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(sig[:half]),
+			S: new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+	return sig, nil
+}
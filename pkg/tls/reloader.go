@@ -58,10 +58,42 @@ func NewCertReloader(certPath, keyPath string, interval time.Duration) (*CertRel
 	return r, nil
 }
 
+// NewPendingCertReloader returns a CertReloader for a certificate/key pair which may not exist yet,
+// e.g. because it is delivered asynchronously by a CSI driver.
+// Until the pair is loaded by Watch, GetCertificate returns an error.
+func NewPendingCertReloader(certPath, keyPath string, interval time.Duration) *CertReloader {
+	r := &CertReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		interval: interval,
+	}
+
+	if err := r.reload(); err != nil {
+		klog.Infof("Waiting for certificate %s: %v", certPath, err)
+	}
+
+	return r
+}
+
 // Watch watches the configured certificate and key path and blocks the current goroutine
 // until the scenario context is done or an error occurred during reloading.
+//
+// While no certificate has been loaded yet, the pair is retried every second and errors are logged.
 func (r *CertReloader) Watch(ctx context.Context) error {
+	for !r.loaded() {
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return nil
+		}
+
+		if err := r.reload(); err != nil {
+			klog.V(4).Infof("Waiting for certificate %s: %v", r.certPath, err)
+		}
+	}
+
 	t := time.NewTicker(r.interval)
+	defer t.Stop()
 
 	for {
 		select {
@@ -76,6 +108,13 @@ func (r *CertReloader) Watch(ctx context.Context) error {
 	}
 }
 
+func (r *CertReloader) loaded() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert != nil
+}
+
 func (r *CertReloader) reload() error {
 	certRaw, err := ioutil.ReadFile(r.certPath)
 	if err != nil {
@@ -118,5 +157,8 @@ func (r *CertReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate,
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	if r.cert == nil {
+		return nil, fmt.Errorf("certificate %s not loaded yet", r.certPath)
+	}
 	return r.cert, nil
 }
@@ -106,11 +106,45 @@ func TestReloader(t *testing.T) {
 	}
 }
 
-func TestMain(m *testing.M) {
-	var err error
-	err = flag.Set("alsologtostderr", "true")
-	err = flag.Set("v", "5")
+func TestPendingCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pending")
 	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &scenario{
+		certPath: path.Join(dir, "cert"),
+		keyPath:  path.Join(dir, "key"),
+	}
+	s.reloader = NewPendingCertReloader(s.certPath, s.keyPath, 10*time.Millisecond)
+
+	if _, err := s.reloader.GetCertificate(nil); err == nil {
+		t.Error("expected error before the certificate is available, got nil")
+	}
+
+	startWatching(t, s)
+	newSelfSignedCert("foo")(t, s)
+	swapCert(t, s)
+
+	if err := poll(10*time.Millisecond, 2*time.Second, func() error {
+		return certCommonNameIs(s.reloader.GetCertificate, "foo")
+	}); err != nil {
+		t.Error(err)
+	}
+
+	for _, cleanup := range s.cleanups {
+		cleanup()
+	}
+}
+
+func TestMain(m *testing.M) {
+	klog.InitFlags(nil)
+
+	if err := flag.Set("alsologtostderr", "true"); err != nil {
+		log.Fatal(err)
+	}
+	if err := flag.Set("v", "5"); err != nil {
 		log.Fatal(err)
 	}
 
@@ -130,24 +164,28 @@ type checkFunc func(*scenario) error
 
 func commonNameIs(want string) checkFunc {
 	return func(g *scenario) error {
-		return poll(10*time.Millisecond, 100*time.Millisecond, func() (err error) {
-			cert, err := g.reloader.GetCertificate(nil)
-			if err != nil {
-				return fmt.Errorf("error getting certificate: %v", err)
-			}
+		return poll(10*time.Millisecond, 100*time.Millisecond, func() error {
+			return certCommonNameIs(g.reloader.GetCertificate, want)
+		})
+	}
+}
 
-			first, err := x509.ParseCertificate(cert.Certificate[0])
-			if err != nil {
-				return fmt.Errorf("error parsing certificate: %v", err)
-			}
+func certCommonNameIs(getCertificate GetCertificateFunc, want string) error {
+	cert, err := getCertificate(nil)
+	if err != nil {
+		return fmt.Errorf("error getting certificate: %v", err)
+	}
 
-			if !strings.HasPrefix(first.Subject.CommonName, want) {
-				return fmt.Errorf("want subject common name to start with %q, got %q", want, first.Subject.CommonName)
-			}
+	first, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("error parsing certificate: %v", err)
+	}
 
-			return nil
-		})
+	if !strings.HasPrefix(first.Subject.CommonName, want) {
+		return fmt.Errorf("want subject common name to start with %q, got %q", want, first.Subject.CommonName)
 	}
+
+	return nil
 }
 
 func newCertReloader(t *testing.T, s *scenario) {
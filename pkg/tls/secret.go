@@ -0,0 +1,140 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// SecretCertificate serves a certificate/key pair stored in a Kubernetes
+// Secret of type kubernetes.io/tls, providing a goroutine safe GetCertificate method.
+//
+// For picking up rotated certificates the Run method must be started explicitly.
+type SecretCertificate struct {
+	client          kubernetes.Interface
+	namespace, name string
+
+	mu   sync.RWMutex // protects the fields below
+	cert *tls.Certificate
+}
+
+// NewSecretCertificate reads the certificate/key pair from the Secret
+// referenced by namespacedName in the form "namespace/name".
+func NewSecretCertificate(client kubernetes.Interface, namespacedName string) (*SecretCertificate, error) {
+	parts := strings.Split(namespacedName, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid secret reference %q, must be namespace/name", namespacedName)
+	}
+
+	s := &SecretCertificate{
+		client:    client,
+		namespace: parts[0],
+		name:      parts[1],
+	}
+
+	secret, err := client.CoreV1().Secrets(s.namespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting secret %s: %v", namespacedName, err)
+	}
+
+	if err := s.update(secret); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Run watches the Secret and swaps the served certificate whenever it changes,
+// blocking the current goroutine until the given context is done.
+//
+// Invalid updates and deletions of the Secret are logged and the last valid
+// certificate keeps being served.
+func (s *SecretCertificate) Run(ctx context.Context) error {
+	selector := fields.OneTermEqualSelector("metadata.name", s.name).String()
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return secrets.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return secrets.Watch(ctx, options)
+		},
+	}
+
+	onChange := func(obj interface{}) {
+		secret, ok := obj.(*corev1.Secret)
+		if !ok || secret.Name != s.name {
+			return
+		}
+		if err := s.update(secret); err != nil {
+			klog.Errorf("Failed to update certificate from secret %s/%s: %v", s.namespace, s.name, err)
+			return
+		}
+		klog.V(4).Infof("Updated certificate from secret %s/%s", s.namespace, s.name)
+	}
+
+	_, controller := cache.NewInformer(lw, &corev1.Secret{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: onChange,
+		UpdateFunc: func(_, obj interface{}) {
+			onChange(obj)
+		},
+		DeleteFunc: func(interface{}) {
+			klog.Warningf("Secret %s/%s was deleted, keeping the current certificate", s.namespace, s.name)
+		},
+	})
+
+	controller.Run(ctx.Done())
+	return nil
+}
+
+func (s *SecretCertificate) update(secret *corev1.Secret) error {
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("error parsing certificate/key pair from secret %s/%s: %v", secret.Namespace, secret.Name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cert = &cert
+	return nil
+}
+
+// GetCertificate returns the current valid certificate.
+// The ClientHello message is ignored
+// and is just there to be compatible with https://golang.org/pkg/crypto/tls/#Config.GetCertificate.
+func (s *SecretCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
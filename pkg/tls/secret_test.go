@@ -0,0 +1,103 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func newTLSSecret(t *testing.T, hostname string) *corev1.Secret {
+	certBytes, keyBytes, err := certutil.GenerateSelfSignedCertKey(hostname, nil, nil)
+	if err != nil {
+		t.Fatalf("generation of self signed cert and key failed: %v", err)
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "serving-cert"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certBytes,
+			corev1.TLSPrivateKeyKey: keyBytes,
+		},
+	}
+}
+
+func TestSecretCertificate(t *testing.T) {
+	client := fake.NewSimpleClientset(newTLSSecret(t, "foo"))
+
+	if _, err := NewSecretCertificate(client, "serving-cert"); err == nil {
+		t.Error("expected error for reference without namespace, got nil")
+	}
+
+	if _, err := NewSecretCertificate(client, "default/missing"); err == nil {
+		t.Error("expected error for missing secret, got nil")
+	}
+
+	s, err := NewSecretCertificate(client, "default/serving-cert")
+	if err != nil {
+		t.Fatalf("error creating secret certificate: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	check := func(want string) error {
+		return poll(10*time.Millisecond, time.Second, func() error {
+			return certCommonNameIs(s.GetCertificate, want)
+		})
+	}
+
+	if err := check("foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.CoreV1().Secrets("default").Update(ctx, newTLSSecret(t, "bar"), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating secret: %v", err)
+	}
+
+	if err := check("bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := newTLSSecret(t, "baz")
+	invalid.Data[corev1.TLSPrivateKeyKey] = []byte("invalid")
+	if _, err := client.CoreV1().Secrets("default").Update(ctx, invalid, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating secret: %v", err)
+	}
+
+	// Give the informer a chance to observe the invalid update.
+	time.Sleep(50 * time.Millisecond)
+	if err := check("bar"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// caValidityFactor is how many certificate lifetimes the generated CA is valid for.
+const caValidityFactor = 10
+
+// SelfSignedConfig holds the settings of generated self-signed serving certificates.
+type SelfSignedConfig struct {
+	// Hosts are the DNS names and IP addresses the certificate is valid for.
+	// The first host is used as common name.
+	Hosts []string
+	// Validity is the lifetime of the serving certificate.
+	Validity time.Duration
+	// RenewBefore is the time before expiry at which certificates are rotated.
+	RenewBefore time.Duration
+	// CAConfigMap, if set, is the ConfigMap in the form namespace/name
+	// the CA bundle is published to under the "ca.crt" key.
+	CAConfigMap string
+}
+
+// SelfSignedCertificate generates an in-memory CA and a serving certificate
+// signed by it, providing a goroutine safe GetCertificate method.
+//
+// For rotating certificates before they expire the Run method must be started explicitly.
+// The next CA is added to the published CA bundle a rotation before it signs the serving certificate,
+// and the previous CA is kept until it expires, so clients can trust both while the serving certificate changes over.
+type SelfSignedCertificate struct {
+	cfg                 SelfSignedConfig
+	client              kubernetes.Interface
+	cmNamespace, cmName string
+
+	mu     sync.RWMutex // protects the fields below
+	cert   *tls.Certificate
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPool []*x509.Certificate
+
+	// next is the published CA taking over from ca.
+	next    *x509.Certificate
+	nextKey *ecdsa.PrivateKey
+}
+
+// NewSelfSignedCertificate generates the initial CA and serving certificate
+// and publishes the CA bundle if a ConfigMap is configured.
+func NewSelfSignedCertificate(client kubernetes.Interface, cfg SelfSignedConfig) (*SelfSignedCertificate, error) {
+	if len(cfg.Hosts) == 0 {
+		return nil, fmt.Errorf("at least one host is required")
+	}
+	if cfg.Validity <= 0 || cfg.RenewBefore < 0 || cfg.RenewBefore >= cfg.Validity {
+		return nil, fmt.Errorf("renew before %v must be shorter than the validity %v", cfg.RenewBefore, cfg.Validity)
+	}
+
+	s := &SelfSignedCertificate{
+		cfg:    cfg,
+		client: client,
+	}
+
+	if cfg.CAConfigMap != "" {
+		parts := strings.Split(cfg.CAConfigMap, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid configmap reference %q, must be namespace/name", cfg.CAConfigMap)
+		}
+		if client == nil {
+			return nil, fmt.Errorf("a kubernetes client is required to publish the CA")
+		}
+		s.cmNamespace, s.cmName = parts[0], parts[1]
+	}
+
+	if err := s.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Run rotates the certificates before they expire,
+// blocking the current goroutine until the given context is done.
+func (s *SelfSignedCertificate) Run(ctx context.Context) error {
+	for {
+		s.mu.RLock()
+		wait := time.Until(s.cert.Leaf.NotAfter.Add(-s.cfg.RenewBefore))
+		s.mu.RUnlock()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil
+		}
+
+		if err := s.rotate(time.Now()); err != nil {
+			klog.Errorf("Failed to rotate self-signed certificate: %v", err)
+
+			select {
+			case <-time.After(time.Minute):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// GetCertificate returns the current valid certificate.
+// The ClientHello message is ignored
+// and is just there to be compatible with https://golang.org/pkg/crypto/tls/#Config.GetCertificate.
+func (s *SelfSignedCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
+
+// CABundle returns the PEM encoded CAs clients should trust.
+func (s *SelfSignedCertificate) CABundle() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return encodeCABundle(s.caPool)
+}
+
+func (s *SelfSignedCertificate) rotate(now time.Time) error {
+	s.mu.RLock()
+	ca, caKey, next, nextKey, pool := s.ca, s.caKey, s.next, s.nextKey, s.caPool
+	s.mu.RUnlock()
+
+	// The CA must outlive the serving certificate signed by it.
+	if ca != nil && now.Add(s.cfg.Validity).After(ca.NotAfter) {
+		ca, caKey, next, nextKey = next, nextKey, nil, nil
+	}
+
+	// The next CA is published one rotation before it signs serving certificates,
+	// so clients trust it by the time they see them. Without a CA to sign with,
+	// e.g. at startup or after failed rotations, the new CA is used right away.
+	renewal := s.cfg.Validity - s.cfg.RenewBefore
+	if ca == nil || (next == nil && now.Add(renewal+s.cfg.Validity).After(ca.NotAfter)) {
+		newCA, newKey, err := generateCA(s.cfg.Hosts[0], now, caValidityFactor*s.cfg.Validity)
+		if err != nil {
+			return fmt.Errorf("error generating CA: %v", err)
+		}
+
+		bundle := []*x509.Certificate{newCA}
+		for _, c := range pool {
+			if c.NotAfter.After(now) {
+				bundle = append(bundle, c)
+			}
+		}
+		pool = bundle
+
+		if err := s.publish(encodeCABundle(pool)); err != nil {
+			return fmt.Errorf("error publishing CA bundle: %v", err)
+		}
+		klog.Infof("Generated self-signed CA valid until %v", newCA.NotAfter)
+
+		if ca == nil {
+			ca, caKey = newCA, newKey
+		} else {
+			next, nextKey = newCA, newKey
+		}
+	}
+
+	cert, err := generateServingCert(ca, caKey, s.cfg.Hosts, now, s.cfg.Validity)
+	if err != nil {
+		return fmt.Errorf("error generating serving certificate: %v", err)
+	}
+	klog.Infof("Generated self-signed certificate for %v valid until %v", s.cfg.Hosts, cert.Leaf.NotAfter)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cert, s.ca, s.caKey, s.next, s.nextKey, s.caPool = cert, ca, caKey, next, nextKey, pool
+	return nil
+}
+
+func (s *SelfSignedCertificate) publish(bundle []byte) error {
+	if s.cmName == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	configMaps := s.client.CoreV1().ConfigMaps(s.cmNamespace)
+	cm, err := configMaps.Get(ctx, s.cmName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.cmNamespace, Name: s.cmName},
+			Data:       map[string]string{"ca.crt": string(bundle)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data["ca.crt"] = string(bundle)
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+func generateCA(host string, now time.Time, validity time.Duration) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("%s-ca@%d", host, now.Unix())},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ca, key, nil
+}
+
+func generateServingCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string, now time.Time, validity time.Duration) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("%s@%d", hosts[0], now.Unix())},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, keyPEM, err := encodeCert([][]byte{der, ca.Raw}, key)
+	if err != nil {
+		return nil, err
+	}
+	return parseCert(certPEM, keyPEM)
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+}
+
+func encodeCABundle(cas []*x509.Certificate) []byte {
+	var bundle []byte
+	for _, ca := range cas {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})...)
+	}
+	return bundle
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	s, err := NewSelfSignedCertificate(client, SelfSignedConfig{
+		Hosts:       []string{"foo.example.com", "127.0.0.1"},
+		Validity:    time.Hour,
+		RenewBefore: 10 * time.Minute,
+		CAConfigMap: "default/serving-ca",
+	})
+	if err != nil {
+		t.Fatalf("error creating self-signed certificate: %v", err)
+	}
+
+	if err := certCommonNameIs(s.GetCertificate, "foo.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := s.GetCertificate(nil)
+	if got := cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore); got != time.Hour+time.Minute {
+		t.Errorf("want certificate lifetime of 1h1m, got %v", got)
+	}
+	if len(cert.Leaf.IPAddresses) != 1 || len(cert.Leaf.DNSNames) != 1 {
+		t.Errorf("want one IP and one DNS SAN, got %v and %v", cert.Leaf.IPAddresses, cert.Leaf.DNSNames)
+	}
+
+	verify := func(cert *x509.Certificate) error {
+		cm, err := client.CoreV1().ConfigMaps("default").Get(context.TODO(), "serving-ca", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		cas, err := certutil.ParseCertsPEM([]byte(cm.Data["ca.crt"]))
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		for _, ca := range cas {
+			pool.AddCert(ca)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{
+			DNSName:     "foo.example.com",
+			Roots:       pool,
+			CurrentTime: cert.NotBefore.Add(time.Minute),
+		})
+		return err
+	}
+
+	if err := verify(cert.Leaf); err != nil {
+		t.Fatalf("want certificate to verify against published CA, got %v", err)
+	}
+
+	// Rotating close to the expiry of the CA rotates the CA as well,
+	// the previous CA stays in the published bundle.
+	if err := s.rotate(time.Now().Add(caValidityFactor*time.Hour - 30*time.Minute)); err != nil {
+		t.Fatalf("error rotating certificate: %v", err)
+	}
+
+	rotated, _ := s.GetCertificate(nil)
+	if rotated.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) == 0 {
+		t.Error("want a new certificate after rotation")
+	}
+	if err := verify(rotated.Leaf); err != nil {
+		t.Fatalf("want rotated certificate to verify against published CA, got %v", err)
+	}
+	if cas, _ := certutil.ParseCertsPEM(s.CABundle()); len(cas) != 2 {
+		t.Errorf("want 2 CAs in bundle, got %d", len(cas))
+	}
+}
+
+func TestSelfSignedCertificateCARotation(t *testing.T) {
+	s, err := NewSelfSignedCertificate(nil, SelfSignedConfig{
+		Hosts:       []string{"foo.example.com"},
+		Validity:    time.Hour,
+		RenewBefore: 10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("error creating self-signed certificate: %v", err)
+	}
+
+	issuers := map[string]bool{}
+	now := time.Now()
+	for i := 0; i < 30; i++ {
+		bundle := string(s.CABundle())
+
+		now = now.Add(50 * time.Minute)
+		if err := s.rotate(now); err != nil {
+			t.Fatalf("error rotating certificate: %v", err)
+		}
+
+		// The CA signing the serving certificate must have been published
+		// before the rotation, not at the same time.
+		cert, _ := s.GetCertificate(nil)
+		ca, err := x509.ParseCertificate(cert.Certificate[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(bundle, string(encodeCABundle([]*x509.Certificate{ca}))) {
+			t.Fatalf("rotation %d: want CA %q in the bundle before it signs the certificate", i, ca.Subject.CommonName)
+		}
+		issuers[ca.Subject.CommonName] = true
+	}
+
+	if len(issuers) < 2 {
+		t.Errorf("want the CA to be rotated, got issuers %v", issuers)
+	}
+}
+
+func TestSelfSignedCertificateInvalidConfig(t *testing.T) {
+	cases := []SelfSignedConfig{
+		{Validity: time.Hour},
+		{Hosts: []string{"foo"}, Validity: time.Hour, RenewBefore: time.Hour},
+		{Hosts: []string{"foo"}, Validity: time.Hour, CAConfigMap: "serving-ca"},
+	}
+
+	for _, cfg := range cases {
+		if _, err := NewSelfSignedCertificate(fake.NewSimpleClientset(), cfg); err == nil {
+			t.Errorf("expected error for config %+v, got nil", cfg)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// KeyProvider returns a signer for the private key referenced by uri,
+// e.g. a key stored in a hardware security module or a cloud KMS.
+// pub is the public key of the certificate the private key belongs to.
+type KeyProvider func(uri *url.URL, pub crypto.PublicKey) (crypto.Signer, error)
+
+var (
+	keyProvidersMu sync.RWMutex
+	keyProviders   = map[string]KeyProvider{}
+)
+
+// RegisterKeyProvider makes a key provider available for private key URIs with the given scheme.
+// It is meant to be called from init functions and panics if the scheme is registered twice.
+func RegisterKeyProvider(scheme string, p KeyProvider) {
+	keyProvidersMu.Lock()
+	defer keyProvidersMu.Unlock()
+
+	if _, ok := keyProviders[scheme]; ok {
+		panic(fmt.Sprintf("key provider for scheme %q already registered", scheme))
+	}
+	keyProviders[scheme] = p
+}
+
+// KeyProviders returns the schemes of the registered key providers.
+func KeyProviders() []string {
+	keyProvidersMu.RLock()
+	defer keyProvidersMu.RUnlock()
+
+	schemes := make([]string, 0, len(keyProviders))
+	for scheme := range keyProviders {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// NewSignerCertificate loads the PEM encoded certificate chain from certFile
+// and pairs it with the private key referenced by keyURI, which never leaves its key provider.
+func NewSignerCertificate(certFile, keyURI string) (*tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificate: %v", err)
+	}
+
+	certs, err := certutil.ParseCertsPEM(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate: %v", err)
+	}
+
+	cert := tls.Certificate{Leaf: certs[0]}
+	for _, c := range certs {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+
+	u, err := url.Parse(keyURI)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key URI: %v", err)
+	}
+
+	keyProvidersMu.RLock()
+	provider, ok := keyProviders[u.Scheme]
+	keyProvidersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no key provider for scheme %q, available are %v", u.Scheme, KeyProviders())
+	}
+
+	signer, err := provider(u, cert.Leaf.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading private key: %v", err)
+	}
+
+	if !reflect.DeepEqual(signer.Public(), cert.Leaf.PublicKey) {
+		return nil, fmt.Errorf("private key does not match the certificate")
+	}
+	// Providers like PKCS #11 return the certificate's public key as their
+	// own, so check the signatures of the key match it, too.
+	if err := verifySigner(signer, cert.Leaf.PublicKey); err != nil {
+		return nil, fmt.Errorf("private key does not match the certificate: %v", err)
+	}
+
+	cert.PrivateKey = signer
+	return &cert, nil
+}
+
+// verifySigner signs a test digest and verifies the signature with pub.
+func verifySigner(signer crypto.Signer, pub crypto.PublicKey) error {
+	digest := sha256.Sum256([]byte("kube-rbac-proxy key check"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("error signing test digest: %v", err)
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		var esig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &esig); err != nil {
+			return fmt.Errorf("invalid signature: %v", err)
+		}
+		if !ecdsa.Verify(pub, digest[:], esig.R, esig.S) {
+			return fmt.Errorf("signature of test digest doesn't verify")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("signature of test digest doesn't verify: %v", err)
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignerCertificate(t *testing.T) {
+	ca, caKey, err := generateCA("test", time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := generateServingCert(ca, caKey, []string{"foo.example.com"}, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var certPEM []byte
+	for _, der := range cert.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	certPath, err := writeTempFile("cert", certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterKeyProvider("test", func(uri *url.URL, pub crypto.PublicKey) (crypto.Signer, error) {
+		switch uri.Opaque {
+		case "other":
+			return otherKey, nil
+		case "wrong-label":
+			// Claims the certificate's public key, like the PKCS #11 provider.
+			return &publicKeySigner{Signer: otherKey, pub: pub}, nil
+		}
+		return cert.PrivateKey.(crypto.Signer), nil
+	})
+
+	if _, err := NewSignerCertificate(certPath, "unknown:key"); err == nil {
+		t.Error("expected error for unknown scheme, got nil")
+	}
+	if _, err := NewSignerCertificate(certPath, "test:other"); err == nil {
+		t.Error("expected error for mismatching key, got nil")
+	}
+	if _, err := NewSignerCertificate(certPath, "test:wrong-label"); err == nil {
+		t.Error("expected error for key signing with a different key than its public key, got nil")
+	}
+
+	got, err := NewSignerCertificate(certPath, "test:key")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if len(got.Certificate) != 2 {
+		t.Errorf("want certificate chain of 2, got %d", len(got.Certificate))
+	}
+
+	// The signer certificate must be usable for handshakes.
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*got}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "foo.example.com"})
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	conn.Close()
+}
+
+// publicKeySigner signs with Signer, but reports pub as its public key.
+type publicKeySigner struct {
+	crypto.Signer
+	pub crypto.PublicKey
+}
+
+func (s *publicKeySigner) Public() crypto.PublicKey {
+	return s.pub
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GetCertificateFunc is the signature of https://golang.org/pkg/crypto/tls/#Config.GetCertificate.
+type GetCertificateFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+// SNICertificates selects the serving certificate by the server name the client requested via SNI.
+//
+// Names are matched exactly, then by wildcard ("*.example.com" matches "foo.example.com").
+// If no name matches, the default is used.
+type SNICertificates struct {
+	def    GetCertificateFunc
+	byName map[string]GetCertificateFunc
+}
+
+// NewSNICertificates creates a SNI certificate selector falling back to def.
+// def may be nil, in which case the certificates of the tls.Config are used as fallback.
+func NewSNICertificates(def GetCertificateFunc) *SNICertificates {
+	return &SNICertificates{
+		def:    def,
+		byName: map[string]GetCertificateFunc{},
+	}
+}
+
+// Add registers the certificate to serve for the given server name.
+func (s *SNICertificates) Add(name string, get GetCertificateFunc) {
+	s.byName[strings.ToLower(name)] = get
+}
+
+// Len returns the number of server names with a dedicated certificate.
+func (s *SNICertificates) Len() int {
+	return len(s.byName)
+}
+
+// Names returns the server names with a dedicated certificate in sorted order.
+func (s *SNICertificates) Names() []string {
+	names := make([]string, 0, len(s.byName))
+	for name := range s.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetCertificate returns the certificate for the requested server name.
+// Its signature is compatible with https://golang.org/pkg/crypto/tls/#Config.GetCertificate.
+func (s *SNICertificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	for _, name := range serverNameCandidates(hello.ServerName) {
+		if get, ok := s.byName[name]; ok {
+			return get(hello)
+		}
+	}
+
+	if s.def == nil {
+		return nil, nil
+	}
+	return s.def(hello)
+}
+
+// serverNameCandidates returns the names a requested server name matches in order of precedence,
+// the name itself and the wildcard name for its parent domain.
+func serverNameCandidates(serverName string) []string {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+
+	if i := strings.Index(name, "."); i > 0 {
+		return []string{name, "*" + name[i:]}
+	}
+	return []string{name}
+}
+
+// CertificateNames returns the DNS names the certificate is valid for,
+// or its common name if it has no DNS subject alternative names.
+func CertificateNames(cert *tls.Certificate) ([]string, error) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("no certificate given")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate: %v", err)
+	}
+
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames, nil
+	}
+	if leaf.Subject.CommonName != "" {
+		return []string{leaf.Subject.CommonName}, nil
+	}
+	return nil, fmt.Errorf("certificate has neither DNS names nor a common name")
+}
+
+// SNIClientCAs selects the CAs client certificates are verified against by the
+// server name the client requested via SNI, matched like in SNICertificates.
+//
+// Clients requesting server names without dedicated CAs are handled by the base config.
+type SNIClientCAs struct {
+	base  *tls.Config
+	pools map[string]*x509.CertPool
+
+	once    sync.Once
+	configs map[string]*tls.Config
+}
+
+// NewSNIClientCAs creates a client CA selector for the base config. The base
+// config is copied for the server names on the first handshake, so it may be
+// changed until the server starts, e.g. by http2.ConfigureServer.
+func NewSNIClientCAs(base *tls.Config) *SNIClientCAs {
+	return &SNIClientCAs{
+		base:  base,
+		pools: map[string]*x509.CertPool{},
+	}
+}
+
+// Add registers the CAs to verify client certificates against for the given server name.
+// Client certificates are verified if given, or required if the base config requires them.
+// Names added after the first handshake are ignored.
+func (s *SNIClientCAs) Add(name string, clientCAs *x509.CertPool) {
+	s.pools[strings.ToLower(name)] = clientCAs
+}
+
+// GetConfigForClient returns the config with the client CAs for the requested server name.
+// Its signature is compatible with https://golang.org/pkg/crypto/tls/#Config.GetConfigForClient.
+func (s *SNIClientCAs) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	s.once.Do(s.buildConfigs)
+
+	for _, name := range serverNameCandidates(hello.ServerName) {
+		if c, ok := s.configs[name]; ok {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *SNIClientCAs) buildConfigs() {
+	s.configs = make(map[string]*tls.Config, len(s.pools))
+	for name, pool := range s.pools {
+		c := s.base.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = pool
+
+		switch s.base.ClientAuth {
+		case tls.RequireAnyClientCert, tls.RequireAndVerifyClientCert:
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		default:
+			c.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		s.configs[name] = c
+	}
+}
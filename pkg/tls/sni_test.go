@@ -0,0 +1,189 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func newCertificate(t *testing.T, host string, alternateDNS ...string) *tls.Certificate {
+	certBytes, keyBytes, err := certutil.GenerateSelfSignedCertKey(host, nil, alternateDNS)
+	if err != nil {
+		t.Fatalf("generation of self signed cert and key failed: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certBytes, keyBytes)
+	if err != nil {
+		t.Fatalf("error parsing cert and key: %v", err)
+	}
+	return &cert
+}
+
+func staticCertificate(cert *tls.Certificate) GetCertificateFunc {
+	return func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return cert, nil
+	}
+}
+
+func TestSNICertificates(t *testing.T) {
+	def := newCertificate(t, "default")
+	foo := newCertificate(t, "foo.example.com")
+	wildcard := newCertificate(t, "wildcard.example.com")
+
+	sni := NewSNICertificates(staticCertificate(def))
+	sni.Add("Foo.example.com", staticCertificate(foo))
+	sni.Add("*.example.com", staticCertificate(wildcard))
+
+	for serverName, want := range map[string]*tls.Certificate{
+		"foo.example.com":  foo,
+		"foo.example.com.": foo,
+		"bar.example.com":  wildcard,
+		"a.b.example.com":  def,
+		"":                 def,
+	} {
+		got, err := sni.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		if err != nil {
+			t.Fatalf("want err to be nil, but got %v", err)
+		}
+		if got != want {
+			t.Errorf("unexpected certificate for server name %q", serverName)
+		}
+	}
+}
+
+func TestCertificateNames(t *testing.T) {
+	names, err := CertificateNames(newCertificate(t, "foo.example.com", "bar.example.com"))
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if want := []string{"foo.example.com", "bar.example.com"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("want names %v, got %v", want, names)
+	}
+
+	if _, err := CertificateNames(nil); err == nil {
+		t.Error("expected error for missing certificate, got nil")
+	}
+}
+
+func newClientCertificate(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := newSerial()
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSNIClientCAs(t *testing.T) {
+	caA, caAKey, err := generateCA("a", time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caB, caBKey, err := generateCA("b", time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	poolA := x509.NewCertPool()
+	poolA.AddCert(caA)
+
+	base := &tls.Config{Certificates: []tls.Certificate{*newCertificate(t, "server")}}
+	sni := NewSNIClientCAs(base)
+	sni.Add("a.example.com", poolA)
+	base.GetConfigForClient = sni.GetConfigForClient
+	// Like http2.ConfigureServer, after the client CAs were set up.
+	base.NextProtos = []string{"h2", "http/1.1"}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+				_, _ = conn.Write([]byte("ok"))
+			}(conn)
+		}
+	}()
+
+	handshake := func(serverName string, certs []tls.Certificate) error {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+			ServerName: serverName,
+			// Present the certificate even if it isn't issued by one of the requested CAs.
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				if len(certs) == 0 {
+					return &tls.Certificate{}, nil
+				}
+				return &certs[0], nil
+			},
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if p := conn.ConnectionState().NegotiatedProtocol; p != "h2" {
+			return fmt.Errorf("want h2 to be negotiated, got %q", p)
+		}
+		// Client certificate errors surface on the first read with TLS 1.3.
+		_, err = conn.Read(make([]byte, 2))
+		return err
+	}
+
+	if err := handshake("a.example.com", []tls.Certificate{newClientCertificate(t, caA, caAKey)}); err != nil {
+		t.Errorf("want client certificate of CA A to be accepted for a.example.com, got %v", err)
+	}
+	if err := handshake("a.example.com", []tls.Certificate{newClientCertificate(t, caB, caBKey)}); err == nil {
+		t.Error("want client certificate of CA B to be refused for a.example.com")
+	}
+	if err := handshake("a.example.com", nil); err != nil {
+		t.Errorf("want clients without certificate to be accepted, got %v", err)
+	}
+	if err := handshake("b.example.com", []tls.Certificate{newClientCertificate(t, caB, caBKey)}); err != nil {
+		t.Errorf("want base config to not verify client certificates, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"k8s.io/klog/v2"
+)
+
+// SPIFFESource obtains X509-SVIDs from the SPIFFE Workload API, e.g. served by a SPIRE agent.
+// The SVIDs are rotated by the Workload API, the latest one is always returned.
+type SPIFFESource struct {
+	// newSource connects to the Workload API.
+	newSource func(ctx context.Context) (x509Source, error)
+
+	mu     sync.RWMutex // protects the source
+	source x509Source
+}
+
+// x509Source is implemented by workloadapi.X509Source.
+type x509Source interface {
+	x509svid.Source
+	Close() error
+}
+
+// NewSPIFFESource connects to the Workload API at addr, e.g. "unix:///run/spire/sockets/agent.sock",
+// and blocks until the first SVID is received or ctx is done.
+// If addr is empty, the SPIFFE_ENDPOINT_SOCKET environment variable is used.
+func NewSPIFFESource(ctx context.Context, addr string) (*SPIFFESource, error) {
+	s := NewPendingSPIFFESource(addr)
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewPendingSPIFFESource returns a SPIFFESource connecting to the Workload API at addr only once Run is started.
+// Until the first SVID is received, GetCertificate and GetClientCertificate return an error.
+func NewPendingSPIFFESource(addr string) *SPIFFESource {
+	var opts []workloadapi.X509SourceOption
+	if addr != "" {
+		opts = append(opts, workloadapi.WithClientOptions(workloadapi.WithAddr(addr)))
+	}
+
+	return &SPIFFESource{
+		newSource: func(ctx context.Context) (x509Source, error) {
+			source, err := workloadapi.NewX509Source(ctx, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return source, nil
+		},
+	}
+}
+
+func (s *SPIFFESource) connect(ctx context.Context) error {
+	source, err := s.newSource(ctx)
+	if err != nil {
+		return fmt.Errorf("error obtaining X509-SVID from workload API: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.source = source
+	return nil
+}
+
+func (s *SPIFFESource) get() (x509Source, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.source == nil {
+		return nil, fmt.Errorf("no X509-SVID received from workload API yet")
+	}
+	return s.source, nil
+}
+
+// GetCertificate returns the current X509-SVID as serving certificate.
+// Its signature is compatible with https://golang.org/pkg/crypto/tls/#Config.GetCertificate.
+func (s *SPIFFESource) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	source, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return tlsconfig.GetCertificate(source)(hello)
+}
+
+// GetClientCertificate returns the current X509-SVID as client certificate.
+// Its signature is compatible with https://golang.org/pkg/crypto/tls/#Config.GetClientCertificate.
+func (s *SPIFFESource) GetClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	source, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return tlsconfig.GetClientCertificate(source)(info)
+}
+
+// Run connects to the Workload API if not connected yet and keeps receiving X509-SVID updates
+// until ctx is done, then closes the connection to the Workload API.
+func (s *SPIFFESource) Run(ctx context.Context) error {
+	if _, err := s.get(); err != nil {
+		if err := s.connect(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		klog.Info("Received X509-SVID from SPIFFE Workload API")
+	}
+
+	<-ctx.Done()
+
+	source, _ := s.get()
+	return source.Close()
+}
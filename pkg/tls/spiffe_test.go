@@ -0,0 +1,184 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// fakeX509Source is a Workload API source whose X509-SVID can be rotated.
+type fakeX509Source struct {
+	mu     sync.Mutex
+	svid   *x509svid.SVID
+	closed bool
+}
+
+func (f *fakeX509Source) GetX509SVID() (*x509svid.SVID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.svid, nil
+}
+
+func (f *fakeX509Source) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeX509Source) rotate(svid *x509svid.SVID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.svid = svid
+}
+
+func (f *fakeX509Source) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func generateSVID(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) *x509svid.SVID {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := url.Parse("spiffe://example.org/kube-rbac-proxy")
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "kube-rbac-proxy"},
+		URIs:         []*url.URL{id},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &x509svid.SVID{Certificates: []*x509.Certificate{cert}, PrivateKey: key}
+}
+
+func TestSPIFFESource(t *testing.T) {
+	ca, caKey, err := generateCA("spiffe", time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := generateSVID(t, ca, caKey)
+	fake := &fakeX509Source{svid: initial}
+
+	// The Workload API becomes available only after Run is started.
+	available := make(chan struct{})
+	s := NewPendingSPIFFESource("")
+	s.newSource = func(ctx context.Context) (x509Source, error) {
+		select {
+		case <-available:
+			return fake, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run(ctx)
+	}()
+
+	if _, err := s.GetCertificate(nil); err == nil {
+		t.Error("want error while the Workload API is not available, got nil")
+	}
+	if _, err := s.GetClientCertificate(nil); err == nil {
+		t.Error("want error while the Workload API is not available, got nil")
+	}
+	close(available)
+
+	waitForSVID := func(svid *x509svid.SVID) {
+		t.Helper()
+		for i := 0; i < 500; i++ {
+			cert, err := s.GetCertificate(nil)
+			if err == nil && bytes.Equal(cert.Certificate[0], svid.Certificates[0].Raw) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("timed out waiting for X509-SVID")
+	}
+	waitForSVID(initial)
+
+	// Rotated SVIDs are returned right away.
+	rotated := generateSVID(t, ca, caKey)
+	fake.rotate(rotated)
+	waitForSVID(rotated)
+	cert, err := s.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if !bytes.Equal(cert.Certificate[0], rotated.Certificates[0].Raw) {
+		t.Error("want the rotated X509-SVID as client certificate")
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("want err to be nil, but got %v", err)
+	}
+	if !fake.isClosed() {
+		t.Error("want the Workload API source to be closed")
+	}
+}
+
+func TestSPIFFESourcePendingCanceled(t *testing.T) {
+	s := NewPendingSPIFFESource("")
+	s.newSource = func(ctx context.Context) (x509Source, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Errorf("want Run to return without error when canceled before the Workload API is available, got %v", err)
+	}
+	if _, err := s.GetCertificate(nil); err == nil {
+		t.Error("want error without X509-SVID, got nil")
+	}
+
+	if _, err := NewSPIFFESource(ctx, "unix:///non-existent.sock"); err == nil {
+		t.Error("want error for unavailable Workload API, got nil")
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+var versions = map[string]uint16{
+	"VersionTLS10": tls.VersionTLS10,
+	"VersionTLS11": tls.VersionTLS11,
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+// Version returns the TLS version constant for the given name,
+// e.g. "VersionTLS12" as in https://golang.org/pkg/crypto/tls/#pkg-constants.
+func Version(versionName string) (uint16, error) {
+	if version, ok := versions[versionName]; ok {
+		return version, nil
+	}
+	return 0, fmt.Errorf("unknown tls version %q", versionName)
+}
+
+// VersionRange returns the TLS versions for the given minimum and maximum
+// version names. An empty maximum allows the highest version Go supports.
+//
+// Ranges allowing TLS 1.0 or 1.1 are refused as insecure.
+func VersionRange(minName, maxName string) (min, max uint16, err error) {
+	min, err = Version(minName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid minimum version: %v", err)
+	}
+	if min < tls.VersionTLS12 {
+		return 0, 0, fmt.Errorf("minimum version %s allows insecure versions, it must be at least VersionTLS12", minName)
+	}
+
+	if maxName == "" {
+		return min, 0, nil
+	}
+
+	max, err = Version(maxName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid maximum version: %v", err)
+	}
+
+	if min > max {
+		return 0, 0, fmt.Errorf("minimum version %s is greater than maximum version %s", minName, maxName)
+	}
+
+	return min, max, nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestVersionRange(t *testing.T) {
+	cases := []struct {
+		name     string
+		min, max string
+		wantMin  uint16
+		wantMax  uint16
+		wantErr  bool
+	}{
+		{name: "min only", min: "VersionTLS12", wantMin: tls.VersionTLS12},
+		{name: "min and max", min: "VersionTLS12", max: "VersionTLS13", wantMin: tls.VersionTLS12, wantMax: tls.VersionTLS13},
+		{name: "tls 1.3 only", min: "VersionTLS13", max: "VersionTLS13", wantMin: tls.VersionTLS13, wantMax: tls.VersionTLS13},
+		{name: "min greater than max", min: "VersionTLS13", max: "VersionTLS12", wantErr: true},
+		{name: "insecure min", min: "VersionTLS10", wantErr: true},
+		{name: "insecure min with max", min: "VersionTLS11", max: "VersionTLS13", wantErr: true},
+		{name: "insecure max", min: "VersionTLS10", max: "VersionTLS11", wantErr: true},
+		{name: "unknown min", min: "VersionSSL30", wantErr: true},
+		{name: "unknown max", min: "VersionTLS12", max: "TLS13", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			min, max, err := VersionRange(c.min, c.max)
+			if c.wantErr {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want err to be nil, but got %v", err)
+			}
+			if min != c.wantMin || max != c.wantMax {
+				t.Errorf("want versions %x-%x, got %x-%x", c.wantMin, c.wantMax, min, max)
+			}
+		})
+	}
+}
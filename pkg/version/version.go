@@ -0,0 +1,59 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package version holds the build information of kube-rbac-proxy, which is
+// set at build time via -ldflags, e.g.
+// -X github.com/brancz/kube-rbac-proxy/pkg/version.Version=v0.8.0.
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+var (
+	// Version is the version of kube-rbac-proxy.
+	Version = "unknown"
+	// Revision is the git commit SHA kube-rbac-proxy was built from.
+	Revision = "unknown"
+	// BuildDate is the date of the build in RFC 3339 format.
+	BuildDate = "unknown"
+)
+
+// Info is the build information.
+type Info struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Revision:  Revision,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// String returns the build information in a single line.
+func (i Info) String() string {
+	return fmt.Sprintf("kube-rbac-proxy %s (revision: %s, build date: %s, go: %s, platform: %s)", i.Version, i.Revision, i.BuildDate, i.GoVersion, i.Platform)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package watchdog samples the proxy's own resource usage and logs
+// diagnostics when it exceeds thresholds, to catch leaks in long-running
+// sidecars.
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+// Resources checked by the watchdog.
+const (
+	ResourceGoroutines = "goroutines"
+	ResourceOpenFDs    = "open_fds"
+	ResourceHeap       = "heap"
+)
+
+// Config configures the watchdog. Thresholds of 0 are disabled.
+type Config struct {
+	// Interval is the time between samples.
+	Interval time.Duration
+	// MaxGoroutines is the number of goroutines above which a goroutine
+	// dump is logged.
+	MaxGoroutines int
+	// MaxOpenFDs is the number of open file descriptors above which the
+	// count is logged.
+	MaxOpenFDs int
+	// MaxHeapBytes is the heap size in bytes above which memory
+	// statistics and a goroutine dump are logged.
+	MaxHeapBytes uint64
+}
+
+// Sample is the resource usage at a point in time.
+type Sample struct {
+	Goroutines int
+	// OpenFDs is -1 if the number of open file descriptors is unknown.
+	OpenFDs   int
+	HeapBytes uint64
+	MemStats  runtime.MemStats
+}
+
+// Watchdog samples resource usage periodically.
+type Watchdog struct {
+	cfg    Config
+	sample func() Sample
+
+	mu        sync.Mutex
+	exceeded  map[string]bool
+	watermark uint64
+}
+
+// New returns a watchdog for cfg.
+func New(cfg Config) *Watchdog {
+	return &Watchdog{
+		cfg:      cfg,
+		sample:   sample,
+		exceeded: map[string]bool{},
+	}
+}
+
+// Run samples resource usage every interval until ctx is done.
+func (w *Watchdog) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.Check()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Check samples resource usage once, updates the heap watermark and logs
+// diagnostics for thresholds which were exceeded since the last check.
+// Diagnostics are logged again only after usage fell below the threshold.
+func (w *Watchdog) Check() {
+	s := w.sample()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if s.HeapBytes > w.watermark {
+		w.watermark = s.HeapBytes
+		metrics.HeapWatermark.Set(float64(s.HeapBytes))
+	}
+
+	if w.crossed(ResourceGoroutines, w.cfg.MaxGoroutines > 0 && s.Goroutines > w.cfg.MaxGoroutines) {
+		klog.Warningf("Number of goroutines %d exceeds %d, goroutines:\n%s", s.Goroutines, w.cfg.MaxGoroutines, goroutineDump())
+	}
+	if w.crossed(ResourceOpenFDs, w.cfg.MaxOpenFDs > 0 && s.OpenFDs > w.cfg.MaxOpenFDs) {
+		klog.Warningf("Number of open file descriptors %d exceeds %d, with %d goroutines", s.OpenFDs, w.cfg.MaxOpenFDs, s.Goroutines)
+	}
+	if w.crossed(ResourceHeap, w.cfg.MaxHeapBytes > 0 && s.HeapBytes > w.cfg.MaxHeapBytes) {
+		klog.Warningf("Heap size of %d bytes exceeds %d bytes (objects: %d, GC cycles: %d, next GC at %d bytes), goroutines:\n%s",
+			s.HeapBytes, w.cfg.MaxHeapBytes, s.MemStats.HeapObjects, s.MemStats.NumGC, s.MemStats.NextGC, goroutineDump())
+	}
+}
+
+// crossed records whether the threshold of resource is exceeded, and
+// returns true if it wasn't exceeded at the last check.
+func (w *Watchdog) crossed(resource string, exceeded bool) bool {
+	was := w.exceeded[resource]
+	w.exceeded[resource] = exceeded
+	if exceeded && !was {
+		metrics.WatchdogThresholdExceeded.WithLabelValues(resource).Inc()
+		return true
+	}
+	return false
+}
+
+func sample() Sample {
+	s := Sample{
+		Goroutines: runtime.NumGoroutine(),
+		OpenFDs:    openFDs(),
+	}
+	runtime.ReadMemStats(&s.MemStats)
+	s.HeapBytes = s.MemStats.HeapInuse
+	return s
+}
+
+// openFDs returns the number of open file descriptors, or -1 if it can't be
+// determined, e.g. on other platforms than Linux.
+func openFDs() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+// goroutineDump returns the stacks of all goroutines, with goroutines of
+// identical stacks grouped.
+func goroutineDump() string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return err.Error()
+	}
+	return buf.String()
+}
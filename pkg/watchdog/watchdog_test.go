@@ -0,0 +1,57 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watchdog
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+)
+
+func TestCheck(t *testing.T) {
+	w := New(Config{MaxGoroutines: 100, MaxHeapBytes: 1 << 20})
+
+	samples := []Sample{
+		{Goroutines: 10, HeapBytes: 1 << 10},
+		{Goroutines: 200, HeapBytes: 1 << 19},
+		{Goroutines: 300, HeapBytes: 1 << 10},
+		{Goroutines: 10, HeapBytes: 1 << 21},
+		{Goroutines: 200, HeapBytes: 1 << 10},
+	}
+	goroutines := metrics.WatchdogThresholdExceeded.WithLabelValues(ResourceGoroutines)
+	heap := metrics.WatchdogThresholdExceeded.WithLabelValues(ResourceHeap)
+	goroutinesBefore, heapBefore := testutil.ToFloat64(goroutines), testutil.ToFloat64(heap)
+
+	for _, s := range samples {
+		s := s
+		w.sample = func() Sample { return s }
+		w.Check()
+	}
+
+	// Exceeding the threshold in consecutive checks is reported once.
+	if got := testutil.ToFloat64(goroutines) - goroutinesBefore; got != 2 {
+		t.Errorf("want goroutine threshold to be exceeded twice, got %v", got)
+	}
+	if got := testutil.ToFloat64(heap) - heapBefore; got != 1 {
+		t.Errorf("want heap threshold to be exceeded once, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.HeapWatermark); got != 1<<21 {
+		t.Errorf("want heap watermark of %d bytes, got %v", 1<<21, got)
+	}
+}
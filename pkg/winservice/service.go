@@ -0,0 +1,65 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package winservice runs kube-rbac-proxy as a Windows service, reporting its
+// status to the service control manager and logging to the event log.
+package winservice
+
+import "sync"
+
+// Service is the Windows service the process runs as. The methods of a nil
+// Service, i.e. when not running as a service, do nothing.
+type Service struct {
+	stopOnce sync.Once
+	stopping chan struct{}
+	doneOnce sync.Once
+	done     chan struct{}
+	err      error
+}
+
+func newService() *Service {
+	return &Service{
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Stopping is closed when the service control manager requests the service
+// to stop, e.g. because the node shuts down.
+func (s *Service) Stopping() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.stopping
+}
+
+// Stopped reports the service as stopped, with a non-zero exit code if err
+// isn't nil. It must be called before the process exits.
+func (s *Service) Stopped(err error) {
+	if s == nil {
+		return
+	}
+	s.doneOnce.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+func (s *Service) stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopping)
+	})
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package winservice
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"k8s.io/klog/v2"
+)
+
+// Start reports to the service control manager that the process runs as the
+// Windows service name. Logs are written to the event log of the source name,
+// if it is registered, and relative paths are resolved against the directory
+// of the executable instead of the system directory services are started in.
+func Start(name string) (*Service, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine executable path: %v", err)
+	}
+	if err := os.Chdir(filepath.Dir(exe)); err != nil {
+		return nil, fmt.Errorf("failed to change to the directory of the executable: %v", err)
+	}
+
+	if l, err := eventlog.Open(name); err != nil {
+		klog.Warningf("Failed to open event log %s, logging to stderr: %v", name, err)
+	} else {
+		klog.LogToStderr(false)
+		klog.SetOutput(&eventLogWriter{log: l})
+	}
+
+	s := newService()
+	go func() {
+		if err := svc.Run(name, &handler{s: s}); err != nil {
+			klog.Errorf("Failed to run as Windows service %s: %v", name, err)
+			s.stop()
+		}
+	}()
+	return s, nil
+}
+
+// handler reports the status of the service to the service control manager.
+type handler struct {
+	s *Service
+}
+
+func (h *handler) Execute(_ []string, r <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				h.s.stop()
+			}
+		case <-h.s.done:
+			if h.s.err != nil {
+				return false, 1
+			}
+			return false, 0
+		}
+	}
+}
+
+// eventLogWriter writes klog lines to the event log, with the event type of
+// their severity.
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+func (w *eventLogWriter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimSpace(p))
+	var err error
+	switch {
+	case bytes.HasPrefix(p, []byte("E")), bytes.HasPrefix(p, []byte("F")):
+		err = w.log.Error(1, msg)
+	case bytes.HasPrefix(p, []byte("W")):
+		err = w.log.Warning(1, msg)
+	default:
+		err = w.log.Info(1, msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
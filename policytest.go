@@ -0,0 +1,230 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+	"github.com/brancz/kube-rbac-proxy/pkg/logging"
+	"github.com/brancz/kube-rbac-proxy/pkg/proxy"
+	"github.com/brancz/kube-rbac-proxy/pkg/routing"
+)
+
+const testUsage = `Usage: kube-rbac-proxy test [flags] -- [proxy flags]
+
+test prints the route and authorization attributes the proxy run with the
+given proxy flags derives for a request of the user, without sending real
+traffic. With --evaluate the attributes are checked with SubjectAccessReviews
+against the kube-apiserver of the --kubeconfig among the proxy flags.
+`
+
+// policyTest is a request to test the authorization of.
+type policyTest struct {
+	user     string
+	groups   []string
+	method   string
+	path     string
+	host     string
+	evaluate bool
+}
+
+// runTest runs the test subcommand with the given arguments.
+func runTest(args []string) error {
+	t := policyTest{}
+	flagset := pflag.NewFlagSet("test", pflag.ExitOnError)
+	flagset.Usage = func() {
+		fmt.Fprint(os.Stderr, testUsage+"\n")
+		flagset.PrintDefaults()
+	}
+	flagset.StringVar(&t.user, "user", "", "The name of the user sending the request.")
+	flagset.StringSliceVar(&t.groups, "groups", nil, "The groups of the user sending the request.")
+	flagset.StringVar(&t.method, "method", http.MethodGet, "The HTTP method of the request.")
+	flagset.StringVar(&t.path, "path", "/", "The path of the request, including the query string.")
+	flagset.StringVar(&t.host, "host", "", "The host name of the request, selecting a virtual host.")
+	flagset.BoolVar(&t.evaluate, "evaluate", false, "Check the attributes with SubjectAccessReviews against the kube-apiserver of the --kubeconfig among the proxy flags, or the in-cluster config.")
+	flagset.Parse(args)
+
+	if t.user == "" {
+		return fmt.Errorf("--user is required")
+	}
+	if !strings.HasPrefix(t.path, "/") {
+		return fmt.Errorf("--path must start with \"/\"")
+	}
+
+	proxyArgs := flagset.Args()
+	if dash := flagset.ArgsLenAtDash(); dash >= 0 {
+		proxyArgs = proxyArgs[dash:]
+	}
+	cfg, _, err := parseConfig(proxyArgs)
+	if err != nil {
+		return err
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	var authorizer authorizer.Authorizer
+	if t.evaluate {
+		kcfg := initKubeConfig(cfg.kubeconfigLocation, cfg.kubeconfigContext)
+		kcfg.Proxy, err = proxyFunc(cfg.kubeAPIProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid --kube-api-proxy-url: %v", err)
+		}
+		kubeClient, err := kubernetes.NewForConfig(kcfg)
+		if err != nil {
+			return fmt.Errorf("failed to instantiate Kubernetes client: %v", err)
+		}
+		authorizer, err = authz.NewAuthorizer(kubeClient.AuthorizationV1().SubjectAccessReviews())
+		if err != nil {
+			return fmt.Errorf("failed to create authorizer: %v", err)
+		}
+	}
+
+	return t.run(os.Stdout, cfg, authorizer)
+}
+
+// run writes the result of the test of cfg to w. Without an authorizer, the
+// attributes are derived but not checked.
+func (t policyTest) run(w io.Writer, cfg *config, a authorizer.Authorizer) error {
+	req := httptest.NewRequest(t.method, t.path, nil)
+	if t.host != "" {
+		req.Host = t.host
+	}
+
+	// The routers of the proxy select the authorization config.
+	route, authzConfig := "", cfg.auth.Authorization
+	selector := func(name string, c *authz.Config) http.Handler {
+		return http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			route, authzConfig = name, c
+		})
+	}
+	paths := routing.NewPaths(selector(defaultRoute, cfg.auth.Authorization))
+	for _, r := range cfg.routes {
+		paths.Add(r.PathPrefix, selector(r.Name, authorizationConfig(r.Authorization, cfg.auth.Authorization)))
+	}
+	hosts := routing.NewHosts(paths)
+	for _, h := range cfg.hosts {
+		hosts.Add(h.Host, selector(h.Host, authorizationConfig(h.Authorization, cfg.auth.Authorization)))
+	}
+	hosts.ServeHTTP(httptest.NewRecorder(), req)
+	fmt.Fprintf(w, "Route: %s\n", route)
+
+	if len(cfg.allowPaths) > 0 && !contains(cfg.allowPaths, req.URL.Path) {
+		fmt.Fprintln(w, "The path is not in --allow-paths, the request is answered with 404 Not Found.")
+		return nil
+	}
+	if contains(cfg.ignorePaths, req.URL.Path) {
+		fmt.Fprintln(w, "The path is in --ignore-paths, the request is passed on without authentication and authorization.")
+		return nil
+	}
+
+	recorder := &recordingAuthorizer{authorizer: a}
+	u := &user.DefaultInfo{Name: t.user, Groups: t.groups}
+	h, err := proxy.NewHandler(proxy.HandlerConfig{
+		Config: proxy.Config{Authorization: authzConfig},
+		Authenticator: authenticator.RequestFunc(func(*http.Request) (*authenticator.Response, bool, error) {
+			return &authenticator.Response{User: u}, true, nil
+		}),
+		Authorizer: recorder,
+	}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	if err != nil {
+		return err
+	}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+
+	if len(recorder.attrs) == 0 {
+		fmt.Fprintf(w, "No authorization attributes can be derived, the request is answered with %d %s.\n", rw.Code, http.StatusText(rw.Code))
+		return nil
+	}
+	fmt.Fprintln(w, "Authorization attributes:")
+	for i, attrs := range logging.FormatAttributes(recorder.attrs) {
+		if a == nil {
+			fmt.Fprintf(w, "  %s\n", attrs)
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %s\n", attrs, recorder.results[i])
+	}
+	if a == nil {
+		fmt.Fprintln(w, "Not evaluated, use --evaluate to check the attributes with SubjectAccessReviews.")
+		return nil
+	}
+	fmt.Fprintf(w, "The request is answered with %d %s.\n", rw.Code, http.StatusText(rw.Code))
+	return nil
+}
+
+// recordingAuthorizer records the attributes it is asked to authorize, and
+// the results of authorizer. Without authorizer, all attributes are allowed.
+// The proxy authorizes the attributes of a request concurrently.
+type recordingAuthorizer struct {
+	authorizer authorizer.Authorizer
+
+	mu      sync.Mutex
+	attrs   []authorizer.Attributes
+	results []string
+}
+
+func (r *recordingAuthorizer) Authorize(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	if r.authorizer == nil {
+		r.record(attrs, "")
+		return authorizer.DecisionAllow, "", nil
+	}
+
+	decision, reason, err := r.authorizer.Authorize(ctx, attrs)
+	result := "denied"
+	switch {
+	case err != nil:
+		result = fmt.Sprintf("error: %v", err)
+	case decision == authorizer.DecisionAllow:
+		result = "allowed"
+	}
+	if reason != "" && err == nil {
+		result += fmt.Sprintf(" (%s)", reason)
+	}
+	r.record(attrs, result)
+	return decision, reason, err
+}
+
+func (r *recordingAuthorizer) record(attrs authorizer.Attributes, result string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attrs = append(r.attrs, attrs)
+	r.results = append(r.results, result)
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// namespaceAuthorizer allows resource requests in the default namespace.
+type namespaceAuthorizer struct{}
+
+func (namespaceAuthorizer) Authorize(_ context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	if attrs.GetNamespace() == "default" {
+		return authorizer.DecisionAllow, "", nil
+	}
+	return authorizer.DecisionNoOpinion, "not in default", nil
+}
+
+func TestPolicyTest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policytest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+apiVersion: kube-rbac-proxy.brancz.com/v1alpha1
+kind: KubeRBACProxyConfig
+upstream:
+  url: http://127.0.0.1:8081/
+authorization:
+  rewrites:
+    byQueryParameter:
+      name: namespace
+  resourceAttributes:
+    resource: services
+    namespace: "{{ .Value }}"
+routes:
+- name: admin
+  pathPrefix: /admin
+  upstream: http://127.0.0.1:8082/
+  authorization:
+    resourceAttributes:
+      namespace: kube-system
+      resource: services
+hosts:
+- host: logs.example.com
+  upstream: http://127.0.0.1:8083/
+  authorization:
+    resourceAttributes:
+      namespace: logging
+      resource: pods
+      subresource: log
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := parseConfig([]string{"--config-file=" + path, "--ignore-paths=/healthz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		test       policyTest
+		authorizer authorizer.Authorizer
+		want       []string
+	}{
+		{
+			name: "rewrites",
+			test: policyTest{method: "GET", path: "/metrics?namespace=default&namespace=other"},
+			want: []string{"Route: default", "  get namespaces/default/services\n", "  get namespaces/other/services\n", "Not evaluated"},
+		},
+		{
+			name:       "evaluated",
+			test:       policyTest{method: "GET", path: "/metrics?namespace=default&namespace=other"},
+			authorizer: namespaceAuthorizer{},
+			want:       []string{"get namespaces/default/services: allowed", "get namespaces/other/services: denied (not in default)", "403 Forbidden"},
+		},
+		{
+			name: "missing parameter",
+			test: policyTest{method: "GET", path: "/metrics"},
+			want: []string{"No authorization attributes can be derived, the request is answered with 400 Bad Request."},
+		},
+		{
+			name: "route",
+			test: policyTest{method: "DELETE", path: "/admin/users"},
+			want: []string{"Route: admin", "delete namespaces/kube-system/services"},
+		},
+		{
+			name: "host",
+			test: policyTest{method: "POST", path: "/", host: "logs.example.com"},
+			want: []string{"Route: logs.example.com", "create namespaces/logging/pods/log"},
+		},
+		{
+			name: "ignored path",
+			test: policyTest{method: "GET", path: "/healthz"},
+			want: []string{"--ignore-paths"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test.user = "alice"
+			var buf bytes.Buffer
+			if err := tc.test.run(&buf, cfg, tc.authorizer); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("want output to contain %q, got:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	rbac_proxy_config "github.com/brancz/kube-rbac-proxy/pkg/config"
+)
+
+func TestProfiles(t *testing.T) {
+	for _, name := range rbac_proxy_config.ProfileNames() {
+		cfg, _, err := parseConfig([]string{"--upstream=http://127.0.0.1:8081/", "--profile=" + name})
+		if err != nil {
+			t.Fatalf("profile %s: %v", name, err)
+		}
+		if err := cfg.validate(); err != nil {
+			t.Errorf("profile %s: %v", name, err)
+		}
+	}
+
+	if _, _, err := parseConfig([]string{"--profile=unknown"}); err == nil {
+		t.Error("want error for unknown profile, got nil")
+	}
+}
+
+func TestProfilePrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+apiVersion: kube-rbac-proxy.brancz.com/v1alpha1
+kind: KubeRBACProxyConfig
+upstream:
+  timeout: 10s
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, _, err := parseConfig([]string{
+		"--profile=metrics-sidecar",
+		"--config-file=" + path,
+		"--allow-paths=/metrics,/federate",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/metrics", "/federate"}; !reflect.DeepEqual(cfg.allowPaths, want) {
+		t.Errorf("want allowed paths of the command line %v, got %v", want, cfg.allowPaths)
+	}
+	if want := 10 * time.Second; cfg.proxyBehavior.timeout != want {
+		t.Errorf("want upstream timeout of the config file %v, got %v", want, cfg.proxyBehavior.timeout)
+	}
+	if cfg.proxyBehavior.retries != 1 || !cfg.health.upstreamCheck {
+		t.Errorf("want upstream retries and check of the profile, got %d and %v", cfg.proxyBehavior.retries, cfg.health.upstreamCheck)
+	}
+}
+
+func TestProfileExclusivePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+apiVersion: kube-rbac-proxy.brancz.com/v1alpha1
+kind: KubeRBACProxyConfig
+flags:
+  allow-paths: /metrics
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"--profile=kubelet-frontend", "--allow-paths=/metrics"},
+		{"--profile=metrics-sidecar", "--ignore-paths=/healthz"},
+		{"--profile=kubelet-frontend", "--config-file=" + path},
+	} {
+		cfg, _, err := parseConfig(append([]string{"--upstream=http://127.0.0.1:8081/"}, args...))
+		if err != nil {
+			t.Fatalf("%v: want err to be nil, but got %v", args, err)
+		}
+		if err := cfg.validate(); err != nil {
+			t.Errorf("%v: want err to be nil, but got %v", args, err)
+		}
+		if cfg.proxyBehavior.retries != 1 {
+			t.Errorf("%v: want upstream retries of the profile, got %d", args, cfg.proxyBehavior.retries)
+		}
+	}
+}
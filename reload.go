@@ -0,0 +1,249 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+	"github.com/brancz/kube-rbac-proxy/pkg/authn"
+	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+	rbac_proxy_config "github.com/brancz/kube-rbac-proxy/pkg/config"
+	"github.com/brancz/kube-rbac-proxy/pkg/proxy"
+)
+
+// configReloader reloads the settings of the config file which take effect
+// without a restart: the authorization config of the default route, of each
+// virtual host and of each route, and the authentication headers sent to the
+// upstream.
+type configReloader struct {
+	flagset *pflag.FlagSet
+	// cmdline holds the names of the flags set on the command line, which
+	// take precedence over the config file.
+	cmdline sets.String
+	// file is the config file last applied.
+	file   v1alpha1.KubeRBACProxyConfig
+	routes map[string]reloadableRoute
+}
+
+// reloadableRoute is the proxy of a route and the authentication config it
+// was created with.
+type reloadableRoute struct {
+	authentication *authn.AuthnConfig
+	proxy          *proxy.Reloadable
+}
+
+func newConfigReloader(flagset *pflag.FlagSet, cmdline sets.String, file v1alpha1.KubeRBACProxyConfig) *configReloader {
+	return &configReloader{
+		flagset: flagset,
+		cmdline: cmdline,
+		file:    file,
+		routes:  map[string]reloadableRoute{},
+	}
+}
+
+// add registers the proxy of route, created with the authentication config.
+func (r *configReloader) add(route string, authentication *authn.AuthnConfig, p *proxy.Reloadable) {
+	r.routes[route] = reloadableRoute{authentication: authentication, proxy: p}
+}
+
+// reload applies the config file content b to new requests. Nothing is
+// applied if b is invalid.
+func (r *configReloader) reload(b []byte) error {
+	b, err := rbac_proxy_config.Expand(b)
+	if err != nil {
+		return err
+	}
+
+	var f v1alpha1.KubeRBACProxyConfig
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("failed to parse config file content: %v", err)
+	}
+	if err := v1alpha1.Validate(&f); err != nil {
+		return err
+	}
+	v1alpha1.SetDefaults_KubeRBACProxyConfig(&f)
+
+	header, err := r.headerConfig(&f.Options)
+	if err != nil {
+		return err
+	}
+
+	authorization := authorizationConfig(f.Authorization, &authz.Config{})
+	authorizations := map[string]*authz.Config{defaultRoute: authorization}
+	for _, h := range f.Hosts {
+		authorizations[h.Host] = authorizationConfig(h.Authorization, authorization)
+	}
+	headers := map[string]*authn.AuthnHeaderConfig{}
+	for _, rt := range f.Routes {
+		authorizations[rt.Name] = authorizationConfig(rt.Authorization, authorization)
+		headers[rt.Name] = routeHeaderConfig(header, rt.Authentication)
+	}
+
+	flags, hosts, routes := restartRequired(r.file, f)
+	if len(flags) > 0 {
+		klog.Warningf("Config file changes of the flags %s take effect after a restart", strings.Join(flags, ", "))
+	}
+	if len(hosts) > 0 {
+		klog.Warningf("Config file changes of the hosts %s other than their authorization take effect after a restart", strings.Join(hosts, ", "))
+	}
+	if len(routes) > 0 {
+		klog.Warningf("Config file changes of the routes %s other than their authorization and authentication headers take effect after a restart", strings.Join(routes, ", "))
+	}
+
+	for route, rr := range r.routes {
+		authorization, ok := authorizations[route]
+		if !ok {
+			// The host or route was removed, which takes effect after a restart.
+			continue
+		}
+		authentication := *rr.authentication
+		authentication.Header = header
+		if h, ok := headers[route]; ok {
+			authentication.Header = h
+		}
+		rr.proxy.Reload(proxy.Config{Authentication: &authentication, Authorization: authorization})
+	}
+	r.file = f
+
+	return nil
+}
+
+// headerConfig returns the authentication header settings configured by o.
+// Flags set on the command line keep their value.
+func (r *configReloader) headerConfig(o *v1alpha1.Options) (*authn.AuthnHeaderConfig, error) {
+	values, err := rbac_proxy_config.Values(o)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &authn.AuthnHeaderConfig{}
+	fs := pflag.NewFlagSet("reload", pflag.ContinueOnError)
+	addAuthnHeaderFlags(fs, header)
+
+	for _, name := range flagNames(fs) {
+		value, ok := values[name]
+		if r.cmdline.Has(name) {
+			value, ok = []string{r.flagset.Lookup(name).Value.String()}, true
+		}
+		if !ok {
+			continue
+		}
+		if err := rbac_proxy_config.Set(fs, name, value); err != nil {
+			return nil, err
+		}
+	}
+	return header, nil
+}
+
+// routeHeaderConfig returns header with the settings of a's header, if any.
+func routeHeaderConfig(header *authn.AuthnHeaderConfig, a *v1alpha1.RouteAuthentication) *authn.AuthnHeaderConfig {
+	if a == nil || a.Header == nil {
+		return header
+	}
+	h := *header
+	// The conversion only sets the header settings of a.
+	_ = v1alpha1.Convert_v1alpha1_RouteAuthentication_To_authn_AuthnConfig(&v1alpha1.RouteAuthentication{Header: a.Header}, &authn.AuthnConfig{Header: &h}, nil)
+	return &h
+}
+
+// restartRequired returns the names of the flags, hosts and routes
+// configured differently by the config files old and f, whose changes only
+// take effect after a restart.
+func restartRequired(old, f v1alpha1.KubeRBACProxyConfig) (flags, hosts, routes []string) {
+	fs := pflag.NewFlagSet("reload", pflag.ContinueOnError)
+	addAuthnHeaderFlags(fs, &authn.AuthnHeaderConfig{})
+	skip := sets.NewString(flagNames(fs)...)
+
+	// Both files are validated, so their values are too.
+	oldValues, _ := rbac_proxy_config.Values(&old.Options)
+	values, _ := rbac_proxy_config.Values(&f.Options)
+	for _, name := range sets.StringKeySet(oldValues).Union(sets.StringKeySet(values)).List() {
+		if !skip.Has(name) && !reflect.DeepEqual(oldValues[name], values[name]) {
+			flags = append(flags, name)
+		}
+	}
+
+	oldHosts, newHosts := hostsByName(old.Hosts), hostsByName(f.Hosts)
+	for _, name := range sets.StringKeySet(oldHosts).Union(sets.StringKeySet(newHosts)).List() {
+		if !reflect.DeepEqual(oldHosts[name], newHosts[name]) {
+			hosts = append(hosts, name)
+		}
+	}
+
+	oldRoutes, newRoutes := routesByName(old.Routes), routesByName(f.Routes)
+	for _, name := range sets.StringKeySet(oldRoutes).Union(sets.StringKeySet(newRoutes)).List() {
+		if !reflect.DeepEqual(oldRoutes[name], newRoutes[name]) {
+			routes = append(routes, name)
+		}
+	}
+	return flags, hosts, routes
+}
+
+// hostsByName returns the hosts by their name, without their authorization
+// config.
+func hostsByName(hosts []v1alpha1.Host) map[string]*v1alpha1.Host {
+	m := map[string]*v1alpha1.Host{}
+	for _, h := range hosts {
+		h := h
+		h.Authorization = nil
+		m[h.Host] = &h
+	}
+	return m
+}
+
+// routesByName returns the routes by their name, without their authorization
+// config and authentication headers.
+func routesByName(routes []v1alpha1.Route) map[string]*v1alpha1.Route {
+	m := map[string]*v1alpha1.Route{}
+	for _, r := range routes {
+		r := r
+		r.Authorization = nil
+		if r.Authentication != nil && r.Authentication.TokenAudiences != nil {
+			r.Authentication = &v1alpha1.RouteAuthentication{TokenAudiences: r.Authentication.TokenAudiences}
+		} else {
+			r.Authentication = nil
+		}
+		m[r.Name] = &r
+	}
+	return m
+}
+
+// flagNames returns the names of the flags of fs.
+func flagNames(fs *pflag.FlagSet) []string {
+	var names []string
+	fs.VisitAll(func(f *pflag.Flag) {
+		names = append(names, f.Name)
+	})
+	return names
+}
+
+// addAuthnHeaderFlags adds the flags configuring the authentication headers
+// sent to the upstream, which take effect when the config file is reloaded.
+func addAuthnHeaderFlags(fs *pflag.FlagSet, header *authn.AuthnHeaderConfig) {
+	fs.BoolVar(&header.Enabled, "auth-header-fields-enabled", false, "When set to true, kube-rbac-proxy adds auth-related fields to the headers of http requests sent to the upstream")
+	fs.StringVar(&header.UserFieldName, "auth-header-user-field-name", "x-remote-user", "The name of the field inside a http(2) request header to tell the upstream server about the user's name")
+	fs.StringVar(&header.GroupsFieldName, "auth-header-groups-field-name", "x-remote-groups", "The name of the field inside a http(2) request header to tell the upstream server about the user's groups")
+	fs.StringVar(&header.GroupSeparator, "auth-header-groups-field-separator", "|", "The separator string used for concatenating multiple group names in a groups header field's value")
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+	"github.com/brancz/kube-rbac-proxy/pkg/authn"
+	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+	"github.com/brancz/kube-rbac-proxy/pkg/proxy"
+)
+
+func TestConfigReloader(t *testing.T) {
+	header := &authn.AuthnHeaderConfig{}
+	flagset := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addAuthnHeaderFlags(flagset, header)
+	if err := flagset.Parse([]string{"--auth-header-user-field-name=x-user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	authentication := &authn.AuthnConfig{Header: header}
+	var namespaces []string
+	authorize := authorizer.AuthorizerFunc(func(attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+		namespaces = append(namespaces, attrs.GetNamespace())
+		return authorizer.DecisionAllow, "", nil
+	})
+	authenticate := authenticator.RequestFunc(func(*http.Request) (*authenticator.Response, bool, error) {
+		return &authenticator.Response{User: &user.DefaultInfo{Name: "alice"}}, true, nil
+	})
+	p := proxy.NewReloadable(proxy.Config{Authentication: authentication, Authorization: &authz.Config{}}, authorize, authenticate)
+
+	r := newConfigReloader(flagset, sets.NewString("auth-header-user-field-name"), v1alpha1.KubeRBACProxyConfig{})
+	r.add(defaultRoute, authentication, p)
+
+	if err := r.reload([]byte(`
+apiVersion: kube-rbac-proxy.brancz.com/v1alpha1
+kind: KubeRBACProxyConfig
+authentication:
+  header:
+    enabled: true
+    userFieldName: x-ignored
+authorization:
+  resourceAttributes:
+    namespace: reloaded
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	if !p.Handle(httptest.NewRecorder(), req) {
+		t.Fatal("want request to be allowed")
+	}
+	if !reflect.DeepEqual(namespaces, []string{"reloaded"}) {
+		t.Errorf("want reloaded authorization, got namespaces %v", namespaces)
+	}
+	if got := req.Header.Get("x-user"); got != "alice" {
+		t.Errorf("want header of the command line flag to be set, got %q", got)
+	}
+
+	if err := r.reload([]byte("apiVersion: v2")); err == nil {
+		t.Error("want error for invalid config file, got nil")
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := v1alpha1.KubeRBACProxyConfig{Hosts: []v1alpha1.Host{{Host: "a", Upstream: "http://a/"}, {Host: "b", Upstream: "http://b/"}}}
+	f := v1alpha1.KubeRBACProxyConfig{Hosts: []v1alpha1.Host{
+		{Host: "a", Upstream: "http://a/", Authorization: &v1alpha1.AuthorizationConfig{}},
+		{Host: "b", Upstream: "http://changed/"},
+		{Host: "c", Upstream: "http://c/"},
+	}}
+	f.APIVersion, f.Kind = "kube-rbac-proxy.brancz.com/v1alpha1", "KubeRBACProxyConfig"
+	f.Flags = map[string]string{"upstream": "http://changed/", "auth-header-fields-enabled": "true"}
+
+	userField := "x-user"
+	old.Routes = []v1alpha1.Route{{Name: "r", PathPrefix: "/r", Upstream: "http://r/"}}
+	f.Routes = []v1alpha1.Route{{Name: "r", PathPrefix: "/r", Upstream: "http://r/", Authentication: &v1alpha1.RouteAuthentication{
+		Header: &v1alpha1.AuthnHeaderOptions{UserFieldName: &userField},
+	}}}
+
+	flags, hosts, routes := restartRequired(old, f)
+	if want := []string{"upstream"}; !reflect.DeepEqual(flags, want) {
+		t.Errorf("want flags %v, got %v", want, flags)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("want hosts %v, got %v", want, hosts)
+	}
+	if len(routes) != 0 {
+		t.Errorf("want header changes of routes to be reloaded, got %v", routes)
+	}
+}
@@ -17,16 +17,45 @@ limitations under the License.
 package e2e
 
 import (
-	"fmt"
+	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/brancz/kube-rbac-proxy/test/kubetest"
 	"k8s.io/client-go/kubernetes"
 )
 
+// scenarioTimeout fails scenarios hanging, e.g. on pods which never become
+// ready, instead of stalling CI.
+const scenarioTimeout = 5 * time.Minute
+
+// proxyURL is the URL of the proxy's service in the scenario namespace.
+const proxyURL = "https://kube-rbac-proxy:8443"
+
+// auditLogs attaches the kube-apiserver's audit events to failed scenarios.
+var auditLogs bool
+
+// scenarioOpts runs each scenario in parallel in its own namespace, records
+// its result in the suite's report, and attaches the proxy's logs, and audit
+// events if enabled, to failed scenarios.
+func scenarioOpts(s *kubetest.Suite) []kubetest.RunOpts {
+	opts := []kubetest.RunOpts{
+		kubetest.RandomNamespace(s.KubeClient),
+		kubetest.Parallel(),
+		kubetest.Timeout(scenarioTimeout),
+		kubetest.Recorded(s.Report),
+		kubetest.LogsOnFailure(s.KubeClient, "app=kube-rbac-proxy", "kube-rbac-proxy"),
+	}
+	if auditLogs {
+		opts = append(opts, kubetest.AuditLogsOnFailure(s.KubeClient))
+	}
+	return opts
+}
+
 func testBasics(s *kubetest.Suite) kubetest.TestSuite {
 	return func(t *testing.T) {
-		command := `curl --connect-timeout 5 -v -s -k --fail -H "Authorization: Bearer $(cat /var/run/secrets/kubernetes.io/serviceaccount/token)" https://kube-rbac-proxy.default.svc.cluster.local:8443/metrics`
+		command := `curl --connect-timeout 5 -v -s -k --fail -H "Authorization: Bearer $(cat /var/run/secrets/kubernetes.io/serviceaccount/token)" https://kube-rbac-proxy:8443/metrics`
 
 		kubetest.Scenario{
 			Name: "NoRBAC",
@@ -63,7 +92,7 @@ func testBasics(s *kubetest.Suite) kubetest.TestSuite {
 					nil,
 				),
 			),
-		}.Run(t)
+		}.Run(t, scenarioOpts(s)...)
 
 		kubetest.Scenario{
 			Name: "WithRBAC",
@@ -97,20 +126,23 @@ func testBasics(s *kubetest.Suite) kubetest.TestSuite {
 				),
 			),
 			Then: kubetest.Checks(
-				ClientSucceeds(
-					s.KubeClient,
-					command,
-					nil,
+				// The client's RBAC rules may take a moment to propagate.
+				kubetest.Eventually(
+					ClientSucceeds(
+						s.KubeClient,
+						command,
+						nil,
+					),
+					time.Minute,
+					5*time.Second,
 				),
 			),
-		}.Run(t)
+		}.Run(t, scenarioOpts(s)...)
 	}
 }
 
 func testTokenAudience(s *kubetest.Suite) kubetest.TestSuite {
 	return func(t *testing.T) {
-		command := `curl --connect-timeout 5 -v -s -k --fail -H "Authorization: Bearer $(cat /var/run/secrets/tokens/requestedtoken)" https://kube-rbac-proxy.default.svc.cluster.local:8443/metrics`
-
 		kubetest.Scenario{
 			Name: "IncorrectAudience",
 			Description: `
@@ -119,16 +151,7 @@ func testTokenAudience(s *kubetest.Suite) kubetest.TestSuite {
 			`,
 
 			Given: kubetest.Setups(
-				kubetest.CreatedManifests(
-					s.KubeClient,
-					"tokenrequest/clusterRole.yaml",
-					"tokenrequest/clusterRoleBinding.yaml",
-					"tokenrequest/deployment.yaml",
-					"tokenrequest/service.yaml",
-					"tokenrequest/serviceAccount.yaml",
-					"tokenrequest/clusterRole-client.yaml",
-					"tokenrequest/clusterRoleBinding-client.yaml",
-				),
+				kubetest.CreatedManifestDir(s.KubeClient, "tokenrequest"),
 			),
 			When: kubetest.Conditions(
 				kubetest.PodsAreReady(
@@ -142,13 +165,14 @@ func testTokenAudience(s *kubetest.Suite) kubetest.TestSuite {
 				),
 			),
 			Then: kubetest.Checks(
-				ClientFails(
+				kubetest.RequestReturns(
 					s.KubeClient,
-					command,
-					&kubetest.RunOptions{TokenAudience: "wrong-audience"},
+					kubetest.HTTPRequest{URL: proxyURL + "/metrics", Token: kubetest.RequestedToken(s.KubeClient, "default", "wrong-audience")},
+					kubetest.HTTPResponse{StatusCode: http.StatusUnauthorized},
+					nil,
 				),
 			),
-		}.Run(t)
+		}.Run(t, scenarioOpts(s)...)
 
 		kubetest.Scenario{
 			Name: "CorrectAudience",
@@ -158,16 +182,7 @@ func testTokenAudience(s *kubetest.Suite) kubetest.TestSuite {
 			`,
 
 			Given: kubetest.Setups(
-				kubetest.CreatedManifests(
-					s.KubeClient,
-					"tokenrequest/clusterRole.yaml",
-					"tokenrequest/clusterRoleBinding.yaml",
-					"tokenrequest/deployment.yaml",
-					"tokenrequest/service.yaml",
-					"tokenrequest/serviceAccount.yaml",
-					"tokenrequest/clusterRole-client.yaml",
-					"tokenrequest/clusterRoleBinding-client.yaml",
-				),
+				kubetest.CreatedManifestDir(s.KubeClient, "tokenrequest"),
 			),
 			When: kubetest.Conditions(
 				kubetest.PodsAreReady(
@@ -181,20 +196,19 @@ func testTokenAudience(s *kubetest.Suite) kubetest.TestSuite {
 				),
 			),
 			Then: kubetest.Checks(
-				ClientSucceeds(
+				kubetest.RequestReturns(
 					s.KubeClient,
-					command,
-					&kubetest.RunOptions{TokenAudience: "kube-rbac-proxy"},
+					kubetest.HTTPRequest{URL: proxyURL + "/metrics", Token: kubetest.RequestedToken(s.KubeClient, "default", "kube-rbac-proxy")},
+					kubetest.HTTPResponse{StatusCode: http.StatusOK},
+					nil,
 				),
 			),
-		}.Run(t)
+		}.Run(t, scenarioOpts(s)...)
 	}
 }
 
 func testAllowPathsRegexp(s *kubetest.Suite) kubetest.TestSuite {
 	return func(t *testing.T) {
-		command := `STATUS_CODE=$(curl --connect-timeout 5 -o /dev/null -v -s -k --write-out "%%{http_code}" -H "Authorization: Bearer $(cat /var/run/secrets/kubernetes.io/serviceaccount/token)" https://kube-rbac-proxy.default.svc.cluster.local:8443%s); if [[ "$STATUS_CODE" != %d ]]; then echo "expecting %d status code, got $STATUS_CODE instead" > /proc/self/fd/2; exit 1; fi`
-
 		kubetest.Scenario{
 			Name: "WithPathhNotAllowed",
 			Description: `
@@ -203,16 +217,7 @@ func testAllowPathsRegexp(s *kubetest.Suite) kubetest.TestSuite {
 			`,
 
 			Given: kubetest.Setups(
-				kubetest.CreatedManifests(
-					s.KubeClient,
-					"allowpaths/clusterRole.yaml",
-					"allowpaths/clusterRoleBinding.yaml",
-					"allowpaths/deployment.yaml",
-					"allowpaths/service.yaml",
-					"allowpaths/serviceAccount.yaml",
-					"allowpaths/clusterRole-client.yaml",
-					"allowpaths/clusterRoleBinding-client.yaml",
-				),
+				kubetest.CreatedManifestDir(s.KubeClient, "allowpaths"),
 			),
 			When: kubetest.Conditions(
 				kubetest.PodsAreReady(
@@ -226,13 +231,14 @@ func testAllowPathsRegexp(s *kubetest.Suite) kubetest.TestSuite {
 				),
 			),
 			Then: kubetest.Checks(
-				ClientSucceeds(
+				kubetest.RequestReturns(
 					s.KubeClient,
-					fmt.Sprintf(command, "/", 404, 404),
+					kubetest.HTTPRequest{URL: proxyURL + "/", BearerToken: true},
+					kubetest.HTTPResponse{StatusCode: http.StatusNotFound},
 					nil,
 				),
 			),
-		}.Run(t)
+		}.Run(t, scenarioOpts(s)...)
 
 		kubetest.Scenario{
 			Name: "WithPathAllowed",
@@ -242,16 +248,7 @@ func testAllowPathsRegexp(s *kubetest.Suite) kubetest.TestSuite {
 			`,
 
 			Given: kubetest.Setups(
-				kubetest.CreatedManifests(
-					s.KubeClient,
-					"allowpaths/clusterRole.yaml",
-					"allowpaths/clusterRoleBinding.yaml",
-					"allowpaths/deployment.yaml",
-					"allowpaths/service.yaml",
-					"allowpaths/serviceAccount.yaml",
-					"allowpaths/clusterRole-client.yaml",
-					"allowpaths/clusterRoleBinding-client.yaml",
-				),
+				kubetest.CreatedManifestDir(s.KubeClient, "allowpaths"),
 			),
 			When: kubetest.Conditions(
 				kubetest.PodsAreReady(
@@ -265,20 +262,19 @@ func testAllowPathsRegexp(s *kubetest.Suite) kubetest.TestSuite {
 				),
 			),
 			Then: kubetest.Checks(
-				ClientSucceeds(
+				kubetest.RequestReturns(
 					s.KubeClient,
-					fmt.Sprintf(command, "/metrics", 200, 200),
+					kubetest.HTTPRequest{URL: proxyURL + "/metrics", BearerToken: true},
+					kubetest.HTTPResponse{StatusCode: http.StatusOK},
 					nil,
 				),
 			),
-		}.Run(t)
+		}.Run(t, scenarioOpts(s)...)
 	}
 }
 
 func testIgnorePaths(s *kubetest.Suite) kubetest.TestSuite {
 	return func(t *testing.T) {
-		commandWithoutAuth := `STATUS_CODE=$(curl --connect-timeout 5 -o /dev/null -v -s -k --write-out "%%{http_code}" https://kube-rbac-proxy.default.svc.cluster.local:8443%s); if [[ "$STATUS_CODE" != %d ]]; then echo "expecting %d status code, got $STATUS_CODE instead" > /proc/self/fd/2; exit 1; fi`
-
 		kubetest.Scenario{
 			Name: "WithIgnorePathMatch",
 			Description: `
@@ -287,16 +283,7 @@ func testIgnorePaths(s *kubetest.Suite) kubetest.TestSuite {
 			`,
 
 			Given: kubetest.Setups(
-				kubetest.CreatedManifests(
-					s.KubeClient,
-					"ignorepaths/clusterRole.yaml",
-					"ignorepaths/clusterRoleBinding.yaml",
-					"ignorepaths/deployment.yaml",
-					"ignorepaths/service.yaml",
-					"ignorepaths/serviceAccount.yaml",
-					"ignorepaths/clusterRole-client.yaml",
-					"ignorepaths/clusterRoleBinding-client.yaml",
-				),
+				kubetest.CreatedManifestDir(s.KubeClient, "ignorepaths"),
 			),
 			When: kubetest.Conditions(
 				kubetest.PodsAreReady(
@@ -310,13 +297,14 @@ func testIgnorePaths(s *kubetest.Suite) kubetest.TestSuite {
 				),
 			),
 			Then: kubetest.Checks(
-				ClientSucceeds(
+				kubetest.RequestReturns(
 					s.KubeClient,
-					fmt.Sprintf(commandWithoutAuth, "/metrics", 200, 200),
+					kubetest.HTTPRequest{URL: proxyURL + "/metrics"},
+					kubetest.HTTPResponse{StatusCode: http.StatusOK},
 					nil,
 				),
 			),
-		}.Run(t)
+		}.Run(t, scenarioOpts(s)...)
 
 		kubetest.Scenario{
 			Name: "WithIgnorePathNoMatch",
@@ -326,16 +314,7 @@ func testIgnorePaths(s *kubetest.Suite) kubetest.TestSuite {
 			`,
 
 			Given: kubetest.Setups(
-				kubetest.CreatedManifests(
-					s.KubeClient,
-					"ignorepaths/clusterRole.yaml",
-					"ignorepaths/clusterRoleBinding.yaml",
-					"ignorepaths/deployment.yaml",
-					"ignorepaths/service.yaml",
-					"ignorepaths/serviceAccount.yaml",
-					"ignorepaths/clusterRole-client.yaml",
-					"ignorepaths/clusterRoleBinding-client.yaml",
-				),
+				kubetest.CreatedManifestDir(s.KubeClient, "ignorepaths"),
 			),
 			When: kubetest.Conditions(
 				kubetest.PodsAreReady(
@@ -349,13 +328,14 @@ func testIgnorePaths(s *kubetest.Suite) kubetest.TestSuite {
 				),
 			),
 			Then: kubetest.Checks(
-				ClientSucceeds(
+				kubetest.RequestReturns(
 					s.KubeClient,
-					fmt.Sprintf(commandWithoutAuth, "/", 401, 401),
+					kubetest.HTTPRequest{URL: proxyURL + "/"},
+					kubetest.HTTPResponse{StatusCode: http.StatusUnauthorized},
 					nil,
 				),
 			),
-		}.Run(t)
+		}.Run(t, scenarioOpts(s)...)
 	}
 }
 
@@ -363,7 +343,7 @@ func ClientSucceeds(client kubernetes.Interface, command string, opts *kubetest.
 	return func(ctx *kubetest.ScenarioContext) error {
 		return kubetest.RunSucceeds(
 			client,
-			"quay.io/brancz/krp-curl:v0.0.1",
+			kubetest.ClientImage,
 			"kube-rbac-proxy-client",
 			[]string{"/bin/sh", "-c", command},
 			opts,
@@ -375,10 +355,64 @@ func ClientFails(client kubernetes.Interface, command string, opts *kubetest.Run
 	return func(ctx *kubetest.ScenarioContext) error {
 		return kubetest.RunFails(
 			client,
-			"quay.io/brancz/krp-curl:v0.0.1",
+			kubetest.ClientImage,
 			"kube-rbac-proxy-client",
 			[]string{"/bin/sh", "-c", command},
 			opts,
 		)(ctx)
 	}
 }
+
+func testLoad(s *kubetest.Suite) kubetest.TestSuite {
+	return func(t *testing.T) {
+		kubetest.LoadScenario{
+			Name: "WithRBAC",
+			Description: `
+				As a client with the correct RBAC rules,
+				I succeed with my requests at a steady rate
+				within the latency budget
+			`,
+
+			Given: kubetest.Setups(
+				kubetest.CreatedManifests(
+					s.KubeClient,
+					"basics/clusterRole.yaml",
+					"basics/clusterRoleBinding.yaml",
+					"basics/deployment.yaml",
+					"basics/service.yaml",
+					"basics/serviceAccount.yaml",
+					"basics/clusterRole-client.yaml",
+					"basics/clusterRoleBinding-client.yaml",
+				),
+			),
+			When: kubetest.Conditions(
+				kubetest.PodsAreReady(
+					s.KubeClient,
+					1,
+					"app=kube-rbac-proxy",
+				),
+				kubetest.ServiceIsReady(
+					s.KubeClient,
+					"kube-rbac-proxy",
+				),
+			),
+
+			Target: func(ctx *kubetest.ScenarioContext) (*url.URL, error) {
+				u, _, err := kubetest.PortForwardURL(ctx, s.RestConfig, s.KubeClient, "https", "service/kube-rbac-proxy", 8443)
+				if err != nil {
+					return nil, err
+				}
+				u.Path = "/metrics"
+				return u, nil
+			},
+			Token:        kubetest.RequestedToken(s.KubeClient, "default"),
+			RPS:          50,
+			Duration:     30 * time.Second,
+			MaxErrorRate: 0.01,
+			MaxLatency: map[float64]time.Duration{
+				0.5:  100 * time.Millisecond,
+				0.99: time.Second,
+			},
+		}.Run(t, scenarioOpts(s)...)
+	}
+}
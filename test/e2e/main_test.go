@@ -18,9 +18,13 @@ package e2e
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/brancz/kube-rbac-proxy/test/kubetest"
 )
@@ -34,17 +38,58 @@ func TestMain(m *testing.M) {
 	kubeconfig := flag.String(
 		"kubeconfig",
 		"",
-		"path to kubeconfig",
+		"path to kubeconfig, if empty a kind cluster is created for the tests",
 	)
+	kind := kubetest.KindConfig{Name: "kube-rbac-proxy-e2e", ConfigFile: "kind-config/kind-config.yaml", WaitTimeout: 5 * time.Minute}
+	flag.StringVar(&kind.NodeImage, "kind-node-image", "", "kindest/node image of the kind cluster, kind's default if empty")
+	flag.IntVar(&kind.Workers, "kind-workers", 0, "number of worker nodes of the kind cluster besides the control plane")
+	flag.BoolVar(&kind.Keep, "kind-keep", false, "keep the kind cluster after the tests")
+	images := flag.String("kind-images", "quay.io/brancz/kube-rbac-proxy:local", "comma-separated local images loaded into the kind cluster, e.g. also "+kubetest.EchoImage+" for scenarios using the echo server")
+	junitReport := flag.String("junit-report", "", "path to write a JUnit XML report of the scenarios to")
+	jsonReport := flag.String("json-report", "", "path to write a JSON report of the scenarios to")
+	flag.BoolVar(&auditLogs, "audit-logs", false, "attach the audit events of failed scenarios, requires the kube-apiserver to write its audit log to stdout")
 	flag.Parse()
 
 	var err error
-	suite, err = kubetest.NewSuiteFromKubeconfig(*kubeconfig)
+	if *kubeconfig != "" {
+		suite, err = kubetest.NewSuiteFromKubeconfig(*kubeconfig)
+	} else {
+		if *images != "" {
+			kind.Images = strings.Split(*images, ",")
+		}
+		suite, err = kubetest.NewSuiteWithKindCluster(kind)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	os.Exit(m.Run())
+	code := m.Run()
+	if *junitReport != "" {
+		if err := writeReport(*junitReport, func(w io.Writer) error { return suite.Report.WriteJUnit(w, "e2e") }); err != nil {
+			log.Print(err)
+		}
+	}
+	if *jsonReport != "" {
+		if err := writeReport(*jsonReport, suite.Report.WriteJSON); err != nil {
+			log.Print(err)
+		}
+	}
+	if err := suite.Teardown(); err != nil {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
+
+func writeReport(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write report %s: %v", path, err)
+	}
+	return f.Close()
 }
 
 func Test(t *testing.T) {
@@ -53,9 +98,14 @@ func Test(t *testing.T) {
 		"TokenAudience": testTokenAudience(suite),
 		"AllowPath":     testAllowPathsRegexp(suite),
 		"IgnorePath":    testIgnorePaths(suite),
+		"Load":          testLoad(suite),
 	}
 
 	for name, tc := range tests {
-		t.Run(name, tc)
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			tc(t)
+		})
 	}
 }
@@ -0,0 +1,65 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The echo server reflects the requests it receives, so e2e scenarios can
+// assert which path and headers the proxy forwarded upstream.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// response is the JSON body of the echo server's responses.
+type response struct {
+	Method string              `json:"method"`
+	Host   string              `json:"host"`
+	Path   string              `json:"path"`
+	Query  string              `json:"query,omitempty"`
+	Header map[string][]string `json:"header"`
+}
+
+// echo responds with the request's method, path and headers as JSON body,
+// and as X-Echo-Method, X-Echo-Path and X-Echo-Header-<name> headers.
+func echo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Echo-Method", r.Method)
+	w.Header().Set("X-Echo-Path", r.URL.Path)
+	for name, values := range r.Header {
+		w.Header().Set("X-Echo-Header-"+name, strings.Join(values, ", "))
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(response{
+		Method: r.Method,
+		Host:   r.Host,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Header: r.Header,
+	}); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func main() {
+	listen := flag.String("listen-address", ":8080", "The address to listen on.")
+	flag.Parse()
+
+	log.Printf("Listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, http.HandlerFunc(echo)))
+}
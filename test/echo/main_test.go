@@ -0,0 +1,47 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEcho(t *testing.T) {
+	req := httptest.NewRequest("POST", "/metrics?foo=bar", nil)
+	req.Header.Set("X-Remote-User", "alice")
+	w := httptest.NewRecorder()
+
+	echo(w, req)
+
+	if got := w.Header().Get("X-Echo-Header-X-Remote-User"); got != "alice" {
+		t.Errorf("want reflected header %q, got %q", "alice", got)
+	}
+	if got := w.Header().Get("X-Echo-Path"); got != "/metrics" {
+		t.Errorf("want reflected path %q, got %q", "/metrics", got)
+	}
+
+	var resp response
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Method != http.MethodPost || resp.Path != "/metrics" || resp.Query != "foo=bar" || resp.Header["X-Remote-User"][0] != "alice" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// EchoImage is the image of the echo server in test/echo, built by
+	// make echo-container. It responds with the method, path and headers of
+	// the requests it receives, as JSON body and as X-Echo-Method,
+	// X-Echo-Path and X-Echo-Header-<name> headers.
+	EchoImage = "quay.io/brancz/krp-echo:local"
+
+	// EchoPort is the port the echo server listens on.
+	EchoPort = 8080
+)
+
+// EchoContainer returns a container running the echo server, e.g. as the
+// upstream in the pod of the proxy.
+func EchoContainer() corev1.Container {
+	return corev1.Container{
+		Name:  "echo",
+		Image: EchoImage,
+		Args:  []string{fmt.Sprintf("--listen-address=:%d", EchoPort)},
+		Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: EchoPort}},
+	}
+}
+
+// CreatedEchoServer creates a Deployment and a Service named name running the
+// echo server in the scenario namespace, for proxies with the upstream
+// http://<name>:8080/.
+func CreatedEchoServer(client kubernetes.Interface, name string) Setup {
+	return func(ctx *ScenarioContext) error {
+		labels := map[string]string{"app": name}
+		replicas := int32(1)
+
+		d := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ctx.Namespace, Labels: labels},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{EchoContainer()}},
+				},
+			},
+		}
+		if _, err := client.AppsV1().Deployments(d.Namespace).Create(ctx, d, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create echo deployment: %v", err)
+		}
+		ctx.AddFinalizer(func() error {
+			return client.AppsV1().Deployments(d.Namespace).Delete(context.TODO(), d.Name, metav1.DeleteOptions{})
+		})
+
+		s := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ctx.Namespace, Labels: labels},
+			Spec: corev1.ServiceSpec{
+				Selector: labels,
+				Ports:    []corev1.ServicePort{{Name: "http", Port: EchoPort, TargetPort: intstr.FromString("http")}},
+			},
+		}
+		if _, err := client.CoreV1().Services(s.Namespace).Create(ctx, s, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create echo service: %v", err)
+		}
+		ctx.AddFinalizer(func() error {
+			return client.CoreV1().Services(s.Namespace).Delete(context.TODO(), s.Name, metav1.DeleteOptions{})
+		})
+
+		return nil
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCreatedEchoServer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := &ScenarioContext{Context: context.Background(), Namespace: "abc"}
+	if err := CreatedEchoServer(client, "upstream")(ctx); err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	d, err := client.AppsV1().Deployments("abc").Get(ctx, "upstream", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Spec.Template.Spec.Containers[0].Image; got != EchoImage {
+		t.Errorf("want image %s, got %s", EchoImage, got)
+	}
+	if _, err := client.CoreV1().Services("abc").Get(ctx, "upstream", metav1.GetOptions{}); err != nil {
+		t.Errorf("want service, got %v", err)
+	}
+	if len(ctx.Finalizer) != 2 {
+		t.Errorf("want 2 finalizers, got %d", len(ctx.Finalizer))
+	}
+}
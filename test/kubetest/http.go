@@ -0,0 +1,139 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// ClientImage is the image of the client pods making requests to the proxy.
+const ClientImage = "quay.io/brancz/krp-curl:v0.0.1"
+
+const (
+	serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	requestedTokenPath      = "/var/run/secrets/tokens/requestedtoken"
+	clientCertPath          = "/var/run/secrets/client-cert"
+)
+
+// HTTPRequest is a request made by a client pod, e.g. to the proxy's service.
+type HTTPRequest struct {
+	// Method defaults to GET.
+	Method string
+	// URL is the URL of the request, e.g. "https://kube-rbac-proxy:8443/metrics".
+	URL string
+	// Header is sent with the request.
+	Header map[string]string
+	// BearerToken sends the token of the client pod's ServiceAccount, or the
+	// token requested with RunOptions.TokenAudience.
+	BearerToken bool
+	// Token is sent as bearer token instead, e.g. RequestedToken to send a
+	// token with specific audiences.
+	Token TokenSource
+}
+
+// HTTPResponse is what a response is expected to match.
+type HTTPResponse struct {
+	// StatusCode is the expected status code.
+	StatusCode int
+	// Header values must be equal to the ones of the response.
+	Header map[string]string
+	// BodyContains must be part of the response body.
+	BodyContains string
+}
+
+// RequestReturns runs a client pod making the request and checks that the
+// response matches. A client certificate is presented if
+// RunOptions.ClientCertSecret is set.
+// Returns a func directly (not Condition or Check) as it can be used in When and Then steps
+func RequestReturns(client kubernetes.Interface, req HTTPRequest, resp HTTPResponse, opts *RunOptions) func(*ScenarioContext) error {
+	return func(ctx *ScenarioContext) error {
+		var token string
+		if req.Token != nil {
+			var err error
+			if token, err = req.Token(ctx); err != nil {
+				return err
+			}
+		}
+		return RunSucceeds(client, ClientImage, "kube-rbac-proxy-client", []string{"/bin/sh", "-c", requestScript(req, token, resp, opts)}, opts)(ctx)
+	}
+}
+
+// requestScript returns a shell script making the request with curl and
+// failing with a description of the mismatch if the response doesn't match.
+// A non-empty token is sent as bearer token.
+func requestScript(req HTTPRequest, token string, resp HTTPResponse, opts *RunOptions) string {
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	args := []string{"curl", "--connect-timeout 5", "-s", "-k", "-X", shellQuote(method), "-o /tmp/body", "-D /tmp/headers", "-w '%{http_code}'"}
+	if token != "" {
+		args = append(args, "-H", shellQuote("Authorization: Bearer "+token))
+	} else if req.BearerToken {
+		path := serviceAccountTokenPath
+		if opts != nil && opts.TokenAudience != "" {
+			path = requestedTokenPath
+		}
+		args = append(args, "-H", `"Authorization: Bearer $(cat `+path+`)"`)
+	}
+	if opts != nil && opts.ClientCertSecret != "" {
+		args = append(args, "--cert", clientCertPath+"/tls.crt", "--key", clientCertPath+"/tls.key")
+	}
+	for _, name := range sortedKeys(req.Header) {
+		args = append(args, "-H", shellQuote(name+": "+req.Header[name]))
+	}
+	args = append(args, shellQuote(req.URL))
+
+	lines := []string{
+		"set -e",
+		strings.Join(args, " ") + " > /tmp/status",
+		`STATUS_CODE=$(cat /tmp/status)`,
+	}
+	if resp.StatusCode != 0 {
+		lines = append(lines, fmt.Sprintf(`if [ "$STATUS_CODE" != %d ]; then echo "expecting %d status code, got $STATUS_CODE instead" >&2; exit 1; fi`, resp.StatusCode, resp.StatusCode))
+	}
+	for _, name := range sortedKeys(resp.Header) {
+		header := shellQuote(name + ": " + resp.Header[name])
+		lines = append(lines, fmt.Sprintf(`if ! tr -d '\r' < /tmp/headers | grep -qixF %s; then echo "expecting header "%s", got:" >&2; cat /tmp/headers >&2; exit 1; fi`, header, header))
+	}
+	if resp.BodyContains != "" {
+		body := shellQuote(resp.BodyContains)
+		lines = append(lines, fmt.Sprintf(`if ! grep -qF %s /tmp/body; then echo "expecting body to contain "%s", got:" >&2; cat /tmp/body >&2; exit 1; fi`, body, body))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// shellQuote quotes s as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
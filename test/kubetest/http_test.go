@@ -0,0 +1,46 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRequestScript(t *testing.T) {
+	script := requestScript(
+		HTTPRequest{URL: "https://kube-rbac-proxy:8443/metrics", BearerToken: true, Header: map[string]string{"X-Test": "it's"}},
+		"",
+		HTTPResponse{StatusCode: 200, Header: map[string]string{"Content-Type": "text/plain"}, BodyContains: "go_goroutines"},
+		&RunOptions{TokenAudience: "kube-rbac-proxy", ClientCertSecret: "client"},
+	)
+
+	for _, want := range []string{
+		`-X 'GET'`,
+		`"Authorization: Bearer $(cat /var/run/secrets/tokens/requestedtoken)"`,
+		`--cert /var/run/secrets/client-cert/tls.crt --key /var/run/secrets/client-cert/tls.key`,
+		`-H 'X-Test: it'\''s'`,
+		`'https://kube-rbac-proxy:8443/metrics'`,
+		`if [ "$STATUS_CODE" != 200 ]`,
+		`grep -qixF 'Content-Type: text/plain'`,
+		`grep -qF 'go_goroutines' /tmp/body`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("want script to contain %q, got:\n%s", want, script)
+		}
+	}
+}
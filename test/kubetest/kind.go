@@ -0,0 +1,165 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// KindConfig configures a kind cluster created for a suite.
+type KindConfig struct {
+	// Name is the name of the cluster.
+	Name string
+	// NodeImage is the kindest/node image of the nodes, kind's default if empty.
+	NodeImage string
+	// Workers is the number of worker nodes besides the control plane.
+	Workers int
+	// ConfigFile is the kind cluster configuration the nodes are added to.
+	ConfigFile string
+	// Images are loaded into the nodes from the local docker daemon, e.g. the
+	// locally built proxy image.
+	Images []string
+	// Keep leaves the cluster running after the suite, e.g. for debugging.
+	Keep bool
+	// WaitTimeout is the maximum duration to wait for the control plane.
+	WaitTimeout time.Duration
+}
+
+// KindCluster is a kind cluster created with the kind command.
+type KindCluster struct {
+	cfg KindConfig
+	dir string
+}
+
+// CreateKindCluster creates a kind cluster and loads the images into it. Its
+// kubeconfig is written to a temporary directory, so the user's kubeconfig
+// isn't changed.
+func CreateKindCluster(cfg KindConfig) (*KindCluster, error) {
+	dir, err := ioutil.TempDir("", "kubetest-kind")
+	if err != nil {
+		return nil, err
+	}
+	c := &KindCluster{cfg: cfg, dir: dir}
+
+	config, err := kindClusterConfig(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	configPath := filepath.Join(dir, "kind-config.yaml")
+	if err := ioutil.WriteFile(configPath, config, 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	args := []string{"create", "cluster", "--name", cfg.Name, "--config", configPath, "--kubeconfig", c.Kubeconfig()}
+	if cfg.NodeImage != "" {
+		args = append(args, "--image", cfg.NodeImage)
+	}
+	if cfg.WaitTimeout > 0 {
+		args = append(args, "--wait", cfg.WaitTimeout.String())
+	}
+	if err := kind(args...); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create kind cluster: %v", err)
+	}
+
+	if len(cfg.Images) > 0 {
+		args := append([]string{"load", "docker-image", "--name", cfg.Name}, cfg.Images...)
+		if err := kind(args...); err != nil {
+			_ = c.Delete()
+			return nil, fmt.Errorf("failed to load images into kind cluster: %v", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Kubeconfig returns the path of the cluster's kubeconfig.
+func (c *KindCluster) Kubeconfig() string {
+	return filepath.Join(c.dir, "kubeconfig")
+}
+
+// Delete deletes the cluster, unless it is kept.
+func (c *KindCluster) Delete() error {
+	if c.cfg.Keep {
+		fmt.Fprintf(os.Stderr, "keeping kind cluster %s, its kubeconfig is %s\n", c.cfg.Name, c.Kubeconfig())
+		return nil
+	}
+
+	defer os.RemoveAll(c.dir)
+	if err := kind("delete", "cluster", "--name", c.cfg.Name, "--kubeconfig", c.Kubeconfig()); err != nil {
+		return fmt.Errorf("failed to delete kind cluster: %v", err)
+	}
+	return nil
+}
+
+// kindClusterConfig returns the kind cluster configuration of cfg.ConfigFile
+// with a control plane and cfg.Workers worker nodes.
+func kindClusterConfig(cfg KindConfig) ([]byte, error) {
+	config := yaml.MapSlice{
+		{Key: "kind", Value: "Cluster"},
+		{Key: "apiVersion", Value: "kind.x-k8s.io/v1alpha4"},
+	}
+	if cfg.ConfigFile != "" {
+		b, err := ioutil.ReadFile(cfg.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		config = nil
+		if err := yaml.Unmarshal(b, &config); err != nil {
+			return nil, fmt.Errorf("invalid kind config %s: %v", cfg.ConfigFile, err)
+		}
+	}
+
+	nodes := []map[string]string{{"role": "control-plane"}}
+	for i := 0; i < cfg.Workers; i++ {
+		nodes = append(nodes, map[string]string{"role": "worker"})
+	}
+
+	replaced := false
+	for i := range config {
+		if config[i].Key == "nodes" {
+			config[i].Value = nodes
+			replaced = true
+		}
+	}
+	if !replaced {
+		config = append(config, yaml.MapItem{Key: "nodes", Value: nodes})
+	}
+
+	return yaml.Marshal(config)
+}
+
+// kind runs the kind command, showing its output.
+func kind(args ...string) error {
+	cmd := exec.Command("kind", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("kind %s: %v", strings.Join(args[:2], " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestKindClusterConfig(t *testing.T) {
+	b, err := kindClusterConfig(KindConfig{ConfigFile: "../e2e/kind-config/kind-config.yaml", Workers: 2})
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	var config struct {
+		Kind                 string
+		KubeadmConfigPatches []string `yaml:"kubeadmConfigPatches"`
+		Nodes                []struct{ Role string }
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Kind != "Cluster" || len(config.KubeadmConfigPatches) != 1 {
+		t.Errorf("want the settings of the config file to be kept, got %s", b)
+	}
+	var roles []string
+	for _, n := range config.Nodes {
+		roles = append(roles, n.Role)
+	}
+	if len(roles) != 3 || roles[0] != "control-plane" || roles[1] != "worker" || roles[2] != "worker" {
+		t.Errorf("want a control plane and 2 workers, got %v", roles)
+	}
+}
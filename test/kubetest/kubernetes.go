@@ -17,11 +17,14 @@ limitations under the License.
 package kubetest
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -32,11 +35,14 @@ import (
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/apimachinery/pkg/util/wait"
 	kubeyaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/kubernetes"
 )
 
+// CreatedManifests creates the objects of the manifest files in the scenario
+// namespace. Files may contain several YAML documents.
 func CreatedManifests(client kubernetes.Interface, paths ...string) Setup {
 	return func(ctx *ScenarioContext) error {
 		for _, path := range paths {
@@ -49,40 +55,90 @@ func CreatedManifests(client kubernetes.Interface, paths ...string) Setup {
 				return fmt.Errorf("manifest has no content: %s", path)
 			}
 
-			var meta metav1.TypeMeta
-			if err = yaml.Unmarshal(content, &meta); err != nil {
-				return err
+			if err := createManifests(client, ctx, content); err != nil {
+				return fmt.Errorf("failed to create %s: %v", path, err)
 			}
+		}
+		return nil
+	}
+}
 
-			// TODO: This needs to be more generic!
+// CreatedManifestDir creates the objects of all .yaml, .yml and .json files
+// in dir, in the order of their names. Each object is deleted again when the
+// scenario is finalized.
+func CreatedManifestDir(client kubernetes.Interface, dir string) Setup {
+	return func(ctx *ScenarioContext) error {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
 
-			kind := strings.ToLower(meta.Kind)
-			switch kind {
-			case "clusterrole":
-				if err := createClusterRole(client, ctx, content); err != nil {
-					return err
-				}
-			case "clusterrolebinding":
-				if err := createClusterRoleBinding(client, ctx, content); err != nil {
-					return err
+		var paths []string
+		for _, f := range files {
+			switch filepath.Ext(f.Name()) {
+			case ".yaml", ".yml", ".json":
+				if !f.IsDir() {
+					paths = append(paths, filepath.Join(dir, f.Name()))
 				}
-			case "deployment":
-				if err := createDeployment(client, ctx, content); err != nil {
-					return err
-				}
-			case "service":
-				if err := createService(client, ctx, content); err != nil {
-					return err
-				}
-			case "serviceaccount":
-				if err := createServiceAccount(client, ctx, content); err != nil {
-					return err
-				}
-			default:
-				return fmt.Errorf("unable to unmarshal manifest with unknown kind: %s", kind)
 			}
 		}
-		return nil
+		if len(paths) == 0 {
+			return fmt.Errorf("no manifests in %s", dir)
+		}
+
+		return CreatedManifests(client, paths...)(ctx)
+	}
+}
+
+// createManifests creates the objects of the YAML documents in content.
+func createManifests(client kubernetes.Interface, ctx *ScenarioContext, content []byte) error {
+	r := kubeyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(content)))
+	for {
+		doc, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var meta metav1.TypeMeta
+		if err = yaml.Unmarshal(doc, &meta); err != nil {
+			return err
+		}
+		if meta.Kind == "" {
+			// Empty documents, e.g. only comments.
+			continue
+		}
+
+		if err := createManifest(client, ctx, strings.ToLower(meta.Kind), doc); err != nil {
+			return err
+		}
+	}
+}
+
+func createManifest(client kubernetes.Interface, ctx *ScenarioContext, kind string, content []byte) error {
+	switch kind {
+	case "clusterrole":
+		return createClusterRole(client, ctx, content)
+	case "clusterrolebinding":
+		return createClusterRoleBinding(client, ctx, content)
+	case "role":
+		return createRole(client, ctx, content)
+	case "rolebinding":
+		return createRoleBinding(client, ctx, content)
+	case "configmap":
+		return createConfigMap(client, ctx, content)
+	case "secret":
+		return createSecret(client, ctx, content)
+	case "deployment":
+		return createDeployment(client, ctx, content)
+	case "service":
+		return createService(client, ctx, content)
+	case "serviceaccount":
+		return createServiceAccount(client, ctx, content)
+	default:
+		return fmt.Errorf("unable to unmarshal manifest with unknown kind: %s", kind)
 	}
 }
 
@@ -94,13 +150,17 @@ func createClusterRole(client kubernetes.Interface, ctx *ScenarioContext, conten
 		return err
 	}
 
-	_, err := client.RbacV1().ClusterRoles().Create(context.TODO(), cr, metav1.CreateOptions{})
+	cr.Name = clusterScopedName(ctx, cr.Name)
+
+	if _, err := client.RbacV1().ClusterRoles().Create(ctx, cr, metav1.CreateOptions{}); err != nil {
+		return err
+	}
 
 	ctx.AddFinalizer(func() error {
 		return client.RbacV1().ClusterRoles().Delete(context.TODO(), cr.Name, metav1.DeleteOptions{})
 	})
 
-	return err
+	return nil
 }
 
 func createClusterRoleBinding(client kubernetes.Interface, ctx *ScenarioContext, content []byte) error {
@@ -111,13 +171,127 @@ func createClusterRoleBinding(client kubernetes.Interface, ctx *ScenarioContext,
 		return err
 	}
 
-	_, err := client.RbacV1().ClusterRoleBindings().Create(context.TODO(), crb, metav1.CreateOptions{})
+	crb.Name = clusterScopedName(ctx, crb.Name)
+	if crb.RoleRef.Kind == "ClusterRole" {
+		crb.RoleRef.Name = clusterScopedName(ctx, crb.RoleRef.Name)
+	}
+	for i := range crb.Subjects {
+		if crb.Subjects[i].Kind == rbacv1.ServiceAccountKind {
+			crb.Subjects[i].Namespace = ctx.Namespace
+		}
+	}
+
+	if _, err := client.RbacV1().ClusterRoleBindings().Create(ctx, crb, metav1.CreateOptions{}); err != nil {
+		return err
+	}
 
 	ctx.AddFinalizer(func() error {
 		return client.RbacV1().ClusterRoleBindings().Delete(context.TODO(), crb.Name, metav1.DeleteOptions{})
 	})
 
-	return err
+	return nil
+}
+
+// clusterScopedName returns the name of a cluster-scoped object created for
+// the scenario. Outside the default namespace it is suffixed with the
+// namespace, so scenarios running in parallel don't share the object.
+func clusterScopedName(ctx *ScenarioContext, name string) string {
+	if ctx.Namespace == "default" {
+		return name
+	}
+	return name + "-" + ctx.Namespace
+}
+
+func createRole(client kubernetes.Interface, ctx *ScenarioContext, content []byte) error {
+	r := bytes.NewReader(content)
+
+	var role *rbacv1.Role
+	if err := kubeyaml.NewYAMLOrJSONDecoder(r, r.Len()).Decode(&role); err != nil {
+		return err
+	}
+
+	role.Namespace = ctx.Namespace
+
+	if _, err := client.RbacV1().Roles(role.Namespace).Create(ctx, role, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+
+	ctx.AddFinalizer(func() error {
+		return client.RbacV1().Roles(role.Namespace).Delete(context.TODO(), role.Name, metav1.DeleteOptions{})
+	})
+
+	return nil
+}
+
+func createRoleBinding(client kubernetes.Interface, ctx *ScenarioContext, content []byte) error {
+	r := bytes.NewReader(content)
+
+	var rb *rbacv1.RoleBinding
+	if err := kubeyaml.NewYAMLOrJSONDecoder(r, r.Len()).Decode(&rb); err != nil {
+		return err
+	}
+
+	rb.Namespace = ctx.Namespace
+	if rb.RoleRef.Kind == "ClusterRole" {
+		rb.RoleRef.Name = clusterScopedName(ctx, rb.RoleRef.Name)
+	}
+	for i := range rb.Subjects {
+		if rb.Subjects[i].Kind == rbacv1.ServiceAccountKind {
+			rb.Subjects[i].Namespace = ctx.Namespace
+		}
+	}
+
+	if _, err := client.RbacV1().RoleBindings(rb.Namespace).Create(ctx, rb, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+
+	ctx.AddFinalizer(func() error {
+		return client.RbacV1().RoleBindings(rb.Namespace).Delete(context.TODO(), rb.Name, metav1.DeleteOptions{})
+	})
+
+	return nil
+}
+
+func createConfigMap(client kubernetes.Interface, ctx *ScenarioContext, content []byte) error {
+	r := bytes.NewReader(content)
+
+	var cm *corev1.ConfigMap
+	if err := kubeyaml.NewYAMLOrJSONDecoder(r, r.Len()).Decode(&cm); err != nil {
+		return err
+	}
+
+	cm.Namespace = ctx.Namespace
+
+	if _, err := client.CoreV1().ConfigMaps(cm.Namespace).Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+
+	ctx.AddFinalizer(func() error {
+		return client.CoreV1().ConfigMaps(cm.Namespace).Delete(context.TODO(), cm.Name, metav1.DeleteOptions{})
+	})
+
+	return nil
+}
+
+func createSecret(client kubernetes.Interface, ctx *ScenarioContext, content []byte) error {
+	r := bytes.NewReader(content)
+
+	var secret *corev1.Secret
+	if err := kubeyaml.NewYAMLOrJSONDecoder(r, r.Len()).Decode(&secret); err != nil {
+		return err
+	}
+
+	secret.Namespace = ctx.Namespace
+
+	if _, err := client.CoreV1().Secrets(secret.Namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+
+	ctx.AddFinalizer(func() error {
+		return client.CoreV1().Secrets(secret.Namespace).Delete(context.TODO(), secret.Name, metav1.DeleteOptions{})
+	})
+
+	return nil
 }
 
 func createDeployment(client kubernetes.Interface, ctx *ScenarioContext, content []byte) error {
@@ -130,13 +304,15 @@ func createDeployment(client kubernetes.Interface, ctx *ScenarioContext, content
 
 	d.Namespace = ctx.Namespace
 
-	_, err := client.AppsV1().Deployments(d.Namespace).Create(context.TODO(), &d, metav1.CreateOptions{})
+	if _, err := client.AppsV1().Deployments(d.Namespace).Create(ctx, &d, metav1.CreateOptions{}); err != nil {
+		return err
+	}
 
 	ctx.AddFinalizer(func() error {
 		return client.AppsV1().Deployments(d.Namespace).Delete(context.TODO(), d.Name, metav1.DeleteOptions{})
 	})
 
-	return err
+	return nil
 }
 
 func createService(client kubernetes.Interface, ctx *ScenarioContext, content []byte) error {
@@ -149,13 +325,15 @@ func createService(client kubernetes.Interface, ctx *ScenarioContext, content []
 
 	s.Namespace = ctx.Namespace
 
-	_, err := client.CoreV1().Services(s.Namespace).Create(context.TODO(), s, metav1.CreateOptions{})
+	if _, err := client.CoreV1().Services(s.Namespace).Create(ctx, s, metav1.CreateOptions{}); err != nil {
+		return err
+	}
 
 	ctx.AddFinalizer(func() error {
 		return client.CoreV1().Services(s.Namespace).Delete(context.TODO(), s.Name, metav1.DeleteOptions{})
 	})
 
-	return err
+	return nil
 }
 
 func createServiceAccount(client kubernetes.Interface, ctx *ScenarioContext, content []byte) error {
@@ -168,21 +346,23 @@ func createServiceAccount(client kubernetes.Interface, ctx *ScenarioContext, con
 
 	sa.Namespace = ctx.Namespace
 
-	_, err := client.CoreV1().ServiceAccounts(sa.Namespace).Create(context.TODO(), sa, metav1.CreateOptions{})
+	if _, err := client.CoreV1().ServiceAccounts(sa.Namespace).Create(ctx, sa, metav1.CreateOptions{}); err != nil {
+		return err
+	}
 
 	ctx.AddFinalizer(func() error {
 		return client.CoreV1().ServiceAccounts(sa.Namespace).Delete(context.TODO(), sa.Name, metav1.DeleteOptions{})
 	})
 
-	return err
+	return nil
 }
 
 // PodsAreReady waits for a number if replicas matching the given labels to be ready.
 // Returns a func directly (not Setup or Conditions) as it can be used in Given and When steps
 func PodsAreReady(client kubernetes.Interface, replicas int, labels string) func(*ScenarioContext) error {
 	return func(ctx *ScenarioContext) error {
-		return wait.Poll(time.Second, time.Minute, func() (bool, error) {
-			list, err := client.CoreV1().Pods(ctx.Namespace).List(context.TODO(), metav1.ListOptions{
+		return poll(ctx, func() (bool, error) {
+			list, err := client.CoreV1().Pods(ctx.Namespace).List(ctx, metav1.ListOptions{
 				LabelSelector: labels,
 			})
 			if err != nil {
@@ -213,13 +393,13 @@ func PodsAreReady(client kubernetes.Interface, replicas int, labels string) func
 // Returns a func directly (not Setup or Conditions) as it can be used in Given and When steps
 func ServiceIsReady(client kubernetes.Interface, service string) func(*ScenarioContext) error {
 	return func(ctx *ScenarioContext) error {
-		return wait.Poll(time.Second, time.Minute, func() (bool, error) {
-			_, err := client.CoreV1().Services(ctx.Namespace).Get(context.TODO(), service, metav1.GetOptions{})
+		return poll(ctx, func() (bool, error) {
+			_, err := client.CoreV1().Services(ctx.Namespace).Get(ctx, service, metav1.GetOptions{})
 			if err != nil {
 				return false, fmt.Errorf("failed to get service: %v", err)
 			}
 
-			endpoints, err := client.CoreV1().Endpoints(ctx.Namespace).Get(context.TODO(), service, metav1.GetOptions{})
+			endpoints, err := client.CoreV1().Endpoints(ctx.Namespace).Get(ctx, service, metav1.GetOptions{})
 			if err != nil {
 				return false, fmt.Errorf("failed to get endpoints: %v", err)
 			}
@@ -237,6 +417,19 @@ func ServiceIsReady(client kubernetes.Interface, service string) func(*ScenarioC
 	}
 }
 
+// poll runs condition every second until it is done, for at most a minute or
+// until the scenario times out.
+func poll(ctx *ScenarioContext, condition wait.ConditionFunc) error {
+	pollCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	err := wait.PollUntil(time.Second, condition, pollCtx.Done())
+	if err == wait.ErrWaitTimeout && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
 // podRunningAndReady returns whether a pod is running and each container has
 // passed it's ready state.
 func podRunningAndReady(pod corev1.Pod) (bool, error) {
@@ -257,14 +450,21 @@ func podRunningAndReady(pod corev1.Pod) (bool, error) {
 
 func Sleep(d time.Duration) Condition {
 	return func(ctx *ScenarioContext) error {
-		time.Sleep(d)
-		return nil
+		select {
+		case <-time.After(d):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
 
 type RunOptions struct {
 	ServiceAccount string
 	TokenAudience  string
+	// ClientCertSecret is the name of a kubernetes.io/tls Secret mounted
+	// into the client pod, to present as client certificate.
+	ClientCertSecret string
 }
 
 func RunSucceeds(client kubernetes.Interface, image string, name string, command []string, opts *RunOptions) Check {
@@ -339,13 +539,29 @@ func run(client kubernetes.Interface, ctx *ScenarioContext, image string, name s
 		)
 	}
 
+	if opts != nil && opts.ClientCertSecret != "" {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "client-cert",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: opts.ClientCertSecret},
+			},
+		})
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts,
+			corev1.VolumeMount{
+				Name:      "client-cert",
+				MountPath: clientCertPath,
+			},
+		)
+	}
+
 	parallelism := int32(1)
 	completions := int32(1)
 	activeDeadlineSeconds := int64(60)
 	backoffLimit := int32(3)
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "kube-rbac-proxy-client",
+			// Unique, so a scenario can run several clients.
+			Name:      name + "-" + rand.String(5),
 			Namespace: ctx.Namespace,
 		},
 		Spec: batchv1.JobSpec{
@@ -357,7 +573,7 @@ func run(client kubernetes.Interface, ctx *ScenarioContext, image string, name s
 		},
 	}
 
-	_, err := client.BatchV1().Jobs(ctx.Namespace).Create(context.TODO(), job, metav1.CreateOptions{})
+	_, err := client.BatchV1().Jobs(ctx.Namespace).Create(ctx, job, metav1.CreateOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create job: %v", err)
 	}
@@ -371,7 +587,7 @@ func run(client kubernetes.Interface, ctx *ScenarioContext, image string, name s
 		return nil
 	})
 
-	watch, err := client.BatchV1().Jobs(ctx.Namespace).Watch(context.TODO(), metav1.SingleObject(job.ObjectMeta))
+	watch, err := client.BatchV1().Jobs(ctx.Namespace).Watch(ctx, metav1.SingleObject(job.ObjectMeta))
 	if err != nil {
 		return nil, fmt.Errorf("failed to watch job: %v", err)
 	}
@@ -402,19 +618,21 @@ func run(client kubernetes.Interface, ctx *ScenarioContext, image string, name s
 		}
 	}
 
-	return nil, nil
+	// The watch is closed when the scenario times out.
+	return nil, ctx.Err()
 }
 
-func podLogs(client kubernetes.Interface, namespace, pod, container string) ([]byte, error) {
+func podLogs(ctx context.Context, client kubernetes.Interface, namespace, pod, container string) ([]byte, error) {
 	rest := client.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{
 		Container: container,
 		Follow:    false,
 	})
 
-	stream, err := rest.Stream(context.TODO())
+	stream, err := rest.Stream(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer stream.Close()
 	return ioutil.ReadAll(stream)
 }
 
@@ -422,9 +640,9 @@ func CreateNamespace(client kubernetes.Interface, name string) error {
 	ns := &v1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: name,
-			},
-		}
-	
+		},
+	}
+
 	_, err := client.CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to create namespace with name %v", name)
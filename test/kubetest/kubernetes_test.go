@@ -0,0 +1,101 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCreatedManifestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"rbac.yaml": `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: metrics
+---
+# only a comment
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: metrics
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: metrics
+subjects:
+- kind: ServiceAccount
+  name: default
+  namespace: default
+`,
+		"config.yml": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: default
+`,
+		"README.md": "not a manifest",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := fake.NewSimpleClientset()
+	ctx := &ScenarioContext{Context: context.Background(), Namespace: "abc"}
+	if err := CreatedManifestDir(client, dir)(ctx); err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	if _, err := client.CoreV1().ConfigMaps("abc").Get(ctx, "config", metav1.GetOptions{}); err != nil {
+		t.Errorf("want config map in the scenario namespace, got %v", err)
+	}
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(ctx, "metrics-abc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("want cluster role binding named after the namespace, got %v", err)
+	}
+	if crb.RoleRef.Name != "metrics-abc" || crb.Subjects[0].Namespace != "abc" {
+		t.Errorf("want binding of metrics-abc to abc/default, got %v and %v", crb.RoleRef, crb.Subjects)
+	}
+
+	if len(ctx.Finalizer) != 3 {
+		t.Fatalf("want 3 finalizers, got %d", len(ctx.Finalizer))
+	}
+	for _, f := range ctx.Finalizer {
+		if err := f(); err != nil {
+			t.Errorf("want err to be nil, but got %v", err)
+		}
+	}
+	if _, err := client.RbacV1().ClusterRoles().Get(ctx, "metrics-abc", metav1.GetOptions{}); err == nil {
+		t.Error("want cluster role to be deleted by its finalizer")
+	}
+}
@@ -17,16 +17,28 @@ limitations under the License.
 package kubetest
 
 import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
 	"testing"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 type Suite struct {
 	KubeClient kubernetes.Interface
+	// RestConfig is the configuration KubeClient was created with, e.g. to
+	// port-forward.
+	RestConfig *rest.Config
+	// Report collects the results of the scenarios run with Recorded.
+	Report *Report
+
+	kind *KindCluster
 }
 
 func NewSuiteFromKubeconfig(path string) (*Suite, error) {
@@ -40,7 +52,33 @@ func NewSuiteFromKubeconfig(path string) (*Suite, error) {
 		return nil, err
 	}
 
-	return &Suite{KubeClient: client}, nil
+	return &Suite{KubeClient: client, RestConfig: config, Report: &Report{}}, nil
+}
+
+// NewSuiteWithKindCluster creates a kind cluster and returns a suite running
+// against it. The cluster is deleted by Teardown.
+func NewSuiteWithKindCluster(cfg KindConfig) (*Suite, error) {
+	c, err := CreateKindCluster(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewSuiteFromKubeconfig(c.Kubeconfig())
+	if err != nil {
+		_ = c.Delete()
+		return nil, err
+	}
+	s.kind = c
+
+	return s, nil
+}
+
+// Teardown deletes the kind cluster created for the suite, if any.
+func (s *Suite) Teardown() error {
+	if s.kind == nil {
+		return nil
+	}
+	return s.kind.Delete()
 }
 
 type TestSuite func(t *testing.T)
@@ -56,9 +94,21 @@ type Scenario struct {
 	Then  Check
 }
 
+// ScenarioContext is passed to the steps of a scenario. The embedded
+// context is done once the scenario times out, see Timeout, so steps waiting
+// for the cluster should use it for their requests.
 type ScenarioContext struct {
+	context.Context
+
 	Namespace string
 	Finalizer []Finalizer
+
+	parallel bool
+	timeout  time.Duration
+	report   *Report
+	optsErr  error
+
+	diagnostics []diagnostic
 }
 
 func (ctx *ScenarioContext) AddFinalizer(f Finalizer) {
@@ -71,27 +121,42 @@ func RandomNamespace(client kubernetes.Interface) RunOpts {
 	return func(ctx *ScenarioContext) *ScenarioContext {
 		ctx.Namespace = rand.String(8)
 
+		if err := CreateNamespace(client, ctx.Namespace); err != nil {
+			// Reported by the scenario's test.
+			ctx.optsErr = err
+			return ctx
+		}
+
 		ctx.AddFinalizer(func() error {
 			return DeleteNamespace(client, ctx.Namespace)
 		})
 
-		if err := CreateNamespace(client, ctx.Namespace); err != nil {
-			panic(err)
-		}
-
 		return ctx
 	}
 }
 
+// Timeout fails the steps of the scenario still running d after it started.
 func Timeout(d time.Duration) RunOpts {
 	return func(ctx *ScenarioContext) *ScenarioContext {
-		// TODO
+		ctx.timeout = d
+		return ctx
+	}
+}
+
+// Parallel runs the scenario in parallel with the other parallel scenarios of
+// the test. Combined with RandomNamespace, each scenario gets its own
+// namespace, and cluster-scoped objects created from manifests are named
+// after it, so the scenarios don't interfere.
+func Parallel() RunOpts {
+	return func(ctx *ScenarioContext) *ScenarioContext {
+		ctx.parallel = true
 		return ctx
 	}
 }
 
 func (s Scenario) Run(t *testing.T, opts ...RunOpts) bool {
 	ctx := &ScenarioContext{
+		Context:   context.Background(),
 		Namespace: "default",
 	}
 
@@ -99,30 +164,85 @@ func (s Scenario) Run(t *testing.T, opts ...RunOpts) bool {
 		o(ctx)
 	}
 
-	defer func(ctx *ScenarioContext) {
-		for _, f := range ctx.Finalizer {
-			if err := f(); err != nil {
-				panic(err)
+	return t.Run(s.Name, func(t *testing.T) {
+		if ctx.parallel {
+			t.Parallel()
+		}
+
+		start := time.Now()
+		var failures []string
+		errorf := func(format string, args ...interface{}) {
+			msg := fmt.Sprintf(format, args...)
+			failures = append(failures, msg)
+			t.Error(msg)
+		}
+		if ctx.report != nil {
+			defer func() {
+				ctx.report.add(ScenarioResult{
+					Name:        t.Name(),
+					Description: strings.Join(strings.Fields(s.Description), " "),
+					Duration:    time.Since(start),
+					Failed:      t.Failed(),
+					Failures:    failures,
+				})
+			}()
+		}
+
+		// Finalizers run within the subtest, which parallel scenarios only
+		// start after Run returned, in reverse order, so the namespace is
+		// deleted after the objects in it. All of them run, even if steps
+		// failed or panicked, and their errors fail the scenario. Diagnostics
+		// of failed scenarios are collected before.
+		defer func() {
+			for i := len(ctx.Finalizer) - 1; i >= 0; i-- {
+				if err := ctx.Finalizer[i](); err != nil {
+					errorf("failed to finalize: %v", err)
+				}
 			}
+		}()
+		defer func() {
+			if t.Failed() {
+				ctx.diagnose(t)
+			}
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				errorf("scenario panicked: %v\n%s", r, debug.Stack())
+			}
+		}()
+
+		if ctx.optsErr != nil {
+			errorf("failed to prepare scenario: %v", ctx.optsErr)
+			t.FailNow()
 		}
-	}(ctx)
 
-	return t.Run(s.Name, func(t *testing.T) {
+		if ctx.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx.Context, cancel = context.WithTimeout(ctx.Context, ctx.timeout)
+			defer cancel()
+		}
+		defer func() {
+			if ctx.Err() == context.DeadlineExceeded {
+				errorf("scenario timed out after %v", ctx.timeout)
+			}
+		}()
+
 		if s.Given != nil {
 			if err := s.Given(ctx); err != nil {
-				t.Fatalf("failed to create given setup: %v", err)
+				errorf("failed to create given setup: %v", err)
+				t.FailNow()
 			}
 		}
 
 		if s.When != nil {
 			if err := s.When(ctx); err != nil {
-				t.Errorf("failed to evaluate state: %v", err)
+				errorf("failed to evaluate state: %v", err)
 			}
 		}
 
-		if s.Given != nil {
+		if s.Then != nil {
 			if err := s.Then(ctx); err != nil {
-				t.Errorf("checks failed: %v", err)
+				errorf("checks failed: %v", err)
 			}
 		}
 	})
@@ -167,4 +287,64 @@ func Checks(cs ...Check) Check {
 	}
 }
 
+// Eventually retries cond every interval until it succeeds, failing with its
+// last error if it doesn't within timeout, e.g. while RBAC rules propagate or
+// a Deployment rolls out. Steps should use the context passed to cond, which
+// is done after timeout.
+// Returns a func directly (not Condition or Check) as it can be used in When and Then steps
+func Eventually(cond func(*ScenarioContext) error, timeout, interval time.Duration) func(*ScenarioContext) error {
+	return func(ctx *ScenarioContext) error {
+		parent := ctx.Context
+		c, cancel := context.WithTimeout(parent, timeout)
+		defer cancel()
+		ctx.Context = c
+		defer func() { ctx.Context = parent }()
+
+		var lastErr error
+		for {
+			err := cond(ctx)
+			if err == nil {
+				return nil
+			}
+			// Keep the error of the previous attempt if this one was only
+			// interrupted by the timeout.
+			if lastErr == nil || c.Err() == nil {
+				lastErr = err
+			}
+
+			select {
+			case <-time.After(interval):
+			case <-c.Done():
+				if parent.Err() != nil {
+					return parent.Err()
+				}
+				return fmt.Errorf("condition not met within %v: %v", timeout, lastErr)
+			}
+		}
+	}
+}
+
+// Consistently runs cond every interval for d, failing as soon as it fails,
+// e.g. to check that access stays denied.
+// Returns a func directly (not Condition or Check) as it can be used in When and Then steps
+func Consistently(cond func(*ScenarioContext) error, d, interval time.Duration) func(*ScenarioContext) error {
+	return func(ctx *ScenarioContext) error {
+		start := time.Now()
+		for {
+			if err := cond(ctx); err != nil {
+				return fmt.Errorf("condition failed after %v: %v", time.Since(start).Round(time.Millisecond), err)
+			}
+			if time.Since(start) >= d {
+				return nil
+			}
+
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
 type Finalizer func() error
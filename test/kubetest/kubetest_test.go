@@ -0,0 +1,101 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScenarioRun(t *testing.T) {
+	var calls []string
+	Scenario{
+		Name: "ThenWithoutGiven",
+		Then: func(ctx *ScenarioContext) error {
+			for _, name := range []string{"first", "second"} {
+				name := name
+				ctx.AddFinalizer(func() error {
+					calls = append(calls, "finalize "+name)
+					return nil
+				})
+			}
+			calls = append(calls, "then")
+			return nil
+		},
+	}.Run(t)
+
+	want := []string{"then", "finalize second", "finalize first"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("want calls %v, got %v", want, calls)
+	}
+}
+
+func TestEventually(t *testing.T) {
+	ctx := &ScenarioContext{Context: context.Background()}
+
+	attempts := 0
+	err := Eventually(func(*ScenarioContext) error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("attempt %d failed", attempts)
+		}
+		return nil
+	}, time.Second, time.Millisecond)(ctx)
+	if err != nil || attempts != 3 {
+		t.Errorf("want success after 3 attempts, got %d attempts and err %v", attempts, err)
+	}
+
+	err = Eventually(func(ctx *ScenarioContext) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, 10*time.Millisecond, time.Millisecond)(ctx)
+	if err == nil || !strings.Contains(err.Error(), "condition not met within 10ms: context deadline exceeded") {
+		t.Errorf("want timeout error, got %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("want scenario context to be restored, got err %v", ctx.Err())
+	}
+}
+
+func TestConsistently(t *testing.T) {
+	ctx := &ScenarioContext{Context: context.Background()}
+
+	attempts := 0
+	err := Consistently(func(*ScenarioContext) error {
+		attempts++
+		return nil
+	}, 20*time.Millisecond, 5*time.Millisecond)(ctx)
+	if err != nil || attempts < 2 {
+		t.Errorf("want success after several attempts, got %d attempts and err %v", attempts, err)
+	}
+
+	attempts = 0
+	err = Consistently(func(*ScenarioContext) error {
+		attempts++
+		if attempts == 2 {
+			return fmt.Errorf("access granted")
+		}
+		return nil
+	}, time.Second, time.Millisecond)(ctx)
+	if err == nil || !strings.Contains(err.Error(), "access granted") || attempts != 2 {
+		t.Errorf("want failure on the 2nd attempt, got %d attempts and err %v", attempts, err)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// LoadScenario is a scenario sending requests at a constant rate to a target,
+// e.g. the proxy through PortForwardURL, and checking the error rate and
+// latency percentiles of the responses.
+type LoadScenario struct {
+	Name        string
+	Description string
+
+	Given Setup
+	When  Condition
+
+	// Target returns the URL requests are sent to.
+	Target func(*ScenarioContext) (*url.URL, error)
+	// Method defaults to GET.
+	Method string
+	// Token is sent as bearer token, if set.
+	Token TokenSource
+	// Client sends the requests. It defaults to a client not verifying the
+	// server's certificate.
+	Client *http.Client
+
+	// RPS is the number of requests sent per second for Duration, whether
+	// previous requests completed or not.
+	RPS      int
+	Duration time.Duration
+
+	// MaxErrorRate is the maximum fraction of requests that may fail or
+	// return a non-2xx status code.
+	MaxErrorRate float64
+	// MaxLatency maps percentiles, e.g. 0.99, to the maximum latency of
+	// requests at that percentile.
+	MaxLatency map[float64]time.Duration
+}
+
+// Run runs the load scenario, see Scenario.Run.
+func (s LoadScenario) Run(t *testing.T, opts ...RunOpts) bool {
+	return Scenario{
+		Name:        s.Name,
+		Description: s.Description,
+		Given:       s.Given,
+		When:        s.When,
+		Then:        s.check,
+	}.Run(t, opts...)
+}
+
+func (s LoadScenario) check(ctx *ScenarioContext) error {
+	if s.RPS <= 0 || s.Duration <= 0 {
+		return fmt.Errorf("RPS and duration of load must be positive")
+	}
+
+	target, err := s.Target(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get load target: %v", err)
+	}
+	var token string
+	if s.Token != nil {
+		if token, err = s.Token(ctx); err != nil {
+			return err
+		}
+	}
+	method := s.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+				MaxIdleConnsPerHost: s.RPS,
+			},
+			Timeout: 10 * time.Second,
+		}
+	}
+
+	res := generateLoad(ctx, s.RPS, s.Duration, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return res.check(s.MaxErrorRate, s.MaxLatency)
+}
+
+// LoadResult is the outcome of the requests sent by a load scenario.
+type LoadResult struct {
+	// Latencies of all requests sent, including failed ones.
+	Latencies []time.Duration
+	// Errors of the failed requests.
+	Errors []error
+}
+
+// ErrorRate returns the fraction of failed requests.
+func (r LoadResult) ErrorRate() float64 {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	return float64(len(r.Errors)) / float64(len(r.Latencies))
+}
+
+// Percentile returns the latency of the requests at percentile p, e.g. 0.99,
+// using the nearest-rank method.
+func (r LoadResult) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(r.Latencies))
+	copy(sorted, r.Latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// check returns an error describing all thresholds the result exceeds.
+func (r LoadResult) check(maxErrorRate float64, maxLatency map[float64]time.Duration) error {
+	if len(r.Latencies) == 0 {
+		return fmt.Errorf("no requests sent")
+	}
+
+	var violations []string
+	if rate := r.ErrorRate(); rate > maxErrorRate {
+		violations = append(violations, fmt.Sprintf("error rate %.4f of %d requests exceeds %.4f, first error: %v", rate, len(r.Latencies), maxErrorRate, r.Errors[0]))
+	}
+
+	percentiles := make([]float64, 0, len(maxLatency))
+	for p := range maxLatency {
+		percentiles = append(percentiles, p)
+	}
+	sort.Float64s(percentiles)
+	for _, p := range percentiles {
+		if got := r.Percentile(p); got > maxLatency[p] {
+			violations = append(violations, fmt.Sprintf("p%g latency %v exceeds %v", p*100, got, maxLatency[p]))
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("load checks failed: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// generateLoad calls send rps times per second for d, or until ctx is done,
+// and waits for all calls to return.
+func generateLoad(ctx context.Context, rps int, d time.Duration, send func(context.Context) error) LoadResult {
+	var (
+		res LoadResult
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+	)
+
+	ticker := time.NewTicker(time.Second / time.Duration(rps))
+	defer ticker.Stop()
+	done := time.After(d)
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-done:
+			break loop
+		case <-ticker.C:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				start := time.Now()
+				err := send(ctx)
+				latency := time.Since(start)
+
+				mu.Lock()
+				defer mu.Unlock()
+				res.Latencies = append(res.Latencies, latency)
+				if err != nil {
+					res.Errors = append(res.Errors, err)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+	return res
+}
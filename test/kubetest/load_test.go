@@ -0,0 +1,88 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadScenario(t *testing.T) {
+	var requests int64
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	LoadScenario{
+		Name: "Load",
+		Target: func(*ScenarioContext) (*url.URL, error) {
+			return url.Parse(srv.URL + "/metrics")
+		},
+		Token:      func(*ScenarioContext) (string, error) { return "s3cr3t", nil },
+		RPS:        100,
+		Duration:   200 * time.Millisecond,
+		MaxLatency: map[float64]time.Duration{0.99: 5 * time.Second},
+	}.Run(t)
+
+	if got := atomic.LoadInt64(&requests); got < 10 || got > 20 {
+		t.Errorf("want about 20 requests, got %d", got)
+	}
+}
+
+func TestLoadResult(t *testing.T) {
+	res := LoadResult{Errors: []error{fmt.Errorf("unexpected status code 502")}}
+	for i := 1; i <= 100; i++ {
+		res.Latencies = append(res.Latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	for p, want := range map[float64]time.Duration{
+		0:    1 * time.Millisecond,
+		0.5:  50 * time.Millisecond,
+		0.99: 99 * time.Millisecond,
+		1:    100 * time.Millisecond,
+	} {
+		if got := res.Percentile(p); got != want {
+			t.Errorf("want p%g %v, got %v", p*100, want, got)
+		}
+	}
+
+	if err := res.check(0.01, map[float64]time.Duration{0.99: 100 * time.Millisecond}); err != nil {
+		t.Errorf("want err to be nil, but got %v", err)
+	}
+	err := res.check(0, map[float64]time.Duration{0.5: 10 * time.Millisecond, 0.99: 100 * time.Millisecond})
+	if err == nil {
+		t.Fatal("want err, got nil")
+	}
+	for _, want := range []string{"error rate 0.0100 of 100 requests exceeds 0.0000", "unexpected status code 502", "p50 latency 50ms exceeds 10ms"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("want error to contain %q, got %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "p99") {
+		t.Errorf("want p99 within threshold, got %v", err)
+	}
+}
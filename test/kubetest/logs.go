@@ -0,0 +1,147 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// diagnosticsTimeout bounds collecting the diagnostics of a failed scenario,
+// which also happens once the scenario timed out.
+const diagnosticsTimeout = 30 * time.Second
+
+// diagnostic collects information attached to the output of a failed
+// scenario.
+type diagnostic struct {
+	name    string
+	collect func(ctx context.Context, namespace string) ([]byte, error)
+}
+
+// diagnose logs the diagnostics of the scenario.
+func (ctx *ScenarioContext) diagnose(t *testing.T) {
+	c, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+
+	for _, d := range ctx.diagnostics {
+		out, err := d.collect(c, ctx.Namespace)
+		if err != nil {
+			t.Logf("failed to collect %s: %v", d.name, err)
+			continue
+		}
+		t.Logf("%s:\n%s", d.name, out)
+	}
+}
+
+// LogsOnFailure attaches the logs of container of the pods matching selector
+// in the scenario namespace, e.g. "app=kube-rbac-proxy", to the output of
+// failed scenarios. All containers' logs are attached if container is empty.
+func LogsOnFailure(client kubernetes.Interface, selector, container string) RunOpts {
+	return func(ctx *ScenarioContext) *ScenarioContext {
+		ctx.diagnostics = append(ctx.diagnostics, diagnostic{
+			name: fmt.Sprintf("logs of %s pods", selector),
+			collect: func(ctx context.Context, namespace string) ([]byte, error) {
+				return selectedPodLogs(ctx, client, namespace, selector, container)
+			},
+		})
+		return ctx
+	}
+}
+
+func selectedPodLogs(ctx context.Context, client kubernetes.Interface, namespace, selector, container string) ([]byte, error) {
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+	if len(pods.Items) == 0 {
+		return []byte("no pods found"), nil
+	}
+
+	var out bytes.Buffer
+	for _, pod := range pods.Items {
+		containers := []string{container}
+		if container == "" {
+			containers = containers[:0]
+			for _, c := range pod.Spec.Containers {
+				containers = append(containers, c.Name)
+			}
+		}
+		for _, c := range containers {
+			fmt.Fprintf(&out, "--- %s/%s\n", pod.Name, c)
+			logs, err := podLogs(ctx, client, namespace, pod.Name, c)
+			if err != nil {
+				fmt.Fprintf(&out, "failed to get logs: %v\n", err)
+				continue
+			}
+			out.Write(logs)
+			if len(logs) > 0 && logs[len(logs)-1] != '\n' {
+				out.WriteByte('\n')
+			}
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// AuditLogsOnFailure attaches the audit events of the scenario namespace to
+// the output of failed scenarios. It requires the kube-apiserver to write
+// its audit log to stdout, i.e. to run with --audit-log-path=- and an
+// --audit-policy-file, and its pods to be labeled component=kube-apiserver,
+// as kubeadm and kind do.
+func AuditLogsOnFailure(client kubernetes.Interface) RunOpts {
+	return func(ctx *ScenarioContext) *ScenarioContext {
+		ctx.diagnostics = append(ctx.diagnostics, diagnostic{
+			name: "audit events",
+			collect: func(ctx context.Context, namespace string) ([]byte, error) {
+				logs, err := selectedPodLogs(ctx, client, "kube-system", "component=kube-apiserver", "")
+				if err != nil {
+					return nil, err
+				}
+				return auditEvents(logs, namespace), nil
+			},
+		})
+		return ctx
+	}
+}
+
+// auditEvents returns the lines of the kube-apiserver logs that are audit
+// events mentioning the namespace, i.e. of its objects or made by its
+// ServiceAccounts, like the proxy's TokenReviews and SubjectAccessReviews.
+func auditEvents(logs []byte, namespace string) []byte {
+	ns := []byte(namespace)
+
+	var out bytes.Buffer
+	s := bufio.NewScanner(bytes.NewReader(logs))
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := s.Bytes()
+		if bytes.Contains(line, []byte(`"kind":"Event"`)) && bytes.Contains(line, ns) {
+			out.Write(line)
+			out.WriteByte('\n')
+		}
+	}
+	if out.Len() == 0 {
+		return []byte("no audit events found")
+	}
+	return out.Bytes()
+}
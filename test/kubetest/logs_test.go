@@ -0,0 +1,71 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSelectedPodLogs(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-rbac-proxy-1", Namespace: "abc", Labels: map[string]string{"app": "kube-rbac-proxy"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "kube-rbac-proxy"},
+			{Name: "prometheus-example-app"},
+		}},
+	})
+
+	logs, err := selectedPodLogs(context.Background(), client, "abc", "app=kube-rbac-proxy", "")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	for _, want := range []string{"--- kube-rbac-proxy-1/kube-rbac-proxy\nfake logs\n", "--- kube-rbac-proxy-1/prometheus-example-app\n"} {
+		if !strings.Contains(string(logs), want) {
+			t.Errorf("want logs to contain %q, got:\n%s", want, logs)
+		}
+	}
+
+	logs, err = selectedPodLogs(context.Background(), client, "abc", "app=kube-rbac-proxy", "kube-rbac-proxy")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if strings.Contains(string(logs), "prometheus-example-app") {
+		t.Errorf("want only the logs of the kube-rbac-proxy container, got:\n%s", logs)
+	}
+}
+
+func TestAuditEvents(t *testing.T) {
+	logs := strings.Join([]string{
+		`I1015 10:00:00.000000       1 httplog.go:89] "HTTP" verb="GET" URI="/api/v1/namespaces/abc/pods"`,
+		`{"kind":"Event","apiVersion":"audit.k8s.io/v1","verb":"create","user":{"username":"system:serviceaccount:abc:kube-rbac-proxy"},"objectRef":{"resource":"subjectaccessreviews"}}`,
+		`{"kind":"Event","apiVersion":"audit.k8s.io/v1","verb":"get","objectRef":{"resource":"pods","namespace":"xyz"}}`,
+	}, "\n")
+
+	got := string(auditEvents([]byte(logs), "abc"))
+	if !strings.Contains(got, "subjectaccessreviews") || strings.Contains(got, "httplog") || strings.Contains(got, "xyz") {
+		t.Errorf("unexpected audit events:\n%s", got)
+	}
+	if got := string(auditEvents([]byte(logs), "def")); got != "no audit events found" {
+		t.Errorf("want no audit events, got:\n%s", got)
+	}
+}
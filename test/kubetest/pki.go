@@ -0,0 +1,222 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CA is a certificate authority issuing certificates for scenarios, so they
+// don't depend on checked-in fixtures.
+type CA struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+
+	key crypto.Signer
+}
+
+// CertOptions configures an issued certificate.
+type CertOptions struct {
+	CommonName   string
+	Organization []string
+	DNSNames     []string
+	IPAddresses  []net.IP
+	// NotBefore defaults to a minute ago, to allow for clock skew.
+	NotBefore time.Time
+	// Validity defaults to a day. Negative values, together with NotBefore,
+	// issue expired certificates.
+	Validity time.Duration
+	// Client issues a client certificate, otherwise a server certificate.
+	Client bool
+}
+
+// KeyPair is an issued certificate and its private key.
+type KeyPair struct {
+	CertPEM     []byte
+	KeyPEM      []byte
+	Certificate tls.Certificate
+}
+
+// NewCA returns a new self-signed certificate authority.
+func NewCA(commonName string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{
+		Cert:    cert,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:     key,
+	}, nil
+}
+
+// Issue issues a certificate signed by the CA.
+func (ca *CA) Issue(opts CertOptions) (*KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	notBefore := opts.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now().Add(-time.Minute)
+	}
+	validity := opts.Validity
+	if validity == 0 {
+		validity = 24 * time.Hour
+	}
+	extKeyUsage := x509.ExtKeyUsageServerAuth
+	if opts.Client {
+		extKeyUsage = x509.ExtKeyUsageClientAuth
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: opts.CommonName, Organization: opts.Organization},
+		DNSNames:     opts.DNSNames,
+		IPAddresses:  opts.IPAddresses,
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{extKeyUsage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyPair{CertPEM: certPEM, KeyPEM: keyPEM, Certificate: cert}, nil
+}
+
+// CertPool returns a pool trusting the CA.
+func (ca *CA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// ClientTLSConfig returns the configuration of a client presenting the key
+// pair, if any, and trusting servers with certificates issued by serverCA.
+func (kp *KeyPair) ClientTLSConfig(serverCA *CA) *tls.Config {
+	cfg := &tls.Config{RootCAs: serverCA.CertPool()}
+	if kp != nil {
+		cfg.Certificates = []tls.Certificate{kp.Certificate}
+	}
+	return cfg
+}
+
+// ServerTLSConfig returns the configuration of a server presenting the key
+// pair. If clientCA is set, clients must present a certificate issued by it.
+func (kp *KeyPair) ServerTLSConfig(clientCA *CA) *tls.Config {
+	cfg := &tls.Config{Certificates: []tls.Certificate{kp.Certificate}}
+	if clientCA != nil {
+		cfg.ClientCAs = clientCA.CertPool()
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// CreatedTLSSecret creates a kubernetes.io/tls Secret of the key pair in the
+// scenario namespace, with the certificate of ca as ca.crt, e.g. for
+// --tls-cert-file and --client-ca-file of the proxy, or
+// RunOptions.ClientCertSecret.
+func CreatedTLSSecret(client kubernetes.Interface, name string, kp *KeyPair, ca *CA) Setup {
+	return func(ctx *ScenarioContext) error {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ctx.Namespace},
+			Type:       corev1.SecretTypeTLS,
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       kp.CertPEM,
+				corev1.TLSPrivateKeyKey: kp.KeyPEM,
+			},
+		}
+		if ca != nil {
+			secret.Data["ca.crt"] = ca.CertPEM
+		}
+
+		if _, err := client.CoreV1().Secrets(ctx.Namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create secret %s: %v", name, err)
+		}
+
+		ctx.AddFinalizer(func() error {
+			return client.CoreV1().Secrets(secret.Namespace).Delete(context.TODO(), secret.Name, metav1.DeleteOptions{})
+		})
+
+		return nil
+	}
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
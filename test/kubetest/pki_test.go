@@ -0,0 +1,110 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPKI(t *testing.T) {
+	ca, err := NewCA("kubetest-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA, err := NewCA("other-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := ca.Issue(CertOptions{CommonName: "kube-rbac-proxy", IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = server.ServerTLSConfig(ca)
+	srv.StartTLS()
+	defer srv.Close()
+
+	issue := func(opts CertOptions, from *CA) *KeyPair {
+		opts.Client = true
+		kp, err := from.Issue(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kp
+	}
+
+	for _, tc := range []struct {
+		name   string
+		client *KeyPair
+		ok     bool
+	}{
+		{name: "valid", client: issue(CertOptions{CommonName: "alice"}, ca), ok: true},
+		{name: "expired", client: issue(CertOptions{CommonName: "alice", NotBefore: time.Now().Add(-2 * time.Hour), Validity: time.Hour}, ca)},
+		{name: "other CA", client: issue(CertOptions{CommonName: "alice"}, otherCA)},
+		{name: "no certificate"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: tc.client.ClientTLSConfig(ca)}}
+			resp, err := c.Get(srv.URL)
+			if tc.ok != (err == nil) {
+				t.Fatalf("want request to succeed: %v, got err %v", tc.ok, err)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
+func TestCreatedTLSSecret(t *testing.T) {
+	ca, err := NewCA("kubetest-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kp, err := ca.Issue(CertOptions{CommonName: "alice", Client: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := fake.NewSimpleClientset()
+	ctx := &ScenarioContext{Context: context.Background(), Namespace: "abc"}
+	if err := CreatedTLSSecret(client, "client", kp, ca)(ctx); err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	secret, err := client.CoreV1().Secrets("abc").Get(ctx, "client", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["tls.crt"]) != string(kp.CertPEM) || string(secret.Data["ca.crt"]) != string(ca.CertPEM) {
+		t.Errorf("want secret with certificate and CA, got keys %v", secret.Data)
+	}
+	if len(ctx.Finalizer) != 1 {
+		t.Errorf("want a finalizer deleting the secret, got %d", len(ctx.Finalizer))
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// PortForward forwards a random local port to port of target in the scenario
+// namespace, given as "pod/<name>" or "service/<name>". For services, port is
+// the service port and the connection is forwarded to a ready pod backing it.
+// It returns the local address, e.g. "127.0.0.1:43127", and a Finalizer
+// stopping the forwarding, which is also added to the scenario.
+func PortForward(ctx *ScenarioContext, config *rest.Config, client kubernetes.Interface, target string, port int) (string, Finalizer, error) {
+	pod, podPort, err := portForwardTarget(ctx, client, target, port)
+	if err != nil {
+		return "", nil, err
+	}
+
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return "", nil, err
+	}
+	u := client.CoreV1().RESTClient().Post().Resource("pods").Namespace(ctx.Namespace).Name(pod).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, u)
+
+	stop := make(chan struct{})
+	ready := make(chan struct{})
+	fw, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", podPort)}, stop, ready, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return "", nil, err
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- fw.ForwardPorts()
+	}()
+
+	select {
+	case <-ready:
+	case err := <-errs:
+		return "", nil, fmt.Errorf("failed to forward port %d of pod %s: %v", podPort, pod, err)
+	case <-ctx.Done():
+		close(stop)
+		return "", nil, ctx.Err()
+	}
+
+	ports, err := fw.GetPorts()
+	if err != nil {
+		close(stop)
+		return "", nil, err
+	}
+
+	var once sync.Once
+	finalizer := func() error {
+		once.Do(func() { close(stop) })
+		return nil
+	}
+	ctx.AddFinalizer(finalizer)
+
+	return fmt.Sprintf("127.0.0.1:%d", ports[0].Local), finalizer, nil
+}
+
+// PortForwardURL is PortForward returning the URL of the local address with
+// the given scheme, e.g. "https://127.0.0.1:43127".
+func PortForwardURL(ctx *ScenarioContext, config *rest.Config, client kubernetes.Interface, scheme, target string, port int) (*url.URL, Finalizer, error) {
+	addr, finalizer, err := PortForward(ctx, config, client, target, port)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &url.URL{Scheme: scheme, Host: addr}, finalizer, nil
+}
+
+// portForwardTarget returns the pod and container port to forward to.
+func portForwardTarget(ctx *ScenarioContext, client kubernetes.Interface, target string, port int) (string, int, error) {
+	kind, name := "pod", target
+	if i := strings.Index(target, "/"); i >= 0 {
+		kind, name = target[:i], target[i+1:]
+	}
+
+	switch kind {
+	case "pod", "pods", "po":
+		return name, port, nil
+	case "service", "services", "svc":
+	default:
+		return "", 0, fmt.Errorf("unable to port-forward to %s, must be a pod or service", target)
+	}
+
+	svc, err := client.CoreV1().Services(ctx.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get service: %v", err)
+	}
+
+	var servicePort *corev1.ServicePort
+	for i := range svc.Spec.Ports {
+		if int(svc.Spec.Ports[i].Port) == port {
+			servicePort = &svc.Spec.Ports[i]
+		}
+	}
+	if servicePort == nil {
+		return "", 0, fmt.Errorf("service %s has no port %d", name, port)
+	}
+
+	pods, err := client.CoreV1().Pods(ctx.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String(),
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to list pods: %v", err)
+	}
+
+	for _, p := range pods.Items {
+		if ready, _ := podRunningAndReady(p); !ready {
+			continue
+		}
+		podPort, ok := containerPort(p, *servicePort)
+		if ok {
+			return p.Name, podPort, nil
+		}
+	}
+	return "", 0, fmt.Errorf("no ready pod of service %s", name)
+}
+
+// containerPort returns the container port of the pod the service port
+// targets.
+func containerPort(pod corev1.Pod, sp corev1.ServicePort) (int, bool) {
+	if sp.TargetPort.StrVal == "" {
+		if sp.TargetPort.IntVal == 0 {
+			return int(sp.Port), true
+		}
+		return int(sp.TargetPort.IntVal), true
+	}
+
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == sp.TargetPort.StrVal {
+				return int(p.ContainerPort), true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPortForwardTarget(t *testing.T) {
+	pod := func(name string, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "abc", Labels: map[string]string{"app": "kube-rbac-proxy"}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  "kube-rbac-proxy",
+				Ports: []corev1.ContainerPort{{Name: "https", ContainerPort: 9443}},
+			}}},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+			},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		pod("not-ready", corev1.ConditionFalse),
+		pod("ready", corev1.ConditionTrue),
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-rbac-proxy", Namespace: "abc"},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "kube-rbac-proxy"},
+				Ports:    []corev1.ServicePort{{Port: 8443, TargetPort: intstr.FromString("https")}},
+			},
+		},
+	)
+	ctx := &ScenarioContext{Context: context.Background(), Namespace: "abc"}
+
+	for _, tc := range []struct {
+		target  string
+		port    int
+		pod     string
+		podPort int
+		err     bool
+	}{
+		{target: "pod/foo", port: 8080, pod: "foo", podPort: 8080},
+		{target: "foo", port: 8080, pod: "foo", podPort: 8080},
+		{target: "svc/kube-rbac-proxy", port: 8443, pod: "ready", podPort: 9443},
+		{target: "service/kube-rbac-proxy", port: 80, err: true},
+		{target: "deployment/kube-rbac-proxy", port: 8443, err: true},
+	} {
+		pod, podPort, err := portForwardTarget(ctx, client, tc.target, tc.port)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", tc.target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: want err to be nil, but got %v", tc.target, err)
+			continue
+		}
+		if pod != tc.pod || podPort != tc.podPort {
+			t.Errorf("%s: want %s:%d, got %s:%d", tc.target, tc.pod, tc.podPort, pod, podPort)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScenarioResult is the outcome of a scenario.
+type ScenarioResult struct {
+	// Name is the full name of the scenario's test, e.g. "Test/Basics/NoRBAC".
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Duration    time.Duration `json:"-"`
+	Failed      bool          `json:"failed"`
+	// Failures are the errors of the steps which failed.
+	Failures []string `json:"failures,omitempty"`
+}
+
+// MarshalJSON encodes the duration in seconds.
+func (r ScenarioResult) MarshalJSON() ([]byte, error) {
+	type result ScenarioResult
+	return json.Marshal(struct {
+		result
+		DurationSeconds float64 `json:"durationSeconds"`
+	}{result(r), r.Duration.Seconds()})
+}
+
+// Report collects the results of scenarios run with Recorded, e.g. to show
+// them per scenario in CI.
+type Report struct {
+	mu      sync.Mutex
+	results []ScenarioResult
+}
+
+// Recorded adds the result of the scenario to the report.
+func Recorded(r *Report) RunOpts {
+	return func(ctx *ScenarioContext) *ScenarioContext {
+		ctx.report = r
+		return ctx
+	}
+}
+
+func (r *Report) add(result ScenarioResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+}
+
+// Results returns the results ordered by name.
+func (r *Report) Results() []ScenarioResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := append([]ScenarioResult(nil), r.results...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
+
+// WriteJSON writes the results as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	results := r.Results()
+	failed := 0
+	for _, result := range results {
+		if result.Failed {
+			failed++
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Scenarios []ScenarioResult `json:"scenarios"`
+		Failed    int              `json:"failed"`
+	}{results, failed})
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Details string `xml:",chardata"`
+}
+
+// WriteJUnit writes the results as JUnit XML test suite named name. The
+// scenarios are classified by their parent tests, their descriptions are
+// written as system-out.
+func (r *Report) WriteJUnit(w io.Writer, name string) error {
+	suite := junitTestSuite{Name: name}
+	var total time.Duration
+	for _, result := range r.Results() {
+		classname, caseName := "", result.Name
+		if i := strings.LastIndex(result.Name, "/"); i >= 0 {
+			classname, caseName = result.Name[:i], result.Name[i+1:]
+		}
+
+		tc := junitTestCase{
+			Name:      caseName,
+			Classname: classname,
+			Time:      seconds(result.Duration),
+			SystemOut: result.Description,
+		}
+		if result.Failed {
+			suite.Failures++
+			message := "scenario failed"
+			if len(result.Failures) > 0 {
+				message = result.Failures[0]
+			}
+			tc.Failure = &junitFailure{Message: message, Details: strings.Join(result.Failures, "\n")}
+		}
+
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+		total += result.Duration
+	}
+	suite.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
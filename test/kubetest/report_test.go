@@ -0,0 +1,80 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	r := &Report{}
+	Scenario{
+		Name: "Passing",
+		Description: `
+			As a client,
+			I succeed
+		`,
+		Then: func(ctx *ScenarioContext) error { return nil },
+	}.Run(t, Recorded(r))
+	r.add(ScenarioResult{Name: "TestReport/Failing", Duration: 1500 * time.Millisecond, Failed: true, Failures: []string{"checks failed: expected run to fail"}})
+
+	results := r.Results()
+	if len(results) != 2 || results[1].Name != "TestReport/Passing" || results[1].Failed || results[1].Description != "As a client, I succeed" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	var js bytes.Buffer
+	if err := r.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Scenarios []struct {
+			Name            string
+			DurationSeconds float64
+			Failures        []string
+		}
+		Failed int
+	}
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Failed != 1 || decoded.Scenarios[0].DurationSeconds != 1.5 || len(decoded.Scenarios[0].Failures) != 1 {
+		t.Errorf("unexpected JSON report %s", js.String())
+	}
+
+	var junit bytes.Buffer
+	if err := r.WriteJUnit(&junit, "e2e"); err != nil {
+		t.Fatal(err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(junit.Bytes(), &suites); err != nil {
+		t.Fatal(err)
+	}
+	suite := suites.Suites[0]
+	if suite.Tests != 2 || suite.Failures != 1 {
+		t.Errorf("want 2 tests and 1 failure, got %d and %d", suite.Tests, suite.Failures)
+	}
+	failing := suite.Cases[0]
+	if failing.Classname != "TestReport" || failing.Name != "Failing" || failing.Time != "1.500" || failing.Failure == nil || !strings.Contains(failing.Failure.Message, "expected run to fail") {
+		t.Errorf("unexpected test case %+v", failing)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// tokenExpirationSeconds is the minimum expiration accepted by the
+// TokenRequest API, scenarios are expected to finish well within it.
+const tokenExpirationSeconds = int64(600)
+
+// TokenSource returns a bearer token to be sent by a client.
+type TokenSource func(*ScenarioContext) (string, error)
+
+// ServiceAccountToken requests a short-lived token for the ServiceAccount
+// using the TokenRequest API. The token is bound to the given audiences, or
+// to the API server's if none are given.
+func ServiceAccountToken(ctx context.Context, client kubernetes.Interface, namespace, serviceAccount string, audiences ...string) (string, error) {
+	expiration := tokenExpirationSeconds
+	tr, err := client.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, serviceAccount, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         audiences,
+			ExpirationSeconds: &expiration,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to request token for service account %s/%s: %v", namespace, serviceAccount, err)
+	}
+	if tr.Status.Token == "" {
+		return "", fmt.Errorf("empty token returned for service account %s/%s", namespace, serviceAccount)
+	}
+	return tr.Status.Token, nil
+}
+
+// RequestedToken is a TokenSource requesting a token for the ServiceAccount
+// in the scenario's namespace, see ServiceAccountToken.
+func RequestedToken(client kubernetes.Interface, serviceAccount string, audiences ...string) TokenSource {
+	return func(ctx *ScenarioContext) (string, error) {
+		return ServiceAccountToken(ctx, client, ctx.Namespace, serviceAccount, audiences...)
+	}
+}
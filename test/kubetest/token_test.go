@@ -0,0 +1,59 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubetest
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestRequestedToken(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "serviceaccounts", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		create := action.(clienttesting.CreateAction)
+		if create.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		tr := create.GetObject().(*authenticationv1.TokenRequest).DeepCopy()
+		if create.GetNamespace() != "abc" || !reflect.DeepEqual(tr.Spec.Audiences, []string{"kube-rbac-proxy"}) || *tr.Spec.ExpirationSeconds != tokenExpirationSeconds {
+			t.Errorf("unexpected token request for %s: %+v", create.GetNamespace(), tr.Spec)
+		}
+		tr.Status.Token = "s3cr3t"
+		return true, tr, nil
+	})
+
+	ctx := &ScenarioContext{Context: context.Background(), Namespace: "abc"}
+	token, err := RequestedToken(client, "default", "kube-rbac-proxy")(ctx)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if token != "s3cr3t" {
+		t.Errorf("want token s3cr3t, got %q", token)
+	}
+
+	script := requestScript(HTTPRequest{URL: "https://kube-rbac-proxy:8443/metrics", BearerToken: true}, token, HTTPResponse{}, nil)
+	if !strings.Contains(script, `-H 'Authorization: Bearer s3cr3t'`) || strings.Contains(script, serviceAccountTokenPath) {
+		t.Errorf("want script to send the requested token, got:\n%s", script)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package load drives concurrent clients through a local kube-rbac-proxy
+// backed by a fake kube-apiserver, to catch performance regressions of the
+// request path before a release.
+package load
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/authn"
+	"github.com/brancz/kube-rbac-proxy/pkg/authz"
+	"github.com/brancz/kube-rbac-proxy/pkg/proxy"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	clients          = flag.Int("load.clients", 16, "Number of concurrent clients.")
+	requests         = flag.Int("load.requests", 100, "Number of requests sent by each client.")
+	tokens           = flag.Int("load.tokens", 16, "Number of distinct bearer tokens the clients use.")
+	rewrites         = flag.Int("load.rewrites", 1, "Number of namespaces each request is authorized for with query parameter rewrites.")
+	apiserverLatency = flag.Duration("load.apiserver-latency", 5*time.Millisecond, "Latency of the fake kube-apiserver's TokenReviews and SubjectAccessReviews.")
+	maxP99           = flag.Duration("load.max-p99", 0, "If set, fail if the 99th percentile of the request latencies exceeds it.")
+)
+
+// fakeAPIServer authenticates every token and allows every
+// SubjectAccessReview after latency, and counts the reviews.
+type fakeAPIServer struct {
+	latency              time.Duration
+	tokenReviews         int64
+	subjectAccessReviews int64
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	time.Sleep(s.latency)
+
+	var resp interface{}
+	switch req.URL.Path {
+	case "/apis/authentication.k8s.io/v1/tokenreviews":
+		atomic.AddInt64(&s.tokenReviews, 1)
+		var tr authenticationv1.TokenReview
+		if err := json.NewDecoder(req.Body).Decode(&tr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tr.Status = authenticationv1.TokenReviewStatus{
+			Authenticated: true,
+			User:          authenticationv1.UserInfo{Username: "system:serviceaccount:load:" + tr.Spec.Token},
+			Audiences:     tr.Spec.Audiences,
+		}
+		resp = tr
+	case "/apis/authorization.k8s.io/v1/subjectaccessreviews":
+		atomic.AddInt64(&s.subjectAccessReviews, 1)
+		var sar authorizationv1.SubjectAccessReview
+		if err := json.NewDecoder(req.Body).Decode(&sar); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sar.Status = authorizationv1.SubjectAccessReviewStatus{Allowed: true}
+		resp = sar
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping load test in short mode")
+	}
+
+	apiserver := &fakeAPIServer{latency: *apiserverLatency}
+	apiserverSrv := httptest.NewServer(apiserver)
+	defer apiserverSrv.Close()
+
+	client, err := kubernetes.NewForConfig(&rest.Config{
+		Host: apiserverSrv.URL,
+		// Client-side throttling would measure the rate limiter instead of
+		// the proxy.
+		QPS:   -1,
+		Burst: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := proxy.NewHandler(proxy.HandlerConfig{
+		Config: proxy.Config{
+			Authentication: &authn.AuthnConfig{
+				Token: &authn.TokenConfig{Audiences: []string{"kube-rbac-proxy"}},
+			},
+			Authorization: &authz.Config{
+				Rewrites:           &authz.SubjectAccessReviewRewrites{ByQueryParameter: &authz.QueryParameterRewriteConfig{Name: "namespace"}},
+				ResourceAttributes: &authz.ResourceAttributes{Namespace: "{{ .Value }}", Resource: "services", Subresource: "metrics"},
+			},
+		},
+		Client: client,
+	}, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxySrv := httptest.NewServer(handler)
+	defer proxySrv.Close()
+
+	url := proxySrv.URL + "/metrics?"
+	for i := 0; i < *rewrites; i++ {
+		url += fmt.Sprintf("namespace=ns-%d&", i)
+	}
+
+	httpClient := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: *clients}}
+	latencies := make([][]time.Duration, *clients)
+	var (
+		wg     sync.WaitGroup
+		failed int64
+	)
+	start := time.Now()
+	for c := 0; c < *clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; i < *requests; i++ {
+				token := fmt.Sprintf("token-%d", (c*(*requests)+i)%(*tokens))
+				req, err := http.NewRequest(http.MethodGet, url, nil)
+				if err != nil {
+					panic(err)
+				}
+				req.Header.Set("Authorization", "Bearer "+token)
+
+				reqStart := time.Now()
+				resp, err := httpClient.Do(req)
+				if err == nil {
+					_, err = ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+				}
+				latencies[c] = append(latencies[c], time.Since(reqStart))
+				if err != nil || resp.StatusCode != http.StatusOK {
+					if atomic.AddInt64(&failed, 1) == 1 {
+						t.Errorf("request failed: %v", describe(resp, err))
+					}
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	p99 := percentile(all, 0.99)
+
+	t.Logf("%d requests by %d clients in %v (%.0f requests/s), %d failed", len(all), *clients, elapsed, float64(len(all))/elapsed.Seconds(), failed)
+	t.Logf("latency p50=%v p90=%v p99=%v max=%v", percentile(all, 0.5), percentile(all, 0.9), p99, all[len(all)-1])
+	t.Logf("fake kube-apiserver received %d TokenReviews and %d SubjectAccessReviews", atomic.LoadInt64(&apiserver.tokenReviews), atomic.LoadInt64(&apiserver.subjectAccessReviews))
+
+	if *maxP99 > 0 && p99 > *maxP99 {
+		t.Errorf("want p99 latency of at most %v, got %v", *maxP99, p99)
+	}
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func describe(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
@@ -17,19 +17,76 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
 	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/sync/singleflight"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/filters"
+	"github.com/brancz/kube-rbac-proxy/pkg/metrics"
+	"github.com/brancz/kube-rbac-proxy/pkg/requestinfo"
+	"github.com/brancz/kube-rbac-proxy/pkg/sockopt"
+)
+
+const (
+	// upstreamProtocolAuto negotiates HTTP/2 via ALPN for TLS upstreams and
+	// probes cleartext upstreams for h2c support.
+	upstreamProtocolAuto = "auto"
+	// upstreamProtocolALPN negotiates HTTP/2 via ALPN for TLS upstreams and
+	// speaks HTTP/1.1 to cleartext upstreams.
+	upstreamProtocolALPN = "alpn"
+	// upstreamProtocolHTTP1 always speaks HTTP/1.1 to the upstream.
+	upstreamProtocolHTTP1 = "http1"
+	// upstreamProtocolHTTP2 always speaks HTTP/2 to the upstream, using h2c
+	// with prior knowledge for cleartext upstreams.
+	upstreamProtocolHTTP2 = "http2"
+
+	h2cProbeTimeout = 5 * time.Second
+	// Failed h2c probes are retried with an exponential backoff between
+	// these durations, so a down upstream isn't probed by every request.
+	h2cProbeMinBackoff = time.Second
+	h2cProbeMaxBackoff = time.Minute
+
+	// Proxy-Authorization and Proxy-Authenticate are hop-by-hop headers, which
+	// httputil.ReverseProxy strips. When auth challenges are passed through,
+	// they are carried past the reverse proxy under these names.
+	passthroughProxyAuthorization = "X-Kube-Rbac-Proxy-Passthrough-Proxy-Authorization"
+	passthroughProxyAuthenticate  = "X-Kube-Rbac-Proxy-Passthrough-Proxy-Authenticate"
 )
 
-func initTransport(upstreamCAFile string) (http.RoundTripper, error) {
+func initTransport(upstreamCAFile string, sockopts sockopt.Config, proxy func(*http.Request) (*url.URL, error)) (http.RoundTripper, error) {
+	// http.Transport sourced from go 1.10.7
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: metrics.TrackUpstreamDial(sockopt.DialContext(&net.Dialer{
+			Timeout:   30 * time.Second,
+			DualStack: true,
+		}, sockopts)),
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+
 	if upstreamCAFile == "" {
-		return http.DefaultTransport, nil
+		return transport, nil
 	}
 
 	rootPEM, err := ioutil.ReadFile(upstreamCAFile)
@@ -42,20 +99,679 @@ func initTransport(upstreamCAFile string) (http.RoundTripper, error) {
 		return nil, errors.New("error parsing upstream CA certificate")
 	}
 
-	// http.Transport sourced from go 1.10.7
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig:       &tls.Config{RootCAs: roots},
-	}
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots}
 
 	return transport, nil
 }
+
+// proxyFunc returns a function selecting the HTTP proxy for a request.
+// If proxyURL is empty, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are honored.
+func proxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing proxy URL: %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q must contain a scheme and host", proxyURL)
+	}
+
+	return http.ProxyURL(u), nil
+}
+
+// kubeAPIHTTP2Config configures the HTTP/2 connection to the kube-apiserver.
+type kubeAPIHTTP2Config struct {
+	enabled         bool
+	readIdleTimeout time.Duration
+	pingTimeout     time.Duration
+}
+
+// configureKubeAPITransport makes the clients created from kcfg share a
+// single HTTP/2 connection to the kube-apiserver, health checked with pings,
+// instead of the transport of client-go, whose connections are only noticed
+// to be broken once requests on them time out.
+//
+// client-go's transport is kept for connections through HTTP proxies, and
+// for client certificates which are rotated, i.e. read from files or
+// provided by exec plugins.
+func configureKubeAPITransport(kcfg *rest.Config, c kubeAPIHTTP2Config) error {
+	if !c.enabled {
+		return nil
+	}
+
+	u, err := url.Parse(kcfg.Host)
+	if err != nil {
+		return fmt.Errorf("error parsing kube-apiserver URL: %v", err)
+	}
+	if u.Scheme != "https" {
+		klog.V(2).Infof("Not using HTTP/2 for the kube-apiserver %s: not served over TLS", kcfg.Host)
+		return nil
+	}
+	if kcfg.ExecProvider != nil || (kcfg.CertFile != "" && kcfg.KeyFile != "") {
+		klog.V(2).Infof("Not using HTTP/2 for the kube-apiserver %s: client certificate is rotated", kcfg.Host)
+		return nil
+	}
+	if kcfg.Proxy != nil {
+		proxyURL, err := kcfg.Proxy(&http.Request{URL: u})
+		if err != nil {
+			return fmt.Errorf("error determining proxy of the kube-apiserver: %v", err)
+		}
+		if proxyURL != nil {
+			klog.V(2).Infof("Not using HTTP/2 for the kube-apiserver %s: connected to through proxy %s", kcfg.Host, proxyURL.Host)
+			return nil
+		}
+	}
+
+	tlsConfig, err := rest.TLSConfigFor(kcfg)
+	if err != nil {
+		return err
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+
+	kcfg.Transport = &http2.Transport{
+		TLSClientConfig: tlsConfig,
+		DialTLS:         dialH2TLS,
+		ReadIdleTimeout: c.readIdleTimeout,
+		PingTimeout:     c.pingTimeout,
+	}
+	// The TLS settings are part of the transport now, client-go refuses
+	// them with a custom transport.
+	kcfg.TLSClientConfig = rest.TLSClientConfig{}
+	return nil
+}
+
+// dialH2TLS dials a TLS connection which negotiated HTTP/2.
+func dialH2TLS(network, addr string, cfg *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, network, addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if p := conn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+		conn.Close()
+		return nil, fmt.Errorf("%s doesn't support HTTP/2, negotiated protocol %q", addr, p)
+	}
+	return conn, nil
+}
+
+// initUpstreamTransport wraps the given transport so that it speaks the
+// requested protocol to the upstream.
+func initUpstreamTransport(base http.RoundTripper, protocol string) (http.RoundTripper, error) {
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unsupported upstream transport %T", base)
+	}
+
+	switch protocol {
+	case upstreamProtocolALPN:
+		// initTransport attempts HTTP/2 with TLS upstreams.
+		return t, nil
+	case upstreamProtocolHTTP1:
+		t = t.Clone()
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		// Cloning sets up HTTP/2 of t first, which offers h2 via ALPN.
+		if t.TLSClientConfig != nil {
+			var protos []string
+			for _, p := range t.TLSClientConfig.NextProtos {
+				if p != http2.NextProtoTLS {
+					protos = append(protos, p)
+				}
+			}
+			t.TLSClientConfig.NextProtos = protos
+		}
+		return t, nil
+	case upstreamProtocolHTTP2:
+		d := newUpstreamDialer(t)
+		return &forcedH2Transport{
+			tls: &http2.Transport{
+				TLSClientConfig: t.TLSClientConfig,
+				DialTLS:         d.DialTLS,
+			},
+			h2c: newH2CTransport(d),
+		}, nil
+	case upstreamProtocolAuto:
+		d := newUpstreamDialer(t)
+		return &negotiatingTransport{
+			h1:         t,
+			h2c:        newH2CTransport(d),
+			dialer:     d,
+			h2cSupport: map[string]h2cSupport{},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown upstream protocol %q, must be one of %q, %q, %q or %q", protocol, upstreamProtocolALPN, upstreamProtocolAuto, upstreamProtocolHTTP1, upstreamProtocolHTTP2)
+	}
+}
+
+// newH2CTransport returns a transport speaking http/2 over cleartext connections
+// with prior knowledge, i.e. without starting with an HTTP1.1 UPGRADE request.
+// See https://github.com/golang/go/issues/14141#issuecomment-219212895 for more context
+func newH2CTransport(d *upstreamDialer) *http2.Transport {
+	return &http2.Transport{
+		// Allow http schema. This doesn't automatically disable TLS
+		AllowHTTP: true,
+		// Do disable TLS.
+		// In combination with the schema check above. We could enforce h2c against the upstream server
+		DialTLS: func(netw, addr string, cfg *tls.Config) (net.Conn, error) {
+			return d.DialContext(context.Background(), "http", addr)
+		},
+	}
+}
+
+// upstreamDialer dials upstreams for the http2 transports, which dial
+// themselves, like the http.Transport of initTransport does, i.e. with its
+// socket options, tracked in the upstream connection metrics, and tunneled
+// through the HTTP proxy selected for the upstream, if any.
+type upstreamDialer struct {
+	dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+	proxy   func(*http.Request) (*url.URL, error)
+	timeout time.Duration
+}
+
+func newUpstreamDialer(t *http.Transport) *upstreamDialer {
+	d := &upstreamDialer{
+		dial:    t.DialContext,
+		proxy:   t.Proxy,
+		timeout: t.TLSHandshakeTimeout,
+	}
+	if d.dial == nil {
+		d.dial = metrics.TrackUpstreamDial((&net.Dialer{Timeout: 30 * time.Second}).DialContext)
+	}
+	if d.timeout == 0 {
+		d.timeout = 10 * time.Second
+	}
+	return d
+}
+
+// DialContext dials addr of an upstream served with the given URL scheme.
+func (d *upstreamDialer) DialContext(ctx context.Context, scheme, addr string) (net.Conn, error) {
+	var proxyURL *url.URL
+	if d.proxy != nil {
+		var err error
+		proxyURL, err = d.proxy(&http.Request{URL: &url.URL{Scheme: scheme, Host: addr}})
+		if err != nil {
+			return nil, fmt.Errorf("error determining proxy of upstream %s: %v", addr, err)
+		}
+	}
+	if proxyURL == nil {
+		return d.dial(ctx, "tcp", addr)
+	}
+	return d.dialThroughProxy(ctx, proxyURL, addr)
+}
+
+// DialTLS dials a TLS connection to addr of an upstream served over https.
+// The handshake must complete within the TLS handshake timeout of the
+// transport.
+func (d *upstreamDialer) DialTLS(network, addr string, cfg *tls.Config) (net.Conn, error) {
+	conn, err := d.DialContext(context.Background(), "https", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(d.timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// dialThroughProxy tunnels a connection to addr through the HTTP proxy with
+// a CONNECT request.
+func (d *upstreamDialer) dialThroughProxy(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+
+	conn, err := d.dial(ctx, "tcp", canonicalAddr(&http.Request{URL: proxyURL}))
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(d.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with proxy %s failed: %v", proxyURL.Host, err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT request to proxy %s failed: %v", proxyURL.Host, err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT request to proxy %s failed: %v", proxyURL.Host, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+	// The upstream only talks once the client did, so nothing but the
+	// response may have been read.
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s sent unexpected data after CONNECT response", proxyURL.Host)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// forcedH2Transport speaks http/2 to TLS and cleartext upstreams alike.
+type forcedH2Transport struct {
+	tls, h2c *http2.Transport
+}
+
+func (t *forcedH2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "https" {
+		return t.tls.RoundTrip(req)
+	}
+	return t.h2c.RoundTrip(req)
+}
+
+func (t *forcedH2Transport) CloseIdleConnections() {
+	t.tls.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+}
+
+// negotiatingTransport picks the protocol per upstream host. TLS upstreams
+// negotiate http/2 via ALPN, cleartext upstreams are probed once for h2c
+// support and the result is remembered. Failed probes are retried after a
+// backoff, requests in the meantime use HTTP/1.1.
+type negotiatingTransport struct {
+	h1     *http.Transport
+	h2c    *http2.Transport
+	dialer *upstreamDialer
+
+	// probes makes concurrent requests to an upstream wait for a single
+	// probe.
+	probes singleflight.Group
+
+	mu         sync.Mutex
+	h2cSupport map[string]h2cSupport
+}
+
+// h2cSupport is the result of probing an upstream for h2c support.
+type h2cSupport struct {
+	ok bool
+	// failures counts the consecutive failed probes, the next one is made
+	// after retryAt.
+	failures int
+	retryAt  time.Time
+}
+
+func (t *negotiatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" && t.h2cSupported(req.Context(), canonicalAddr(req)) {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.h1.RoundTrip(req)
+}
+
+func (t *negotiatingTransport) CloseIdleConnections() {
+	t.h1.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+}
+
+func (t *negotiatingTransport) h2cSupported(ctx context.Context, addr string) bool {
+	t.mu.Lock()
+	s, probed := t.h2cSupport[addr]
+	t.mu.Unlock()
+	if probed && (s.failures == 0 || time.Now().Before(s.retryAt)) {
+		return s.ok
+	}
+
+	ch := t.probes.DoChan(addr, func() (interface{}, error) {
+		return t.probe(addr), nil
+	})
+	select {
+	case r := <-ch:
+		return r.Val.(bool)
+	case <-ctx.Done():
+		// The request is done anyways, the probe goes on for the next ones.
+		return false
+	}
+}
+
+// probe probes the upstream for h2c support and records the result.
+func (t *negotiatingTransport) probe(addr string) bool {
+	ok, err := probeH2C(t.dialer, addr, h2cProbeTimeout)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err != nil {
+		// Don't remember the result for good, the upstream might just not be
+		// up yet.
+		s := t.h2cSupport[addr]
+		s.failures++
+		backoff := h2cProbeMinBackoff
+		for i := 1; i < s.failures && backoff < h2cProbeMaxBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > h2cProbeMaxBackoff {
+			backoff = h2cProbeMaxBackoff
+		}
+		s.retryAt = time.Now().Add(backoff)
+		t.h2cSupport[addr] = s
+
+		klog.V(4).Infof("Failed to probe upstream %s for h2c support, retrying in %v: %v", addr, backoff, err)
+		return false
+	}
+
+	klog.V(2).Infof("Upstream %s h2c support: %t", addr, ok)
+	t.h2cSupport[addr] = h2cSupport{ok: ok}
+	return ok
+}
+
+// probeH2C sends the http/2 client preface to addr and reports whether the
+// server answers with a SETTINGS frame.
+func probeH2C(d *upstreamDialer, addr string, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := d.DialContext(ctx, "http", addr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return false, err
+	}
+
+	if _, err := io.WriteString(conn, http2.ClientPreface); err != nil {
+		return false, err
+	}
+
+	fr := http2.NewFramer(conn, conn)
+	if err := fr.WriteSettings(); err != nil {
+		return false, err
+	}
+
+	f, err := fr.ReadFrame()
+	if err != nil {
+		// Whatever the server answered, it wasn't http/2.
+		return false, nil
+	}
+
+	_, ok := f.(*http2.SettingsFrame)
+	return ok, nil
+}
+
+func canonicalAddr(req *http.Request) string {
+	if _, _, err := net.SplitHostPort(req.URL.Host); err == nil {
+		return req.URL.Host
+	}
+	if req.URL.Scheme == "https" {
+		return net.JoinHostPort(req.URL.Host, "443")
+	}
+	return net.JoinHostPort(req.URL.Host, "80")
+}
+
+// withAuthChallengePassthrough makes the reverse proxy pass 407 challenges of
+// the upstream to the client, and the client's Proxy-Authorization answer back
+// to the upstream. 401 responses and their WWW-Authenticate headers are not
+// hop-by-hop and pass through unchanged already.
+func withAuthChallengePassthrough(p *httputil.ReverseProxy) {
+	director := p.Director
+	p.Director = func(req *http.Request) {
+		director(req)
+		moveHeader(req.Header, "Proxy-Authorization", passthroughProxyAuthorization)
+	}
+
+	p.Transport = &authChallengeTransport{next: p.Transport}
+
+	modifyResponse := p.ModifyResponse
+	p.ModifyResponse = func(resp *http.Response) error {
+		moveHeader(resp.Header, passthroughProxyAuthenticate, "Proxy-Authenticate")
+		if modifyResponse != nil {
+			return modifyResponse(resp)
+		}
+		return nil
+	}
+}
+
+type authChallengeTransport struct {
+	next http.RoundTripper
+}
+
+func (t *authChallengeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Header[passthroughProxyAuthorization]; ok {
+		req = req.Clone(req.Context())
+		moveHeader(req.Header, passthroughProxyAuthorization, "Proxy-Authorization")
+	}
+
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		moveHeader(resp.Header, "Proxy-Authenticate", passthroughProxyAuthenticate)
+	}
+
+	return resp, nil
+}
+
+func moveHeader(h http.Header, from, to string) {
+	values, ok := h[http.CanonicalHeaderKey(from)]
+	if !ok {
+		return
+	}
+	h.Del(from)
+	h[http.CanonicalHeaderKey(to)] = values
+}
+
+// retryTransport retries idempotent requests without a body which failed
+// before a response was received, e.g. because the upstream wasn't reachable.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+	backoff time.Duration
+}
+
+func withRetries(next http.RoundTripper, retries int) http.RoundTripper {
+	if retries <= 0 {
+		return next
+	}
+	return &retryTransport{next: next, retries: retries, backoff: 100 * time.Millisecond}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if !retryable(req) {
+		return resp, err
+	}
+
+	backoff := t.backoff
+	for i := 0; err != nil && i < t.retries; i++ {
+		klog.V(4).Infof("Retrying %s %s after upstream error: %v", req.Method, req.URL.Path, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, err
+		}
+		backoff *= 2
+
+		resp, err = t.next.RoundTrip(req)
+	}
+
+	return resp, err
+}
+
+// timingTransport records the time until the upstream responded with headers
+// in the request info.
+type timingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	requestinfo.AddUpstreamDuration(req.Context(), time.Since(start))
+	return resp, err
+}
+
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
+
+// withErrorResponse answers requests the upstream failed to respond to with
+// 502 Bad Gateway, including the request ID like other errors of the proxy.
+func withErrorResponse(p *httputil.ReverseProxy) {
+	p.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		id := ""
+		if info := requestinfo.From(req.Context()); info != nil {
+			id = info.ID
+		}
+		klog.Errorf("Proxying %s %s (request ID: %s) failed: %v", req.Method, req.URL.Path, id, err)
+		filters.Error(w, req, "Bad Gateway", http.StatusBadGateway)
+	}
+}
+
+// withResponseSizeLimit terminates responses of the upstream larger than
+// maxBytes. Responses known to be too large are answered with 502 Bad Gateway,
+// responses without a known content length are cut off once they exceed the limit.
+func withResponseSizeLimit(p *httputil.ReverseProxy, route string, maxBytes int64) {
+	if maxBytes <= 0 {
+		return
+	}
+
+	exceeded := metrics.ResponseSizeLimitExceeded.WithLabelValues(route)
+	modifyResponse := p.ModifyResponse
+	p.ModifyResponse = func(resp *http.Response) error {
+		if resp.ContentLength > maxBytes {
+			exceeded.Inc()
+			return fmt.Errorf("response size %d exceeds limit of %d bytes", resp.ContentLength, maxBytes)
+		}
+
+		resp.Body = &limitedBody{
+			ReadCloser: resp.Body,
+			remaining:  maxBytes,
+			onExceeded: func() {
+				exceeded.Inc()
+				klog.Errorf("Terminating response of %s %s: response exceeds limit of %d bytes", resp.Request.Method, resp.Request.URL.Path, maxBytes)
+			},
+		}
+
+		if modifyResponse != nil {
+			return modifyResponse(resp)
+		}
+		return nil
+	}
+}
+
+// bufferPool is an httputil.BufferPool of buffers of a fixed size. Reusing
+// the buffers responses of the upstream are copied with avoids allocating
+// one per request, which adds up for large /metrics responses scraped at a
+// high frequency.
+type bufferPool struct {
+	// buffers holds *[]byte, as putting a slice into it would allocate.
+	// Get keeps the emptied pointers in holders for Put to reuse.
+	buffers sync.Pool
+	holders sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{}
+	p.buffers.New = func() interface{} {
+		b := make([]byte, size)
+		return &b
+	}
+	return p
+}
+
+func (p *bufferPool) Get() []byte {
+	h := p.buffers.Get().(*[]byte)
+	b := *h
+	*h = nil
+	p.holders.Put(h)
+	return b
+}
+
+func (p *bufferPool) Put(b []byte) {
+	h, _ := p.holders.Get().(*[]byte)
+	if h == nil {
+		h = new([]byte)
+	}
+	*h = b
+	p.buffers.Put(h)
+}
+
+var errResponseTooLarge = errors.New("response exceeds size limit")
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining  int64
+	onExceeded func()
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+
+	// Read one byte more than allowed to detect exceeding the limit.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		b.onExceeded()
+		return n + int(b.remaining), errResponseTooLarge
+	}
+	return n, err
+}
@@ -5,7 +5,7 @@ Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
 You may obtain a copy of the License at
 
-    http://www.apache.org/licenses/LICENSE-2.0
+	http://www.apache.org/licenses/LICENSE-2.0
 
 Unless required by applicable law or agreed to in writing, software
 distributed under the License is distributed on an "AS IS" BASIS,
@@ -16,12 +16,37 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/sockopt"
 )
 
 func TestInitTransportWithDefault(t *testing.T) {
-	roundTripper, err := initTransport("")
+	roundTripper, err := initTransport("", sockopt.Config{}, http.ProxyFromEnvironment)
 	if err != nil {
 		t.Errorf("want err to be nil, but got %v", err)
 		return
@@ -32,7 +57,7 @@ func TestInitTransportWithDefault(t *testing.T) {
 }
 
 func TestInitTransportWithCustomCA(t *testing.T) {
-	roundTripper, err := initTransport("test/ca.pem")
+	roundTripper, err := initTransport("test/ca.pem", sockopt.Config{}, http.ProxyFromEnvironment)
 	if err != nil {
 		t.Errorf("want err to be nil, but got %v", err)
 		return
@@ -42,3 +67,663 @@ func TestInitTransportWithCustomCA(t *testing.T) {
 		t.Error("expected root CA to be set, got nil")
 	}
 }
+
+func TestInitUpstreamTransportNegotiatesH2C(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Proto))
+	})
+
+	cases := []struct {
+		name     string
+		handler  http.Handler
+		protocol string
+		want     string
+	}{
+		{
+			name:     "auto with http1 upstream",
+			handler:  handler,
+			protocol: upstreamProtocolAuto,
+			want:     "HTTP/1.1",
+		},
+		{
+			name:     "auto with h2c upstream",
+			handler:  h2c.NewHandler(handler, &http2.Server{}),
+			protocol: upstreamProtocolAuto,
+			want:     "HTTP/2.0",
+		},
+		{
+			name:     "alpn with h2c upstream",
+			handler:  h2c.NewHandler(handler, &http2.Server{}),
+			protocol: upstreamProtocolALPN,
+			want:     "HTTP/1.1",
+		},
+		{
+			name:     "http1 with h2c upstream",
+			handler:  h2c.NewHandler(handler, &http2.Server{}),
+			protocol: upstreamProtocolHTTP1,
+			want:     "HTTP/1.1",
+		},
+		{
+			name:     "http2 with h2c upstream",
+			handler:  h2c.NewHandler(handler, &http2.Server{}),
+			protocol: upstreamProtocolHTTP2,
+			want:     "HTTP/2.0",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			upstream := httptest.NewServer(c.handler)
+			defer upstream.Close()
+
+			base, err := initTransport("", sockopt.Config{}, http.ProxyFromEnvironment)
+			if err != nil {
+				t.Fatalf("want err to be nil, but got %v", err)
+			}
+			transport, err := initUpstreamTransport(base, c.protocol)
+			if err != nil {
+				t.Fatalf("want err to be nil, but got %v", err)
+			}
+
+			for i := 0; i < 2; i++ {
+				resp, err := (&http.Client{Transport: transport}).Get(upstream.URL)
+				if err != nil {
+					t.Fatalf("want err to be nil, but got %v", err)
+				}
+				body, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatalf("want err to be nil, but got %v", err)
+				}
+
+				if string(body) != c.want {
+					t.Errorf("want upstream to be called with %s, got %s", c.want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestInitUpstreamTransportWithUnknownProtocol(t *testing.T) {
+	if _, err := initUpstreamTransport(http.DefaultTransport, "spdy"); err == nil {
+		t.Error("expected error for unknown protocol, got nil")
+	}
+}
+
+func TestUpstreamTransportCloseIdleConnections(t *testing.T) {
+	for _, protocol := range []string{
+		// The default of --upstream-protocol.
+		upstreamProtocolALPN,
+		upstreamProtocolHTTP1,
+		upstreamProtocolAuto,
+		upstreamProtocolHTTP2,
+	} {
+		t.Run(protocol, func(t *testing.T) {
+			upstream := httptest.NewUnstartedServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write([]byte(req.Proto))
+			}), &http2.Server{}))
+			closed := make(chan struct{})
+			upstream.Listener = &closeNotifyingListener{Listener: upstream.Listener, closed: closed}
+			upstream.Start()
+			defer upstream.Close()
+
+			base, err := initTransport("", sockopt.Config{}, http.ProxyFromEnvironment)
+			if err != nil {
+				t.Fatalf("want err to be nil, but got %v", err)
+			}
+			transport, err := initUpstreamTransport(base, protocol)
+			if err != nil {
+				t.Fatalf("want err to be nil, but got %v", err)
+			}
+			c, ok := transport.(idleConnectionsCloser)
+			if !ok {
+				t.Fatalf("want %T to close idle connections", transport)
+			}
+
+			resp, err := (&http.Client{Transport: transport}).Get(upstream.URL)
+			if err != nil {
+				t.Fatalf("want err to be nil, but got %v", err)
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			c.CloseIdleConnections()
+			select {
+			case <-closed:
+			case <-time.After(5 * time.Second):
+				t.Error("want idle upstream connection to be closed, but it wasn't")
+			}
+		})
+	}
+}
+
+// closeNotifyingListener closes the closed channel once a connection it
+// accepted, other than h2c probes, is closed by the peer.
+type closeNotifyingListener struct {
+	net.Listener
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *closeNotifyingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &closeNotifyingConn{Conn: conn, l: l}, nil
+}
+
+type closeNotifyingConn struct {
+	net.Conn
+	l      *closeNotifyingListener
+	served bool
+}
+
+func (c *closeNotifyingConn) Write(p []byte) (int, error) {
+	// Probes are answered with a SETTINGS frame only, requests with a
+	// response containing their protocol.
+	if strings.Contains(string(p), "HTTP/") {
+		c.served = true
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *closeNotifyingConn) Close() error {
+	if c.served {
+		c.l.once.Do(func() { close(c.l.closed) })
+	}
+	return c.Conn.Close()
+}
+
+func TestInitUpstreamTransportThroughProxy(t *testing.T) {
+	upstream := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Proto))
+	}), &http2.Server{}))
+	defer upstream.Close()
+
+	var connects int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		atomic.AddInt32(&connects, 1)
+
+		upstreamConn, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		clientConn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstreamConn.Close()
+			return
+		}
+		clientConn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			io.Copy(upstreamConn, clientConn)
+			upstreamConn.Close()
+		}()
+		io.Copy(clientConn, upstreamConn)
+		clientConn.Close()
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := initTransport("", sockopt.Config{}, http.ProxyURL(proxyURL))
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	transport, err := initUpstreamTransport(base, upstreamProtocolAuto)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("want upstream to be called with HTTP/2.0, got %s", body)
+	}
+	// One tunnel for the probe, one for the h2c connection.
+	if got := atomic.LoadInt32(&connects); got != 2 {
+		t.Errorf("want 2 CONNECT requests, got %d", got)
+	}
+}
+
+func TestNegotiatingTransportProbeBackoff(t *testing.T) {
+	var dials int32
+	transport := &negotiatingTransport{
+		dialer: &upstreamDialer{dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return nil, errors.New("connection refused")
+		}},
+		h2cSupport: map[string]h2cSupport{},
+	}
+
+	for i := 0; i < 3; i++ {
+		if transport.h2cSupported(context.Background(), "upstream:8080") {
+			t.Fatal("want h2c to be unsupported")
+		}
+	}
+	if got := atomic.LoadInt32(&dials); got != 1 {
+		t.Fatalf("want failed probe to be remembered, got %d probes", got)
+	}
+
+	// Pretend the backoff passed.
+	transport.h2cSupport["upstream:8080"] = h2cSupport{failures: 1, retryAt: time.Now()}
+	transport.h2cSupported(context.Background(), "upstream:8080")
+	if got := atomic.LoadInt32(&dials); got != 2 {
+		t.Fatalf("want probe to be retried after the backoff, got %d probes", got)
+	}
+	s := transport.h2cSupport["upstream:8080"]
+	if backoff := time.Until(s.retryAt); s.failures != 2 || backoff <= h2cProbeMinBackoff || backoff > 2*h2cProbeMinBackoff {
+		t.Errorf("want backoff to double after 2 failures, got %d failures and backoff %v", s.failures, backoff)
+	}
+}
+
+func TestNegotiatingTransportSingleProbe(t *testing.T) {
+	var dials int32
+	release := make(chan struct{})
+	transport := &negotiatingTransport{
+		dialer: &upstreamDialer{dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			if addr == "upstream:8080" {
+				<-release
+			}
+			return nil, errors.New("connection refused")
+		}},
+		h2cSupport: map[string]h2cSupport{},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transport.h2cSupported(context.Background(), "upstream:8080")
+		}()
+	}
+
+	// Other upstreams aren't blocked by the pending probe.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	transport.h2cSupported(ctx, "other:8080")
+	if ctx.Err() != nil {
+		t.Error("want probe of another upstream not to wait for the pending probe")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&dials); got != 2 {
+		t.Errorf("want a single probe per upstream, got %d probes", got)
+	}
+}
+
+func TestInitUpstreamTransportHTTP2WithTLS(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Proto))
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	for protocol, want := range map[string]string{
+		upstreamProtocolALPN:  "HTTP/2.0",
+		upstreamProtocolAuto:  "HTTP/2.0",
+		upstreamProtocolHTTP1: "HTTP/1.1",
+		upstreamProtocolHTTP2: "HTTP/2.0",
+	} {
+		base, err := initTransport("", sockopt.Config{}, http.ProxyFromEnvironment)
+		if err != nil {
+			t.Fatalf("want err to be nil, but got %v", err)
+		}
+		base.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: upstream.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+		transport, err := initUpstreamTransport(base, protocol)
+		if err != nil {
+			t.Fatalf("want err to be nil, but got %v", err)
+		}
+
+		resp, err := (&http.Client{Transport: transport}).Get(upstream.URL)
+		if err != nil {
+			t.Fatalf("%s: want err to be nil, but got %v", protocol, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("want err to be nil, but got %v", err)
+		}
+		if string(body) != want {
+			t.Errorf("%s: want upstream to be called with %s, got %s", protocol, want, body)
+		}
+	}
+}
+
+func TestUpstreamDialerTLSHandshakeTimeout(t *testing.T) {
+	// The upstream accepts connections but never answers the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	d := newUpstreamDialer(&http.Transport{TLSHandshakeTimeout: 100 * time.Millisecond})
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := d.DialTLS("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("want handshake to fail, got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want handshake to time out, but it didn't")
+	}
+}
+
+func TestProxyFunc(t *testing.T) {
+	proxy, err := proxyFunc("http://proxy.example.com:3128")
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://upstream.example.com/metrics", nil)
+	u, err := proxy(req)
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if u == nil || u.Host != "proxy.example.com:3128" {
+		t.Errorf("want proxy host to be proxy.example.com:3128, got %v", u)
+	}
+
+	if _, err := proxyFunc("proxy.example.com"); err == nil {
+		t.Error("expected error for proxy URL without scheme, got nil")
+	}
+}
+
+func TestAuthChallengePassthrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Proxy-Authorization") == "Basic Zm9vOmJhcg==" {
+			return
+		}
+		w.Header().Set("Proxy-Authenticate", `Basic realm="upstream"`)
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	p := httputil.NewSingleHostReverseProxy(upstreamURL)
+	withAuthChallengePassthrough(p)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusProxyAuthRequired {
+		t.Errorf("want status %d, got %d", http.StatusProxyAuthRequired, w.Code)
+	}
+	if got := w.Header().Get("Proxy-Authenticate"); got != `Basic realm="upstream"` {
+		t.Errorf("want Proxy-Authenticate header to be passed through, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	p.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	attempts := 0
+	flaky := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	transport := withRetries(flaky, 2)
+	transport.(*retryTransport).backoff = time.Millisecond
+
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream/", nil))
+	if err != nil {
+		t.Fatalf("want err to be nil, but got %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || attempts != 3 {
+		t.Errorf("want request to succeed after 3 attempts, got status %d after %d attempts", resp.StatusCode, attempts)
+	}
+
+	attempts = 0
+	if _, err := transport.RoundTrip(httptest.NewRequest(http.MethodPost, "http://upstream/", strings.NewReader("body"))); err == nil {
+		t.Error("expected non-idempotent request not to be retried, got nil error")
+	}
+	if attempts != 1 {
+		t.Errorf("want 1 attempt for non-idempotent request, got %d", attempts)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestResponseSizeLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/streaming" {
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte("0123456789"))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	cases := []struct {
+		name     string
+		path     string
+		maxBytes int64
+		status   int
+		body     string
+	}{
+		{name: "within limit", path: "/", maxBytes: 10, status: http.StatusOK, body: "0123456789"},
+		{name: "content length exceeds limit", path: "/", maxBytes: 9, status: http.StatusBadGateway},
+		{name: "streaming response cut off", path: "/streaming", maxBytes: 4, status: http.StatusOK, body: "0123"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := httputil.NewSingleHostReverseProxy(upstreamURL)
+			p.ErrorLog = log.New(ioutil.Discard, "", 0)
+			withResponseSizeLimit(p, "test", c.maxBytes)
+
+			w := httptest.NewRecorder()
+			func() {
+				// The reverse proxy aborts the handler when the response is cut off.
+				defer func() {
+					if r := recover(); r != nil && r != http.ErrAbortHandler {
+						panic(r)
+					}
+				}()
+				p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+			}()
+
+			if w.Code != c.status {
+				t.Errorf("want status %d, got %d", c.status, w.Code)
+			}
+			if c.body != "" && w.Body.String() != c.body {
+				t.Errorf("want body %q, got %q", c.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	body := strings.Repeat("metric_total 1\n", 100000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	pool := newBufferPool(1024)
+	p := httputil.NewSingleHostReverseProxy(upstreamURL)
+	p.BufferPool = pool
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if w.Body.String() != body {
+			t.Fatalf("want a body of %d bytes, got %d bytes", len(body), w.Body.Len())
+		}
+	}
+
+	if b := pool.Get(); len(b) != 1024 {
+		t.Errorf("want buffers of 1024 bytes, got %d", len(b))
+	}
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		pool.Put(pool.Get())
+	})
+	if allocs >= 1 {
+		t.Errorf("want buffers to be reused without allocating, got %v allocations per buffer", allocs)
+	}
+}
+
+func BenchmarkProxyLargeResponse(b *testing.B) {
+	body := []byte(strings.Repeat("metric_total 1\n", 100000))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(body)
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	for _, bc := range []struct {
+		name string
+		pool httputil.BufferPool
+	}{
+		{name: "unpooled"},
+		{name: "pooled-32k", pool: newBufferPool(32 * 1024)},
+		{name: "pooled-64k", pool: newBufferPool(64 * 1024)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			p := httputil.NewSingleHostReverseProxy(upstreamURL)
+			p.BufferPool = bc.pool
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.ServeHTTP(discardResponseWriter{header: http.Header{}}, req)
+			}
+		})
+	}
+}
+
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header         { return w.header }
+func (w discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardResponseWriter) WriteHeader(int)             {}
+
+func TestKubeAPITransportSharesHTTP2Connection(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 {
+			t.Errorf("want HTTP/2 requests, got %s", req.Proto)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":{"allowed":true,"authenticated":true}}`))
+	}))
+	srv.EnableHTTP2 = true
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	kcfg := &rest.Config{Host: srv.URL, TLSClientConfig: rest.TLSClientConfig{CAData: caData}, QPS: -1}
+	if err := configureKubeAPITransport(kcfg, kubeAPIHTTP2Config{enabled: true, readIdleTimeout: 30 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kcfg.Transport.(*http2.Transport); !ok {
+		t.Fatalf("want an HTTP/2 transport, got %T", kcfg.Transport)
+	}
+	client, err := kubernetes.NewForConfig(kcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := client.AuthenticationV1().TokenReviews().Create(context.Background(), &authenticationv1.TokenReview{}, metav1.CreateOptions{}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := client.AuthorizationV1().SubjectAccessReviews().Create(context.Background(), &authorizationv1.SubjectAccessReview{}, metav1.CreateOptions{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&conns); got != 1 {
+		t.Errorf("want the clients to share 1 connection, got %d", got)
+	}
+}
+
+func TestKubeAPITransportFallback(t *testing.T) {
+	proxy, _ := proxyFunc("http://proxy.example.com:3128")
+	for _, c := range []struct {
+		name string
+		kcfg *rest.Config
+	}{
+		{name: "cleartext", kcfg: &rest.Config{Host: "http://127.0.0.1:8080"}},
+		{name: "proxy", kcfg: &rest.Config{Host: "https://127.0.0.1:6443", Proxy: proxy}},
+		{name: "rotated client certificate", kcfg: &rest.Config{Host: "https://127.0.0.1:6443", TLSClientConfig: rest.TLSClientConfig{CertFile: "tls.crt", KeyFile: "tls.key"}}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if err := configureKubeAPITransport(c.kcfg, kubeAPIHTTP2Config{enabled: true}); err != nil {
+				t.Fatal(err)
+			}
+			if c.kcfg.Transport != nil {
+				t.Errorf("want client-go's transport, got %T", c.kcfg.Transport)
+			}
+		})
+	}
+}
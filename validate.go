@@ -0,0 +1,217 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	k8sapiflag "k8s.io/component-base/cli/flag"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/apis/config/v1alpha1"
+	rbac_proxy_config "github.com/brancz/kube-rbac-proxy/pkg/config"
+	"github.com/brancz/kube-rbac-proxy/pkg/startup"
+	rbac_proxy_tls "github.com/brancz/kube-rbac-proxy/pkg/tls"
+)
+
+// runValidate parses and validates the flags and config file in args like
+// the proxy does at startup, without starting it.
+func runValidate(args []string) error {
+	cfg, _, err := parseConfig(args)
+	if err != nil {
+		return err
+	}
+	return cfg.validate()
+}
+
+// printErrors prints the message followed by each error aggregated in err
+// on its own line.
+func printErrors(w io.Writer, msg string, err error) {
+	fmt.Fprintln(w, msg)
+	errs := []error{err}
+	if agg, ok := err.(utilerrors.Aggregate); ok {
+		errs = agg.Errors()
+	}
+	for _, err := range errs {
+		fmt.Fprintf(w, "  %v\n", err)
+	}
+}
+
+// validate returns all errors of cfg which can be detected without
+// connecting to the kube-apiserver or the upstream, or reading certificates.
+func (cfg *config) validate() error {
+	var errs []error
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, err := url.Parse(cfg.upstream); err != nil {
+		addErr("invalid --upstream: %v", err)
+	}
+	if !sets.NewString(upstreamProtocolALPN, upstreamProtocolAuto, upstreamProtocolHTTP1, upstreamProtocolHTTP2).Has(cfg.upstreamProtocol) {
+		addErr("invalid --upstream-protocol %q, must be one of %q, %q, %q or %q", cfg.upstreamProtocol, upstreamProtocolALPN, upstreamProtocolAuto, upstreamProtocolHTTP1, upstreamProtocolHTTP2)
+	}
+	if _, err := proxyFunc(cfg.upstreamProxyURL); err != nil {
+		addErr("invalid --upstream-proxy-url: %v", err)
+	}
+	if _, err := proxyFunc(cfg.kubeAPIProxyURL); err != nil {
+		addErr("invalid --kube-api-proxy-url: %v", err)
+	}
+	sources := 0
+	for _, source := range []string{cfg.configFile, cfg.configObject, cfg.configConfigMap} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		addErr("only one of --config-file, --config-object and --config-configmap can be used")
+	}
+	if cfg.configObject != "" {
+		if _, _, err := rbac_proxy_config.ParseObjectName(cfg.configObject); err != nil {
+			addErr("invalid --config-object: %v", err)
+		}
+	}
+	if cfg.configConfigMap != "" {
+		if _, err := rbac_proxy_config.ParseConfigMapKey(cfg.configConfigMap); err != nil {
+			addErr("invalid --config-configmap: %v", err)
+		}
+	}
+	if cfg.kubeconfigContext != "" && cfg.kubeconfigLocation == "" {
+		addErr("--kubeconfig-context requires --kubeconfig")
+	}
+	if cfg.windowsServiceName != "" && !cfg.cmdlineFlags.Has("windows-service-name") {
+		addErr("--windows-service-name can only be set on the command line")
+	}
+	if cfg.auth.Authentication.Token.CacheSize < 1 {
+		addErr("--auth-token-cache-size must be positive")
+	}
+	if cfg.kubeAPIHTTP2.readIdleTimeout < 0 || cfg.kubeAPIHTTP2.pingTimeout < 0 {
+		addErr("--kube-api-http2-read-idle-timeout and --kube-api-http2-ping-timeout must not be negative")
+	}
+	if cfg.runtimeLimits.GOMAXPROCS < 0 || cfg.runtimeLimits.MemoryLimitBytes < 0 {
+		addErr("--gomaxprocs and --gomemlimit-bytes must not be negative")
+	}
+	if cfg.runtimeLimits.MemoryLimitRatio < 0 || cfg.runtimeLimits.MemoryLimitRatio > 1 {
+		addErr("--gomemlimit-ratio must be between 0 and 1, got %v", cfg.runtimeLimits.MemoryLimitRatio)
+	}
+	if cfg.upstreamCopyBufferSize < 1 {
+		addErr("--upstream-copy-buffer-size must be positive")
+	}
+	if cfg.profile != "" && !cfg.cmdlineFlags.Has("profile") {
+		addErr("--profile can only be set on the command line")
+	}
+	if len(cfg.allowPaths) > 0 && len(cfg.ignorePaths) > 0 {
+		addErr("cannot use --allow-paths and --ignore-paths together")
+	}
+
+	if agg, ok := v1alpha1.Validate(&cfg.file).(utilerrors.Aggregate); ok {
+		errs = append(errs, agg.Errors()...)
+	}
+
+	if len(cfg.secureListenAddresses) > 0 {
+		errs = append(errs, cfg.tls.validate(cfg.auth.Authentication.X509.ClientCAFile)...)
+	}
+	if cfg.tls.fips && !rbac_proxy_tls.FIPSAvailable() {
+		addErr("--fips requires a binary built with Go+BoringCrypto")
+	}
+
+	if n := cfg.server.http2MaxReadFrameSize; n != 0 && (n < 1<<14 || n > 1<<24-1) {
+		addErr("--http2-max-size must be between 16KiB and 16MiB, got %d", n)
+	}
+	if cfg.auth.LatencyBudget.Total < 0 {
+		addErr("--auth-latency-budget must not be negative, got %v", cfg.auth.LatencyBudget.Total)
+	}
+	if r := cfg.auth.LatencyBudget.AuthenticationRatio; r <= 0 || r > 1 {
+		addErr("--auth-latency-budget-authn-ratio must be greater than 0 and at most 1, got %v", r)
+	}
+	if cfg.server.handoffTimeout <= 0 {
+		addErr("--handoff-timeout must be positive, got %v", cfg.server.handoffTimeout)
+	}
+	if cfg.debug.endpoints && cfg.health.listenAddress == "" {
+		addErr("--debug-endpoints requires --health-listen-address")
+	}
+	if cfg.metricsSALimit < 0 {
+		addErr("--metrics-service-account-limit must not be negative, got %d", cfg.metricsSALimit)
+	}
+	if cfg.auditLogMaxSize < 0 || cfg.auditLogMaxAge < 0 || cfg.audit.LogRotation.MaxBackups < 0 {
+		addErr("--audit-log-maxsize, --audit-log-maxage and --audit-log-maxbackup must not be negative")
+	}
+	if err := cfg.logSampling.Validate(); err != nil {
+		addErr("invalid request log sampling: %v", err)
+	}
+	if cfg.decisionLog.URL != "" && (cfg.decisionLog.BufferSize < 1 || cfg.decisionLog.MaxBatchSize < 1 || cfg.decisionLog.FlushInterval <= 0) {
+		addErr("--decision-log-buffer-size, --decision-log-max-batch-size and --decision-log-flush-interval must be positive")
+	}
+	if err := cfg.startup.Validate(); err != nil {
+		addErr("invalid startup failure policies: %v", err)
+	}
+	if policy, _ := cfg.startup.Policy(startup.Certificate); policy == startup.Degraded && cfg.tls.secret != "" {
+		addErr("the degraded certificate startup failure policy cannot be used with --tls-secret")
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// validate returns the errors of the TLS settings of the secure listener,
+// whose client certificates are verified against clientCAFile.
+func (c tlsConfig) validate(clientCAFile string) []error {
+	var errs []error
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch {
+	case c.spiffe:
+		if c.certFile != "" || c.keyFile != "" || c.secret != "" || len(c.acme.Domains) > 0 {
+			addErr("cannot use --tls-spiffe with --tls-cert-file, --tls-secret or --acme-domains")
+		}
+	case len(c.acme.Domains) > 0:
+		if c.certFile != "" || c.keyFile != "" || c.secret != "" {
+			addErr("cannot use --acme-domains with --tls-cert-file or --tls-secret")
+		}
+	case c.secret != "":
+		if c.certFile != "" || c.keyFile != "" {
+			addErr("cannot use --tls-secret and --tls-cert-file together")
+		}
+	case c.keyURI != "":
+		if c.certFile == "" || c.keyFile != "" {
+			addErr("--tls-private-key-uri requires --tls-cert-file and cannot be used with --tls-private-key-file")
+		}
+	}
+
+	if _, _, err := rbac_proxy_tls.VersionRange(c.minVersion, c.maxVersion); err != nil {
+		addErr("invalid TLS version: %v", err)
+	}
+	if _, err := k8sapiflag.TLSCipherSuites(c.cipherSuites); err != nil {
+		addErr("invalid --tls-cipher-suites: %v", err)
+	}
+	if _, err := rbac_proxy_tls.CurvePreferences(c.curvePreferences); err != nil {
+		addErr("invalid --tls-curve-preferences: %v", err)
+	}
+	if c.clientAuth != "" {
+		clientAuth, err := rbac_proxy_tls.ClientAuthType(c.clientAuth)
+		if err != nil {
+			addErr("invalid --tls-client-auth: %v", err)
+		} else if rbac_proxy_tls.VerifiesClientCerts(clientAuth) && clientCAFile == "" {
+			addErr("--tls-client-auth %s requires --client-ca-file", c.clientAuth)
+		}
+	}
+	return errs
+}
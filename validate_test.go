@@ -0,0 +1,102 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		config string
+		errs   []string
+	}{
+		{
+			name: "valid",
+			args: []string{"--upstream=http://127.0.0.1:8081/", "--secure-listen-address=:8443"},
+			config: `
+authorization:
+  rewrites:
+    byQueryParameter:
+      name: namespace
+  resourceAttributes:
+    namespace: "{{ .Value }}"
+`,
+		},
+		{
+			name: "invalid",
+			args: []string{"--secure-listen-address=:8443", "--tls-min-version=VersionTLS14", "--startup-failure-policy=upstream=ignore", "--allow-paths=/metrics", "--ignore-paths=/healthz", "--kubeconfig-context=other", "--config-object=proxy", "--config-configmap=default/proxy", "--gomemlimit-ratio=1.5", "--handoff-timeout=0s", "--auth-latency-budget-authn-ratio=0"},
+			config: `
+hosts:
+- host: a.example.com
+  upstream: http://127.0.0.1:8081/
+  authorization:
+    resourceAttributes:
+      namespace: "{{ .Value }}"
+- host: a.example.com
+`,
+			errs: []string{
+				"--allow-paths and --ignore-paths",
+				`authorization config of host "a.example.com": resourceAttributes.namespace is a template`,
+				`got host "a.example.com" with upstream ""`,
+				"invalid TLS version",
+				`invalid startup failure policies: unknown policy "ignore" for upstream`,
+				"--kubeconfig-context requires --kubeconfig",
+				"only one of --config-file, --config-object and --config-configmap can be used",
+				`invalid --config-configmap: "default/proxy" must be of the form namespace/name/key`,
+				`invalid --config-object: "proxy" must be of the form namespace/name`,
+				"--gomemlimit-ratio must be between 0 and 1",
+				"--auth-latency-budget-authn-ratio must be greater than 0 and at most 1",
+				"--handoff-timeout must be positive",
+			},
+		},
+	} {
+		path := filepath.Join(dir, tc.name+".yaml")
+		if err := ioutil.WriteFile(path, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := runValidate(append(tc.args, "--config-file="+path))
+		if len(tc.errs) == 0 {
+			if err != nil {
+				t.Errorf("%s: want no error, got %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: want errors, got nil", tc.name)
+			continue
+		}
+		for _, want := range tc.errs {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: want error containing %q, got %v", tc.name, want, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2017 Frederic Branczyk All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/brancz/kube-rbac-proxy/pkg/version"
+)
+
+// runVersion runs the version subcommand with the given arguments.
+func runVersion(args []string) error {
+	output := ""
+	flagset := pflag.NewFlagSet("version", pflag.ExitOnError)
+	flagset.StringVarP(&output, "output", "o", "", "The output format, \"json\" or empty for a single line.")
+	flagset.Parse(args)
+
+	return printVersion(os.Stdout, output)
+}
+
+func printVersion(w io.Writer, output string) error {
+	info := version.Get()
+	switch output {
+	case "":
+		_, err := fmt.Fprintln(w, info)
+		return err
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	default:
+		return fmt.Errorf("unknown output format %q, must be \"json\" or empty", output)
+	}
+}
+
+// versionHandler serves the build information as JSON.
+func versionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = printVersion(w, "json")
+	})
+}